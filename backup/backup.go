@@ -0,0 +1,318 @@
+// Package backup writes and restores archives with all the bot data.
+//
+// An archive is a gzip compressed stream of JSON lines:
+//
+//	{"format":"purchaselist-backup","version":1,"created_at":"2021-04-01T10:00:00Z"}
+//	{"collection":"users","doc":{...}}
+//	{"collection":"purchaseLists","doc":{...}}
+//	{"collection":"sessions","doc":{...}}
+//	{"collection":"itemHistory","doc":{...}}
+//	{"collection":"categoryWords","doc":{...}}
+//	{"collection":"templates","doc":{...}}
+//	{"collection":"jobs","doc":{...}}
+//	{"collection":"recipes","doc":{...}}
+//	{"collection":"pantry","doc":{...}}
+//	{"trailer":true,"counts":{"categoryWords":1,"itemHistory":1,"jobs":1,"pantry":1,"purchaseLists":1,"recipes":1,"sessions":1,"templates":1,"users":1}}
+//
+// The first line is the header, every document is stored as canonical
+// MongoDB Extended JSON, so ObjectIDs and dates survive a round trip.
+// The trailer holds the number of documents per collection and tells
+// a complete archive from a truncated one.
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boryashkin/purchaselist/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"time"
+)
+
+const (
+	FormatName    = "purchaselist-backup"
+	FormatVersion = 1
+
+	maxLineSize = 16 * 1024 * 1024
+)
+
+// Collections in the order they are written and restored, so references point backwards
+var Collections = []string{db.ColUsers, db.ColProducts, db.ColSessions, db.ColItemHistory, db.ColCategoryWords, db.ColTemplates, db.ColJobs, db.ColRecipes, db.ColPantry}
+
+type Header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type record struct {
+	Collection string          `json:"collection,omitempty"`
+	Doc        json.RawMessage `json:"doc,omitempty"`
+	Trailer    bool            `json:"trailer,omitempty"`
+	Counts     map[string]int  `json:"counts,omitempty"`
+}
+
+type Archive struct {
+	Header Header
+	Docs   map[string][]bson.D
+}
+
+// Write dumps every collection of the database into w
+func Write(ctx context.Context, w io.Writer, database *mongo.Database) (map[string]int, error) {
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	err := enc.Encode(Header{Format: FormatName, Version: FormatVersion, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, col := range Collections {
+		cursor, err := database.Collection(col).Find(ctx, bson.M{})
+		if err != nil {
+			return nil, err
+		}
+		for cursor.Next(ctx) {
+			doc, err := bson.MarshalExtJSON(cursor.Current, true, false)
+			if err != nil {
+				cursor.Close(ctx)
+				return nil, err
+			}
+			err = enc.Encode(record{Collection: col, Doc: doc})
+			if err != nil {
+				cursor.Close(ctx)
+				return nil, err
+			}
+			counts[col]++
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = enc.Encode(record{Trailer: true, Counts: counts})
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, zw.Close()
+}
+
+// Read loads a whole archive and checks that it is complete
+func Read(r io.Reader) (*Archive, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	archive := Archive{Docs: map[string][]bson.D{}}
+	if !scanner.Scan() {
+		return nil, errors.New("empty archive")
+	}
+	err = json.Unmarshal(scanner.Bytes(), &archive.Header)
+	if err != nil || archive.Header.Format != FormatName {
+		return nil, errors.New("not a purchaselist backup")
+	}
+	if archive.Header.Version > FormatVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", archive.Header.Version)
+	}
+
+	known := map[string]bool{}
+	for _, col := range Collections {
+		known[col] = true
+	}
+	var trailer *record
+	line := 1
+	for scanner.Scan() {
+		line++
+		if trailer != nil {
+			return nil, fmt.Errorf("line %d: data after the trailer", line)
+		}
+		var rec record
+		err = json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if rec.Trailer {
+			trailer = &rec
+			continue
+		}
+		if !known[rec.Collection] {
+			return nil, fmt.Errorf("line %d: unknown collection %q", line, rec.Collection)
+		}
+		var doc bson.D
+		err = bson.UnmarshalExtJSON(rec.Doc, true, &doc)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		archive.Docs[rec.Collection] = append(archive.Docs[rec.Collection], doc)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if trailer == nil {
+		return nil, errors.New("archive is truncated: no trailer")
+	}
+	for _, col := range Collections {
+		if trailer.Counts[col] != len(archive.Docs[col]) {
+			return nil, fmt.Errorf("archive is truncated: %d of %d %s", len(archive.Docs[col]), trailer.Counts[col], col)
+		}
+	}
+
+	return &archive, nil
+}
+
+// Validate checks that every session, list, history item, category word and template points to an existing user and list,
+// and every template job to an existing template
+func (a *Archive) Validate() error {
+	userIDs := map[primitive.ObjectID]bool{}
+	for _, doc := range a.Docs[db.ColUsers] {
+		var user db.User
+		if err := decode(doc, &user); err != nil {
+			return err
+		}
+		if userIDs[user.Id] {
+			return fmt.Errorf("duplicate user %s", user.Id.Hex())
+		}
+		userIDs[user.Id] = true
+	}
+	listIDs := map[primitive.ObjectID]bool{}
+	for _, doc := range a.Docs[db.ColProducts] {
+		var pList db.PurchaseList
+		if err := decode(doc, &pList); err != nil {
+			return err
+		}
+		if !userIDs[pList.UserID] {
+			return fmt.Errorf("list %s belongs to a missing user %s", pList.Id.Hex(), pList.UserID.Hex())
+		}
+		listIDs[pList.Id] = true
+	}
+	sessionUsers := map[primitive.ObjectID]bool{}
+	for _, doc := range a.Docs[db.ColSessions] {
+		var session db.Session
+		if err := decode(doc, &session); err != nil {
+			return err
+		}
+		if !userIDs[session.UserId] {
+			return fmt.Errorf("session %s belongs to a missing user %s", session.Id.Hex(), session.UserId.Hex())
+		}
+		if sessionUsers[session.UserId] {
+			return fmt.Errorf("user %s has more than one session", session.UserId.Hex())
+		}
+		sessionUsers[session.UserId] = true
+		if session.PurchaseListId != primitive.NilObjectID && !listIDs[session.PurchaseListId] {
+			return fmt.Errorf("session %s points to a missing list %s", session.Id.Hex(), session.PurchaseListId.Hex())
+		}
+	}
+	for _, doc := range a.Docs[db.ColItemHistory] {
+		var item db.HistoryItem
+		if err := decode(doc, &item); err != nil {
+			return err
+		}
+		if !userIDs[item.UserID] {
+			return fmt.Errorf("history item %s belongs to a missing user %s", item.Id.Hex(), item.UserID.Hex())
+		}
+	}
+	for _, doc := range a.Docs[db.ColCategoryWords] {
+		var word db.CategoryWord
+		if err := decode(doc, &word); err != nil {
+			return err
+		}
+		if !userIDs[word.UserID] {
+			return fmt.Errorf("category word %s belongs to a missing user %s", word.Id.Hex(), word.UserID.Hex())
+		}
+	}
+	templateIDs := map[primitive.ObjectID]bool{}
+	for _, doc := range a.Docs[db.ColTemplates] {
+		var tpl db.Template
+		if err := decode(doc, &tpl); err != nil {
+			return err
+		}
+		if !userIDs[tpl.UserID] {
+			return fmt.Errorf("template %s belongs to a missing user %s", tpl.Id.Hex(), tpl.UserID.Hex())
+		}
+		templateIDs[tpl.Id] = true
+	}
+	for _, doc := range a.Docs[db.ColJobs] {
+		var job db.Job
+		if err := decode(doc, &job); err != nil {
+			return err
+		}
+		if job.Kind == db.JobTemplate && !templateIDs[job.RefID] {
+			return fmt.Errorf("job %s points to a missing template %s", job.Id.Hex(), job.RefID.Hex())
+		}
+		if job.Kind == db.JobReminder && !listIDs[job.RefID] {
+			return fmt.Errorf("job %s points to a missing list %s", job.Id.Hex(), job.RefID.Hex())
+		}
+	}
+	for _, doc := range a.Docs[db.ColRecipes] {
+		var r db.Recipe
+		if err := decode(doc, &r); err != nil {
+			return err
+		}
+		if !userIDs[r.UserID] {
+			return fmt.Errorf("recipe %s belongs to a missing user %s", r.Id.Hex(), r.UserID.Hex())
+		}
+	}
+	for _, doc := range a.Docs[db.ColPantry] {
+		var item db.PantryItem
+		if err := decode(doc, &item); err != nil {
+			return err
+		}
+		if !userIDs[item.UserID] {
+			return fmt.Errorf("pantry item %s belongs to a missing user %s", item.Id.Hex(), item.UserID.Hex())
+		}
+	}
+
+	return nil
+}
+
+// Restore loads a validated archive into a database with empty collections
+func Restore(ctx context.Context, database *mongo.Database, archive *Archive) (map[string]int, error) {
+	if err := archive.Validate(); err != nil {
+		return nil, err
+	}
+	for _, col := range Collections {
+		count, err := database.Collection(col).CountDocuments(ctx, bson.M{})
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, fmt.Errorf("collection %s is not empty", col)
+		}
+	}
+	counts := map[string]int{}
+	for _, col := range Collections {
+		docs := make([]interface{}, 0, len(archive.Docs[col]))
+		for _, doc := range archive.Docs[col] {
+			docs = append(docs, doc)
+		}
+		if len(docs) == 0 {
+			continue
+		}
+		result, err := database.Collection(col).InsertMany(ctx, docs)
+		if err != nil {
+			return counts, err
+		}
+		counts[col] = len(result.InsertedIDs)
+	}
+
+	return counts, nil
+}
+
+func decode(doc bson.D, v interface{}) error {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, v)
+}
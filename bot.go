@@ -4,22 +4,40 @@ import (
 	"bufio"
 	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"github.com/boryashkin/purchaselist/cache"
+	"github.com/boryashkin/purchaselist/cluster"
+	"github.com/boryashkin/purchaselist/config"
 	"github.com/boryashkin/purchaselist/db"
 	"github.com/boryashkin/purchaselist/dialog"
+	"github.com/boryashkin/purchaselist/export"
+	"github.com/boryashkin/purchaselist/feature"
+	"github.com/boryashkin/purchaselist/health"
+	"github.com/boryashkin/purchaselist/importer"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/boryashkin/purchaselist/migrations"
+	"github.com/boryashkin/purchaselist/price"
 	"github.com/boryashkin/purchaselist/queue"
+	"github.com/boryashkin/purchaselist/tracing"
 	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/writeas/go-strip-markdown"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -31,15 +49,14 @@ type MessageEnvelope struct {
 }
 
 const (
-	DbName      = "purchaselist"
-	ColUsers    = "users"
-	ColSessions = "sessions"
-	ColProducts = "purchaseLists"
-
-	MaxCountOfItemsInList = 50
+	// maxInlineCompletions of the item being typed are offered in an inline query
+	maxInlineCompletions = 3
+	// downloadTimeout limits the download of an imported file
+	downloadTimeout = 30 * time.Second
 )
 
 var (
+	cfg           *config.Config
 	users         *mongo.Collection
 	sessions      *mongo.Collection
 	purchaseLists *mongo.Collection
@@ -48,7 +65,27 @@ var (
 	userService         db.UserService
 	sessionService      db.SessionService
 	purchaseListService db.PurchaseListService
+	updateService       db.UpdateService
+	leaseService        db.LeaseService
+	historyService      db.HistoryService
+	categoryService     db.CategoryService
+	templateService     db.TemplateService
+	jobService          db.JobService
+	recipeService       db.RecipeService
+	pantryService       db.PantryService
+	scheduler           *queue.Scheduler
+	updateLocker        cluster.Locker
 	delayMessage        queue.DelayMessage
+	sender              *dialog.Sender
+	features            *feature.Handler
+
+	// downloadClient fetches the files sent to the bot
+	downloadClient = &http.Client{Timeout: downloadTimeout}
+
+	healthChecker *health.Checker
+	flushSpans    func(context.Context) error
+
+	updateDispatcher *dispatcher
 )
 
 func generateStdinUpdates(ch chan *MessageEnvelope) {
@@ -74,6 +111,7 @@ func generateStdinUpdates(ch chan *MessageEnvelope) {
 
 // Handle them via
 // go generateSingleThreadedTgUpdates(ch)
+//
 //	for {
 //		select {
 //		case envelope := <-ch:
@@ -81,26 +119,17 @@ func generateStdinUpdates(ch chan *MessageEnvelope) {
 //		}
 //	}
 func generateSingleThreadedTgUpdates(ch chan *MessageEnvelope) {
-	tgtoken := os.Getenv("TGTOKEN")
-
-	bot1, err := tgbotapi.NewBotAPI(tgtoken)
-	if err != nil {
-		log.Panic(err)
-	}
-	bot = bot1
-	bot.Debug = false
-
-	log.Printf("Authorized on account %s", bot.Self.UserName)
+	newBot()
 
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
 	updates, err := bot.GetUpdatesChan(u)
 	if err != nil {
-		log.Panic(err)
+		fatal(context.Background(), "failed to poll telegram", err)
 	}
 	for update := range updates {
-		log.Println("Received update: ", update)
+		logger.Debug(context.Background(), "received update", "update_id", update.UpdateID)
 		envelope := MessageEnvelope{}
 		if update.Message != nil {
 			envelope.Text = update.Message.Text
@@ -109,65 +138,237 @@ func generateSingleThreadedTgUpdates(ch chan *MessageEnvelope) {
 		ch <- &envelope
 	}
 }
-func generateTgUpdates() *tgbotapi.UpdatesChannel {
-	tgtoken := os.Getenv("TGTOKEN")
-
-	bot1, err := tgbotapi.NewBotAPI(tgtoken)
+func newBot() {
+	bot1, err := dialog.NewBotAPI(cfg.Telegram.Token, cfg.Telegram.APIEndpoint)
 	if err != nil {
-		log.Panic(err)
+		fatal(context.Background(), "failed to authorize the bot", err)
 	}
 	bot = bot1
 	bot.Debug = false
+	features = feature.NewHandler(feature.Services{
+		Users:      &userService,
+		Sessions:   &sessionService,
+		Lists:      &purchaseListService,
+		History:    &historyService,
+		Categories: &categoryService,
+		Templates:  &templateService,
+		Jobs:       &jobService,
+		Recipes:    &recipeService,
+		Pantry:     &pantryService,
+	}, cfg, bot, sender, updateLocker)
 
-	log.Printf("Authorized on account %s", bot.Self.UserName)
+	logger.Info(context.Background(), "authorized", "account", bot.Self.UserName)
+}
 
-	u := tgbotapi.NewUpdate(0)
+func generateTgUpdates(ctx context.Context) *tgbotapi.UpdatesChannel {
+	offset, err := updateService.GetOffset(ctx)
+	if err != nil {
+		logger.Error(ctx, "failed to read the polling offset", "err", err)
+	}
+	logger.Info(ctx, "polling", "offset", offset)
+	u := tgbotapi.NewUpdate(offset)
 	u.Timeout = 60
 
 	updates, err := bot.GetUpdatesChan(u)
 	if err != nil {
-		log.Panic(err)
+		fatal(ctx, "failed to poll telegram", err)
+	}
+	return &updates
+}
+
+// listenTgWebhook registers the webhook on the metrics http server, every instance behind the url gets a share of updates
+func listenTgWebhook() *tgbotapi.UpdatesChannel {
+	webhook, err := url.Parse(cfg.Telegram.WebhookURL)
+	if err != nil {
+		fatal(context.Background(), "invalid webhook url", err)
+	}
+	_, err = bot.SetWebhook(tgbotapi.WebhookConfig{URL: webhook})
+	if err != nil {
+		fatal(context.Background(), "failed to set the webhook", err)
 	}
+	logger.Info(context.Background(), "listening for the webhook", "path", webhook.Path)
+	http.Handle(webhook.Path, updateDispatcher)
+	// the dispatcher takes the updates itself, nothing comes through the channel
+	var updates tgbotapi.UpdatesChannel
+
 	return &updates
 }
 
+// receiveUpdates starts a webhook or long polling. With several instances only one of them polls,
+// the others wait for its lease to expire.
+func receiveUpdates(ctx context.Context, leaderCtx context.Context, lost func()) (*tgbotapi.UpdatesChannel, error) {
+	if cfg.Telegram.WebhookURL != "" {
+		return listenTgWebhook(), nil
+	}
+	if cfg.Cluster.Enabled {
+		leader := cluster.NewLeader(&leaseService, "telegram_polling", cfg.Cluster.InstanceID, cfg.Cluster.LeaseTTL.Duration)
+		err := leader.Wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		go leader.Keep(leaderCtx, lost)
+	}
+	return generateTgUpdates(ctx), nil
+}
+
 func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a yaml config, environment variables override it")
+	flag.Parse()
+	var err error
+	cfg, err = config.Load(*configPath)
+	if err != nil {
+		log.Fatalln("Config err", err)
+	}
+	err = cfg.Validate()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	level, _ := logger.ParseLevel(cfg.Log.Level)
+	logger.Setup(os.Stderr, level, cfg.Log.Format)
+	// the libraries log through the standard logger
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer(logger.LevelWarn))
+	logger.Info(context.Background(), "effective config", "config", cfg.Redacted())
+	flushSpans, err = tracing.Setup(context.Background(), tracing.Options{
+		Exporter: cfg.Tracing.Exporter,
+		Endpoint: cfg.Tracing.Endpoint,
+		Insecure: cfg.Tracing.Insecure,
+		Ratio:    cfg.Tracing.SampleRatio,
+		Stdout:   os.Stdout,
+		Service:  "purchaselist",
+		Instance: cfg.Cluster.InstanceID,
+	})
+	if err != nil {
+		fatal(context.Background(), "tracing setup err", err)
+	}
+
 	h := promhttp.Handler()
 	http.Handle("/metrics", h)
-	go http.ListenAndServe("0.0.0.0:"+os.Getenv("METRICSPORT"), nil)
-	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://" + os.Getenv("MONGODB") + ":" + os.Getenv("MONGOPORT")))
+	healthChecker = health.NewChecker(cfg.Health.Timeout.Duration)
+	http.HandleFunc("/healthz", healthChecker.Live)
+	http.HandleFunc("/readyz", healthChecker.Ready)
+	healthChecker.Add("telegram", func(ctx context.Context) error {
+		return errors.New("not authorized yet")
+	})
+	httpServer := &http.Server{Addr: "0.0.0.0:" + strconv.Itoa(cfg.Metrics.Port)}
+	go httpServer.ListenAndServe()
+	client, err := mongo.NewClient(cfg.Mongo.ClientOptions())
 	if err != nil {
-		log.Println("Mongo instantiation err", err)
+		fatal(context.Background(), "mongo instantiation err", err)
 	}
-	dbCtx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	healthChecker.Add("mongo", func(ctx context.Context) error {
+		return client.Ping(ctx, nil)
+	})
+	dbCtx, cancel := context.WithTimeout(context.Background(), cfg.Mongo.ConnectTimeout.Duration)
 	defer cancel()
 	err = client.Connect(dbCtx)
 	if err != nil {
-		log.Println("Mongo connection err", err)
+		logger.Error(context.Background(), "mongo connection err", "err", err)
 	}
-	users = client.Database(DbName).Collection(ColUsers)
-	sessions = client.Database(DbName).Collection(ColSessions)
-	purchaseLists = client.Database(DbName).Collection(ColProducts)
+	if cfg.Features.Migrations {
+		err = migrations.Run(context.Background(), client.Database(db.DbName), false, nil)
+		if err != nil {
+			// serving a half migrated schema would break the lists, the migration is retried on the next start
+			fatal(context.Background(), "mongo migration err", err)
+		}
+	}
+	db.Configure(cfg.Mongo.Timeouts(), db.NewBreaker(cfg.Mongo.BreakerFailures, cfg.Mongo.BreakerCooldown.Duration))
+	users = client.Database(db.DbName).Collection(db.ColUsers)
+	sessions = client.Database(db.DbName).Collection(db.ColSessions)
+	purchaseLists = client.Database(db.DbName).Collection(db.ColProducts)
 	userService = db.NewUserService(users)
 	sessionService = db.NewSessionService(sessions)
 	purchaseListService = db.NewPurchaseListService(purchaseLists)
-	delayMessage = queue.NewDelayMessage(dialog.Reply, &purchaseListService)
-	rand.Seed(16000000000) //random number
+	historyService = db.NewHistoryService(client.Database(db.DbName).Collection(db.ColItemHistory))
+	categoryService = db.NewCategoryService(client.Database(db.DbName).Collection(db.ColCategoryWords))
+	templateService = db.NewTemplateService(client.Database(db.DbName).Collection(db.ColTemplates))
+	jobService = db.NewJobService(client.Database(db.DbName).Collection(db.ColJobs))
+	recipeService = db.NewRecipeService(client.Database(db.DbName).Collection(db.ColRecipes))
+	pantryService = db.NewPantryService(client.Database(db.DbName).Collection(db.ColPantry))
+	if cfg.Cache.Enabled && cfg.Cluster.Enabled {
+		// other instances change the documents without updating this cache
+		logger.Warn(context.Background(), "the cache is disabled in cluster mode")
+	} else if cfg.Cache.Enabled {
+		purchaseListService.EnableCache(cache.New("purchase_lists", cfg.Cache.Size, cfg.Cache.TTL.Duration))
+		sessionService.EnableCache(cache.New("sessions", cfg.Cache.Size, cfg.Cache.TTL.Duration))
+	}
+	updateService = db.NewUpdateService(
+		client.Database(db.DbName).Collection(db.ColProcessedUpdates),
+		client.Database(db.DbName).Collection(db.ColBotState),
+	)
+	leaseService = db.NewLeaseService(client.Database(db.DbName).Collection(db.ColLeases))
+	var debounce queue.DebounceStore = queue.NewMemoryDebounce()
+	updateLocker = cluster.NewMemoryLocker()
+	if cfg.Cluster.Enabled {
+		debounceService := db.NewDebounceService(client.Database(db.DbName).Collection(db.ColDebounce))
+		debounce = &debounceService
+		ticketService := db.NewTicketService(client.Database(db.DbName).Collection(db.ColTickets))
+		updateLocker = cluster.NewTicketLocker(&ticketService, cfg.Cluster.LockWait.Duration)
+	}
+	var saveOffset func(ctx context.Context, offset int) error
+	if cfg.Telegram.WebhookURL == "" {
+		saveOffset = updateService.SaveOffset
+	}
+	updateDispatcher = newDispatcher(updateLocker, &updateService, saveOffset, handleUpdate)
+	healthChecker.Add("backlog", func(ctx context.Context) error {
+		if backlog := updateDispatcher.Backlog(); backlog > int64(cfg.Health.MaxBacklog) {
+			return fmt.Errorf("%d updates in flight", backlog)
+		}
+		return nil
+	})
+	sender = dialog.NewSender(dialog.SenderLimits{
+		PerSecond:    cfg.Telegram.RateLimit,
+		ChatInterval: cfg.Telegram.ChatInterval.Duration,
+		Retries:      cfg.Telegram.SendRetries,
+	})
+	delayMessage = queue.NewDelayMessage(sender.Reply, &purchaseListService, cfg.List.DebounceDelay.Duration, debounce)
+	// every instance runs the scheduler, a due job is claimed by one of them
+	scheduler = queue.NewScheduler(&jobService, cfg.Cluster.InstanceID, cfg.Schedule.Interval.Duration)
+	// instances share debounce marks, so they must not share the sequence
+	rand.Seed(time.Now().UnixNano())
 	//ch := make(chan *MessageEnvelope)
 	//go generateStdinUpdates(ch)
 	//go generateSingleThreadedTgUpdates(ch)//side effect: duplicate messages on race conditions
-	updates := generateTgUpdates()
-	for update := range *updates {
-		update := update // trying to make a local copy to prevent races
-		envelope := MessageEnvelope{}
-		if update.Message != nil {
-			envelope.Text = update.Message.Text
-		} else {
-			envelope.Text = ""
+	ctx, stopRunning := context.WithCancel(context.Background())
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-stop
+		logger.Info(context.Background(), "shutdown: received a signal", "signal", sig.String())
+		stopRunning()
+	}()
+	leaderCtx, stopLeading := context.WithCancel(context.Background())
+	defer stopLeading()
+	go countActiveLists(ctx)
+
+	newBot()
+	scheduler.Handle(db.JobTemplate, features.RunTemplateJob)
+	scheduler.Handle(db.JobReminder, features.RunReminderJob)
+	healthChecker.Add("telegram", func(ctx context.Context) error {
+		_, err := bot.GetMe()
+		return err
+	})
+	if cfg.Features.Templates || cfg.Features.Reminders {
+		go scheduler.Run(ctx)
+	}
+	updates, err := receiveUpdates(ctx, leaderCtx, stopRunning)
+	if err != nil {
+		logger.Info(ctx, "shutdown: stopped waiting for the lease", "err", err)
+		shutdown(nil, client, httpServer)
+		return
+	}
+	for running := true; running; {
+		select {
+		case update := <-*updates:
+			err = updateDispatcher.Dispatch(ctx, update)
+			if err != nil {
+				logger.Error(ctx, "failed to dispatch the update", "update_id", update.UpdateID, "err", err)
+			}
+		case <-ctx.Done():
+			running = false
 		}
-		envelope.Update = &update
-		go handleAsync(&envelope)
 	}
+	shutdown(*updates, client, httpServer)
 
 	//for {
 	//	select {
@@ -177,7 +378,142 @@ func main() {
 	//}
 }
 
-func handleAsync(envelope *MessageEnvelope) {
+// handleUpdate is called by the dispatcher in the turn of the user
+func handleUpdate(ctx context.Context, update tgbotapi.Update) {
+	envelope := MessageEnvelope{}
+	if update.Message != nil {
+		envelope.Text = update.Message.Text
+	} else {
+		envelope.Text = ""
+	}
+	envelope.Update = &update
+	handleAsync(ctx, &envelope)
+}
+
+func updateType(update tgbotapi.Update) string {
+	if update.Message != nil {
+		return "message"
+	} else if update.CallbackQuery != nil {
+		return "callback"
+	} else if update.InlineQuery != nil {
+		return "inline"
+	}
+	return "other"
+}
+
+// updateLockKey serializes updates of the same user, as they share a session and a current list
+func updateLockKey(update tgbotapi.Update) string {
+	if update.Message != nil && update.Message.From != nil {
+		return "user:" + strconv.Itoa(update.Message.From.ID)
+	} else if update.CallbackQuery != nil && update.CallbackQuery.From != nil {
+		return "user:" + strconv.Itoa(update.CallbackQuery.From.ID)
+	} else if update.InlineQuery != nil && update.InlineQuery.From != nil {
+		return "user:" + strconv.Itoa(update.InlineQuery.From.ID)
+	}
+	return "update:" + strconv.Itoa(update.UpdateID)
+}
+
+// countActiveLists refreshes the gauge of lists changed within a day every minute
+func countActiveLists(ctx context.Context) {
+	for {
+		count, err := purchaseListService.CountUpdatedSince(ctx, time.Now().Add(-24*time.Hour))
+		if err != nil {
+			logger.Error(ctx, "failed to count active lists", "err", err)
+		} else {
+			metrics.DbActiveLists.Set(float64(count))
+		}
+		select {
+		case <-time.After(time.Minute):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// shutdown stops polling, handles updates already fetched from telegram and sends delayed messages.
+// Whatever is not done within the shutdown timeout is dropped.
+func shutdown(updates tgbotapi.UpdatesChannel, client *mongo.Client, httpServer *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout.Duration)
+	defer cancel()
+
+	healthChecker.Stop()
+	webhook := cfg.Telegram.WebhookURL != ""
+	if webhook {
+		// the webhook is served by the same server, stop taking updates first
+		err := httpServer.Shutdown(ctx)
+		if err != nil {
+			logger.Error(ctx, "shutdown: http server err", "err", err)
+		}
+	}
+	bot.StopReceivingUpdates()
+	for drained := updates == nil; !drained; {
+		select {
+		case update := <-updates:
+			err := updateDispatcher.Dispatch(ctx, update)
+			if err != nil {
+				logger.Error(ctx, "shutdown: failed to dispatch the update", "update_id", update.UpdateID, "err", err)
+			}
+		default:
+			drained = true
+		}
+	}
+	err := updateDispatcher.Wait(ctx)
+	if err != nil {
+		logger.Warn(ctx, "shutdown: in-flight updates are dropped", "err", err)
+	}
+	err = delayMessage.Flush(ctx)
+	if err != nil {
+		logger.Warn(ctx, "shutdown: delayed messages are dropped", "err", err)
+	}
+	err = scheduler.Wait(ctx)
+	if err != nil {
+		logger.Warn(ctx, "shutdown: the running job is dropped", "err", err)
+	}
+
+	// the next getUpdates confirms everything before the offset, the rest is redelivered after restart.
+	// An update cut off by the timeout is never done, so the offset stays at it.
+	offset := updateDispatcher.Offset()
+	if offset > 0 && !webhook {
+		_, err = bot.GetUpdates(tgbotapi.UpdateConfig{Offset: offset, Limit: 1})
+		if err != nil {
+			logger.Error(ctx, "shutdown: failed to confirm the offset", "offset", offset, "err", err)
+		} else {
+			logger.Info(ctx, "shutdown: confirmed the offset", "offset", offset)
+		}
+	}
+
+	err = client.Disconnect(ctx)
+	if err != nil {
+		logger.Error(ctx, "shutdown: mongo disconnect err", "err", err)
+	}
+	if !webhook {
+		err = httpServer.Shutdown(ctx)
+		if err != nil {
+			logger.Error(ctx, "shutdown: http server err", "err", err)
+		}
+	}
+	err = flushSpans(ctx)
+	if err != nil {
+		logger.Error(ctx, "shutdown: spans are dropped", "err", err)
+	}
+	logger.Info(ctx, "shutdown: done")
+}
+
+func waitWithContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func handleAsync(ctx context.Context, envelope *MessageEnvelope) {
 	var chatMsgID dialog.ChatMessageID
 	if envelope.Update == nil {
 		// tests
@@ -190,86 +526,123 @@ func handleAsync(envelope *MessageEnvelope) {
 	var dState *dialog.DialogState
 	update := envelope.Update
 
-	c := dialog.NewMessageHandler(bot, &purchaseListService)
+	c := dialog.NewMessageHandler(bot, &purchaseListService, cfg)
 
-	log.Println("[new mess] something", update)
-	if update.CallbackQuery != nil {
-		log.Println("[cb] new", update.CallbackQuery)
+	if update.CallbackQuery != nil && feature.IsSuggestionCallback(update.CallbackQuery) {
+		logger.Debug(ctx, "suggestion pressed", "data", logger.Private(update.CallbackQuery.Data))
+		chatMsgID = getCallbackChatId(envelope)
+		m, err = features.ReadSuggestionCallback(ctx, update.CallbackQuery, chatMsgID)
+		if err != nil {
+			logger.Warn(ctx, "suggestion failed", "err", err)
+			sender.AnswerCallback(ctx, bot, tgbotapi.CallbackConfig{CallbackQueryID: update.CallbackQuery.ID, Text: dialog.ErrorText(err, "Товар не найден")})
+			return
+		}
+		// the list is sent after the debounce, the button must not spin until then
+		sender.AnswerCallback(ctx, bot, tgbotapi.CallbackConfig{CallbackQueryID: update.CallbackQuery.ID, Text: "Добавлено: " + m.Text})
+	} else if update.CallbackQuery != nil {
+		logger.Debug(ctx, "callback query", "data", logger.Private(update.CallbackQuery.Data))
 		chatMsgID = getCallbackChatId(envelope)
-		msg = readCallbackQuery(update.CallbackQuery, &c)
-		reply(chatMsgID, msg)
+		msg = readCallbackQuery(ctx, update.CallbackQuery, &c)
+		reply(ctx, chatMsgID, msg)
 		return
 	} else if update.Message != nil {
 		message = update.Message
 		chatMsgID = getMessageChatId(envelope)
-		log.Printf("[RECEIVED][%d] %s", message.From.ID, message.Text)
+		logger.Debug(ctx, "message", "tg_user", message.From.ID, "text", logger.Private(message.Text))
 
 		m = c.ReadMessage(message, chatMsgID)
+		if m.Command != "" {
+			metrics.BotCommand.With(prometheus.Labels{"command": m.Command}).Inc()
+		}
 	} else if update.InlineQuery != nil {
-		log.Println("[Inline]", update.InlineQuery)
+		logger.Debug(ctx, "inline query", "query", logger.Private(update.InlineQuery.Query))
 		chatMsgID = getInlineMessageChatId(update.InlineQuery)
 		m = c.ReadInlineQuery(update.InlineQuery, chatMsgID)
 	} else {
-		log.Println("[noop] Unknown request", update)
+		logger.Debug(ctx, "unknown update")
 		return
 	}
 
-	dState, err = createDialogStateFromMessage(&m)
+	stateCtx, span := tracing.Start(ctx, "dialog_state")
+	dState, err = createDialogStateFromMessage(stateCtx, &m)
+	tracing.End(span, err)
 	if err != nil {
-		reply(chatMsgID, dialog.MessageForReply{Text: err.Error()})
+		logger.Error(ctx, "failed to load the dialog state", "err", err)
+		reply(ctx, chatMsgID, dialog.MessageForReply{Text: dialog.ErrorText(err, err.Error())})
 		return
 	}
 
+	if m.Document != nil {
+		if dState.ImportErr != nil {
+			logger.Warn(ctx, "import failed", "err", dState.ImportErr)
+		}
+		reply(ctx, chatMsgID, c.GetMessageForImport(dState.Import, dState.ImportErr))
+	}
+
 	prevPlist := *dState.PurchaseList
-	st := c.GetNewStateByMessage(&m, dState)
-	err = updateSession(st.Session)
+	st := c.GetNewStateByMessage(ctx, &m, dState)
+	err = updateSession(ctx, st.Session)
 	if err != nil {
-		reply(chatMsgID, dialog.MessageForReply{Text: err.Error()})
+		logger.Error(ctx, "failed to update the session", "err", err)
+		reply(ctx, chatMsgID, dialog.MessageForReply{Text: dialog.ErrorText(err, err.Error())})
 		return
 	}
 	msg = c.GetMessageForReply(&m, dState.Session, dState.User, dState.PurchaseList)
 	if m.ChatMsgID.InlineMessageID != nil {
-		replyInline(chatMsgID, msg)
+		replyInline(ctx, chatMsgID, msg, completeInline(ctx, &c, &m, dState)...)
 		return
 	}
+	msg = features.Command(ctx, &c, &m, dState, msg)
 	if msg.DeletePrevious != nil && *msg.DeletePrevious == true {
-		deleteMessage(dState.Session.PurchaseListId, &prevPlist)
+		deleteMessage(ctx, dState.Session.PurchaseListId, &prevPlist)
 	}
-	log.Println(msg.DeletePrevious)
 	dd := time.Now()
 	msg.CreatedAt = &dd
 	msg.Rand = rand.Intn(16000000000)
-	log.Println("[rand]", msg.Rand)
 	msg.SessionID = dState.Session.Id
 	msg.PListID = dState.PurchaseList.Id
 	if msg.DeletePrevious != nil {
-		delayMessage.SetLastDate(msg.PListID, msg.Rand)
-		replyDelayed(chatMsgID, msg)
+		delayMessage.SetLastDate(ctx, msg.PListID, msg.Rand)
+		replyDelayed(ctx, chatMsgID, msg)
 	} else {
-		log.Println("sending straight")
-		delayMessage.SetLastDate(msg.PListID, msg.Rand)
-		sent, err := reply(chatMsgID, msg)
-		if err == nil {
+		logger.Debug(ctx, "sending straight")
+		delayMessage.SetLastDate(ctx, msg.PListID, msg.Rand)
+		sent, err := reply(ctx, chatMsgID, msg)
+		if err == nil && sent.Chat != nil {
 			msgID := db.TgMsgID{
 				TgChatID:    sent.Chat.ID,
 				TgMessageID: sent.MessageID,
 			}
-			purchaseListService.AddMsgID(dState.PurchaseList.Id, msgID)
+			purchaseListService.AddMsgID(ctx, dState.PurchaseList.Id, msgID)
 		}
 	}
 }
 
-func createDialogStateFromMessage(m *dialog.MessageDto) (*dialog.DialogState, error) {
-	user, err := getOrRegisterUser(m.TgUser, m.TgContact)
+func createDialogStateFromMessage(ctx context.Context, m *dialog.MessageDto) (*dialog.DialogState, error) {
+	user, err := getOrRegisterUser(ctx, m.TgUser, m.TgContact)
 	if err != nil {
 		return nil, err
 	}
-	session, err := getOrCreateSession(user)
+	session, err := features.Session(ctx, user)
 	if err != nil {
 		return nil, err
 	}
 
-	purchaseList, err := createOrUpdateList(m, session)
+	if m.Document != nil && m.ImportToNewList {
+		session.PurchaseListId = primitive.NilObjectID
+	}
+	if m.Text != "" && session.PricePrompt != nil {
+		// an amount answers the question about the price, any other text is a new item
+		if amount, ok := price.Parse(m.Text); ok {
+			err = purchaseListService.SetPrice(ctx, session.PricePrompt.ListID, session.PricePrompt.Hash, amount)
+			if err != nil {
+				return nil, fmt.Errorf("failed to save a price: %w", err)
+			}
+			m.Text = ""
+		}
+		session.PricePrompt = nil
+	}
+	purchaseList, err := createOrUpdateList(ctx, m, session, features.UserLocation(user))
 	if err != nil {
 		return nil, err
 	}
@@ -279,10 +652,17 @@ func createDialogStateFromMessage(m *dialog.MessageDto) (*dialog.DialogState, er
 		Session:      session,
 		PurchaseList: purchaseList,
 	}
+	if m.Document != nil {
+		dState.Import, dState.ImportErr = importDocument(ctx, m.Document, purchaseList)
+		*purchaseList, err = purchaseListService.FindByID(ctx, purchaseList.Id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find a purchaseList: %w", err)
+		}
+	}
 	return &dState, nil
 }
 
-func getOrRegisterUser(tgUser *tgbotapi.User, tgContact *tgbotapi.Contact) (*db.User, error) {
+func getOrRegisterUser(ctx context.Context, tgUser *tgbotapi.User, tgContact *tgbotapi.Contact) (*db.User, error) {
 	phone := ""
 	if tgContact != nil {
 		phone = tgContact.PhoneNumber
@@ -294,39 +674,20 @@ func getOrRegisterUser(tgUser *tgbotapi.User, tgContact *tgbotapi.Contact) (*db.
 		Lang:      tgUser.LanguageCode,
 		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
 	}
-	err := userService.Upsert(&user)
+	err := userService.Upsert(ctx, &user)
 	if err != nil {
-		user, err = userService.FindByTgID(tgUser.ID)
+		user, err = userService.FindByTgID(ctx, tgUser.ID)
 	}
 
 	return &user, err
 }
-func getOrCreateSession(user *db.User) (*db.Session, error) {
-	session := db.Session{
-		UserId:         user.Id,
-		PostingState:   db.SessPStateNew,
-		PurchaseListId: primitive.NilObjectID,
-		CreatedAt:      primitive.NewDateTimeFromTime(time.Now()),
-	}
-	log.Println("getOrCreateSession")
-	err := sessionService.Create(&session)
-	if err != nil {
-		log.Println("failed to create session", err)
-		session, err = sessionService.FindByUserID(user.Id)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return &session, err
-}
-func updateSession(session *db.Session) error {
-	return sessionService.UpdateSession(session)
+func updateSession(ctx context.Context, session *db.Session) error {
+	return sessionService.UpdateSession(ctx, session)
 }
-func reply(chatMsgID dialog.ChatMessageID, forReply dialog.MessageForReply) (*tgbotapi.Message, error) {
-	return dialog.Reply(bot, chatMsgID, forReply)
+func reply(ctx context.Context, chatMsgID dialog.ChatMessageID, forReply dialog.MessageForReply) (*tgbotapi.Message, error) {
+	return sender.Reply(ctx, bot, chatMsgID, forReply)
 }
-func replyInline(chatMsgID dialog.ChatMessageID, forReply dialog.MessageForReply) (tgbotapi.APIResponse, error) {
+func replyInline(ctx context.Context, chatMsgID dialog.ChatMessageID, forReply dialog.MessageForReply, completions ...inlineCompletion) (tgbotapi.APIResponse, error) {
 	im := tgbotapi.InlineQueryResultArticle{
 		Type:  "article",
 		ID:    *chatMsgID.InlineMessageID,
@@ -341,17 +702,42 @@ func replyInline(chatMsgID dialog.ChatMessageID, forReply dialog.MessageForReply
 	}
 	var testR []interface{}
 	testR = append(testR, im)
-	return bot.AnswerInlineQuery(tgbotapi.InlineConfig{*chatMsgID.InlineMessageID, testR, 0, true, "", "", "para"})
+	for i, completion := range completions {
+		testR = append(testR, tgbotapi.InlineQueryResultArticle{
+			Type:  "article",
+			ID:    *chatMsgID.InlineMessageID + ":" + strconv.Itoa(i),
+			Title: "Дополнить: " + completion.name,
+			InputMessageContent: tgbotapi.InputTextMessageContent{
+				Text: completion.msg.Text,
+			},
+			ReplyMarkup: completion.msg.InlineKeyboard,
+			HideURL:     true,
+			Description: completion.msg.Text,
+		})
+	}
+	var resp tgbotapi.APIResponse
+	_, err := sender.Do(ctx, 0, "", "inline_answer", func() (tgbotapi.Message, error) {
+		var err error
+		resp, err = bot.AnswerInlineQuery(tgbotapi.InlineConfig{
+			InlineQueryID:     *chatMsgID.InlineMessageID,
+			Results:           testR,
+			IsPersonal:        true,
+			SwitchPMParameter: "para",
+		})
+		return tgbotapi.Message{}, err
+	})
+	return resp, err
 }
 
-func replyDelayed(chatMsgID dialog.ChatMessageID, forReply dialog.MessageForReply) {
-	go delayMessage.ExecItem(bot, chatMsgID, forReply)
+func replyDelayed(ctx context.Context, chatMsgID dialog.ChatMessageID, forReply dialog.MessageForReply) {
+	delayMessage.Schedule(ctx, bot, chatMsgID, forReply)
 }
-func createEmptyList(session *db.Session) (*db.PurchaseList, error) {
-	return purchaseListService.CreateEmptyList(session.UserId)
+func createEmptyList(ctx context.Context, session *db.Session) (*db.PurchaseList, error) {
+	return purchaseListService.CreateEmptyList(ctx, session.UserId)
 }
 
-func createOrUpdateList(m *dialog.MessageDto, session *db.Session) (*db.PurchaseList, error) {
+// createOrUpdateList adds the items of the message to the current list, the due times of the items are in loc
+func createOrUpdateList(ctx context.Context, m *dialog.MessageDto, session *db.Session, loc *time.Location) (*db.PurchaseList, error) {
 	var purchaseList db.PurchaseList
 	var err error
 	if session.PurchaseListId == primitive.NilObjectID || m.ChatMsgID.InlineMessageID != nil {
@@ -375,44 +761,59 @@ func createOrUpdateList(m *dialog.MessageDto, session *db.Session) (*db.Purchase
 		purchaseList.ItemsDictionary = []db.PurchaseItem{}
 		purchaseList.Items = []db.PurchaseItemHash{}
 		purchaseList.DeletedItemHashes = []db.PurchaseItemHash{}
-		err = purchaseListService.Create(&purchaseList)
+		err = purchaseListService.Create(ctx, &purchaseList)
 		if err != nil {
-			log.Println("Failed to insert a purchaseList", err)
-			return nil, errors.New("failed to save a purchaseList")
+			logger.Error(ctx, "failed to insert a purchaseList", "err", err)
+			return nil, fmt.Errorf("failed to save a purchaseList: %w", err)
 		}
 		session.PurchaseListId = purchaseList.Id
 	} else {
-		purchaseList, err = purchaseListService.FindByID(session.PurchaseListId)
+		purchaseList, err = purchaseListService.FindByID(ctx, session.PurchaseListId)
 		if err != nil {
 			return nil, err
 		}
 	}
 	switch session.PostingState {
 	case db.SessPStateCreation, db.SessPStateDone:
-		textItems := []string{}
-		textItems = append(textItems, createListFromText(m.Text)...)
+		textItems, ingredients := features.ListFromText(ctx, session.UserId, m.Text)
 		textItems = sanitizeList(textItems)
+		learned := features.LearnedCategories(ctx, session.UserId, len(textItems)+len(ingredients))
 		for _, textItem := range textItems {
+			textItem, due, dated := features.SplitDue(textItem, loc)
+			textItem, amount, priced := features.SplitPrice(textItem)
 			err = purchaseListService.AddItemToPurchaseList(
+				ctx,
 				purchaseList.Id,
 				db.PurchaseItemName(textItem),
+				features.ClassifyItem(textItem, learned),
 			)
 			if err != nil {
 				err = purchaseListService.AddItemToPurchaseList(
+					ctx,
 					purchaseList.Id,
 					db.PurchaseItemName(textItem),
+					features.ClassifyItem(textItem, learned),
 				)
-				log.Println("Failed to add an item", err)
+				logger.Warn(ctx, "failed to add an item", "err", err)
+			}
+			if err == nil && priced {
+				err = purchaseListService.SetPrice(ctx, purchaseList.Id, db.PurchaseItemHash(db.GetMD5Hash(textItem)), amount)
+			}
+			if err == nil && dated {
+				err = features.DueItem(ctx, &purchaseList, db.PurchaseItemHash(db.GetMD5Hash(textItem)), due)
 			}
 		}
+		if err == nil && len(ingredients) > 0 {
+			err = features.MergeIngredients(ctx, purchaseList.Id, ingredients, learned)
+		}
 	}
 	if err != nil {
 		return nil, err
 	}
 
-	purchaseList, err = purchaseListService.FindByID(purchaseList.Id)
+	purchaseList, err = purchaseListService.FindByID(ctx, purchaseList.Id)
 	if err != nil {
-		return nil, errors.New("failed to find a purchaseList " + err.Error())
+		return nil, fmt.Errorf("failed to find a purchaseList: %w", err)
 	}
 	return &purchaseList, nil
 }
@@ -442,198 +843,358 @@ func createFakeUpdate(envelope *MessageEnvelope) {
 	}
 }
 
-func crossOutItemFromPurchaseList(id primitive.ObjectID, itemHash string) (*db.PurchaseList, error) {
-	err := purchaseListService.CrossOutItemFromPurchaseList(id, itemHash)
+// crossOutItemFromPurchaseList tells whether the item was crossed out just now, the one who crossed it out paid for it
+func crossOutItemFromPurchaseList(ctx context.Context, id primitive.ObjectID, itemHash string, tgUser *tgbotapi.User) (*db.PurchaseList, bool, error) {
+	payer := &db.ListMember{TgID: tgUser.ID, Name: tgUser.FirstName}
+	crossed, err := purchaseListService.CrossOutItemFromPurchaseList(ctx, id, itemHash, payer)
 	if err != nil {
-		log.Println("failed to cross out", err)
+		logger.Error(ctx, "failed to cross out", "err", err)
+	}
+	pList, err := purchaseListService.FindByID(ctx, id)
+	if err == nil && crossed {
+		features.CrossedOut(ctx, &pList, db.PurchaseItemHash(itemHash))
+	}
+	return &pList, crossed, err
+}
+
+// inlineCompletion is an extra inline result with the last line of the query completed from the history
+type inlineCompletion struct {
+	name string
+	msg  dialog.MessageForReply
+}
+
+// completeInline offers lists with the item being typed completed, each of them is saved ahead as any inline result
+func completeInline(ctx context.Context, c *dialog.MessageHandler, m *dialog.MessageDto, dState *dialog.DialogState) []inlineCompletion {
+	lines := strings.Split(m.Text, "\n")
+	typed := sanitizeItem(lines[len(lines)-1])
+	if typed == "" {
+		return nil
+	}
+	var completions []inlineCompletion
+	for _, item := range features.SuggestItems(ctx, dState.User.Id, typed, dState.PurchaseList, maxInlineCompletions) {
+		lines[len(lines)-1] = string(item.Name)
+		pList, err := createInlineList(ctx, dState.Session.UserId, *m.ChatMsgID.InlineMessageID, strings.Join(lines, "\n"))
+		if err != nil {
+			logger.Warn(ctx, "failed to save a completed list", "err", err)
+			continue
+		}
+		completions = append(completions, inlineCompletion{
+			name: string(item.Name),
+			msg:  c.GetMessageForReply(m, nil, nil, pList),
+		})
+	}
+
+	return completions
+}
+
+func createInlineList(ctx context.Context, userID primitive.ObjectID, inlineMsgID string, text string) (*db.PurchaseList, error) {
+	names, ingredients := features.ListFromText(ctx, userID, text)
+	names = sanitizeList(names)
+	for _, ingredient := range ingredients {
+		names = append(names, sanitizeItem(ingredient.String()))
+	}
+	learned := features.LearnedCategories(ctx, userID, len(names))
+	now := primitive.NewDateTimeFromTime(time.Now())
+	pList := db.PurchaseList{
+		UserID:            userID,
+		InlineMsgID:       inlineMsgID,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		ItemsDictionary:   []db.PurchaseItem{},
+		Items:             []db.PurchaseItemHash{},
+		DeletedItemHashes: []db.PurchaseItemHash{},
+		Categories:        map[db.PurchaseItemHash]string{},
+		Prices:            map[db.PurchaseItemHash]int64{},
+	}
+	for _, name := range names {
+		name, amount, priced := features.SplitPrice(name)
+		hash := db.PurchaseItemHash(db.GetMD5Hash(name))
+		if _, added := pList.Categories[hash]; added {
+			continue
+		}
+		if priced {
+			pList.Prices[hash] = amount
+		}
+		pList.Categories[hash] = features.ClassifyItem(name, learned)
+		pList.ItemsDictionary = append(pList.ItemsDictionary, db.PurchaseItem{Name: db.PurchaseItemName(name), Hash: hash})
+		pList.Items = append(pList.Items, hash)
 	}
-	pList, err := purchaseListService.FindByID(id)
+	err := purchaseListService.Create(ctx, &pList)
+
 	return &pList, err
 }
 
 func getMessageChatId(envelope *MessageEnvelope) dialog.ChatMessageID {
 	return dialog.ChatMessageID{
-		&envelope.Update.Message.Chat.ID,
-		&envelope.Update.Message.MessageID,
-		nil,
+		ChatID:    &envelope.Update.Message.Chat.ID,
+		MessageID: &envelope.Update.Message.MessageID,
 	}
 }
 func getCallbackChatId(envelope *MessageEnvelope) dialog.ChatMessageID {
 	if envelope.Update.CallbackQuery.Message != nil {
-		log.Println("[getCallbackChatId] CbQ.Message")
 		return dialog.ChatMessageID{
-			&envelope.Update.CallbackQuery.Message.Chat.ID,
-			&envelope.Update.CallbackQuery.Message.MessageID,
-			nil,
+			ChatID:    &envelope.Update.CallbackQuery.Message.Chat.ID,
+			MessageID: &envelope.Update.CallbackQuery.Message.MessageID,
 		}
 	}
-	log.Println("[getCallbackChatId] CbQ.InlineMessageID")
 	return dialog.ChatMessageID{
-		nil,
-		nil,
-		&envelope.Update.CallbackQuery.InlineMessageID,
+		InlineMessageID: &envelope.Update.CallbackQuery.InlineMessageID,
 	}
 }
 
 func getInlineMessageChatId(query *tgbotapi.InlineQuery) dialog.ChatMessageID {
 	return dialog.ChatMessageID{
-		nil,
-		nil,
-		&query.ID,
+		InlineMessageID: &query.ID,
 	}
 }
 
-func readCallbackQuery(query *tgbotapi.CallbackQuery, c *dialog.MessageHandler) dialog.MessageForReply {
+func readCallbackQuery(ctx context.Context, query *tgbotapi.CallbackQuery, c *dialog.MessageHandler) dialog.MessageForReply {
 	cbQueryData := query.Data
 	m := dialog.MessageDto{UnknownContent: true}
-	log.Println("query data", cbQueryData)
 	if len(cbQueryData) < 24 {
-		log.Println("invalid CallbackQuery data length")
+		logger.Warn(ctx, "invalid CallbackQuery data length")
+		metrics.BotCallback.With(prometheus.Labels{"action": "invalid"}).Inc()
 
 		return dialog.MessageForReply{Text: "[Ошибка] Недостаточно данных"}
 	}
 	strListID := cbQueryData[:24]
-	log.Println("strListID", strListID)
 	itemHash := cbQueryData[25:]
 	listID, err := primitive.ObjectIDFromHex(strListID)
 	if err != nil {
-		log.Println("failed to read ID from CallbackQuery", err)
+		logger.Warn(ctx, "failed to read ID from CallbackQuery", "err", err)
 
 		return dialog.MessageForReply{Text: "[Ошибка] Некорректные данные"}
 	}
-	log.Println("listID", listID, "text", itemHash)
+	logger.Debug(ctx, "callback", "list_id", listID.Hex(), "action", itemHash)
 	cbAnswer := tgbotapi.CallbackConfig{CallbackQueryID: query.ID, Text: ""}
+	if msg, handled := features.Callback(ctx, query, c, listID, itemHash, &cbAnswer); handled {
+		return msg
+	}
 	if itemHash == dialog.ComFinishedCrossout {
-		_, session, _, err := getStateByList(listID)
+		metrics.BotCallback.With(prometheus.Labels{"action": "finished"}).Inc()
+		_, session, user, err := features.ListState(ctx, listID)
 		if err != nil {
-			cbAnswer.Text = "Ошибка"
-			log.Println(err)
+			cbAnswer.Text = dialog.ErrorText(err, "Ошибка")
+			logger.Error(ctx, "callback failed", "err", err)
 			return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: &cbAnswer}
 		}
 		msg := dialog.MessageForReply{NewMessage: true, Text: "Введите название товара или список", AnswerCallback: &cbAnswer}
 		session.PreviousState = session.PostingState
 		session.PostingState = db.SessPStateCreation
 		session.PurchaseListId = primitive.NilObjectID
-		err = updateSession(session)
+		err = updateSession(ctx, session)
 		if err != nil {
-			cbAnswer.Text = "Ошибка обновления сессии, попробуйте ещё раз"
-			log.Println(err)
+			cbAnswer.Text = dialog.ErrorText(err, "Ошибка обновления сессии, попробуйте ещё раз")
+			logger.Error(ctx, "callback failed", "err", err)
 			return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: &cbAnswer}
 		}
-		return msg
+		return features.AddSuggestions(ctx, msg, user, listID)
+	} else if strings.HasPrefix(itemHash, dialog.CbExport) {
+		metrics.BotCallback.With(prometheus.Labels{"action": "export"}).Inc()
+		return exportList(ctx, query, listID, strings.TrimPrefix(itemHash, dialog.CbExport), &cbAnswer)
 	} else { //element is crossed out
-		purchaseList, err := crossOutItemFromPurchaseList(listID, itemHash)
+		metrics.BotCallback.With(prometheus.Labels{"action": "cross_out"}).Inc()
+		purchaseList, crossed, err := crossOutItemFromPurchaseList(ctx, listID, itemHash, query.From)
 		if err != nil {
-			cbAnswer.Text = "Ошибка, попробуйте ещё раз или нажмите /clear"
-			log.Println(err)
+			cbAnswer.Text = dialog.ErrorText(err, "Ошибка, попробуйте ещё раз или нажмите /clear")
+			logger.Error(ctx, "callback failed", "err", err)
 			return dialog.MessageForReply{NewMessage: false, Text: "failed to cross out an item", AnswerCallback: &cbAnswer}
 		}
+		if crossed {
+			features.PromptPrice(ctx, query, purchaseList, db.PurchaseItemHash(itemHash), c)
+		}
 		msg := c.GetMessageForReply(&m, nil, nil, purchaseList)
-		log.Println("[PLIST]", purchaseList.InlineMsgID)
 		if purchaseList.InlineMsgID != "" {
 			//copypaste
-			_, session, _, err := getStateByList(listID)
+			_, session, _, err := features.ListState(ctx, listID)
 			if err != nil {
-				cbAnswer.Text = "Ошибка"
-				log.Println(err)
+				cbAnswer.Text = dialog.ErrorText(err, "Ошибка")
+				logger.Error(ctx, "callback failed", "err", err)
 				return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: &cbAnswer}
 			}
 			session.PreviousState = session.PostingState
 			session.PostingState = db.SessPStateCreation
 			session.PurchaseListId = primitive.NilObjectID
-			err = updateSession(session)
+			err = updateSession(ctx, session)
 			if err != nil {
-				cbAnswer.Text = "Ошибка обновления сессии, попробуйте ещё раз"
-				log.Println(err)
+				cbAnswer.Text = dialog.ErrorText(err, "Ошибка обновления сессии, попробуйте ещё раз")
+				logger.Error(ctx, "callback failed", "err", err)
 				return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: &cbAnswer}
 			}
 		}
 
 		msg.AnswerCallback = &cbAnswer
-		log.Println("crossed out")
 
 		return msg
 	}
 }
 
-func createListFromText(text string) []string {
-	list := strings.Split(text, "\n")
-	return getUniqueItemsFromListBoundedToMax(list)
-}
+// exportList sends the list as a file, only to the owner and the members of the list
+func exportList(ctx context.Context, query *tgbotapi.CallbackQuery, listID primitive.ObjectID, strFormat string, cbAnswer *tgbotapi.CallbackConfig) dialog.MessageForReply {
+	if !cfg.Features.Export {
+		cbAnswer.Text = "Выгрузка отключена"
+		return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: cbAnswer}
+	}
+	format, err := export.ParseFormat(strFormat)
+	if err != nil {
+		cbAnswer.Text = "Неизвестный формат"
+		logger.Warn(ctx, "export failed", "err", err)
+		return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: cbAnswer}
+	}
+	purchaseList, _, owner, err := features.ListState(ctx, listID)
+	if err == nil && !feature.IsListMember(purchaseList, owner, query.From.ID) {
+		err = dialog.ErrNotYours
+	}
+	if err != nil {
+		cbAnswer.Text = dialog.ErrorText(err, "Список не найден")
+		logger.Warn(ctx, "export failed", "err", err)
+		return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: cbAnswer}
+	}
+	content, err := export.Export(purchaseList, format)
+	if err != nil {
+		cbAnswer.Text = "Не удалось выгрузить список"
+		logger.Warn(ctx, "export failed", "err", err)
+		return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: cbAnswer}
+	}
 
-func sanitizeList(list []string) []string {
-	var result []string
-	for _, text := range list {
-		text = stripmd.Strip(text)
-		text = strings.Trim(text, "\n\t")
-		//crazy way to deal with long strings with emojis
-		if len(text) > 30 {
-			text = string([]rune(text)[:30])
-			text = strings.Trim(text, "\u0000")
+	return dialog.MessageForReply{
+		NewMessage:     true,
+		Document:       &tgbotapi.FileBytes{Name: export.FileName(purchaseList, format), Bytes: content},
+		AnswerCallback: cbAnswer,
+	}
+}
 
-			text += "…"
-		}
+func importDocument(ctx context.Context, document *tgbotapi.Document, purchaseList *db.PurchaseList) (*importer.Result, error) {
+	if bot == nil {
+		return nil, errors.New("No bot")
+	}
+	if document.FileSize > importer.MaxFileSize {
+		return nil, errors.New("file is too big")
+	}
+	content, err := downloadFile(ctx, document.FileID)
+	if err != nil {
+		return nil, err
+	}
+	result, err := importer.Parse(document.FileName, document.MimeType, content)
+	if err != nil {
+		return nil, err
+	}
+	count := len(purchaseList.Items)
+	learned := features.LearnedCategories(ctx, purchaseList.UserID, len(result.Items))
+	for _, item := range result.Items {
+		text := sanitizeItem(item.String())
 		if text == "" {
+			result.Reject(item.Name, "пустое название")
 			continue
 		}
-		result = append(result, text)
-	}
-	return getUniqueItemsFromListBoundedToMax(result)
-}
-
-func getUniqueItemsFromListBoundedToMax(list []string) []string {
-	var result []string
-	keys := make(map[string]string)
-	i := 0
-	for _, key := range list {
-		hash := db.GetMD5Hash(key)
-		if _, found := keys[hash]; !found {
-			keys[hash] = key
-			result = append(result, key)
-
-			i++
-			if i >= MaxCountOfItemsInList {
-				break
+		if count >= cfg.List.MaxItems {
+			result.Reject(text, "в списке уже "+strconv.Itoa(cfg.List.MaxItems)+" товаров")
+			continue
+		}
+		text, amount, priced := features.SplitPrice(text)
+		err = purchaseListService.AddItemToPurchaseList(ctx, purchaseList.Id, db.PurchaseItemName(text), features.ClassifyItem(text, learned))
+		if err != nil {
+			logger.Warn(ctx, "failed to add an item", "err", err)
+			result.Reject(text, "не удалось сохранить")
+			continue
+		}
+		if priced {
+			err = purchaseListService.SetPrice(ctx, purchaseList.Id, db.PurchaseItemHash(db.GetMD5Hash(text)), amount)
+			if err != nil {
+				logger.Warn(ctx, "failed to save a price", "err", err)
+			}
+		}
+		if item.CrossedOut {
+			_, err = purchaseListService.CrossOutItemFromPurchaseList(ctx, purchaseList.Id, db.GetMD5Hash(text), nil)
+			if err != nil {
+				logger.Warn(ctx, "failed to cross out", "err", err)
 			}
 		}
+		count++
+		result.Added++
 	}
 
-	return result
+	return result, nil
 }
 
-func getStateByList(listID primitive.ObjectID) (*db.PurchaseList, *db.Session, *db.User, error) {
-	var pList db.PurchaseList
-	var user db.User
-	pList, err := purchaseListService.FindByID(listID)
+// downloadFile reads a file sent to the bot. The url of the file holds the bot token,
+// so it is cut out of the errors, they are logged and kept in the dialog state.
+func downloadFile(ctx context.Context, fileID string) ([]byte, error) {
+	fileURL, err := bot.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("file lookup failed: %w", withoutURL(err))
+	}
+	ctx, cancel := context.WithTimeout(ctx, downloadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
 	if err != nil {
-		return nil, nil, nil, errors.New("failed to find a purchaseList" + listID.Hex())
+		return nil, errors.New("download failed: invalid file url")
 	}
-	user, err = userService.FindByID(pList.UserID)
+	resp, err := downloadClient.Do(req)
 	if err != nil {
-		return nil, nil, nil, errors.New("failed to find a user")
+		return nil, fmt.Errorf("download failed: %w", withoutURL(err))
 	}
-	session, err := getOrCreateSession(&user)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed: %s", resp.Status)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, importer.MaxFileSize+1))
 	if err != nil {
-		return nil, nil, nil, errors.New("failed to find a session")
+		return nil, fmt.Errorf("download failed: %w", withoutURL(err))
+	}
+
+	return content, nil
+}
+
+// withoutURL drops the url of a failed request, which holds the bot token, and keeps the cause
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+func sanitizeList(list []string) []string {
+	var result []string
+	for _, text := range list {
+		text = sanitizeItem(text)
+		if text == "" {
+			continue
+		}
+		result = append(result, text)
 	}
+	return dialog.UniqueItems(result, cfg.List.MaxItems)
+}
 
-	return &pList, session, &user, err
+func sanitizeItem(text string) string {
+	return dialog.SanitizeItem(text, cfg.List.ItemMaxLength)
 }
 
-func deleteMessage(listID primitive.ObjectID, prevPList *db.PurchaseList) error {
+func deleteMessage(ctx context.Context, listID primitive.ObjectID, prevPList *db.PurchaseList) error {
 	if bot == nil {
-		log.Println("[No bot] ")
 		return errors.New("No bot")
 	}
-	log.Println("[message] DELETE")
 
 	var err error
 	for _, id := range prevPList.TgMsgID {
 		if id.IsInitial == false {
-			_, err = bot.DeleteMessage(tgbotapi.NewDeleteMessage(id.TgChatID, id.TgMessageID))
+			deleteConfig := tgbotapi.NewDeleteMessage(id.TgChatID, id.TgMessageID)
+			_, err = sender.Do(ctx, id.TgChatID, "", "delete", func() (tgbotapi.Message, error) {
+				_, err := bot.DeleteMessage(deleteConfig)
+				return tgbotapi.Message{}, err
+			})
 		}
-		log.Println("[message] DELETE one", err)
-		_ = purchaseListService.DeleteMsgID(listID, id)
+		if err != nil {
+			logger.Warn(ctx, "failed to delete a message", "err", err)
+		}
+		_ = purchaseListService.DeleteMsgID(ctx, listID, id)
 	}
 	return err
 }
+
+// fatal stops the bot on a startup error
+func fatal(ctx context.Context, msg string, err error) {
+	logger.Error(ctx, msg, "err", err)
+	os.Exit(1)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/boryashkin/purchaselist/cache"
+	"github.com/boryashkin/purchaselist/config"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/dialog"
+	"github.com/boryashkin/purchaselist/fakemongo"
+	"github.com/boryashkin/purchaselist/feature"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// BenchmarkUpdate counts the round trips to mongo of a text and a callback update
+// with the cache of lists and sessions on and off
+func BenchmarkUpdate(b *testing.B) {
+	for _, cached := range []bool{false, true} {
+		name := "cache_off"
+		if cached {
+			name = "cache_on"
+		}
+		b.Run(name+"/text", func(b *testing.B) {
+			benchmarkUpdate(b, cached, handleText)
+		})
+		b.Run(name+"/callback", func(b *testing.B) {
+			benchmarkUpdate(b, cached, handleCrossOut)
+		})
+	}
+}
+
+func benchmarkUpdate(b *testing.B, cached bool, handle func(ctx context.Context, c *dialog.MessageHandler, state *fakeState) error) {
+	mongoDB, state := setupFakeMongo(b, cached)
+	c := dialog.NewMessageHandler(nil, &purchaseListService, cfg)
+	ctx := context.Background()
+	// the first update fills the cache like any update of an active user
+	err := handle(ctx, &c, state)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	start := mongoDB.Count()
+	for i := 0; i < b.N; i++ {
+		err = handle(ctx, &c, state)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(mongoDB.Count()-start)/float64(b.N), "mongo_calls/update")
+}
+
+// fakeState is what the fake mongo returns for every read
+type fakeState struct {
+	user    db.User
+	session db.Session
+	list    db.PurchaseList
+}
+
+// handleText does the db part of handleAsync for a new item, the replies are left out
+func handleText(ctx context.Context, c *dialog.MessageHandler, state *fakeState) error {
+	chatID, messageID := int64(state.user.TgId), 1
+	m := dialog.MessageDto{
+		ChatMsgID: dialog.ChatMessageID{ChatID: &chatID, MessageID: &messageID},
+		TgUser:    &tgbotapi.User{ID: state.user.TgId, FirstName: state.user.Name},
+		Text:      "хлеб",
+	}
+	dState, err := createDialogStateFromMessage(ctx, &m)
+	if err != nil {
+		return err
+	}
+	st := c.GetNewStateByMessage(ctx, &m, dState)
+	err = updateSession(ctx, st.Session)
+	if err != nil {
+		return err
+	}
+	c.GetMessageForReply(&m, dState.Session, dState.User, dState.PurchaseList)
+
+	return purchaseListService.AddMsgID(ctx, dState.PurchaseList.Id, db.TgMsgID{TgChatID: chatID, TgMessageID: messageID + 1})
+}
+
+// handleCrossOut presses an item of the list
+func handleCrossOut(ctx context.Context, c *dialog.MessageHandler, state *fakeState) error {
+	query := &tgbotapi.CallbackQuery{
+		ID:   "1",
+		From: &tgbotapi.User{ID: state.user.TgId},
+		Data: state.list.Id.Hex() + ":" + string(state.list.Items[0]),
+	}
+	msg := readCallbackQuery(ctx, query, c)
+	if msg.AnswerCallback == nil || msg.AnswerCallback.Text != "" {
+		return errors.New("the item is not crossed out")
+	}
+	return nil
+}
+
+// setupFakeMongo points the services of the bot at a fake mongo holding one user with a list of a few items
+func setupFakeMongo(tb testing.TB, cached bool) (*fakemongo.Deployment, *fakeState) {
+	logger.Setup(ioutil.Discard, logger.LevelError, logger.FormatJSON)
+	cfg = &config.Config{}
+	*cfg = config.Default()
+	// the features read their own collections, the same way with and without the cache
+	cfg.Features = config.Features{}
+	userID, listID := primitive.NewObjectID(), primitive.NewObjectID()
+	state := &fakeState{
+		user:    db.User{Id: userID, TgId: 42, Name: "Bench"},
+		session: db.Session{Id: primitive.NewObjectID(), UserId: userID, PostingState: db.SessPStateCreation, PurchaseListId: listID},
+		list:    db.PurchaseList{Id: listID, UserID: userID, TgMsgID: []db.TgMsgID{}},
+	}
+	for _, name := range []string{"молоко", "яйца", "сыр"} {
+		hash := db.PurchaseItemHash(db.GetMD5Hash(name))
+		state.list.ItemsDictionary = append(state.list.ItemsDictionary, db.PurchaseItem{Name: db.PurchaseItemName(name), Hash: hash})
+		state.list.Items = append(state.list.Items, hash)
+	}
+	mongoDB := fakemongo.New(map[string]interface{}{
+		db.ColUsers:    state.user,
+		db.ColSessions: state.session,
+		db.ColProducts: state.list,
+	})
+	client, err := fakemongo.Connect(context.Background(), mongoDB)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		client.Disconnect(context.Background())
+	})
+	database := client.Database(db.DbName)
+	userService = db.NewUserService(database.Collection(db.ColUsers))
+	sessionService = db.NewSessionService(database.Collection(db.ColSessions))
+	purchaseListService = db.NewPurchaseListService(database.Collection(db.ColProducts))
+	if cached {
+		purchaseListService.EnableCache(cache.New("purchase_lists", cfg.Cache.Size, time.Hour))
+		sessionService.EnableCache(cache.New("sessions", cfg.Cache.Size, time.Hour))
+	}
+	features = feature.NewHandler(feature.Services{
+		Users:    &userService,
+		Sessions: &sessionService,
+		Lists:    &purchaseListService,
+	}, cfg, nil, nil, nil)
+
+	return mongoDB, state
+}
@@ -0,0 +1,130 @@
+// Package cache keeps recently read documents in memory, so the db services
+// don't read the same list or session several times per update.
+//
+// Entries expire after a TTL and the least recently used ones are evicted
+// when the cache is full. Writes put the documents they change with Put or,
+// when the result is unknown, invalidate them; a value read before either
+// is not stored after it.
+package cache
+
+import (
+	"container/list"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+// LRU is safe for concurrent use, a nil LRU caches nothing
+type LRU struct {
+	name  string
+	size  int
+	ttl   time.Duration
+	mu    sync.Mutex
+	order *list.List
+	items map[string]*list.Element
+	// generation grows with every invalidation
+	generation uint64
+}
+
+type entry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+// New makes a cache of up to size entries, name labels its metrics
+func New(name string, size int, ttl time.Duration) *LRU {
+	return &LRU{
+		name:  name,
+		size:  size,
+		ttl:   ttl,
+		order: list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+func (c *LRU) Get(key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, found := c.items[key]
+	if found && time.Now().After(el.Value.(*entry).expiresAt) {
+		c.remove(el)
+		metrics.CacheEvicted.With(prometheus.Labels{"cache": c.name, "reason": "expired"}).Inc()
+		found = false
+	}
+	if !found {
+		metrics.CacheRequests.With(prometheus.Labels{"cache": c.name, "result": "miss"}).Inc()
+		return nil, false
+	}
+	metrics.CacheRequests.With(prometheus.Labels{"cache": c.name, "result": "hit"}).Inc()
+	c.order.MoveToFront(el)
+	return el.Value.(*entry).value, true
+}
+
+// Generation is taken before reading a value from the db and passed to Set
+func (c *LRU) Generation() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// Set stores the value unless something was invalidated since the generation was taken,
+// the value could be read before that write
+func (c *LRU) Set(key string, value interface{}, generation uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if el, found := c.items[key]; found {
+		c.remove(el)
+	}
+	c.items[key] = c.order.PushFront(&entry{key: key, value: value, expiresAt: time.Now().Add(c.ttl)})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+		metrics.CacheEvicted.With(prometheus.Labels{"cache": c.name, "reason": "size"}).Inc()
+	}
+	metrics.CacheSize.With(prometheus.Labels{"cache": c.name}).Set(float64(c.order.Len()))
+}
+
+// Put stores the value just written, reads started before the write don't overwrite it
+func (c *LRU) Put(key string, value interface{}) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.generation++
+	generation := c.generation
+	c.mu.Unlock()
+	c.Set(key, value, generation)
+}
+
+// Invalidate drops the entry, it is called once a write of the document is done
+func (c *LRU) Invalidate(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if el, found := c.items[key]; found {
+		c.remove(el)
+		metrics.CacheSize.With(prometheus.Labels{"cache": c.name}).Set(float64(c.order.Len()))
+	}
+}
+
+// remove is called with mu held
+func (c *LRU) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*entry).key)
+}
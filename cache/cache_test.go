@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	const ttl = 10 * time.Millisecond
+	// the steps are: "gen" takes the generation, "set k v" stores a read with the last one taken,
+	// "put k v", "inv k", "get k v" and "miss k" check the cache, "wait" lets the TTL pass
+	tests := []struct {
+		name  string
+		size  int
+		steps []string
+	}{
+		{
+			name:  "a read is stored",
+			size:  2,
+			steps: []string{"miss a", "gen", "set a 1", "get a 1", "miss b"},
+		},
+		{
+			name:  "the least recently used is evicted",
+			size:  2,
+			steps: []string{"gen", "set a 1", "set b 2", "get a 1", "set c 3", "miss b", "get a 1", "get c 3"},
+		},
+		{
+			name:  "an entry expires",
+			size:  2,
+			steps: []string{"gen", "set a 1", "wait", "miss a"},
+		},
+		{
+			name:  "an invalidated entry is dropped",
+			size:  2,
+			steps: []string{"gen", "set a 1", "inv a", "miss a"},
+		},
+		{
+			name:  "a read started before an invalidation is not stored",
+			size:  2,
+			steps: []string{"gen", "inv b", "set a 1", "miss a"},
+		},
+		{
+			name:  "a write replaces the entry",
+			size:  2,
+			steps: []string{"gen", "set a 1", "put a 2", "get a 2"},
+		},
+		{
+			name:  "a read started before a write doesn't overwrite it",
+			size:  2,
+			steps: []string{"gen", "put a 2", "set a 1", "get a 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New("test", tt.size, ttl)
+			var generation uint64
+			for i, step := range tt.steps {
+				args := strings.Fields(step)
+				switch args[0] {
+				case "gen":
+					generation = c.Generation()
+				case "set":
+					c.Set(args[1], args[2], generation)
+				case "put":
+					c.Put(args[1], args[2])
+				case "inv":
+					c.Invalidate(args[1])
+				case "get":
+					if value, found := c.Get(args[1]); !found || value != args[2] {
+						t.Fatalf("step %d: Get(%s) = %v, %v, want %s", i, args[1], value, found, args[2])
+					}
+				case "miss":
+					if value, found := c.Get(args[1]); found {
+						t.Fatalf("step %d: Get(%s) = %v, want a miss", i, args[1], value)
+					}
+				case "wait":
+					time.Sleep(2 * ttl)
+				}
+			}
+		})
+	}
+}
+
+func TestNilLRU(t *testing.T) {
+	var c *LRU
+	c.Set("a", 1, c.Generation())
+	c.Put("b", 2)
+	c.Invalidate("a")
+	for _, key := range []string{"a", "b"} {
+		if value, found := c.Get(key); found {
+			t.Errorf("Get(%s) = %v, want a miss", key, value)
+		}
+	}
+}
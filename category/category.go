@@ -0,0 +1,173 @@
+// Package category sorts purchase items into store departments.
+//
+// An item name is matched against stems of a built-in dictionary: a stem
+// matches when a word of the name starts with it, the longest stem wins.
+// Words learned from a user are checked before the dictionary, so a user
+// can both extend it and correct it.
+package category
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Other holds the items no stem matched, it always goes last
+const Other = "other"
+
+type Category struct {
+	ID    string
+	Title string
+}
+
+// All categories in the default store-walk order
+var All = []Category{
+	{ID: "produce", Title: "🥦 Овощи и фрукты"},
+	{ID: "bakery", Title: "🍞 Хлеб и выпечка"},
+	{ID: "meat", Title: "🥩 Мясо и рыба"},
+	{ID: "dairy", Title: "🥛 Молочное и яйца"},
+	{ID: "grocery", Title: "🍝 Бакалея"},
+	{ID: "sweets", Title: "🍫 Сладости"},
+	{ID: "drinks", Title: "🧃 Напитки"},
+	{ID: "frozen", Title: "🧊 Заморозка"},
+	{ID: "household", Title: "🧽 Для дома"},
+	{ID: "hygiene", Title: "🧴 Гигиена"},
+	{ID: Other, Title: "📦 Прочее"},
+}
+
+// DefaultOrder is the walk through a typical supermarket, without Other
+func DefaultOrder() []string {
+	var order []string
+	for _, c := range All {
+		if c.ID != Other {
+			order = append(order, c.ID)
+		}
+	}
+	return order
+}
+
+var dictionary = map[string][]string{
+	"produce": {
+		"яблок", "банан", "апельсин", "мандарин", "лимон", "груш", "виноград", "картош", "картоф", "морков",
+		"лук", "чеснок", "капуст", "огур", "помидор", "томат", "перец", "кабач", "баклажан", "свекл", "зелен",
+		"укроп", "петрушк", "салат", "авокадо", "киви", "ягод", "клубник", "малин", "гриб", "шампиньон",
+	},
+	"bakery": {
+		"хлеб", "батон", "булк", "булочк", "лаваш", "багет", "круассан", "пирог", "лепешк", "сухар", "бородинск",
+	},
+	"meat": {
+		"мяс", "говяд", "свинин", "куриц", "курин", "индейк", "филе", "фарш", "колбас", "сосис", "сардельк",
+		"ветчин", "бекон", "рыб", "лосос", "семг", "форел", "треск", "селедк", "сельд", "креветк", "кальмар",
+	},
+	"dairy": {
+		"молок", "кефир", "йогурт", "творог", "творож", "сметан", "сливк", "сыр", "масло сливочн", "сливочное масло",
+		"ряженк", "простокваш", "айран", "яйц", "яйца",
+	},
+	"grocery": {
+		"макарон", "спагетти", "паста", "лапш", "рис", "гречк", "греча", "крупа", "овсянк", "мука", "сахар", "соль",
+		"масло", "подсолнечн", "оливков", "чай", "кофе", "консерв", "тушенк", "фасол", "горох", "кетчуп",
+		"майонез", "горчиц", "соус", "специ", "перец молот", "хлопья", "мюсли", "орех", "мед",
+	},
+	"sweets": {
+		"шоколад", "конфет", "печень", "торт", "мармелад", "зефир", "вафл", "пряник", "сушк", "халв",
+	},
+	"drinks": {
+		"вода", "воды", "минералк", "сок", "лимонад", "газировк", "кола", "квас", "морс", "компот", "пиво", "вино",
+		"водк", "коньяк",
+	},
+	"frozen": {
+		"пельмен", "вареник", "морожен", "заморож", "наггетс", "лед",
+	},
+	"household": {
+		"губк", "моющ", "порошок", "стирал", "кондиционер для бель", "отбелив", "бумажн полотенц", "салфетк",
+		"пакет", "мешки для мусора", "фольг", "пленк", "батарейк", "лампочк", "средство", "освежител", "свечи",
+	},
+	"hygiene": {
+		"шампун", "мыло", "зубн", "зубная паста", "дезодорант", "бритв", "прокладк", "тампон", "подгузн", "туалетн",
+		"гель для душ", "крем", "ватн", "пластыр",
+	},
+}
+
+// Find returns the category by its id, title or the first words of the title, ignoring the case and the emoji
+func Find(text string) (Category, bool) {
+	text = Normalize(text)
+	if text == "" {
+		return Category{}, false
+	}
+	for _, c := range All {
+		title := Normalize(c.Title)
+		if text == c.ID || text == title || strings.HasPrefix(title, text+" ") {
+			return c, true
+		}
+	}
+	return Category{}, false
+}
+
+// Title of a category id, an unknown id is titled as Other
+func Title(id string) string {
+	for _, c := range All {
+		if c.ID == id {
+			return c.Title
+		}
+	}
+	return Title(Other)
+}
+
+// Classify returns the category of an item, learned maps words of a user to category ids
+func Classify(name string, learned map[string]string) string {
+	name = Normalize(name)
+	if id := match(name, learned); id != "" {
+		return id
+	}
+	best, bestLen := Other, 0
+	// in the order of All, so a tie is resolved the same way every time
+	for _, c := range All {
+		for _, stem := range dictionary[c.ID] {
+			if len(stem) > bestLen && hasWordPrefix(name, stem) {
+				best, bestLen = c.ID, len(stem)
+			}
+		}
+	}
+	return best
+}
+
+func match(name string, learned map[string]string) string {
+	if id, found := learned[name]; found {
+		return id
+	}
+	best, bestWord := "", ""
+	for word, id := range learned {
+		longer := len(word) > len(bestWord) || (len(word) == len(bestWord) && word < bestWord)
+		if longer && hasWordPrefix(name, word) {
+			best, bestWord = id, word
+		}
+	}
+	return best
+}
+
+func hasWordPrefix(name string, stem string) bool {
+	return strings.HasPrefix(name, stem) || strings.Contains(name, " "+stem)
+}
+
+// Normalize lowercases the text and keeps only the words, ё is written as е
+func Normalize(text string) string {
+	text = strings.ReplaceAll(strings.ToLower(text), "ё", "е")
+	return strings.Join(strings.FieldsFunc(text, func(r rune) bool { return !unicode.IsLetter(r) }), " ")
+}
+
+// Order puts category ids in the walk order, the ones missing from it go after, Other goes last
+func Order(order []string) []string {
+	seen := map[string]bool{Other: true}
+	var result []string
+	for _, id := range order {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	for _, c := range All {
+		if !seen[c.ID] {
+			result = append(result, c.ID)
+		}
+	}
+	return append(result, Other)
+}
@@ -0,0 +1,353 @@
+// Package cluster coordinates several bot instances sharing one database.
+//
+// Leader keeps a lease for jobs which must run on a single instance at a time,
+// such as long polling. Locker serializes updates of the same user in the order
+// they are received, so a list is changed and rendered in the order of its updates.
+package cluster
+
+import (
+	"context"
+	"errors"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/tracing"
+	"sync"
+	"time"
+)
+
+// ErrTurnPassed is returned by Turn.Wait when the others passed the turn over, as it looked stuck
+var ErrTurnPassed = errors.New("the turn was passed over")
+
+// Locker serializes the updates of a key, such as a user, in the order their turns are taken
+type Locker interface {
+	// Join takes the next turn of the key, it is called in the order the updates are received
+	Join(ctx context.Context, key string) (Turn, error)
+}
+
+// Turn is a place in the queue of a key
+type Turn interface {
+	// Wait blocks until the turns taken before are done and returns a function passing the turn on.
+	// The turn is given up when the context is done first.
+	Wait(ctx context.Context) (func(), error)
+}
+
+// Lock takes a turn of the key and waits for it
+func Lock(ctx context.Context, locker Locker, key string) (func(), error) {
+	turn, err := locker.Join(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return turn.Wait(ctx)
+}
+
+// Leases are held by one holder at a time until they expire, db.LeaseService keeps them in mongo
+type Leases interface {
+	// Acquire takes a free or expired lease or prolongs the own one, false means someone else holds it
+	Acquire(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, name string, holder string) error
+}
+
+// Tickets are the queues of the keys shared by the instances, db.TicketService keeps them in mongo
+type Tickets interface {
+	// Take returns the next ticket of the key, tickets start from 0
+	Take(ctx context.Context, key string) (int64, error)
+	// Served returns the ticket whose turn it is, when the turn last moved or was touched,
+	// and whether the ticket was abandoned by its instance
+	Served(ctx context.Context, key string) (served int64, movedAt time.Time, abandoned bool, err error)
+	// Serve passes the turn from the ticket to the next one, false means the turn is not at the ticket
+	Serve(ctx context.Context, key string, ticket int64) (bool, error)
+	// Touch tells the others the holder of the turn is alive
+	Touch(ctx context.Context, key string, ticket int64) error
+	// Abandon marks a ticket nobody waits for anymore, its turn is passed on as soon as it comes.
+	// It returns false when the turn is at the ticket already.
+	Abandon(ctx context.Context, key string, ticket int64) (bool, error)
+}
+
+// Processed records handled updates, db.UpdateService keeps them in mongo
+type Processed interface {
+	// IsProcessed tells whether the update was handled already, by this or another instance
+	IsProcessed(ctx context.Context, updateID int) (bool, error)
+	// MarkProcessed records the update after it is handled
+	MarkProcessed(ctx context.Context, updateID int) error
+}
+
+// Handle waits for the turn of an update and calls handle unless the update is a duplicate.
+// The update is marked processed only after handle returns, so an update lost in a crash is handled
+// again when it is redelivered. It returns false for a duplicate, and an error without handling
+// the update when the turn doesn't come.
+func Handle(ctx context.Context, turn Turn, processed Processed, updateID int, handle func(ctx context.Context)) (bool, error) {
+	lockCtx, lockSpan := tracing.Start(ctx, "update.lock")
+	unlock, err := turn.Wait(lockCtx)
+	tracing.End(lockSpan, err)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	done, err := processed.IsProcessed(ctx, updateID)
+	if err != nil {
+		// handling twice is better than losing an update while the db hiccups
+		logger.Error(ctx, "failed to check the update", "err", err)
+	} else if done {
+		logger.Info(ctx, "skip a duplicate update")
+		return false, nil
+	}
+	handle(ctx)
+	err = processed.MarkProcessed(ctx, updateID)
+	if err != nil {
+		logger.Error(ctx, "failed to mark the update processed", "err", err)
+	}
+
+	return true, nil
+}
+
+// MemoryLocker serializes goroutines of a single instance
+type MemoryLocker struct {
+	mu     sync.Mutex
+	queues map[string]*memoryQueue
+}
+
+type memoryQueue struct {
+	taken     int64
+	served    int64
+	abandoned map[int64]bool
+	// changed is closed when the turn moves
+	changed chan struct{}
+}
+
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{queues: map[string]*memoryQueue{}}
+}
+
+func (l *MemoryLocker) Join(ctx context.Context, key string) (Turn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	q, found := l.queues[key]
+	if !found {
+		q = &memoryQueue{abandoned: map[int64]bool{}, changed: make(chan struct{})}
+		l.queues[key] = q
+	}
+	ticket := q.taken
+	q.taken++
+
+	return &memoryTurn{locker: l, key: key, queue: q, ticket: ticket}, nil
+}
+
+type memoryTurn struct {
+	locker *MemoryLocker
+	key    string
+	queue  *memoryQueue
+	ticket int64
+}
+
+func (t *memoryTurn) Wait(ctx context.Context) (func(), error) {
+	l := t.locker
+	for {
+		l.mu.Lock()
+		if t.queue.served == t.ticket {
+			l.mu.Unlock()
+			return func() {
+				l.mu.Lock()
+				l.pass(t.key, t.queue)
+				l.mu.Unlock()
+			}, nil
+		}
+		changed := t.queue.changed
+		l.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			l.mu.Lock()
+			if t.queue.served == t.ticket {
+				l.pass(t.key, t.queue)
+			} else {
+				t.queue.abandoned[t.ticket] = true
+			}
+			l.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// pass moves the turn to the next ticket somebody waits for, the queue is dropped once it is empty
+func (l *MemoryLocker) pass(key string, q *memoryQueue) {
+	q.served++
+	for q.abandoned[q.served] {
+		delete(q.abandoned, q.served)
+		q.served++
+	}
+	close(q.changed)
+	q.changed = make(chan struct{})
+	if q.served == q.taken {
+		delete(l.queues, key)
+	}
+}
+
+// TicketLocker serializes all the instances through the queues of tickets in the database
+type TicketLocker struct {
+	tickets Tickets
+	wait    time.Duration
+}
+
+// NewTicketLocker makes a locker which passes over a turn stuck for longer than wait,
+// as its instance is considered dead. The holder of a turn touches it every wait/3 while it runs.
+func NewTicketLocker(tickets Tickets, wait time.Duration) *TicketLocker {
+	return &TicketLocker{tickets: tickets, wait: wait}
+}
+
+// Join retries until the context is done, an update without a turn can't be handled in order
+func (l *TicketLocker) Join(ctx context.Context, key string) (Turn, error) {
+	backoff := 10 * time.Millisecond
+	for {
+		ticket, err := l.tickets.Take(ctx, key)
+		if err == nil {
+			return &ticketTurn{locker: l, key: key, ticket: ticket}, nil
+		}
+		logger.Warn(ctx, "failed to take a ticket", "key", key, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if backoff < time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+type ticketTurn struct {
+	locker *TicketLocker
+	key    string
+	ticket int64
+}
+
+func (t *ticketTurn) Wait(ctx context.Context) (func(), error) {
+	tickets := t.locker.tickets
+	backoff := 10 * time.Millisecond
+	for {
+		served, movedAt, abandoned, err := tickets.Served(ctx, t.key)
+		if err != nil {
+			logger.Warn(ctx, "failed to read the turn", "key", t.key, "err", err)
+		} else if served == t.ticket {
+			return t.hold(ctx), nil
+		} else if served > t.ticket {
+			// the turn was passed over as stuck, the update is left for a redelivery
+			return nil, ErrTurnPassed
+		} else if abandoned || time.Since(movedAt) > t.locker.wait {
+			_, err = tickets.Serve(ctx, t.key, served)
+			if err != nil {
+				logger.Warn(ctx, "failed to pass a stuck turn", "key", t.key, "ticket", served, "err", err)
+			}
+			continue
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			// the context is done already, giving up the turn needs its own
+			giveUpCtx, cancel := context.WithTimeout(context.Background(), t.locker.wait)
+			defer cancel()
+			passed, err := tickets.Serve(giveUpCtx, t.key, t.ticket)
+			if err == nil && !passed {
+				var abandoned bool
+				abandoned, err = tickets.Abandon(giveUpCtx, t.key, t.ticket)
+				if err == nil && !abandoned {
+					// the turn came in between
+					_, err = tickets.Serve(giveUpCtx, t.key, t.ticket)
+				}
+			}
+			if err != nil {
+				logger.Warn(ctx, "failed to give up a turn", "key", t.key, "ticket", t.ticket, "err", err)
+			}
+			return nil, ctx.Err()
+		}
+		if backoff < 200*time.Millisecond {
+			backoff *= 2
+		}
+	}
+}
+
+// hold touches the turn until the returned function passes it on
+func (t *ticketTurn) hold(ctx context.Context) func() {
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-time.After(t.locker.wait / 3):
+			case <-done:
+				return
+			}
+			err := t.locker.tickets.Touch(ctx, t.key, t.ticket)
+			if err != nil {
+				logger.Warn(ctx, "failed to touch a turn", "key", t.key, "err", err)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		_, err := t.locker.tickets.Serve(ctx, t.key, t.ticket)
+		if err != nil {
+			// the next turn waits until this one looks stuck
+			logger.Warn(ctx, "failed to pass a turn", "key", t.key, "ticket", t.ticket, "err", err)
+		}
+	}
+}
+
+// Leader holds a named lease for as long as its instance lives
+type Leader struct {
+	leases Leases
+	name   string
+	holder string
+	ttl    time.Duration
+}
+
+func NewLeader(leases Leases, name string, holder string, ttl time.Duration) *Leader {
+	return &Leader{leases: leases, name: name, holder: holder, ttl: ttl}
+}
+
+// Wait blocks until the lease is taken or the context is done
+func (l *Leader) Wait(ctx context.Context) error {
+	for {
+		acquired, err := l.leases.Acquire(ctx, l.name, l.holder, l.ttl)
+		if err != nil {
+			logger.Warn(ctx, "failed to acquire a lease", "lease", l.name, "err", err)
+		}
+		if acquired {
+			logger.Info(ctx, "lease acquired", "lease", l.name, "holder", l.holder)
+			return nil
+		}
+		select {
+		case <-time.After(l.ttl / 3):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Keep prolongs the lease until the context is done, then releases it.
+// lost is called once the lease can't be prolonged before it expires.
+func (l *Leader) Keep(ctx context.Context, lost func()) {
+	prolongedAt := time.Now()
+	for {
+		select {
+		case <-time.After(l.ttl / 3):
+		case <-ctx.Done():
+			// the context is done already, the release needs its own
+			err := l.leases.Release(context.Background(), l.name, l.holder)
+			if err != nil {
+				logger.Warn(ctx, "failed to release a lease", "lease", l.name, "err", err)
+			}
+			return
+		}
+		acquired, err := l.leases.Acquire(ctx, l.name, l.holder, l.ttl)
+		if err != nil {
+			logger.Warn(ctx, "failed to prolong a lease", "lease", l.name, "err", err)
+		}
+		if acquired {
+			prolongedAt = time.Now()
+			continue
+		}
+		if err == nil || time.Since(prolongedAt) > l.ttl {
+			logger.Error(ctx, "lease lost", "lease", l.name)
+			lost()
+			return
+		}
+	}
+}
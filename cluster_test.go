@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"github.com/boryashkin/purchaselist/cluster"
+	"github.com/boryashkin/purchaselist/dialog"
+	"github.com/boryashkin/purchaselist/faketg"
+	"github.com/boryashkin/purchaselist/feature"
+	"github.com/boryashkin/purchaselist/queue"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestCluster sends the updates of several users back to back to instances behind the fake telegram,
+// some of them twice, and checks that every update is handled once and the updates of a user
+// one at a time in the order they were sent
+func TestCluster(t *testing.T) {
+	tests := []struct {
+		name      string
+		instances int
+		locker    func(tickets cluster.Tickets) cluster.Locker
+	}{
+		{
+			name:      "memory",
+			instances: 1,
+			locker: func(cluster.Tickets) cluster.Locker {
+				return cluster.NewMemoryLocker()
+			},
+		},
+		{
+			name:      "tickets",
+			instances: 3,
+			locker: func(tickets cluster.Tickets) cluster.Locker {
+				return cluster.NewTicketLocker(tickets, 5*time.Second)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const users, items = 5, 10
+			setupFakeMongo(t, false)
+			s := faketg.NewServer(0.3, 0, 0)
+			api := httptest.NewServer(s)
+			defer api.Close()
+			var err error
+			bot, err = dialog.NewBotAPI("test", api.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sender = dialog.NewSender(dialog.SenderLimits{PerSecond: 10000})
+			delayMessage = queue.NewDelayMessage(sender.Reply, &purchaseListService, time.Millisecond, queue.NewMemoryDebounce())
+			features = feature.NewHandler(feature.Services{
+				Users:    &userService,
+				Sessions: &sessionService,
+				Lists:    &purchaseListService,
+			}, cfg, bot, sender, nil)
+
+			tickets := &memoryTickets{queues: map[string]*memoryTicketQueue{}}
+			processed := &memoryProcessed{done: map[int]bool{}}
+			handled := &handledLog{times: map[int]int{}, texts: map[int][]string{}, busy: map[int]bool{}}
+			var dispatchers []*dispatcher
+			for i := 0; i < tt.instances; i++ {
+				d := newDispatcher(tt.locker(tickets), processed, nil, handled.wrap(handleUpdate))
+				webhook := httptest.NewServer(d)
+				defer webhook.Close()
+				_, err = bot.SetWebhook(tgbotapi.NewWebhook(webhook.URL + "/tg"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				dispatchers = append(dispatchers, d)
+			}
+
+			s.Run(users, items)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			for _, d := range dispatchers {
+				err = d.Wait(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = delayMessage.Flush(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s.Delivered() == s.Updates() {
+				t.Fatalf("no update was delivered twice out of %d", s.Updates())
+			}
+			for updateID := 1; updateID <= s.Updates(); updateID++ {
+				if times := handled.times[updateID]; times != 1 {
+					t.Errorf("update %d is handled %d times", updateID, times)
+				}
+			}
+			for userID := 1; userID <= users; userID++ {
+				want := append([]string{"/start"}, s.Items(int64(userID))...)
+				if got := handled.texts[userID]; !reflect.DeepEqual(got, want) {
+					t.Errorf("updates of user %d are handled as %v, want %v", userID, got, want)
+				}
+			}
+			if handled.overlaps > 0 {
+				t.Errorf("updates of the same user are handled at once %d times", handled.overlaps)
+			}
+		})
+	}
+}
+
+// handledLog keeps the updates handled by all the instances
+type handledLog struct {
+	mu       sync.Mutex
+	times    map[int]int
+	texts    map[int][]string
+	busy     map[int]bool
+	overlaps int
+}
+
+// wrap records the updates passed to handle and whether another update of the user is handled meanwhile
+func (l *handledLog) wrap(handle func(ctx context.Context, update tgbotapi.Update)) func(ctx context.Context, update tgbotapi.Update) {
+	return func(ctx context.Context, update tgbotapi.Update) {
+		userID := update.Message.From.ID
+		l.mu.Lock()
+		if l.busy[userID] {
+			l.overlaps++
+		}
+		l.busy[userID] = true
+		l.times[update.UpdateID]++
+		l.texts[userID] = append(l.texts[userID], update.Message.Text)
+		l.mu.Unlock()
+		handle(ctx, update)
+		l.mu.Lock()
+		l.busy[userID] = false
+		l.mu.Unlock()
+	}
+}
+
+type memoryTicketQueue struct {
+	taken     int64
+	served    int64
+	movedAt   time.Time
+	abandoned map[int64]bool
+}
+
+// memoryTickets are shared by the instances instead of the tickets collection
+type memoryTickets struct {
+	mu     sync.Mutex
+	queues map[string]*memoryTicketQueue
+}
+
+func (m *memoryTickets) queue(key string) *memoryTicketQueue {
+	q, found := m.queues[key]
+	if !found {
+		q = &memoryTicketQueue{movedAt: time.Now(), abandoned: map[int64]bool{}}
+		m.queues[key] = q
+	}
+	return q
+}
+
+func (m *memoryTickets) Take(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q := m.queue(key)
+	if q.served == q.taken {
+		q.movedAt = time.Now()
+	}
+	q.taken++
+	return q.taken - 1, nil
+}
+
+func (m *memoryTickets) Served(ctx context.Context, key string) (int64, time.Time, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q := m.queue(key)
+	return q.served, q.movedAt, q.abandoned[q.served], nil
+}
+
+func (m *memoryTickets) Serve(ctx context.Context, key string, ticket int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q := m.queue(key)
+	if q.served != ticket {
+		return false, nil
+	}
+	delete(q.abandoned, ticket)
+	q.served++
+	q.movedAt = time.Now()
+	return true, nil
+}
+
+func (m *memoryTickets) Touch(ctx context.Context, key string, ticket int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if q := m.queue(key); q.served == ticket {
+		q.movedAt = time.Now()
+	}
+	return nil
+}
+
+func (m *memoryTickets) Abandon(ctx context.Context, key string, ticket int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q := m.queue(key)
+	if q.served >= ticket {
+		return false, nil
+	}
+	q.abandoned[ticket] = true
+	return true, nil
+}
+
+// memoryProcessed are shared by the instances instead of the processed updates collection
+type memoryProcessed struct {
+	mu   sync.Mutex
+	done map[int]bool
+}
+
+func (p *memoryProcessed) IsProcessed(ctx context.Context, updateID int) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done[updateID], nil
+}
+
+func (p *memoryProcessed) MarkProcessed(ctx context.Context, updateID int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done[updateID] = true
+	return nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/boryashkin/purchaselist/backup"
+	"github.com/boryashkin/purchaselist/config"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/migrations"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"os"
+	"strconv"
+	"time"
+)
+
+const usage = `Usage: admin [flags] <command> [args]
+
+Commands:
+  user <tg_id>                  show the user, their session and latest lists
+  reset-session <tg_id>         drop the current list and start the dialog over
+  repair-list <list_id>         recalculate dictionary hashes of a list
+  dump-list <list_id>           print a list as JSON
+  backup <file>                 write all the data into a compressed archive
+  restore <file>                load an archive into an empty database
+  migrate                       apply pending schema migrations
+  migrate-status                list migrations and when they were applied
+
+Flags:
+`
+
+var (
+	database            *mongo.Database
+	userService         db.UserService
+	sessionService      db.SessionService
+	purchaseListService db.PurchaseListService
+)
+
+func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a yaml config, environment variables override it")
+	mongoURI := flag.String("mongo", "", "mongo connection uri, overrides the config")
+	lists := flag.Int64("lists", 5, "number of lists shown by the user command")
+	dropOrphans := flag.Bool("drop-orphans", false, "repair-list: remove items which names can't be restored")
+	dryRun := flag.Bool("dry-run", false, "repair-list, reset-session, restore, migrate: show changes without saving them")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	// the output is for a human, only problems are worth logging next to it
+	logger.Setup(os.Stderr, logger.LevelWarn, logger.FormatText)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fail("Config err", err)
+	}
+	if *mongoURI != "" {
+		cfg.Mongo.URI = *mongoURI
+	}
+	err = cfg.Mongo.Validate()
+	if err != nil {
+		fail("Config err", err)
+	}
+	client, err := mongo.NewClient(cfg.Mongo.ClientOptions())
+	if err != nil {
+		fail("Mongo instantiation err", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Mongo.ConnectTimeout.Duration)
+	defer cancel()
+	err = client.Connect(ctx)
+	if err != nil {
+		fail("Mongo connection err", err)
+	}
+	defer client.Disconnect(context.Background())
+	// a one-off command has nothing to protect with a circuit breaker
+	db.Configure(cfg.Mongo.Timeouts(), db.NewBreaker(0, 0))
+	database = client.Database(db.DbName)
+	userService = db.NewUserService(database.Collection(db.ColUsers))
+	sessionService = db.NewSessionService(database.Collection(db.ColSessions))
+	purchaseListService = db.NewPurchaseListService(database.Collection(db.ColProducts))
+
+	arg := flag.Arg(1)
+	switch flag.Arg(0) {
+	case "user":
+		err = showUser(arg, *lists)
+	case "reset-session":
+		err = resetSession(arg, *dryRun)
+	case "repair-list":
+		err = repairList(arg, *dropOrphans, *dryRun)
+	case "dump-list":
+		err = dumpList(arg)
+	case "backup":
+		err = writeBackup(arg)
+	case "restore":
+		err = restoreBackup(arg, *dryRun)
+	case "migrate":
+		err = migrate(*dryRun)
+	case "migrate-status":
+		err = migrateStatus()
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fail(flag.Arg(0)+" failed:", err)
+	}
+}
+
+func showUser(strTgID string, lists int64) error {
+	user, err := findUser(strTgID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("User %s\n  tg_id: %d\n  name: %s\n  lang: %s\n  created: %s\n",
+		user.Id.Hex(), user.TgId, user.Name, user.Lang, formatDate(user.CreatedAt))
+
+	session, err := sessionService.FindByUserID(context.Background(), user.Id)
+	if err != nil {
+		fmt.Println("Session: not found,", err)
+	} else {
+		fmt.Printf("Session %s\n  state: %s (previous %s)\n  list: %s\n  created: %s\n",
+			session.Id.Hex(), stateName(session.PostingState), stateName(session.PreviousState),
+			session.PurchaseListId.Hex(), formatDate(session.CreatedAt))
+	}
+
+	pLists, err := purchaseListService.FindByUserID(context.Background(), user.Id, lists)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Lists (latest %d)\n", lists)
+	for _, pList := range pLists {
+		_, orphans := db.RepairDictionary(&pList, false)
+		fmt.Printf("  %s updated %s: %d active, %d crossed out, %d without a name\n",
+			pList.Id.Hex(), formatDate(pList.UpdatedAt), len(pList.Items), len(pList.DeletedItemHashes), len(orphans))
+	}
+
+	return nil
+}
+
+func resetSession(strTgID string, dryRun bool) error {
+	user, err := findUser(strTgID)
+	if err != nil {
+		return err
+	}
+	session, err := sessionService.FindByUserID(context.Background(), user.Id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Session %s: %s -> %s, list %s -> none\n",
+		session.Id.Hex(), stateName(session.PostingState), stateName(db.SessPStateCreation), session.PurchaseListId.Hex())
+	if dryRun {
+		return nil
+	}
+	session.PreviousState = session.PostingState
+	session.PostingState = db.SessPStateCreation
+	session.PurchaseListId = primitive.NilObjectID
+
+	return sessionService.UpdateSession(context.Background(), &session)
+}
+
+func repairList(strListID string, dropOrphans bool, dryRun bool) error {
+	pList, err := findList(strListID)
+	if err != nil {
+		return err
+	}
+	fixed, orphans := db.RepairDictionary(&pList, dropOrphans)
+	fmt.Printf("List %s: %d dictionary entries fixed, %d items without a name\n", pList.Id.Hex(), fixed, len(orphans))
+	for _, hash := range orphans {
+		fmt.Println("  orphan", hash)
+	}
+	if len(orphans) > 0 && !dropOrphans {
+		fmt.Println("Run with -drop-orphans to remove them from the list")
+	}
+	if dryRun || (fixed == 0 && (len(orphans) == 0 || !dropOrphans)) {
+		return nil
+	}
+
+	return purchaseListService.Repair(context.Background(), &pList)
+}
+
+func dumpList(strListID string) error {
+	pList, err := findList(strListID)
+	if err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(pList, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+
+	return nil
+}
+
+func writeBackup(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	counts, err := backup.Write(context.Background(), file, database)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	printCounts("Saved", counts)
+
+	return file.Close()
+}
+
+func restoreBackup(path string, dryRun bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	archive, err := backup.Read(file)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Archive version %d created %s\n", archive.Header.Version, archive.Header.CreatedAt.Format(time.RFC3339))
+	if dryRun {
+		err = archive.Validate()
+		if err == nil {
+			fmt.Println("Archive is valid")
+		}
+		return err
+	}
+	counts, err := backup.Restore(context.Background(), database, archive)
+	printCounts("Restored", counts)
+
+	return err
+}
+
+func printCounts(action string, counts map[string]int) {
+	for _, col := range backup.Collections {
+		fmt.Printf("%s %d %s\n", action, counts[col], col)
+	}
+}
+
+func migrate(dryRun bool) error {
+	applied := 0
+	err := migrations.Run(context.Background(), database, dryRun, func(m migrations.Migration, summary string) {
+		applied++
+		fmt.Printf("%3d %s: %s\n", m.ID, m.Name, summary)
+	})
+	if err == nil && applied == 0 {
+		fmt.Println("No pending migrations")
+	}
+
+	return err
+}
+
+func migrateStatus() error {
+	statuses, err := migrations.GetStatus(context.Background(), database)
+	if err != nil {
+		return err
+	}
+	for _, st := range statuses {
+		if st.Record == nil {
+			fmt.Printf("%3d %s: pending\n", st.Migration.ID, st.Migration.Name)
+			continue
+		}
+		fmt.Printf("%3d %s: applied %s, %s\n", st.Migration.ID, st.Migration.Name, formatDate(st.Record.AppliedAt), st.Record.Summary)
+	}
+
+	return nil
+}
+
+func findUser(strTgID string) (db.User, error) {
+	tgID, err := strconv.Atoi(strTgID)
+	if err != nil {
+		return db.User{}, fmt.Errorf("invalid tg_id %q", strTgID)
+	}
+	return userService.FindByTgID(context.Background(), tgID)
+}
+
+func findList(strListID string) (db.PurchaseList, error) {
+	listID, err := primitive.ObjectIDFromHex(strListID)
+	if err != nil {
+		return db.PurchaseList{}, fmt.Errorf("invalid list id %q", strListID)
+	}
+	return purchaseListService.FindByID(context.Background(), listID)
+}
+
+func stateName(state db.SessState) string {
+	switch state {
+	case db.SessPStateNew:
+		return "new"
+	case db.SessPStateRegistered:
+		return "registered"
+	case db.SessPStateCreation:
+		return "creation"
+	case db.SessPStateInProgress:
+		return "in progress"
+	case db.SessPStateDone:
+		return "done"
+	}
+	return "unknown(" + string(state) + ")"
+}
+
+func formatDate(date primitive.DateTime) string {
+	return date.Time().Format("2006-01-02 15:04:05")
+}
+
+func fail(msg string, err error) {
+	fmt.Fprintln(os.Stderr, msg, err)
+	os.Exit(1)
+}
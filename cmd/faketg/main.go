@@ -0,0 +1,40 @@
+// Command faketg imitates the parts of the Telegram Bot API the bot uses, so several
+// instances can be tested locally without a real bot token.
+//
+// Point the bot at it with TG_API_ENDPOINT. Every instance registering a webhook gets
+// a share of updates round robin, like behind a load balancer, instances without one
+// receive updates through long polling. POST /run sends /start and a number of items
+// from every fake user, some of the updates are delivered twice. With -flood some of
+// the messages are rejected with 429. GET /verify checks that the latest list of every
+// user has all of its items.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/boryashkin/purchaselist/faketg"
+	"log"
+	"net/http"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8081", "address to listen on")
+	users := flag.Int("users", 5, "number of fake users")
+	items := flag.Int("items", 10, "number of items sent by every user")
+	duplicates := flag.Float64("duplicates", 0.1, "share of updates delivered twice")
+	flood := flag.Float64("flood", 0, "share of sent and edited messages rejected with 429 retry_after")
+	pause := flag.Duration("pause", 20*time.Millisecond, "pause between updates of one user")
+	flag.Parse()
+
+	s := faketg.NewServer(*duplicates, *flood, *pause)
+	http.HandleFunc("/run", func(w http.ResponseWriter, r *http.Request) {
+		go s.Run(*users, *items)
+		fmt.Fprintf(w, "sending %d items from %d users\n", *items, *users)
+	})
+	http.HandleFunc("/verify", s.Verify)
+	http.HandleFunc("/stats", s.Stats)
+	http.Handle("/", s)
+	log.Println("fake telegram is listening on", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,641 @@
+// Package config loads the bot settings from an optional YAML file and the environment.
+// Environment variables take precedence over the file.
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/boryashkin/purchaselist/category"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/schedule"
+	"github.com/boryashkin/purchaselist/tracing"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const redacted = "***"
+
+type Config struct {
+	Telegram Telegram `yaml:"telegram"`
+	Mongo    Mongo    `yaml:"mongo"`
+	Metrics  Metrics  `yaml:"metrics"`
+	List     List     `yaml:"list"`
+	Features Features `yaml:"features"`
+	Shutdown Shutdown `yaml:"shutdown"`
+	Health   Health   `yaml:"health"`
+	Log      Log      `yaml:"log"`
+	Tracing  Tracing  `yaml:"tracing"`
+	Cache    Cache    `yaml:"cache"`
+	Cluster  Cluster  `yaml:"cluster"`
+	Schedule Schedule `yaml:"schedule"`
+}
+
+type Telegram struct {
+	// Token is read from TGTOKEN
+	Token string `yaml:"token"`
+	// BotName is read from BOTNAME, it is used in links back to the bot
+	BotName string `yaml:"bot_name"`
+	// APIEndpoint is read from TG_API_ENDPOINT, it points the bot to a fake telegram server in tests
+	APIEndpoint string `yaml:"api_endpoint"`
+	// WebhookURL is read from TG_WEBHOOK_URL, updates are pushed to it instead of long polling when set.
+	// Its path is served by the metrics http server.
+	WebhookURL string `yaml:"webhook_url"`
+	// RateLimit is read from TG_RATE_LIMIT, requests per second to the whole api
+	RateLimit int `yaml:"rate_limit"`
+	// ChatInterval is read from TG_CHAT_INTERVAL, the minimal pause between messages to one chat
+	ChatInterval Duration `yaml:"chat_interval"`
+	// SendRetries is read from TG_SEND_RETRIES, a failed request is repeated up to this number of times
+	// if telegram asks to retry after a pause or the error looks transient
+	SendRetries int `yaml:"send_retries"`
+}
+
+type Mongo struct {
+	// URI is read from MONGO_URI, it replaces Host and Port when set
+	URI string `yaml:"uri"`
+	// Host is read from MONGODB
+	Host string `yaml:"host"`
+	// Port is read from MONGOPORT
+	Port int `yaml:"port"`
+	// User is read from MONGO_USER
+	User string `yaml:"user"`
+	// Password is read from MONGO_PASSWORD
+	Password string `yaml:"password"`
+	// AuthSource is read from MONGO_AUTH_SOURCE
+	AuthSource string `yaml:"auth_source"`
+	// TLS is read from MONGO_TLS
+	TLS bool `yaml:"tls"`
+	// ConnectTimeout is read from MONGO_CONNECT_TIMEOUT
+	ConnectTimeout Duration `yaml:"connect_timeout"`
+	// ReadTimeout is read from MONGO_READ_TIMEOUT, a lookup is cancelled after it
+	ReadTimeout Duration `yaml:"read_timeout"`
+	// WriteTimeout is read from MONGO_WRITE_TIMEOUT, an insert or update is cancelled after it
+	WriteTimeout Duration `yaml:"write_timeout"`
+	// OperationTimeouts are read from MONGO_OPERATION_TIMEOUTS as "plist_find_by_id=1s,user_upsert=3s",
+	// they override the read and write timeouts of single operations
+	OperationTimeouts map[string]Duration `yaml:"operation_timeouts"`
+	// BreakerFailures is read from MONGO_BREAKER_FAILURES, calls fail fast after this many timeouts in a row, 0 disables it
+	BreakerFailures int `yaml:"breaker_failures"`
+	// BreakerCooldown is read from MONGO_BREAKER_COOLDOWN, the database is probed again after it
+	BreakerCooldown Duration `yaml:"breaker_cooldown"`
+}
+
+type Metrics struct {
+	// Port is read from METRICSPORT
+	Port int `yaml:"port"`
+}
+
+type List struct {
+	// DebounceDelay is read from DEBOUNCE_DELAY, a list is rendered once no changes came within it
+	DebounceDelay Duration `yaml:"debounce_delay"`
+	// MaxItems is read from MAX_ITEMS
+	MaxItems int `yaml:"max_items"`
+	// ItemMaxLength is read from ITEM_MAX_LENGTH, longer names are truncated
+	ItemMaxLength int `yaml:"item_max_length"`
+	// CategoryOrder is read from CATEGORY_ORDER, comma separated category ids in the order of the store aisles
+	CategoryOrder []string `yaml:"category_order"`
+}
+
+type Features struct {
+	// Export is read from FEATURE_EXPORT
+	Export bool `yaml:"export"`
+	// Import is read from FEATURE_IMPORT
+	Import bool `yaml:"import"`
+	// Migrations is read from FEATURE_MIGRATIONS, pending migrations are applied on startup
+	Migrations bool `yaml:"migrations"`
+	// Suggestions is read from FEATURE_SUGGESTIONS, items bought before are suggested for new lists and inline queries
+	Suggestions bool `yaml:"suggestions"`
+	// Categories is read from FEATURE_CATEGORIES, lists are grouped by category
+	Categories bool `yaml:"categories"`
+	// Prices is read from FEATURE_PRICES, items may have prices and lists a budget
+	Prices bool `yaml:"prices"`
+	// Templates is read from FEATURE_TEMPLATES, lists are saved as templates and created from them on a schedule
+	Templates bool `yaml:"templates"`
+	// Reminders is read from FEATURE_REMINDERS, lists and items may be due and are sent back when they are
+	Reminders bool `yaml:"reminders"`
+	// Recipes is read from FEATURE_RECIPES, "борщ на 6" in a list becomes the ingredients of the recipe
+	Recipes bool `yaml:"recipes"`
+	// Pantry is read from FEATURE_PANTRY, users may keep the crossed out items in a pantry with /pantry
+	Pantry bool `yaml:"pantry"`
+}
+
+type Shutdown struct {
+	// Timeout is read from SHUTDOWN_TIMEOUT, in-flight updates and delayed messages are dropped after it
+	Timeout Duration `yaml:"timeout"`
+}
+
+type Health struct {
+	// Timeout is read from HEALTH_TIMEOUT, a readiness check failing to answer in time counts as failed
+	Timeout Duration `yaml:"timeout"`
+	// MaxBacklog is read from HEALTH_MAX_BACKLOG, the instance is not ready with more updates in flight
+	MaxBacklog int `yaml:"max_backlog"`
+}
+
+type Log struct {
+	// Level is read from LOG_LEVEL: debug, info, warn or error. Message texts and contacts are logged only at debug.
+	Level string `yaml:"level"`
+	// Format is read from LOG_FORMAT: json or text
+	Format string `yaml:"format"`
+}
+
+type Tracing struct {
+	// Exporter is read from TRACING_EXPORTER: none, stdout or otlp
+	Exporter string `yaml:"exporter"`
+	// Endpoint is read from TRACING_OTLP_ENDPOINT, host:port of an OTLP http collector
+	Endpoint string `yaml:"endpoint"`
+	// Insecure is read from TRACING_OTLP_INSECURE, spans are sent over plain http
+	Insecure bool `yaml:"insecure"`
+	// SampleRatio is read from TRACING_SAMPLE_RATIO, the share of updates traced
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+type Cache struct {
+	// Enabled is read from CACHE_ENABLED, lists and sessions are kept in memory between updates.
+	// It is ignored in cluster mode, where other instances change them.
+	Enabled bool `yaml:"enabled"`
+	// Size is read from CACHE_SIZE, the number of lists and, separately, sessions kept
+	Size int `yaml:"size"`
+	// TTL is read from CACHE_TTL, changes made by the admin tools are seen after it
+	TTL Duration `yaml:"ttl"`
+}
+
+type Cluster struct {
+	// Enabled is read from CLUSTER_ENABLED, instances share debounce state and queue the updates of users through the database
+	Enabled bool `yaml:"enabled"`
+	// InstanceID is read from INSTANCE_ID, it defaults to hostname and pid
+	InstanceID string `yaml:"instance_id"`
+	// LeaseTTL is read from CLUSTER_LEASE_TTL, a dead leader is replaced after it
+	LeaseTTL Duration `yaml:"lease_ttl"`
+	// LockWait is read from CLUSTER_LOCK_WAIT, a turn of a user which doesn't move for longer is passed over,
+	// as its instance is considered dead
+	LockWait Duration `yaml:"lock_wait"`
+}
+
+type Schedule struct {
+	// Interval is read from SCHEDULE_INTERVAL, the due jobs are looked for this often
+	Interval Duration `yaml:"interval"`
+	// Timezone is read from TIMEZONE, the schedules of the users who haven't set their own are in it
+	Timezone string `yaml:"timezone"`
+	// ReminderRepeat is read from REMINDER_REPEAT, an unfinished list is reminded of again after it
+	ReminderRepeat Duration `yaml:"reminder_repeat"`
+	// ReminderTimes is read from REMINDER_TIMES, the reminders about a list stop after this number of them
+	ReminderTimes int `yaml:"reminder_times"`
+}
+
+// Duration reads "500ms" or "20s" from YAML
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	err := unmarshal(&s)
+	if err != nil {
+		return err
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func Default() Config {
+	return Config{
+		Telegram: Telegram{
+			RateLimit:    30,
+			ChatInterval: Duration{time.Second},
+			SendRetries:  3,
+		},
+		Mongo: Mongo{
+			Host:            "localhost",
+			Port:            27017,
+			ConnectTimeout:  Duration{20 * time.Second},
+			ReadTimeout:     Duration{5 * time.Second},
+			WriteTimeout:    Duration{10 * time.Second},
+			BreakerFailures: 5,
+			BreakerCooldown: Duration{30 * time.Second},
+		},
+		Metrics: Metrics{Port: 21398},
+		List: List{
+			DebounceDelay: Duration{500 * time.Millisecond},
+			MaxItems:      50,
+			ItemMaxLength: 30,
+			CategoryOrder: category.DefaultOrder(),
+		},
+		Features: Features{
+			Export:      true,
+			Import:      true,
+			Migrations:  true,
+			Suggestions: true,
+			Categories:  true,
+			Prices:      true,
+			Templates:   true,
+			Reminders:   true,
+			Recipes:     true,
+			Pantry:      true,
+		},
+		Shutdown: Shutdown{Timeout: Duration{15 * time.Second}},
+		Health: Health{
+			Timeout:    Duration{5 * time.Second},
+			MaxBacklog: 100,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: logger.FormatJSON,
+		},
+		Tracing: Tracing{
+			Exporter:    tracing.ExporterNone,
+			Endpoint:    "localhost:4318",
+			SampleRatio: 1,
+		},
+		Cache: Cache{
+			Enabled: true,
+			Size:    10000,
+			TTL:     Duration{time.Minute},
+		},
+		Cluster: Cluster{
+			InstanceID: defaultInstanceID(),
+			LeaseTTL:   Duration{15 * time.Second},
+			LockWait:   Duration{10 * time.Second},
+		},
+		Schedule: Schedule{
+			Interval:       Duration{30 * time.Second},
+			Timezone:       "Europe/Moscow",
+			ReminderRepeat: Duration{2 * time.Hour},
+			ReminderTimes:  3,
+		},
+	}
+}
+
+// Load reads the file, if a path is given, then applies the environment on top of it
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		err = yaml.UnmarshalStrict(content, &cfg)
+		if err != nil {
+			return nil, fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+	err := cfg.applyEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func (c *Config) applyEnv() error {
+	var errs []string
+	str := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			*dst = v
+		}
+	}
+	num := func(name string, dst *int) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, name+" is not a number: "+v)
+				return
+			}
+			*dst = n
+		}
+	}
+	flag := func(name string, dst *bool) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, name+" is not a boolean: "+v)
+				return
+			}
+			*dst = b
+		}
+	}
+	ratio := func(name string, dst *float64) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, name+" is not a number: "+v)
+				return
+			}
+			*dst = f
+		}
+	}
+	duration := func(name string, dst *Duration) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, name+" is not a duration: "+v)
+				return
+			}
+			dst.Duration = d
+		}
+	}
+
+	str("TGTOKEN", &c.Telegram.Token)
+	str("BOTNAME", &c.Telegram.BotName)
+	str("TG_API_ENDPOINT", &c.Telegram.APIEndpoint)
+	str("TG_WEBHOOK_URL", &c.Telegram.WebhookURL)
+	num("TG_RATE_LIMIT", &c.Telegram.RateLimit)
+	duration("TG_CHAT_INTERVAL", &c.Telegram.ChatInterval)
+	num("TG_SEND_RETRIES", &c.Telegram.SendRetries)
+	str("MONGO_URI", &c.Mongo.URI)
+	str("MONGODB", &c.Mongo.Host)
+	num("MONGOPORT", &c.Mongo.Port)
+	str("MONGO_USER", &c.Mongo.User)
+	str("MONGO_PASSWORD", &c.Mongo.Password)
+	str("MONGO_AUTH_SOURCE", &c.Mongo.AuthSource)
+	flag("MONGO_TLS", &c.Mongo.TLS)
+	duration("MONGO_CONNECT_TIMEOUT", &c.Mongo.ConnectTimeout)
+	duration("MONGO_READ_TIMEOUT", &c.Mongo.ReadTimeout)
+	duration("MONGO_WRITE_TIMEOUT", &c.Mongo.WriteTimeout)
+	if v, ok := os.LookupEnv("MONGO_OPERATION_TIMEOUTS"); ok && v != "" {
+		c.Mongo.OperationTimeouts = map[string]Duration{}
+		for _, pair := range strings.Split(v, ",") {
+			parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(parts) != 2 {
+				errs = append(errs, "MONGO_OPERATION_TIMEOUTS is not a list of operation=duration: "+v)
+				break
+			}
+			d, err := time.ParseDuration(parts[1])
+			if err != nil {
+				errs = append(errs, "MONGO_OPERATION_TIMEOUTS has an invalid duration: "+parts[1])
+				break
+			}
+			c.Mongo.OperationTimeouts[parts[0]] = Duration{d}
+		}
+	}
+	num("MONGO_BREAKER_FAILURES", &c.Mongo.BreakerFailures)
+	duration("MONGO_BREAKER_COOLDOWN", &c.Mongo.BreakerCooldown)
+	num("METRICSPORT", &c.Metrics.Port)
+	duration("DEBOUNCE_DELAY", &c.List.DebounceDelay)
+	num("MAX_ITEMS", &c.List.MaxItems)
+	num("ITEM_MAX_LENGTH", &c.List.ItemMaxLength)
+	if v, ok := os.LookupEnv("CATEGORY_ORDER"); ok && v != "" {
+		c.List.CategoryOrder = nil
+		for _, id := range strings.Split(v, ",") {
+			c.List.CategoryOrder = append(c.List.CategoryOrder, strings.TrimSpace(id))
+		}
+	}
+	flag("FEATURE_EXPORT", &c.Features.Export)
+	flag("FEATURE_IMPORT", &c.Features.Import)
+	flag("FEATURE_MIGRATIONS", &c.Features.Migrations)
+	flag("FEATURE_SUGGESTIONS", &c.Features.Suggestions)
+	flag("FEATURE_CATEGORIES", &c.Features.Categories)
+	flag("FEATURE_PRICES", &c.Features.Prices)
+	flag("FEATURE_TEMPLATES", &c.Features.Templates)
+	flag("FEATURE_REMINDERS", &c.Features.Reminders)
+	flag("FEATURE_RECIPES", &c.Features.Recipes)
+	flag("FEATURE_PANTRY", &c.Features.Pantry)
+	duration("SHUTDOWN_TIMEOUT", &c.Shutdown.Timeout)
+	duration("HEALTH_TIMEOUT", &c.Health.Timeout)
+	num("HEALTH_MAX_BACKLOG", &c.Health.MaxBacklog)
+	str("LOG_LEVEL", &c.Log.Level)
+	str("LOG_FORMAT", &c.Log.Format)
+	str("TRACING_EXPORTER", &c.Tracing.Exporter)
+	str("TRACING_OTLP_ENDPOINT", &c.Tracing.Endpoint)
+	flag("TRACING_OTLP_INSECURE", &c.Tracing.Insecure)
+	ratio("TRACING_SAMPLE_RATIO", &c.Tracing.SampleRatio)
+	flag("CACHE_ENABLED", &c.Cache.Enabled)
+	num("CACHE_SIZE", &c.Cache.Size)
+	duration("CACHE_TTL", &c.Cache.TTL)
+	flag("CLUSTER_ENABLED", &c.Cluster.Enabled)
+	str("INSTANCE_ID", &c.Cluster.InstanceID)
+	duration("CLUSTER_LEASE_TTL", &c.Cluster.LeaseTTL)
+	duration("CLUSTER_LOCK_WAIT", &c.Cluster.LockWait)
+	duration("SCHEDULE_INTERVAL", &c.Schedule.Interval)
+	str("TIMEZONE", &c.Schedule.Timezone)
+	duration("REMINDER_REPEAT", &c.Schedule.ReminderRepeat)
+	num("REMINDER_TIMES", &c.Schedule.ReminderTimes)
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Validate checks everything the bot needs to start
+func (c *Config) Validate() error {
+	var errs []string
+	if c.Telegram.Token == "" {
+		errs = append(errs, "TGTOKEN is required")
+	}
+	if c.Telegram.BotName == "" {
+		errs = append(errs, "BOTNAME is required")
+	}
+	// telegram allows about 30 messages per second
+	if c.Telegram.RateLimit < 1 || c.Telegram.RateLimit > 30 {
+		errs = append(errs, "TG_RATE_LIMIT must be within 1..30")
+	}
+	if c.Telegram.ChatInterval.Duration < 0 {
+		errs = append(errs, "TG_CHAT_INTERVAL must not be negative")
+	}
+	if c.Telegram.SendRetries < 0 || c.Telegram.SendRetries > 10 {
+		errs = append(errs, "TG_SEND_RETRIES must be within 0..10")
+	}
+	if err := c.Mongo.Validate(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if c.Metrics.Port <= 0 || c.Metrics.Port > 65535 {
+		errs = append(errs, "METRICSPORT must be within 1..65535")
+	}
+	if c.List.DebounceDelay.Duration < 0 || c.List.DebounceDelay.Duration > 10*time.Second {
+		errs = append(errs, "DEBOUNCE_DELAY must be within 0..10s")
+	}
+	// a list is rendered as an inline keyboard, telegram allows up to 100 buttons
+	if c.List.MaxItems < 1 || c.List.MaxItems > 99 {
+		errs = append(errs, "MAX_ITEMS must be within 1..99")
+	}
+	if c.List.ItemMaxLength < 1 || c.List.ItemMaxLength > 64 {
+		errs = append(errs, "ITEM_MAX_LENGTH must be within 1..64")
+	}
+	for _, id := range c.List.CategoryOrder {
+		if found, ok := category.Find(id); !ok || found.ID != id {
+			errs = append(errs, "CATEGORY_ORDER has an unknown category "+id)
+		}
+	}
+	if c.Shutdown.Timeout.Duration <= 0 {
+		errs = append(errs, "SHUTDOWN_TIMEOUT must be positive")
+	}
+	if c.Health.Timeout.Duration <= 0 {
+		errs = append(errs, "HEALTH_TIMEOUT must be positive")
+	}
+	if c.Health.MaxBacklog < 1 {
+		errs = append(errs, "HEALTH_MAX_BACKLOG must be positive")
+	}
+	if _, err := logger.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, "LOG_LEVEL must be one of debug, info, warn, error")
+	}
+	if c.Log.Format != logger.FormatJSON && c.Log.Format != logger.FormatText {
+		errs = append(errs, "LOG_FORMAT must be json or text")
+	}
+	switch c.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterStdout:
+	case tracing.ExporterOTLP:
+		if c.Tracing.Endpoint == "" {
+			errs = append(errs, "TRACING_OTLP_ENDPOINT is required for the otlp exporter")
+		}
+	default:
+		errs = append(errs, "TRACING_EXPORTER must be none, stdout or otlp")
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, "TRACING_SAMPLE_RATIO must be within 0..1")
+	}
+	for name, value := range map[string]string{"TG_API_ENDPOINT": c.Telegram.APIEndpoint, "TG_WEBHOOK_URL": c.Telegram.WebhookURL} {
+		if value == "" {
+			continue
+		}
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, name+" must be an absolute url")
+		}
+	}
+	if c.Cache.Enabled && c.Cache.Size < 1 {
+		errs = append(errs, "CACHE_SIZE must be positive")
+	}
+	if c.Cache.Enabled && c.Cache.TTL.Duration <= 0 {
+		errs = append(errs, "CACHE_TTL must be positive")
+	}
+	if c.Cluster.InstanceID == "" {
+		errs = append(errs, "INSTANCE_ID is required")
+	}
+	if c.Cluster.LeaseTTL.Duration < time.Second {
+		errs = append(errs, "CLUSTER_LEASE_TTL must be at least 1s")
+	}
+	if c.Cluster.LockWait.Duration <= 0 {
+		errs = append(errs, "CLUSTER_LOCK_WAIT must be positive")
+	}
+	if c.Schedule.Interval.Duration < time.Second {
+		errs = append(errs, "SCHEDULE_INTERVAL must be at least 1s")
+	}
+	if _, err := schedule.Location(c.Schedule.Timezone); err != nil {
+		errs = append(errs, "TIMEZONE must be a timezone like Europe/Moscow or an offset like +3")
+	}
+	if c.Schedule.ReminderRepeat.Duration < time.Minute {
+		errs = append(errs, "REMINDER_REPEAT must be at least 1m")
+	}
+	if c.Schedule.ReminderTimes < 1 || c.Schedule.ReminderTimes > 10 {
+		errs = append(errs, "REMINDER_TIMES must be within 1..10")
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Validate checks only the database settings, the admin tools need nothing else
+func (m *Mongo) Validate() error {
+	var errs []string
+	if m.URI != "" {
+		u, err := url.Parse(m.URI)
+		if err != nil || (u.Scheme != "mongodb" && u.Scheme != "mongodb+srv") {
+			errs = append(errs, "MONGO_URI must be a mongodb:// or mongodb+srv:// uri")
+		}
+	} else {
+		if m.Host == "" {
+			errs = append(errs, "MONGODB or MONGO_URI is required")
+		}
+		if m.Port <= 0 || m.Port > 65535 {
+			errs = append(errs, "MONGOPORT must be within 1..65535")
+		}
+	}
+	if m.Password != "" && m.User == "" {
+		errs = append(errs, "MONGO_PASSWORD is set without MONGO_USER")
+	}
+	if m.ConnectTimeout.Duration <= 0 {
+		errs = append(errs, "MONGO_CONNECT_TIMEOUT must be positive")
+	}
+	if m.ReadTimeout.Duration <= 0 {
+		errs = append(errs, "MONGO_READ_TIMEOUT must be positive")
+	}
+	if m.WriteTimeout.Duration <= 0 {
+		errs = append(errs, "MONGO_WRITE_TIMEOUT must be positive")
+	}
+	for op, d := range m.OperationTimeouts {
+		if d.Duration <= 0 {
+			errs = append(errs, "MONGO_OPERATION_TIMEOUTS of "+op+" must be positive")
+		}
+	}
+	if m.BreakerFailures < 0 {
+		errs = append(errs, "MONGO_BREAKER_FAILURES must not be negative")
+	}
+	if m.BreakerFailures > 0 && m.BreakerCooldown.Duration <= 0 {
+		errs = append(errs, "MONGO_BREAKER_COOLDOWN must be positive")
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Timeouts of the db services
+func (m *Mongo) Timeouts() db.Timeouts {
+	ops := map[string]time.Duration{}
+	for op, d := range m.OperationTimeouts {
+		ops[op] = d.Duration
+	}
+	return db.Timeouts{Read: m.ReadTimeout.Duration, Write: m.WriteTimeout.Duration, Operations: ops}
+}
+
+func (m *Mongo) ClientOptions() *options.ClientOptions {
+	uri := m.URI
+	if uri == "" {
+		uri = "mongodb://" + m.Host + ":" + strconv.Itoa(m.Port)
+	}
+	opts := options.Client().ApplyURI(uri)
+	if m.User != "" {
+		opts.SetAuth(options.Credential{
+			Username:   m.User,
+			Password:   m.Password,
+			AuthSource: m.AuthSource,
+		})
+	}
+	if m.TLS {
+		opts.SetTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+
+	return opts
+}
+
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "bot"
+	}
+	return host + "-" + strconv.Itoa(os.Getpid())
+}
+
+// Redacted prints the effective config with the secrets hidden
+func (c Config) Redacted() string {
+	if c.Telegram.Token != "" {
+		c.Telegram.Token = redacted
+	}
+	if c.Mongo.Password != "" {
+		c.Mongo.Password = redacted
+	}
+	if u, err := url.Parse(c.Mongo.URI); err == nil && u.User != nil {
+		if _, set := u.User.Password(); set {
+			u.User = url.User(u.User.Username())
+			c.Mongo.URI = strings.Replace(u.String(), "@", ":"+redacted+"@", 1)
+		}
+	}
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/metrics"
+	"sync"
+	"time"
+)
+
+// ErrUnavailable is returned without calling mongo while the circuit breaker is open
+var ErrUnavailable = errors.New("database is temporarily unavailable")
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// Breaker opens after a number of db calls in a row run out of time, so the following calls
+// fail fast instead of piling up. After the cooldown one call at a time probes the database,
+// the first one to succeed closes the breaker.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreaker makes a breaker, a zero threshold disables it
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow tells whether a call may go to the database, a call allowed must be followed by Done
+func (b *Breaker) Allow() bool {
+	if b.threshold == 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// Done records the outcome of an allowed call
+func (b *Breaker) Done(failed bool) {
+	if b.threshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.probing = false
+	}
+	if !failed {
+		if b.state != breakerClosed {
+			logger.Info(context.Background(), "db circuit breaker is closed")
+			metrics.DbBreakerOpen.Set(0)
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		if b.state == breakerClosed {
+			logger.Warn(context.Background(), "db circuit breaker is open", "failures", b.failures, "cooldown", b.cooldown.String())
+			metrics.DbBreakerOpen.Set(1)
+		}
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
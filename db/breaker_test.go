@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	const cooldown = 10 * time.Millisecond
+	// the steps are: allow and reject check Allow, ok and fail call Done, wait lets the cooldown pass
+	tests := []struct {
+		name      string
+		threshold int
+		steps     string
+	}{
+		{
+			name:      "a zero threshold never opens",
+			threshold: 0,
+			steps:     "allow fail allow fail allow fail allow",
+		},
+		{
+			name:      "opens after failures in a row",
+			threshold: 2,
+			steps:     "allow fail allow fail reject reject",
+		},
+		{
+			name:      "a success resets the failures",
+			threshold: 2,
+			steps:     "allow fail allow ok allow fail allow",
+		},
+		{
+			name:      "one call probes after the cooldown and closes it",
+			threshold: 1,
+			steps:     "allow fail reject wait allow reject ok allow allow",
+		},
+		{
+			name:      "a failed probe opens it again",
+			threshold: 2,
+			steps:     "allow fail allow fail wait allow fail reject wait allow ok allow",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBreaker(tt.threshold, cooldown)
+			for i, step := range strings.Fields(tt.steps) {
+				switch step {
+				case "allow", "reject":
+					if allowed := b.Allow(); allowed != (step == "allow") {
+						t.Fatalf("step %d: Allow() = %v, want %s", i, allowed, step)
+					}
+				case "ok", "fail":
+					b.Done(step == "fail")
+				case "wait":
+					time.Sleep(2 * cooldown)
+				}
+			}
+		})
+	}
+}
+
+func TestStartOp(t *testing.T) {
+	defer Configure(timeouts, breaker)
+	Configure(Timeouts{
+		Read:       time.Second,
+		Write:      time.Second,
+		Operations: map[string]time.Duration{"plist_find_by_id": time.Millisecond},
+	}, NewBreaker(1, time.Hour))
+	steps := []struct {
+		operation string
+		err       error
+	}{
+		{operation: "user_upsert"},
+		{operation: "plist_find_by_id"},
+		{operation: "user_upsert", err: ErrUnavailable},
+	}
+	for i, step := range steps {
+		ctx, end, err := startOp(context.Background(), step.operation, opWrite)
+		if err != step.err {
+			t.Fatalf("step %d: startOp(%s) error = %v, want %v", i, step.operation, err, step.err)
+		}
+		if err != nil {
+			continue
+		}
+		// the call lasts until its timeout, only the one of plist_find_by_id runs out in time
+		select {
+		case <-ctx.Done():
+		case <-time.After(20 * time.Millisecond):
+		}
+		end()
+	}
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// CategoryWord is a correction of the built-in category dictionary made by a user
+type CategoryWord struct {
+	Id        primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Word      string             `json:"word" bson:"word"`
+	Category  string             `json:"category" bson:"category"`
+	UpdatedAt primitive.DateTime `json:"updated_at" bson:"updated_at"`
+}
+
+type CategoryService struct {
+	collection *mongo.Collection
+}
+
+func NewCategoryService(categoryCollection *mongo.Collection) CategoryService {
+	return CategoryService{
+		collection: categoryCollection,
+	}
+}
+
+// Learn puts the items starting with the normalized word into the category for this user
+func (s *CategoryService) Learn(ctx context.Context, userID primitive.ObjectID, word string, category string) error {
+	ctx, end, err := startOp(ctx, "category_learn", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	upsert := true
+	_, err = s.collection.UpdateOne(
+		ctx,
+		bson.M{"user_id": userID, "word": word},
+		bson.M{"$set": bson.M{"category": category, "updated_at": primitive.NewDateTimeFromTime(time.Now())}},
+		&options.UpdateOptions{Upsert: &upsert},
+	)
+	if err != nil {
+		metrics.DbCategoryLearn.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbCategoryLearn.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+// Learned maps the words learned from the user to category ids
+func (s *CategoryService) Learned(ctx context.Context, userID primitive.ObjectID) (map[string]string, error) {
+	ctx, end, err := startOp(ctx, "category_learned", opRead)
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+	var words []CategoryWord
+	cursor, err := s.collection.Find(ctx, bson.M{"user_id": userID})
+	if err == nil {
+		err = cursor.All(ctx, &words)
+	}
+	if err != nil {
+		metrics.DbCategoryLearned.With(prometheus.Labels{"result": "error"}).Inc()
+		return nil, err
+	}
+	metrics.DbCategoryLearned.With(prometheus.Labels{"result": "success"}).Inc()
+	learned := map[string]string{}
+	for _, word := range words {
+		learned[word.Word] = word.Category
+	}
+
+	return learned, nil
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/boryashkin/purchaselist/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+const (
+	DbName      = "purchaselist"
+	ColUsers    = "users"
+	ColSessions = "sessions"
+	ColProducts = "purchaseLists"
+
+	ColMigrations       = "migrations"
+	ColProcessedUpdates = "processedUpdates"
+	ColBotState         = "botState"
+	ColLeases           = "leases"
+	ColTickets          = "tickets"
+	ColDebounce         = "debounce"
+	ColItemHistory      = "itemHistory"
+	ColCategoryWords    = "categoryWords"
+	ColTemplates        = "templates"
+	ColJobs             = "jobs"
+	ColRecipes          = "recipes"
+	ColPantry           = "pantry"
+)
+
+const (
+	opRead = iota
+	opWrite
+)
+
+// Timeouts limit every db call, so a stuck mongo doesn't pile up goroutines
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+	// Operations override the timeouts of single operations, such as plist_find_by_id
+	Operations map[string]time.Duration
+}
+
+var (
+	timeouts = Timeouts{Read: 5 * time.Second, Write: 10 * time.Second}
+	breaker  = NewBreaker(5, 30*time.Second)
+)
+
+// Configure replaces the default timeouts and circuit breaker of all the services, it is called before the first call
+func Configure(t Timeouts, b *Breaker) {
+	timeouts = t
+	breaker = b
+}
+
+func timeoutOf(operation string, kind int) time.Duration {
+	if d, found := timeouts.Operations[operation]; found {
+		return d
+	}
+	if kind == opRead {
+		return timeouts.Read
+	}
+	return timeouts.Write
+}
+
+// startOp opens a span of a db call limited by its timeout, the returned func closes it and observes the duration.
+// The call fails fast with ErrUnavailable while the circuit breaker is open.
+func startOp(ctx context.Context, operation string, kind int) (context.Context, func(), error) {
+	if !breaker.Allow() {
+		metrics.DbBreakerRejected.With(prometheus.Labels{"operation": operation}).Inc()
+		return ctx, nil, ErrUnavailable
+	}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, timeoutOf(operation, kind))
+	ctx, span := tracing.Start(ctx, "db."+operation,
+		attribute.String("db.system", "mongodb"),
+		attribute.String("db.operation", operation),
+	)
+	return ctx, func() {
+		timedOut := ctx.Err() == context.DeadlineExceeded
+		if timedOut {
+			metrics.DbTimeout.With(prometheus.Labels{"operation": operation}).Inc()
+			span.SetAttributes(attribute.Bool("timeout", true))
+		}
+		breaker.Done(timedOut)
+		cancel()
+		span.End()
+		metrics.ObserveDb(operation, start)
+	}, nil
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// DebounceService keeps the latest pending render of every list, so all the bot instances agree which one to send
+type DebounceService struct {
+	collection *mongo.Collection
+}
+
+func NewDebounceService(debounceCollection *mongo.Collection) DebounceService {
+	return DebounceService{
+		collection: debounceCollection,
+	}
+}
+
+func (s *DebounceService) SetLast(ctx context.Context, id primitive.ObjectID, random int) error {
+	ctx, end, err := startOp(ctx, "debounce_set_last", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	upsert := true
+	_, err = s.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"rand": random, "updated_at": primitive.NewDateTimeFromTime(time.Now())}},
+		&options.UpdateOptions{Upsert: &upsert},
+	)
+	if err != nil {
+		metrics.DbDebounceSetLast.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbDebounceSetLast.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+func (s *DebounceService) IsLast(ctx context.Context, id primitive.ObjectID, random int) (bool, error) {
+	ctx, end, err := startOp(ctx, "debounce_is_last", opRead)
+	if err != nil {
+		return false, err
+	}
+	defer end()
+	var last struct {
+		Rand int `bson:"rand"`
+	}
+	err = s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&last)
+	if err != nil {
+		metrics.DbDebounceIsLast.With(prometheus.Labels{"result": "error"}).Inc()
+		return false, err
+	}
+	metrics.DbDebounceIsLast.With(prometheus.Labels{"result": "success"}).Inc()
+
+	return last.Rand == random, nil
+}
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// historyHalfLife halves the weight of a purchase every month, so the suggestions follow changing habits
+	historyHalfLife = 30 * 24 * time.Hour
+	// historyScanLimit recent items of a user are ranked, older ones are rarely worth suggesting
+	historyScanLimit = 300
+)
+
+// HistoryItem counts the purchases of an item by a user, an item is bought when it is crossed out
+type HistoryItem struct {
+	Id           primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	UserID       primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Hash         PurchaseItemHash   `json:"hash" bson:"hash"`
+	Name         PurchaseItemName   `json:"name" bson:"name"`
+	Count        int                `json:"count" bson:"count"`
+	LastBoughtAt primitive.DateTime `json:"last_bought_at" bson:"last_bought_at"`
+}
+
+type HistoryService struct {
+	collection *mongo.Collection
+}
+
+func NewHistoryService(historyCollection *mongo.Collection) HistoryService {
+	return HistoryService{
+		collection: historyCollection,
+	}
+}
+
+// Record counts the items as bought by the user at the given time
+func (s *HistoryService) Record(ctx context.Context, userID primitive.ObjectID, items []PurchaseItem, at time.Time) error {
+	if len(items) == 0 {
+		return nil
+	}
+	ctx, end, err := startOp(ctx, "history_record", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	logger.Debug(ctx, "history.Record", "user_id", userID, "items", len(items))
+	var models []mongo.WriteModel
+	for _, item := range items {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"user_id": userID, "hash": item.Hash}).
+			SetUpdate(bson.M{
+				"$inc": bson.M{"count": 1},
+				"$set": bson.M{"name": item.Name},
+				"$max": bson.M{"last_bought_at": primitive.NewDateTimeFromTime(at)},
+			}).
+			SetUpsert(true))
+	}
+	_, err = s.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		metrics.DbHistoryRecord.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbHistoryRecord.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+func (s *HistoryService) Find(ctx context.Context, userID primitive.ObjectID, hash PurchaseItemHash) (HistoryItem, error) {
+	ctx, end, err := startOp(ctx, "history_find", opRead)
+	if err != nil {
+		return HistoryItem{}, err
+	}
+	defer end()
+	var item HistoryItem
+	err = s.collection.FindOne(ctx, bson.M{"user_id": userID, "hash": hash}).Decode(&item)
+	if err != nil {
+		metrics.DbHistoryFind.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbHistoryFind.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return item, err
+}
+
+// Suggest returns up to limit items the user buys most often and most recently.
+// When query is set only the items containing it are returned, those starting with it go first.
+// Items in exclude, usually the ones already in the list, are skipped.
+func (s *HistoryService) Suggest(ctx context.Context, userID primitive.ObjectID, query string, exclude map[PurchaseItemHash]bool, limit int) ([]HistoryItem, error) {
+	ctx, end, err := startOp(ctx, "history_suggest", opRead)
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+	var items []HistoryItem
+	opts := options.Find().SetSort(bson.M{"last_bought_at": -1}).SetLimit(historyScanLimit)
+	cursor, err := s.collection.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err == nil {
+		err = cursor.All(ctx, &items)
+	}
+	if err != nil {
+		metrics.DbHistorySuggest.With(prometheus.Labels{"result": "error"}).Inc()
+		return nil, err
+	}
+	metrics.DbHistorySuggest.With(prometheus.Labels{"result": "success"}).Inc()
+
+	return RankHistory(items, query, exclude, limit, time.Now()), nil
+}
+
+// RankHistory orders the items by the number of purchases, each one weighted by its age
+func RankHistory(items []HistoryItem, query string, exclude map[PurchaseItemHash]bool, limit int, now time.Time) []HistoryItem {
+	query = strings.ToLower(strings.TrimSpace(query))
+	type ranked struct {
+		item   HistoryItem
+		prefix bool
+		score  float64
+	}
+	var candidates []ranked
+	for _, item := range items {
+		if exclude[item.Hash] {
+			continue
+		}
+		name := strings.ToLower(string(item.Name))
+		if query != "" && !strings.Contains(name, query) {
+			continue
+		}
+		age := now.Sub(item.LastBoughtAt.Time())
+		if age < 0 {
+			age = 0
+		}
+		candidates = append(candidates, ranked{
+			item:   item,
+			prefix: query != "" && strings.HasPrefix(name, query),
+			score:  float64(item.Count) * math.Pow(0.5, float64(age)/float64(historyHalfLife)),
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].prefix != candidates[j].prefix {
+			return candidates[i].prefix
+		}
+		return candidates[i].score > candidates[j].score
+	})
+	var result []HistoryItem
+	for _, c := range candidates {
+		if len(result) >= limit {
+			break
+		}
+		result = append(result, c.item)
+	}
+
+	return result
+}
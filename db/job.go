@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const (
+	// JobTemplate creates a list from the template RefID points to
+	JobTemplate = "template"
+	// JobReminder sends the list RefID points to back to its members
+	JobReminder = "reminder"
+)
+
+// Job is a run of something at RunAt, kept in the database so it survives restarts.
+// There is one job per kind and ref, scheduling it again moves it.
+type Job struct {
+	Id    primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	Kind  string             `json:"kind" bson:"kind"`
+	RefID primitive.ObjectID `json:"ref_id" bson:"ref_id"`
+	RunAt primitive.DateTime `json:"run_at" bson:"run_at"`
+	// Attempts failed in a row
+	Attempts int `json:"attempts" bson:"attempts"`
+	// LockedBy is the instance running the job until LockedUntil
+	LockedBy    string             `json:"locked_by,omitempty" bson:"locked_by,omitempty"`
+	LockedUntil primitive.DateTime `json:"locked_until,omitempty" bson:"locked_until,omitempty"`
+}
+
+type JobService struct {
+	collection *mongo.Collection
+}
+
+func NewJobService(jobCollection *mongo.Collection) JobService {
+	return JobService{
+		collection: jobCollection,
+	}
+}
+
+// Schedule runs the job of the ref at the given time, a running job is left to finish but won't move it back
+func (s *JobService) Schedule(ctx context.Context, kind string, refID primitive.ObjectID, runAt time.Time) error {
+	ctx, end, err := startOp(ctx, "job_schedule", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	upsert := true
+	_, err = s.collection.UpdateOne(
+		ctx,
+		bson.M{"kind": kind, "ref_id": refID},
+		bson.M{
+			"$set":   bson.M{"run_at": primitive.NewDateTimeFromTime(runAt), "attempts": 0},
+			"$unset": bson.M{"locked_by": "", "locked_until": ""},
+		},
+		&options.UpdateOptions{Upsert: &upsert},
+	)
+	if err != nil {
+		metrics.DbJobSchedule.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbJobSchedule.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+func (s *JobService) Cancel(ctx context.Context, kind string, refID primitive.ObjectID) error {
+	ctx, end, err := startOp(ctx, "job_cancel", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	_, err = s.collection.DeleteOne(ctx, bson.M{"kind": kind, "ref_id": refID})
+	if err != nil {
+		metrics.DbJobCancel.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbJobCancel.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+// Claim locks the earliest due job for the holder until ttl passes, found is false when nothing is due.
+// A job of a holder which died while running it is claimed again once its lock expires.
+func (s *JobService) Claim(ctx context.Context, now time.Time, holder string, ttl time.Duration) (Job, bool, error) {
+	ctx, end, err := startOp(ctx, "job_claim", opWrite)
+	if err != nil {
+		return Job{}, false, err
+	}
+	defer end()
+	var job Job
+	err = s.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{
+			"run_at": bson.M{"$lte": primitive.NewDateTimeFromTime(now)},
+			"$or": bson.A{
+				bson.M{"locked_until": bson.M{"$exists": false}},
+				bson.M{"locked_until": bson.M{"$lt": primitive.NewDateTimeFromTime(now)}},
+			},
+		},
+		bson.M{"$set": bson.M{"locked_by": holder, "locked_until": primitive.NewDateTimeFromTime(now.Add(ttl))}},
+		options.FindOneAndUpdate().SetSort(bson.M{"run_at": 1}).SetReturnDocument(options.After),
+	).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		metrics.DbJobClaim.With(prometheus.Labels{"result": "empty"}).Inc()
+		return job, false, nil
+	}
+	if err != nil {
+		metrics.DbJobClaim.With(prometheus.Labels{"result": "error"}).Inc()
+		return job, false, err
+	}
+	metrics.DbJobClaim.With(prometheus.Labels{"result": "success"}).Inc()
+
+	return job, true, nil
+}
+
+// Finish unlocks the job and moves it to the next run, attempts are the failures in a row.
+// A zero next run removes the job. A job scheduled again while it ran is left as scheduled.
+func (s *JobService) Finish(ctx context.Context, job Job, next time.Time, attempts int) error {
+	ctx, end, err := startOp(ctx, "job_finish", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	filter := bson.M{"_id": job.Id, "locked_by": job.LockedBy}
+	if next.IsZero() {
+		_, err = s.collection.DeleteOne(ctx, filter)
+	} else {
+		_, err = s.collection.UpdateOne(ctx, filter, bson.M{
+			"$set":   bson.M{"run_at": primitive.NewDateTimeFromTime(next), "attempts": attempts},
+			"$unset": bson.M{"locked_by": "", "locked_until": ""},
+		})
+	}
+	if err != nil {
+		metrics.DbJobFinish.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbJobFinish.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// Lease is held by a single bot instance until it expires or is released
+type Lease struct {
+	Name      string             `json:"_id" bson:"_id"`
+	Holder    string             `json:"holder" bson:"holder"`
+	ExpiresAt primitive.DateTime `json:"expires_at" bson:"expires_at"`
+}
+
+type LeaseService struct {
+	collection *mongo.Collection
+}
+
+func NewLeaseService(leaseCollection *mongo.Collection) LeaseService {
+	return LeaseService{
+		collection: leaseCollection,
+	}
+}
+
+// Acquire takes a free or expired lease, or prolongs the one the holder already has.
+// It returns false while the lease belongs to someone else.
+func (s *LeaseService) Acquire(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	ctx, end, err := startOp(ctx, "lease_acquire", opWrite)
+	if err != nil {
+		return false, err
+	}
+	defer end()
+	now := time.Now()
+	upsert := true
+	_, err = s.collection.UpdateOne(
+		ctx,
+		bson.M{
+			"_id": name,
+			"$or": bson.A{
+				bson.M{"holder": holder},
+				bson.M{"expires_at": bson.M{"$lt": primitive.NewDateTimeFromTime(now)}},
+			},
+		},
+		bson.M{
+			"$set": bson.M{
+				"holder":     holder,
+				"expires_at": primitive.NewDateTimeFromTime(now.Add(ttl)),
+			},
+		},
+		&options.UpdateOptions{Upsert: &upsert},
+	)
+	// the filter didn't match an existing lease, so the upsert collided with it
+	if mongo.IsDuplicateKeyError(err) {
+		metrics.DbLeaseAcquire.With(prometheus.Labels{"result": "busy"}).Inc()
+		return false, nil
+	}
+	if err != nil {
+		metrics.DbLeaseAcquire.With(prometheus.Labels{"result": "error"}).Inc()
+		return false, err
+	}
+	metrics.DbLeaseAcquire.With(prometheus.Labels{"result": "success"}).Inc()
+
+	return true, nil
+}
+
+func (s *LeaseService) Release(ctx context.Context, name string, holder string) error {
+	ctx, end, err := startOp(ctx, "lease_release", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	_, err = s.collection.DeleteOne(ctx, bson.M{"_id": name, "holder": holder})
+	if err != nil {
+		metrics.DbLeaseRelease.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbLeaseRelease.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
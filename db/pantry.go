@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/boryashkin/purchaselist/recipe"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+)
+
+// PantryItem is something a user has at home, crossed out items are added to it
+type PantryItem struct {
+	Id     primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	UserID primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Name   string             `json:"name" bson:"name"`
+	// Key is the lowercased name, a user has one item per key
+	Key string `json:"key" bson:"key"`
+	// Amount in the unit, zero means it is used up
+	Amount float64 `json:"amount" bson:"amount"`
+	// Unit is "г", "мл", "шт" or another one as written, like in recipes
+	Unit string `json:"unit" bson:"unit"`
+	// Threshold in the unit, less than it is low stock, zero means only a used up item is
+	Threshold float64            `json:"threshold,omitempty" bson:"threshold,omitempty"`
+	UpdatedAt primitive.DateTime `json:"updated_at" bson:"updated_at,omitempty"`
+}
+
+// Low tells whether the item is used up or below its threshold
+func (i PantryItem) Low() bool {
+	return i.Amount <= 0 || i.Amount < i.Threshold
+}
+
+// Ingredient is the item with its amount, "молоко 2 л"
+func (i PantryItem) Ingredient() recipe.Ingredient {
+	return recipe.Ingredient{Name: i.Name, Amount: i.Amount, Unit: i.Unit}
+}
+
+type PantryService struct {
+	collection *mongo.Collection
+}
+
+func NewPantryService(pantryCollection *mongo.Collection) PantryService {
+	return PantryService{
+		collection: pantryCollection,
+	}
+}
+
+// Stock adds the amount to the item with the same name and unit, an item bought in another unit
+// starts over in it and loses its threshold
+func (s *PantryService) Stock(ctx context.Context, userID primitive.ObjectID, ingredient recipe.Ingredient) error {
+	ctx, end, err := startOp(ctx, "pantry_stock", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	now := primitive.NewDateTimeFromTime(time.Now())
+	key := strings.ToLower(ingredient.Name)
+	res, err := s.collection.UpdateOne(
+		ctx,
+		bson.M{"user_id": userID, "key": key, "unit": ingredient.Unit},
+		bson.M{"$inc": bson.M{"amount": ingredient.Amount}, "$set": bson.M{"updated_at": now}},
+	)
+	if err == nil && res.MatchedCount == 0 {
+		upsert := true
+		_, err = s.collection.UpdateOne(
+			ctx,
+			bson.M{"user_id": userID, "key": key},
+			bson.M{
+				"$set":   bson.M{"name": ingredient.Name, "amount": ingredient.Amount, "unit": ingredient.Unit, "updated_at": now},
+				"$unset": bson.M{"threshold": ""},
+			},
+			&options.UpdateOptions{Upsert: &upsert},
+		)
+	}
+	if err != nil {
+		metrics.DbPantryStock.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPantryStock.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+func (s *PantryService) FindByID(ctx context.Context, id primitive.ObjectID) (PantryItem, error) {
+	ctx, end, err := startOp(ctx, "pantry_find_by_id", opRead)
+	if err != nil {
+		return PantryItem{}, err
+	}
+	defer end()
+	var item PantryItem
+	err = s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&item)
+	if err != nil {
+		metrics.DbPantryFindByID.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPantryFindByID.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return item, err
+}
+
+// FindByName ignores the case of the name
+func (s *PantryService) FindByName(ctx context.Context, userID primitive.ObjectID, name string) (PantryItem, error) {
+	ctx, end, err := startOp(ctx, "pantry_find_by_name", opRead)
+	if err != nil {
+		return PantryItem{}, err
+	}
+	defer end()
+	var item PantryItem
+	err = s.collection.FindOne(ctx, bson.M{"user_id": userID, "key": strings.ToLower(name)}).Decode(&item)
+	if err != nil {
+		metrics.DbPantryFindByName.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPantryFindByName.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return item, err
+}
+
+// FindByUserID returns the pantry of the user by name
+func (s *PantryService) FindByUserID(ctx context.Context, userID primitive.ObjectID) ([]PantryItem, error) {
+	ctx, end, err := startOp(ctx, "pantry_find_by_user_id", opRead)
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+	var items []PantryItem
+	cursor, err := s.collection.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"key": 1}))
+	if err == nil {
+		err = cursor.All(ctx, &items)
+	}
+	if err != nil {
+		metrics.DbPantryFindByUserID.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPantryFindByUserID.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return items, err
+}
+
+// SetAmount of the item, zero marks it used up
+func (s *PantryService) SetAmount(ctx context.Context, id primitive.ObjectID, amount float64) error {
+	ctx, end, err := startOp(ctx, "pantry_set_amount", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	_, err = s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"amount": amount, "updated_at": primitive.NewDateTimeFromTime(time.Now())},
+	})
+	if err != nil {
+		metrics.DbPantrySetAmount.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPantrySetAmount.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+// SetThreshold of the item, an item not bought yet is created used up, so it is low stock at once
+func (s *PantryService) SetThreshold(ctx context.Context, userID primitive.ObjectID, ingredient recipe.Ingredient) (PantryItem, error) {
+	ctx, end, err := startOp(ctx, "pantry_set_threshold", opWrite)
+	if err != nil {
+		return PantryItem{}, err
+	}
+	defer end()
+	var item PantryItem
+	err = s.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"user_id": userID, "key": strings.ToLower(ingredient.Name)},
+		bson.M{
+			"$set":         bson.M{"threshold": ingredient.Amount, "updated_at": primitive.NewDateTimeFromTime(time.Now())},
+			"$setOnInsert": bson.M{"name": ingredient.Name, "amount": 0, "unit": ingredient.Unit},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&item)
+	if err != nil {
+		metrics.DbPantrySetThreshold.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPantrySetThreshold.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return item, err
+}
+
+func (s *PantryService) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, end, err := startOp(ctx, "pantry_delete", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	_, err = s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		metrics.DbPantryDelete.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPantryDelete.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
@@ -5,12 +5,14 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
+	"github.com/boryashkin/purchaselist/cache"
+	"github.com/boryashkin/purchaselist/logger"
 	"github.com/boryashkin/purchaselist/metrics"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"log"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"strings"
 	"time"
 )
@@ -19,8 +21,14 @@ type PurchaseItemName string
 type PurchaseItemHash string
 
 type PurchaseItem struct {
-	Name PurchaseItemName
-	Hash PurchaseItemHash
+	Name PurchaseItemName `json:"name" bson:"name"`
+	Hash PurchaseItemHash `json:"hash" bson:"hash"`
+}
+
+// ListMember is someone who crossed out items of the list, usually a list shared to a group
+type ListMember struct {
+	TgID int    `json:"tg_id" bson:"tg_id"`
+	Name string `json:"name" bson:"name"`
 }
 
 type TgMsgID struct {
@@ -38,10 +46,127 @@ type PurchaseList struct {
 	TgMsgID           []TgMsgID          `json:"tg_msg_id" bson:"tg_msg_id"`
 	CreatedAt         primitive.DateTime `json:"created_at" bson:"created_at,omitempty"`
 	UpdatedAt         primitive.DateTime `json:"updated_at" bson:"updated_at,omitempty"`
+	// Categories maps items to category ids, the items missing in it are classified when the list is rendered
+	Categories map[PurchaseItemHash]string `json:"categories,omitempty" bson:"categories,omitempty"`
+	// Store is a copy of the store profile chosen for the list, its sections order the items
+	Store *StoreLayout `json:"store,omitempty" bson:"store,omitempty"`
+	// Prices of the items in kopecks
+	Prices map[PurchaseItemHash]int64 `json:"prices,omitempty" bson:"prices,omitempty"`
+	// BoughtAt is the time every item was crossed out, purchases are counted by it
+	BoughtAt map[PurchaseItemHash]primitive.DateTime `json:"bought_at,omitempty" bson:"bought_at,omitempty"`
+	// Budget in kopecks, zero means no budget
+	Budget int64 `json:"budget,omitempty" bson:"budget,omitempty"`
+	// Members who crossed out items, the costs are split between them
+	Members []ListMember `json:"members,omitempty" bson:"members,omitempty"`
+	// PaidBy maps the crossed out items to the telegram ids of the members who paid for them
+	PaidBy map[PurchaseItemHash]int `json:"paid_by,omitempty" bson:"paid_by,omitempty"`
+	// DueAt is when the list is sent back to its members, zero means no reminder
+	DueAt primitive.DateTime `json:"due_at,omitempty" bson:"due_at,omitempty"`
+	// ItemsDue are the due times of single items, the earliest of them brings DueAt forward
+	ItemsDue map[PurchaseItemHash]primitive.DateTime `json:"items_due,omitempty" bson:"items_due,omitempty"`
+	// Reminded counts the reminders sent since DueAt was set
+	Reminded int `json:"reminded,omitempty" bson:"reminded,omitempty"`
+}
+
+// Paid sums the prices of the crossed out items by the members who paid for them
+func (l *PurchaseList) Paid() map[int]int64 {
+	paid := map[int]int64{}
+	for _, hash := range l.DeletedItemHashes {
+		payer, found := l.PaidBy[hash]
+		if !found || l.Prices[hash] == 0 {
+			continue
+		}
+		paid[payer] += l.Prices[hash]
+	}
+	return paid
+}
+
+// MemberName of a telegram user, the latest name the member was seen with
+func (l *PurchaseList) MemberName(tgID int) string {
+	name := ""
+	for _, member := range l.Members {
+		if member.TgID == tgID {
+			name = member.Name
+		}
+	}
+	return name
+}
+
+// Spent sums the prices of the crossed out and the remaining items
+func (l *PurchaseList) Spent() (bought int64, remaining int64) {
+	for _, hash := range l.DeletedItemHashes {
+		bought += l.Prices[hash]
+	}
+	for _, hash := range l.Items {
+		remaining += l.Prices[hash]
+	}
+	return bought, remaining
+}
+
+// clone copies the slices, so a list changed by the caller doesn't change the cached one
+func (l PurchaseList) clone() PurchaseList {
+	// an empty slice stays empty rather than nil, nil is saved as null
+	if l.ItemsDictionary != nil {
+		l.ItemsDictionary = append(make([]PurchaseItem, 0, len(l.ItemsDictionary)), l.ItemsDictionary...)
+	}
+	if l.Items != nil {
+		l.Items = append(make([]PurchaseItemHash, 0, len(l.Items)), l.Items...)
+	}
+	if l.DeletedItemHashes != nil {
+		l.DeletedItemHashes = append(make([]PurchaseItemHash, 0, len(l.DeletedItemHashes)), l.DeletedItemHashes...)
+	}
+	if l.TgMsgID != nil {
+		l.TgMsgID = append(make([]TgMsgID, 0, len(l.TgMsgID)), l.TgMsgID...)
+	}
+	if l.Categories != nil {
+		categories := make(map[PurchaseItemHash]string, len(l.Categories))
+		for hash, category := range l.Categories {
+			categories[hash] = category
+		}
+		l.Categories = categories
+	}
+	if l.Prices != nil {
+		prices := make(map[PurchaseItemHash]int64, len(l.Prices))
+		for hash, amount := range l.Prices {
+			prices[hash] = amount
+		}
+		l.Prices = prices
+	}
+	if l.BoughtAt != nil {
+		boughtAt := make(map[PurchaseItemHash]primitive.DateTime, len(l.BoughtAt))
+		for hash, at := range l.BoughtAt {
+			boughtAt[hash] = at
+		}
+		l.BoughtAt = boughtAt
+	}
+	if l.Members != nil {
+		l.Members = append(make([]ListMember, 0, len(l.Members)), l.Members...)
+	}
+	if l.PaidBy != nil {
+		paidBy := make(map[PurchaseItemHash]int, len(l.PaidBy))
+		for hash, payer := range l.PaidBy {
+			paidBy[hash] = payer
+		}
+		l.PaidBy = paidBy
+	}
+	if l.ItemsDue != nil {
+		itemsDue := make(map[PurchaseItemHash]primitive.DateTime, len(l.ItemsDue))
+		for hash, at := range l.ItemsDue {
+			itemsDue[hash] = at
+		}
+		l.ItemsDue = itemsDue
+	}
+	if l.Store != nil {
+		store := *l.Store
+		store.Sections = append([]string{}, store.Sections...)
+		l.Store = &store
+	}
+	return l
 }
 
 type PurchaseListService struct {
 	collection *mongo.Collection
+	cache      *cache.LRU
 }
 
 func NewPurchaseListService(purchaseListCollection *mongo.Collection) PurchaseListService {
@@ -50,27 +175,70 @@ func NewPurchaseListService(purchaseListCollection *mongo.Collection) PurchaseLi
 	}
 }
 
-func (s *PurchaseListService) Create(list *PurchaseList) error {
-	log.Println("pl.Create")
-	result, err := s.collection.InsertOne(context.Background(), list)
+// EnableCache serves FindByID from memory, the writes of the service put the lists they change into it
+func (s *PurchaseListService) EnableCache(c *cache.LRU) {
+	s.cache = c
+}
+
+// update changes the list and puts the changed list into the cache, so the next read of the list doesn't
+// go to the db. It returns false when the filter matched nothing.
+func (s *PurchaseListService) update(ctx context.Context, id primitive.ObjectID, filter bson.M, update bson.M) (bool, error) {
+	if s.cache == nil {
+		res, err := s.collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return false, err
+		}
+		return res.MatchedCount > 0, nil
+	}
+	var pList PurchaseList
+	after := options.After
+	err := s.collection.FindOneAndUpdate(ctx, filter, update, &options.FindOneAndUpdateOptions{ReturnDocument: &after}).Decode(&pList)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		// the write may be done or not, the list is read again
+		s.cache.Invalidate(id.Hex())
+		return false, err
+	}
+	s.cache.Put(id.Hex(), pList.clone())
+
+	return true, nil
+}
+
+func (s *PurchaseListService) Create(ctx context.Context, list *PurchaseList) error {
+	ctx, end, err := startOp(ctx, "plist_create", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	logger.Debug(ctx, "pl.Create")
+	result, err := s.collection.InsertOne(ctx, list)
 	if err != nil {
 		return err
 	}
 	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
 		list.Id = oid
 		metrics.DbPlistCreate.With(prometheus.Labels{"result": "success"}).Inc()
+		s.cache.Put(oid.Hex(), list.clone())
 	} else {
-		log.Println("Failed to extract ObjectId")
+		logger.Error(ctx, "failed to extract ObjectId")
 		metrics.DbPlistCreate.With(prometheus.Labels{"result": "error"}).Inc()
 		return errors.New("failed to extract id")
 	}
 	return err
 }
 
-func (s *PurchaseListService) AddMsgID(id primitive.ObjectID, msgID TgMsgID) error {
-	log.Println("pl.AddMsgID")
-	_, err := s.collection.UpdateOne(
-		context.Background(),
+func (s *PurchaseListService) AddMsgID(ctx context.Context, id primitive.ObjectID, msgID TgMsgID) error {
+	ctx, end, err := startOp(ctx, "plist_add_msg_id", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	logger.Debug(ctx, "pl.AddMsgID")
+	_, err = s.update(
+		ctx,
+		id,
 		bson.M{"_id": id}, bson.M{"$push": bson.M{"tg_msg_id": msgID}},
 	)
 	if err != nil {
@@ -82,10 +250,16 @@ func (s *PurchaseListService) AddMsgID(id primitive.ObjectID, msgID TgMsgID) err
 	return err
 }
 
-func (s *PurchaseListService) DeleteMsgID(id primitive.ObjectID, msgID TgMsgID) error {
-	log.Println("pl.DeleteMsgID")
-	_, err := s.collection.UpdateOne(
-		context.Background(),
+func (s *PurchaseListService) DeleteMsgID(ctx context.Context, id primitive.ObjectID, msgID TgMsgID) error {
+	ctx, end, err := startOp(ctx, "plist_delete_msg_id", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	logger.Debug(ctx, "pl.DeleteMsgID")
+	_, err = s.update(
+		ctx,
+		id,
 		bson.M{"_id": id}, bson.M{"$pull": bson.M{"tg_msg_id": msgID}},
 	)
 	if err != nil {
@@ -97,28 +271,145 @@ func (s *PurchaseListService) DeleteMsgID(id primitive.ObjectID, msgID TgMsgID)
 	return err
 }
 
-func (s *PurchaseListService) FindByID(id primitive.ObjectID) (PurchaseList, error) {
-	log.Println("pl.FindByID", id)
+func (s *PurchaseListService) FindByID(ctx context.Context, id primitive.ObjectID) (PurchaseList, error) {
+	if cached, found := s.cache.Get(id.Hex()); found {
+		return cached.(PurchaseList).clone(), nil
+	}
+	generation := s.cache.Generation()
+	ctx, end, err := startOp(ctx, "plist_find_by_id", opRead)
+	if err != nil {
+		return PurchaseList{}, err
+	}
+	defer end()
+	logger.Debug(ctx, "pl.FindByID", "list_id", id)
 	var pList PurchaseList
-	err := s.collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&pList)
+	err = s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&pList)
 	if err != nil {
 		metrics.DbPlistFindByID.With(prometheus.Labels{"result": "error"}).Inc()
 	} else {
 		metrics.DbPlistFindByID.With(prometheus.Labels{"result": "success"}).Inc()
+		s.cache.Set(id.Hex(), pList.clone(), generation)
 	}
 
 	return pList, err
 }
 
-func (s *PurchaseListService) CrossOutItemFromPurchaseList(id primitive.ObjectID, itemHash string) error {
-	log.Println("pl.CrossOut")
-	_, err := s.collection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": id},
+func (s *PurchaseListService) FindByUserID(ctx context.Context, id primitive.ObjectID, limit int64) ([]PurchaseList, error) {
+	ctx, end, err := startOp(ctx, "plist_find_by_user_id", opRead)
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+	logger.Debug(ctx, "pl.FindByUserID", "user_id", id)
+	var pLists []PurchaseList
+	opts := options.Find().SetSort(bson.M{"updated_at": -1}).SetLimit(limit)
+	cursor, err := s.collection.Find(ctx, bson.M{"user_id": id}, opts)
+	if err == nil {
+		err = cursor.All(ctx, &pLists)
+	}
+	if err != nil {
+		metrics.DbPlistFindByUserID.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPlistFindByUserID.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return pLists, err
+}
+
+// CountUpdatedSince counts lists changed after the given time
+func (s *PurchaseListService) CountUpdatedSince(ctx context.Context, since time.Time) (int64, error) {
+	ctx, end, err := startOp(ctx, "plist_count_updated_since", opRead)
+	if err != nil {
+		return 0, err
+	}
+	defer end()
+	count, err := s.collection.CountDocuments(ctx, bson.M{
+		"updated_at": bson.M{"$gt": primitive.NewDateTimeFromTime(since)},
+	})
+	if err != nil {
+		metrics.DbPlistCountUpdatedSince.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPlistCountUpdatedSince.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return count, err
+}
+
+// UpdateItems overwrites the dictionary and both item sets of the list
+func (s *PurchaseListService) UpdateItems(ctx context.Context, list *PurchaseList) error {
+	ctx, end, err := startOp(ctx, "plist_update_items", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	logger.Debug(ctx, "pl.UpdateItems")
+	_, err = s.update(
+		ctx,
+		list.Id,
+		bson.M{"_id": list.Id},
+		bson.M{
+			"$set": bson.M{
+				"items_dictionary":       list.ItemsDictionary,
+				"purchase_items":         list.Items,
+				"deleted_purchase_items": list.DeletedItemHashes,
+				"updated_at":             primitive.NewDateTimeFromTime(time.Now()),
+			},
+		},
+	)
+	if err != nil {
+		metrics.DbPlistUpdateItems.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPlistUpdateItems.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+// Repair saves a list fixed by RepairDictionary
+func (s *PurchaseListService) Repair(ctx context.Context, list *PurchaseList) error {
+	ctx, end, err := startOp(ctx, "plist_repair", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	fields := RepairFields(list)
+	fields["updated_at"] = primitive.NewDateTimeFromTime(time.Now())
+	_, err = s.update(ctx, list.Id, bson.M{"_id": list.Id}, bson.M{"$set": fields})
+	if err != nil {
+		metrics.DbPlistRepair.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPlistRepair.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+// CrossOutItemFromPurchaseList tells whether the item was in the list, it is false when it is crossed out again.
+// The payer, if known, becomes a member of the list.
+func (s *PurchaseListService) CrossOutItemFromPurchaseList(ctx context.Context, id primitive.ObjectID, itemHash string, payer *ListMember) (bool, error) {
+	ctx, end, err := startOp(ctx, "plist_cross_out_item_from_purchase_list", opWrite)
+	if err != nil {
+		return false, err
+	}
+	defer end()
+	logger.Debug(ctx, "pl.CrossOut")
+	set := bson.M{
+		"updated_at":            primitive.NewDateTimeFromTime(time.Now()),
+		"bought_at." + itemHash: primitive.NewDateTimeFromTime(time.Now()),
+	}
+	addToSet := bson.M{"deleted_purchase_items": itemHash}
+	if payer != nil {
+		set["paid_by."+itemHash] = payer.TgID
+		addToSet["members"] = *payer
+	}
+	matched, err := s.update(
+		ctx,
+		id,
+		bson.M{"_id": id, "purchase_items": itemHash},
 		bson.M{
-			"$addToSet": bson.M{"deleted_purchase_items": itemHash},
+			"$addToSet": addToSet,
 			"$pull":     bson.M{"purchase_items": itemHash},
-			"$set":      bson.M{"updated_at": primitive.NewDateTimeFromTime(time.Now())},
+			"$set":      set,
 		},
 	)
 	if err != nil {
@@ -127,21 +418,36 @@ func (s *PurchaseListService) CrossOutItemFromPurchaseList(id primitive.ObjectID
 		metrics.DbPlistCrossOutItemFromPurchaseList.With(prometheus.Labels{"result": "success"}).Inc()
 	}
 
-	return err
+	if err != nil {
+		return false, err
+	}
+
+	return matched, nil
 }
 
-func (s *PurchaseListService) AddItemToPurchaseList(id primitive.ObjectID, item PurchaseItemName) error {
-	log.Println("pl.AddItemToPurchaseList")
+// AddItemToPurchaseList adds the item to the list, the category is kept unless it is empty
+func (s *PurchaseListService) AddItemToPurchaseList(ctx context.Context, id primitive.ObjectID, item PurchaseItemName, category string) error {
+	ctx, end, err := startOp(ctx, "plist_add_item_to_purchase_list", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	logger.Debug(ctx, "pl.AddItemToPurchaseList")
 	hash := PurchaseItemHash(GetMD5Hash(string(item)))
-	_, err := s.collection.UpdateOne(
-		context.Background(),
+	set := bson.M{"updated_at": primitive.NewDateTimeFromTime(time.Now())}
+	if category != "" {
+		set["categories."+string(hash)] = category
+	}
+	_, err = s.update(
+		ctx,
+		id,
 		bson.M{"_id": id},
 		bson.M{
 			"$addToSet": bson.M{
 				"items_dictionary": PurchaseItem{Name: item, Hash: hash},
 				"purchase_items":   hash,
 			},
-			"$set": bson.M{"updated_at": primitive.NewDateTimeFromTime(time.Now())},
+			"$set": set,
 		},
 	)
 	if err != nil {
@@ -153,8 +459,210 @@ func (s *PurchaseListService) AddItemToPurchaseList(id primitive.ObjectID, item
 	return err
 }
 
-func (s *PurchaseListService) CreateEmptyList(id primitive.ObjectID) (*PurchaseList, error) {
-	log.Println("CreateEmptyList")
+// ReplaceItem puts the item in place of an active one, it is false when the old item isn't active anymore,
+// such as crossed out in the meantime. The category is kept unless it is empty.
+func (s *PurchaseListService) ReplaceItem(ctx context.Context, id primitive.ObjectID, old PurchaseItemHash, item PurchaseItemName, category string) (bool, error) {
+	ctx, end, err := startOp(ctx, "plist_replace_item", opWrite)
+	if err != nil {
+		return false, err
+	}
+	defer end()
+	hash := PurchaseItemHash(GetMD5Hash(string(item)))
+	set := bson.M{
+		"purchase_items.$": hash,
+		"updated_at":       primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if category != "" {
+		set["categories."+string(hash)] = category
+	}
+	matched, err := s.update(
+		ctx,
+		id,
+		bson.M{"_id": id, "purchase_items": old},
+		bson.M{
+			"$addToSet": bson.M{"items_dictionary": PurchaseItem{Name: item, Hash: hash}},
+			"$set":      set,
+		},
+	)
+	if err != nil {
+		metrics.DbPlistReplaceItem.With(prometheus.Labels{"result": "error"}).Inc()
+		return false, err
+	}
+	metrics.DbPlistReplaceItem.With(prometheus.Labels{"result": "success"}).Inc()
+
+	return matched, nil
+}
+
+// SetStore orders the list by the store sections, a nil store brings back the default order
+func (s *PurchaseListService) SetStore(ctx context.Context, id primitive.ObjectID, store *StoreLayout) error {
+	ctx, end, err := startOp(ctx, "plist_set_store", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	update := bson.M{"$set": bson.M{"store": store, "updated_at": primitive.NewDateTimeFromTime(time.Now())}}
+	if store == nil {
+		update = bson.M{"$unset": bson.M{"store": ""}, "$set": bson.M{"updated_at": primitive.NewDateTimeFromTime(time.Now())}}
+	}
+	_, err = s.update(ctx, id, bson.M{"_id": id}, update)
+	if err != nil {
+		metrics.DbPlistSetStore.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPlistSetStore.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+// SetPrice keeps the price of an item in kopecks, zero removes it
+func (s *PurchaseListService) SetPrice(ctx context.Context, id primitive.ObjectID, hash PurchaseItemHash, amount int64) error {
+	ctx, end, err := startOp(ctx, "plist_set_price", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	update := bson.M{"$set": bson.M{"prices." + string(hash): amount, "updated_at": primitive.NewDateTimeFromTime(time.Now())}}
+	if amount == 0 {
+		update = bson.M{"$unset": bson.M{"prices." + string(hash): ""}, "$set": bson.M{"updated_at": primitive.NewDateTimeFromTime(time.Now())}}
+	}
+	_, err = s.update(ctx, id, bson.M{"_id": id}, update)
+	if err != nil {
+		metrics.DbPlistSetPrice.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPlistSetPrice.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+// SetBudget of the list in kopecks, zero removes it
+func (s *PurchaseListService) SetBudget(ctx context.Context, id primitive.ObjectID, budget int64) error {
+	ctx, end, err := startOp(ctx, "plist_set_budget", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	_, err = s.update(ctx, id, bson.M{"_id": id}, bson.M{"$set": bson.M{"budget": budget}})
+	if err != nil {
+		metrics.DbPlistSetBudget.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPlistSetBudget.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+// SetDue of the list and starts counting its reminders again, a zero time removes it
+func (s *PurchaseListService) SetDue(ctx context.Context, id primitive.ObjectID, due time.Time) error {
+	ctx, end, err := startOp(ctx, "plist_set_due", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	update := bson.M{"$set": bson.M{"due_at": primitive.NewDateTimeFromTime(due)}, "$unset": bson.M{"reminded": ""}}
+	if due.IsZero() {
+		update = bson.M{"$unset": bson.M{"due_at": "", "reminded": ""}}
+	}
+	_, err = s.update(ctx, id, bson.M{"_id": id}, update)
+	if err != nil {
+		metrics.DbPlistSetDue.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPlistSetDue.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+func (s *PurchaseListService) SetItemDue(ctx context.Context, id primitive.ObjectID, hash PurchaseItemHash, due time.Time) error {
+	ctx, end, err := startOp(ctx, "plist_set_item_due", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	_, err = s.update(ctx, id, bson.M{"_id": id}, bson.M{"$set": bson.M{"items_due." + string(hash): primitive.NewDateTimeFromTime(due)}})
+	if err != nil {
+		metrics.DbPlistSetItemDue.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPlistSetItemDue.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+// IncReminded counts a reminder sent about the list
+func (s *PurchaseListService) IncReminded(ctx context.Context, id primitive.ObjectID) error {
+	ctx, end, err := startOp(ctx, "plist_inc_reminded", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	_, err = s.update(ctx, id, bson.M{"_id": id}, bson.M{"$inc": bson.M{"reminded": 1}})
+	if err != nil {
+		metrics.DbPlistIncReminded.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPlistIncReminded.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+// FindWithPurchasesSince returns the lists with prices changed after the given time,
+// both of the user and the ones the user crossed out items in
+func (s *PurchaseListService) FindWithPurchasesSince(ctx context.Context, userID primitive.ObjectID, tgID int, since time.Time) ([]PurchaseList, error) {
+	ctx, end, err := startOp(ctx, "plist_find_with_purchases_since", opRead)
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+	var pLists []PurchaseList
+	cursor, err := s.collection.Find(ctx, bson.M{
+		"$or":        bson.A{bson.M{"user_id": userID}, bson.M{"members.tg_id": tgID}},
+		"updated_at": bson.M{"$gte": primitive.NewDateTimeFromTime(since)},
+		"prices":     bson.M{"$exists": true},
+	})
+	if err == nil {
+		err = cursor.All(ctx, &pLists)
+	}
+	if err != nil {
+		metrics.DbPlistFindWithPurchasesSince.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPlistFindWithPurchasesSince.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return pLists, err
+}
+
+// SetCategories sets the categories of the given items, the categories of the other items are kept
+func (s *PurchaseListService) SetCategories(ctx context.Context, id primitive.ObjectID, categories map[PurchaseItemHash]string) error {
+	if len(categories) == 0 {
+		return nil
+	}
+	ctx, end, err := startOp(ctx, "plist_set_categories", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	set := bson.M{"updated_at": primitive.NewDateTimeFromTime(time.Now())}
+	for hash, category := range categories {
+		set["categories."+string(hash)] = category
+	}
+	_, err = s.update(ctx, id, bson.M{"_id": id}, bson.M{"$set": set})
+	if err != nil {
+		metrics.DbPlistSetCategories.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbPlistSetCategories.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+func (s *PurchaseListService) CreateEmptyList(ctx context.Context, id primitive.ObjectID) (*PurchaseList, error) {
+	ctx, end, err := startOp(ctx, "plist_create_empty_list", opWrite)
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+	logger.Debug(ctx, "pl.CreateEmptyList")
 	purchaseList := PurchaseList{
 		UserID:    id,
 		TgMsgID:   []TgMsgID{},
@@ -165,14 +673,123 @@ func (s *PurchaseListService) CreateEmptyList(id primitive.ObjectID) (*PurchaseL
 	purchaseList.ItemsDictionary = []PurchaseItem{}
 	purchaseList.Items = []PurchaseItemHash{}
 	purchaseList.DeletedItemHashes = []PurchaseItemHash{}
-	err := s.Create(&purchaseList)
+	err = s.Create(ctx, &purchaseList)
 	if err != nil {
-		log.Println("Failed to insert a purchaseList", err)
+		logger.Error(ctx, "failed to insert a purchaseList", "err", err)
 		return nil, errors.New("failed to save a purchaseList")
 	}
 	return &purchaseList, err
 }
 
+// RepairDictionary recalculates missing or stale hashes of the dictionary entries
+// and returns the number of fixed entries with hashes still having no name.
+// The items, crossed out items and the maps by hash follow the new hashes of their entries.
+// Orphaned hashes are removed from the list when dropOrphans is set.
+func RepairDictionary(list *PurchaseList, dropOrphans bool) (int, []PurchaseItemHash) {
+	fixed := 0
+	dic := []PurchaseItem{}
+	known := map[PurchaseItemHash]bool{}
+	renamed := map[PurchaseItemHash]PurchaseItemHash{}
+	for _, pItem := range list.ItemsDictionary {
+		if pItem.Name == "" {
+			fixed++
+			continue
+		}
+		hash := PurchaseItemHash(GetMD5Hash(string(pItem.Name)))
+		if pItem.Hash != hash {
+			fixed++
+			if pItem.Hash != "" {
+				renamed[pItem.Hash] = hash
+			}
+			pItem.Hash = hash
+		}
+		if known[hash] {
+			continue
+		}
+		known[hash] = true
+		dic = append(dic, pItem)
+	}
+	list.ItemsDictionary = dic
+	rename := func(hash PurchaseItemHash) PurchaseItemHash {
+		if to, found := renamed[hash]; found {
+			return to
+		}
+		return hash
+	}
+
+	var orphans []PurchaseItemHash
+	filter := func(hashes []PurchaseItemHash) []PurchaseItemHash {
+		result := []PurchaseItemHash{}
+		seen := map[PurchaseItemHash]bool{}
+		for _, hash := range hashes {
+			hash = rename(hash)
+			if seen[hash] {
+				continue
+			}
+			seen[hash] = true
+			if !known[hash] {
+				orphans = append(orphans, hash)
+				if dropOrphans {
+					continue
+				}
+			}
+			result = append(result, hash)
+		}
+		return result
+	}
+	list.Items = filter(list.Items)
+	list.DeletedItemHashes = filter(list.DeletedItemHashes)
+	for from, to := range renamed {
+		if category, found := list.Categories[from]; found {
+			delete(list.Categories, from)
+			list.Categories[to] = category
+		}
+		if price, found := list.Prices[from]; found {
+			delete(list.Prices, from)
+			list.Prices[to] = price
+		}
+		if at, found := list.BoughtAt[from]; found {
+			delete(list.BoughtAt, from)
+			list.BoughtAt[to] = at
+		}
+		if tgID, found := list.PaidBy[from]; found {
+			delete(list.PaidBy, from)
+			list.PaidBy[to] = tgID
+		}
+		if at, found := list.ItemsDue[from]; found {
+			delete(list.ItemsDue, from)
+			list.ItemsDue[to] = at
+		}
+	}
+
+	return fixed, orphans
+}
+
+// RepairFields are the fields of the list changed by RepairDictionary, for a $set
+func RepairFields(list *PurchaseList) bson.M {
+	fields := bson.M{
+		"items_dictionary":       list.ItemsDictionary,
+		"purchase_items":         list.Items,
+		"deleted_purchase_items": list.DeletedItemHashes,
+	}
+	if list.Categories != nil {
+		fields["categories"] = list.Categories
+	}
+	if list.Prices != nil {
+		fields["prices"] = list.Prices
+	}
+	if list.BoughtAt != nil {
+		fields["bought_at"] = list.BoughtAt
+	}
+	if list.PaidBy != nil {
+		fields["paid_by"] = list.PaidBy
+	}
+	if list.ItemsDue != nil {
+		fields["items_due"] = list.ItemsDue
+	}
+	return fields
+}
+
 func GetMD5Hash(text string) string {
 	hash := md5.Sum([]byte(strings.ToLower(text)))
 	return hex.EncodeToString(hash[:])
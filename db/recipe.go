@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/boryashkin/purchaselist/recipe"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+)
+
+// Recipe is a named dish of a user, "борщ на 6" in a list becomes its ingredients for 6 servings
+type Recipe struct {
+	Id     primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	UserID primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Name   string             `json:"name" bson:"name"`
+	// Key is the lowercased name, a user has one recipe per key
+	Key string `json:"key" bson:"key"`
+	// Servings the recipe was written for, it is shown for them
+	Servings int `json:"servings" bson:"servings"`
+	// Ingredients with the amounts per serving
+	Ingredients []recipe.Ingredient `json:"ingredients" bson:"ingredients"`
+	CreatedAt   primitive.DateTime  `json:"created_at" bson:"created_at,omitempty"`
+	UpdatedAt   primitive.DateTime  `json:"updated_at" bson:"updated_at,omitempty"`
+}
+
+type RecipeService struct {
+	collection *mongo.Collection
+}
+
+func NewRecipeService(recipeCollection *mongo.Collection) RecipeService {
+	return RecipeService{
+		collection: recipeCollection,
+	}
+}
+
+// Save replaces the recipe with the same name or creates it
+func (s *RecipeService) Save(ctx context.Context, r *Recipe) error {
+	ctx, end, err := startOp(ctx, "recipe_save", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	now := primitive.NewDateTimeFromTime(time.Now())
+	err = s.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"user_id": r.UserID, "key": strings.ToLower(r.Name)},
+		bson.M{
+			"$set": bson.M{
+				"name":        r.Name,
+				"servings":    r.Servings,
+				"ingredients": r.Ingredients,
+				"updated_at":  now,
+			},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(r)
+	if err != nil {
+		metrics.DbRecipeSave.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbRecipeSave.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+// FindByName ignores the case of the name
+func (s *RecipeService) FindByName(ctx context.Context, userID primitive.ObjectID, name string) (Recipe, error) {
+	ctx, end, err := startOp(ctx, "recipe_find_by_name", opRead)
+	if err != nil {
+		return Recipe{}, err
+	}
+	defer end()
+	var r Recipe
+	err = s.collection.FindOne(ctx, bson.M{"user_id": userID, "key": strings.ToLower(name)}).Decode(&r)
+	if err != nil {
+		metrics.DbRecipeFindByName.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbRecipeFindByName.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return r, err
+}
+
+// FindByUserID returns the recipes of the user by name
+func (s *RecipeService) FindByUserID(ctx context.Context, userID primitive.ObjectID) ([]Recipe, error) {
+	ctx, end, err := startOp(ctx, "recipe_find_by_user_id", opRead)
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+	var recipes []Recipe
+	cursor, err := s.collection.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"key": 1}))
+	if err == nil {
+		err = cursor.All(ctx, &recipes)
+	}
+	if err != nil {
+		metrics.DbRecipeFindByUserID.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbRecipeFindByUserID.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return recipes, err
+}
+
+func (s *RecipeService) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, end, err := startOp(ctx, "recipe_delete", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	_, err = s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		metrics.DbRecipeDelete.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbRecipeDelete.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
@@ -3,14 +3,15 @@ package db
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/boryashkin/purchaselist/cache"
+	"github.com/boryashkin/purchaselist/logger"
 	"github.com/boryashkin/purchaselist/metrics"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"log"
-	"time"
 )
 
 const (
@@ -30,10 +31,18 @@ type Session struct {
 	PreviousState  SessState          `json:"previous_state" bson:"previous_state"`
 	PurchaseListId primitive.ObjectID `json:"purchase_list_id" bson:"purchase_list_id"`
 	CreatedAt      primitive.DateTime `json:"created_at" bson:"created_at,omitempty"`
+	// PricePrompt is the crossed out item the bot asked the price of, the next amount sent is its price
+	PricePrompt *PricePrompt `json:"price_prompt,omitempty" bson:"price_prompt,omitempty"`
+}
+
+type PricePrompt struct {
+	ListID primitive.ObjectID `json:"list_id" bson:"list_id"`
+	Hash   PurchaseItemHash   `json:"hash" bson:"hash"`
 }
 
 type SessionService struct {
 	collection *mongo.Collection
+	cache      *cache.LRU
 }
 
 func NewSessionService(sessionCollection *mongo.Collection) SessionService {
@@ -42,26 +51,43 @@ func NewSessionService(sessionCollection *mongo.Collection) SessionService {
 	}
 }
 
-func (s *SessionService) FindByUserID(id primitive.ObjectID) (Session, error) {
-	log.Println("session.FindByUserID")
+// EnableCache serves FindByUserID from memory, the writes of the service put the sessions they change into it
+func (s *SessionService) EnableCache(c *cache.LRU) {
+	s.cache = c
+}
+
+func (s *SessionService) FindByUserID(ctx context.Context, id primitive.ObjectID) (Session, error) {
+	if cached, found := s.cache.Get(id.Hex()); found {
+		return cached.(Session), nil
+	}
+	generation := s.cache.Generation()
+	ctx, end, err := startOp(ctx, "session_find_by_user_id", opRead)
+	if err != nil {
+		return Session{}, err
+	}
+	defer end()
+	logger.Debug(ctx, "session.FindByUserID")
 	var session Session
-	err := s.collection.FindOne(context.Background(), bson.M{
+	err = s.collection.FindOne(ctx, bson.M{
 		"user_id": id,
 	}).Decode(&session)
 	if err != nil {
 		metrics.DbSessionFindByUserID.With(prometheus.Labels{"result": "error"}).Inc()
 	} else {
 		metrics.DbSessionFindByUserID.With(prometheus.Labels{"result": "success"}).Inc()
+		s.cache.Set(id.Hex(), session, generation)
 	}
 
 	return session, err
 }
 
-func (s *SessionService) Create(session *Session) error {
-	log.Println("session.Create")
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
-
+func (s *SessionService) Create(ctx context.Context, session *Session) error {
+	ctx, end, err := startOp(ctx, "session_create", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	logger.Debug(ctx, "session.Create")
 	upsert := true
 	opts := options.UpdateOptions{
 		Upsert: &upsert,
@@ -78,6 +104,7 @@ func (s *SessionService) Create(session *Session) error {
 	)
 	if err != nil {
 		metrics.DbSessionCreate.With(prometheus.Labels{"result": "error"}).Inc()
+		s.cache.Invalidate(session.UserId.Hex())
 		return err
 	}
 
@@ -86,31 +113,44 @@ func (s *SessionService) Create(session *Session) error {
 		if oid, ok := result.UpsertedID.(primitive.ObjectID); ok {
 			session.Id = oid
 		} else {
-			log.Println("Failed to extract ObjectId")
+			logger.Error(ctx, "failed to extract ObjectId")
+			s.cache.Invalidate(session.UserId.Hex())
 			return errors.New("failed to extract id")
 		}
 	} else {
 		metrics.DbSessionCreate.With(prometheus.Labels{"result": "noop"}).Inc()
+		s.cache.Invalidate(session.UserId.Hex())
 		return errors.New("already exists")
 	}
+	// the new session is inserted as it is
+	s.cache.Put(session.UserId.Hex(), *session)
 
 	return nil
 }
 
-func (s *SessionService) UpdateSession(session *Session) error {
-	log.Println("session.UpdateSession", session.PreviousState, session.PostingState, session.PurchaseListId)
-	_, err := s.collection.UpdateOne(context.Background(), bson.M{"_id": session.Id}, bson.M{
+func (s *SessionService) UpdateSession(ctx context.Context, session *Session) error {
+	ctx, end, err := startOp(ctx, "session_update_session", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	logger.Debug(ctx, "session.UpdateSession", "previous_state", session.PreviousState, "state", session.PostingState, "list_id", session.PurchaseListId)
+	_, err = s.collection.UpdateOne(ctx, bson.M{"_id": session.Id}, bson.M{
 		"$set": bson.M{
 			"posting_state":    session.PostingState,
 			"previous_state":   session.PreviousState,
 			"purchase_list_id": session.PurchaseListId,
+			"price_prompt":     session.PricePrompt,
 		},
 	})
 	if err != nil {
 		metrics.DbSessionUpdate.With(prometheus.Labels{"result": "error"}).Inc()
-		return errors.New("failed to update a session")
+		s.cache.Invalidate(session.UserId.Hex())
+		return fmt.Errorf("failed to update a session: %w", err)
 	}
 	metrics.DbSessionUpdate.With(prometheus.Labels{"result": "success"}).Inc()
+	// the update sets every field which may change, so the session is cached as it is
+	s.cache.Put(session.UserId.Hex(), *session)
 
 	return nil
 }
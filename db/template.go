@@ -0,0 +1,168 @@
+package db
+
+import (
+	"context"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/boryashkin/purchaselist/schedule"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+)
+
+// Template is a saved list a user creates new lists from, by a tap or on a schedule
+type Template struct {
+	Id     primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	UserID primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Name   string             `json:"name" bson:"name"`
+	// Key is the lowercased name, a user has one template per key
+	Key        string                      `json:"key" bson:"key"`
+	Items      []PurchaseItem              `json:"items" bson:"items"`
+	Categories map[PurchaseItemHash]string `json:"categories,omitempty" bson:"categories,omitempty"`
+	Prices     map[PurchaseItemHash]int64  `json:"prices,omitempty" bson:"prices,omitempty"`
+	// Schedule creates a list from the template in the timezone of the user, nil means by hand only
+	Schedule *schedule.Weekly `json:"schedule,omitempty" bson:"schedule,omitempty"`
+	// ChatID the scheduled lists are sent to
+	ChatID    int64              `json:"chat_id" bson:"chat_id"`
+	CreatedAt primitive.DateTime `json:"created_at" bson:"created_at,omitempty"`
+	UpdatedAt primitive.DateTime `json:"updated_at" bson:"updated_at,omitempty"`
+}
+
+type TemplateService struct {
+	collection *mongo.Collection
+}
+
+func NewTemplateService(templateCollection *mongo.Collection) TemplateService {
+	return TemplateService{
+		collection: templateCollection,
+	}
+}
+
+// Save replaces the items of the template with the same name or creates it, the schedule is kept
+func (s *TemplateService) Save(ctx context.Context, tpl *Template) error {
+	ctx, end, err := startOp(ctx, "template_save", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	now := primitive.NewDateTimeFromTime(time.Now())
+	err = s.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"user_id": tpl.UserID, "key": strings.ToLower(tpl.Name)},
+		bson.M{
+			"$set": bson.M{
+				"name":       tpl.Name,
+				"items":      tpl.Items,
+				"categories": tpl.Categories,
+				"prices":     tpl.Prices,
+				"chat_id":    tpl.ChatID,
+				"updated_at": now,
+			},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(tpl)
+	if err != nil {
+		metrics.DbTemplateSave.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbTemplateSave.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+func (s *TemplateService) FindByID(ctx context.Context, id primitive.ObjectID) (Template, error) {
+	ctx, end, err := startOp(ctx, "template_find_by_id", opRead)
+	if err != nil {
+		return Template{}, err
+	}
+	defer end()
+	var tpl Template
+	err = s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&tpl)
+	if err != nil {
+		metrics.DbTemplateFindByID.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbTemplateFindByID.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return tpl, err
+}
+
+// FindByName ignores the case of the name
+func (s *TemplateService) FindByName(ctx context.Context, userID primitive.ObjectID, name string) (Template, error) {
+	ctx, end, err := startOp(ctx, "template_find_by_name", opRead)
+	if err != nil {
+		return Template{}, err
+	}
+	defer end()
+	var tpl Template
+	err = s.collection.FindOne(ctx, bson.M{"user_id": userID, "key": strings.ToLower(name)}).Decode(&tpl)
+	if err != nil {
+		metrics.DbTemplateFindByName.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbTemplateFindByName.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return tpl, err
+}
+
+// FindByUserID returns the templates of the user by name
+func (s *TemplateService) FindByUserID(ctx context.Context, userID primitive.ObjectID) ([]Template, error) {
+	ctx, end, err := startOp(ctx, "template_find_by_user_id", opRead)
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+	var templates []Template
+	cursor, err := s.collection.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"key": 1}))
+	if err == nil {
+		err = cursor.All(ctx, &templates)
+	}
+	if err != nil {
+		metrics.DbTemplateFindByUserID.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbTemplateFindByUserID.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return templates, err
+}
+
+// SetSchedule of the template, nil removes it
+func (s *TemplateService) SetSchedule(ctx context.Context, id primitive.ObjectID, weekly *schedule.Weekly) error {
+	ctx, end, err := startOp(ctx, "template_set_schedule", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	update := bson.M{"$set": bson.M{"schedule": weekly}}
+	if weekly == nil {
+		update = bson.M{"$unset": bson.M{"schedule": ""}}
+	}
+	_, err = s.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		metrics.DbTemplateSetSchedule.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbTemplateSetSchedule.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+func (s *TemplateService) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, end, err := startOp(ctx, "template_delete", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	_, err = s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		metrics.DbTemplateDelete.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbTemplateDelete.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
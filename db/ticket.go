@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// TicketQueue orders the updates of a key, such as a user, across the bot instances.
+// Every update takes the next ticket and waits until served reaches it.
+type TicketQueue struct {
+	Key    string `json:"_id" bson:"_id"`
+	Taken  int64  `json:"taken" bson:"taken"`
+	Served int64  `json:"served" bson:"served"`
+	// Abandoned are the tickets nobody waits for anymore
+	Abandoned []int64 `json:"abandoned" bson:"abandoned"`
+	// MovedAt is when the turn moved to the served ticket or its holder last reported being alive
+	MovedAt primitive.DateTime `json:"moved_at" bson:"moved_at"`
+}
+
+type TicketService struct {
+	collection *mongo.Collection
+}
+
+func NewTicketService(ticketCollection *mongo.Collection) TicketService {
+	return TicketService{
+		collection: ticketCollection,
+	}
+}
+
+// Take returns the next ticket of the key, the queue is created with the first one
+func (s *TicketService) Take(ctx context.Context, key string) (int64, error) {
+	ctx, end, err := startOp(ctx, "ticket_take", opWrite)
+	if err != nil {
+		return 0, err
+	}
+	defer end()
+	now := primitive.NewDateTimeFromTime(time.Now())
+	// the turn of an idle queue goes to the new ticket right away, it must not look stuck since the last update
+	_, err = s.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": key, "$expr": bson.M{"$eq": bson.A{"$served", "$taken"}}},
+		bson.M{"$set": bson.M{"moved_at": now}},
+	)
+	if err != nil {
+		metrics.DbTicketTake.With(prometheus.Labels{"result": "error"}).Inc()
+		return 0, err
+	}
+	var queue TicketQueue
+	upsert := true
+	after := options.After
+	for attempt := 0; attempt < 2; attempt++ {
+		err = s.collection.FindOneAndUpdate(
+			ctx,
+			bson.M{"_id": key},
+			bson.M{
+				"$inc":         bson.M{"taken": 1},
+				"$setOnInsert": bson.M{"served": 0, "abandoned": bson.A{}, "moved_at": now},
+			},
+			&options.FindOneAndUpdateOptions{Upsert: &upsert, ReturnDocument: &after},
+		).Decode(&queue)
+		// two instances created the queue at once, the second one finds it on a retry
+		if !mongo.IsDuplicateKeyError(err) {
+			break
+		}
+	}
+	if err != nil {
+		metrics.DbTicketTake.With(prometheus.Labels{"result": "error"}).Inc()
+		return 0, err
+	}
+	metrics.DbTicketTake.With(prometheus.Labels{"result": "success"}).Inc()
+
+	return queue.Taken - 1, nil
+}
+
+// Served returns the ticket whose turn it is, when the turn moved and whether the ticket is abandoned
+func (s *TicketService) Served(ctx context.Context, key string) (int64, time.Time, bool, error) {
+	ctx, end, err := startOp(ctx, "ticket_served", opRead)
+	if err != nil {
+		return 0, time.Time{}, false, err
+	}
+	defer end()
+	var queue TicketQueue
+	err = s.collection.FindOne(ctx, bson.M{"_id": key}).Decode(&queue)
+	if err != nil {
+		metrics.DbTicketServed.With(prometheus.Labels{"result": "error"}).Inc()
+		return 0, time.Time{}, false, err
+	}
+	metrics.DbTicketServed.With(prometheus.Labels{"result": "success"}).Inc()
+	abandoned := false
+	for _, ticket := range queue.Abandoned {
+		if ticket == queue.Served {
+			abandoned = true
+		}
+	}
+
+	return queue.Served, queue.MovedAt.Time(), abandoned, nil
+}
+
+// Serve passes the turn from the ticket to the next one, it returns false when the turn is elsewhere
+func (s *TicketService) Serve(ctx context.Context, key string, ticket int64) (bool, error) {
+	ctx, end, err := startOp(ctx, "ticket_serve", opWrite)
+	if err != nil {
+		return false, err
+	}
+	defer end()
+	res, err := s.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": key, "served": ticket},
+		bson.M{
+			"$set":  bson.M{"served": ticket + 1, "moved_at": primitive.NewDateTimeFromTime(time.Now())},
+			"$pull": bson.M{"abandoned": ticket},
+		},
+	)
+	if err != nil {
+		metrics.DbTicketServe.With(prometheus.Labels{"result": "error"}).Inc()
+		return false, err
+	}
+	if res.MatchedCount == 0 {
+		metrics.DbTicketServe.With(prometheus.Labels{"result": "moved"}).Inc()
+		return false, nil
+	}
+	metrics.DbTicketServe.With(prometheus.Labels{"result": "success"}).Inc()
+
+	return true, nil
+}
+
+// Touch keeps the turn of a running ticket from looking stuck
+func (s *TicketService) Touch(ctx context.Context, key string, ticket int64) error {
+	ctx, end, err := startOp(ctx, "ticket_touch", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	_, err = s.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": key, "served": ticket},
+		bson.M{"$set": bson.M{"moved_at": primitive.NewDateTimeFromTime(time.Now())}},
+	)
+	if err != nil {
+		metrics.DbTicketTouch.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbTicketTouch.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+// Abandon marks a ticket still waiting for its turn, it returns false when the turn came to it already
+func (s *TicketService) Abandon(ctx context.Context, key string, ticket int64) (bool, error) {
+	ctx, end, err := startOp(ctx, "ticket_abandon", opWrite)
+	if err != nil {
+		return false, err
+	}
+	defer end()
+	res, err := s.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": key, "served": bson.M{"$lt": ticket}},
+		bson.M{"$addToSet": bson.M{"abandoned": ticket}},
+	)
+	if err != nil {
+		metrics.DbTicketAbandon.With(prometheus.Labels{"result": "error"}).Inc()
+		return false, err
+	}
+	metrics.DbTicketAbandon.With(prometheus.Labels{"result": "success"}).Inc()
+
+	return res.MatchedCount > 0, nil
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const offsetStateID = "polling_offset"
+
+// ProcessedUpdate marks a telegram update as handled by one of the bot instances
+type ProcessedUpdate struct {
+	UpdateID  int                `json:"_id" bson:"_id"`
+	CreatedAt primitive.DateTime `json:"created_at" bson:"created_at"`
+}
+
+type botState struct {
+	Id        string             `bson:"_id"`
+	Offset    int                `bson:"offset"`
+	UpdatedAt primitive.DateTime `bson:"updated_at"`
+}
+
+type UpdateService struct {
+	processed *mongo.Collection
+	state     *mongo.Collection
+}
+
+func NewUpdateService(processedCollection *mongo.Collection, stateCollection *mongo.Collection) UpdateService {
+	return UpdateService{
+		processed: processedCollection,
+		state:     stateCollection,
+	}
+}
+
+// IsProcessed tells whether the update was handled already, by this or another instance
+func (s *UpdateService) IsProcessed(ctx context.Context, updateID int) (bool, error) {
+	ctx, end, err := startOp(ctx, "update_is_processed", opRead)
+	if err != nil {
+		return false, err
+	}
+	defer end()
+	err = s.processed.FindOne(ctx, bson.M{"_id": updateID}).Err()
+	if err == mongo.ErrNoDocuments {
+		metrics.DbUpdateIsProcessed.With(prometheus.Labels{"result": "new"}).Inc()
+		return false, nil
+	}
+	if err != nil {
+		metrics.DbUpdateIsProcessed.With(prometheus.Labels{"result": "error"}).Inc()
+		return false, err
+	}
+	metrics.DbUpdateIsProcessed.With(prometheus.Labels{"result": "duplicate"}).Inc()
+
+	return true, nil
+}
+
+// MarkProcessed records the update once it is handled, so its duplicates are skipped.
+// An update recorded already is not an error.
+func (s *UpdateService) MarkProcessed(ctx context.Context, updateID int) error {
+	ctx, end, err := startOp(ctx, "update_mark_processed", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	_, err = s.processed.InsertOne(ctx, ProcessedUpdate{
+		UpdateID:  updateID,
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+	})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		metrics.DbUpdateMarkProcessed.With(prometheus.Labels{"result": "error"}).Inc()
+		return err
+	}
+	metrics.DbUpdateMarkProcessed.With(prometheus.Labels{"result": "success"}).Inc()
+
+	return nil
+}
+
+// SaveOffset moves the stored polling offset forward, it never goes back.
+// The offset is the lowest update not handled yet, the updates after it may be handled already.
+func (s *UpdateService) SaveOffset(ctx context.Context, offset int) error {
+	ctx, end, err := startOp(ctx, "update_save_offset", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	upsert := true
+	_, err = s.state.UpdateOne(
+		ctx,
+		bson.M{"_id": offsetStateID},
+		bson.M{
+			"$max": bson.M{"offset": offset},
+			"$set": bson.M{"updated_at": primitive.NewDateTimeFromTime(time.Now())},
+		},
+		&options.UpdateOptions{Upsert: &upsert},
+	)
+	if err != nil {
+		metrics.DbUpdateSaveOffset.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbUpdateSaveOffset.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+// GetOffset returns the offset to continue polling from, 0 if nothing was saved yet
+func (s *UpdateService) GetOffset(ctx context.Context) (int, error) {
+	ctx, end, err := startOp(ctx, "update_get_offset", opRead)
+	if err != nil {
+		return 0, err
+	}
+	defer end()
+	logger.Debug(ctx, "update.GetOffset")
+	var state botState
+	err = s.state.FindOne(ctx, bson.M{"_id": offsetStateID}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+
+	return state.Offset, err
+}
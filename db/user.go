@@ -3,14 +3,13 @@ package db
 import (
 	"context"
 	"errors"
+	"github.com/boryashkin/purchaselist/logger"
 	"github.com/boryashkin/purchaselist/metrics"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"log"
-	"time"
 )
 
 type User struct {
@@ -20,6 +19,17 @@ type User struct {
 	Phone     string             `json:"phone" bson:"phone"`
 	Lang      string             `json:"lang" bson:"lang"`
 	CreatedAt primitive.DateTime `json:"created_at" bson:"created_at,omitempty"`
+	Stores    []StoreLayout      `json:"stores,omitempty" bson:"stores,omitempty"`
+	// Timezone is a name like Europe/Moscow or an offset like UTC+03:00, empty means the default of the bot
+	Timezone string `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	// Pantry is true when the crossed out items are added to the pantry of the user
+	Pantry bool `json:"pantry,omitempty" bson:"pantry,omitempty"`
+}
+
+// StoreLayout is a store profile of a user, its sections are category ids in the order of the aisles
+type StoreLayout struct {
+	Name     string   `json:"name" bson:"name"`
+	Sections []string `json:"sections" bson:"sections"`
 }
 
 type UserService struct {
@@ -27,25 +37,18 @@ type UserService struct {
 }
 
 func NewUserService(userCollection *mongo.Collection) UserService {
-	unq := true
-	idxOpts := options.IndexOptions{Unique: &unq}
-	_, err := userCollection.Indexes().CreateOne(
-		context.Background(),
-		mongo.IndexModel{Keys: bson.M{"tg_id": 1}, Options: &idxOpts},
-	)
-	if err != nil {
-		log.Println("failed to create index tg_id", err)
-	}
 	return UserService{
 		collection: userCollection,
 	}
 }
 
-func (s *UserService) Upsert(user *User) error {
-	log.Println("user.upsert")
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
-
+func (s *UserService) Upsert(ctx context.Context, user *User) error {
+	ctx, end, err := startOp(ctx, "user_upsert", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	logger.Debug(ctx, "user.upsert")
 	upsert := true
 	opts := options.UpdateOptions{
 		Upsert: &upsert,
@@ -76,10 +79,10 @@ func (s *UserService) Upsert(user *User) error {
 	if result.UpsertedCount > 0 {
 		metrics.DbUserUpsert.With(prometheus.Labels{"result": "success"}).Inc()
 		if oid, ok := result.UpsertedID.(primitive.ObjectID); ok {
-			log.Println("Upserted")
+			logger.Debug(ctx, "user.upserted", "user_id", oid)
 			user.Id = oid
 		} else {
-			log.Println("Failed to extract ObjectId")
+			logger.Error(ctx, "failed to extract ObjectId")
 			return errors.New("failed to extract id")
 		}
 	} else {
@@ -90,10 +93,15 @@ func (s *UserService) Upsert(user *User) error {
 	return nil
 }
 
-func (s *UserService) FindByID(id primitive.ObjectID) (User, error) {
-	log.Println("user.findByID")
+func (s *UserService) FindByID(ctx context.Context, id primitive.ObjectID) (User, error) {
+	ctx, end, err := startOp(ctx, "user_find_by_id", opRead)
+	if err != nil {
+		return User{}, err
+	}
+	defer end()
+	logger.Debug(ctx, "user.findByID")
 	var user User
-	err := s.collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&user)
+	err = s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
 	if err != nil {
 		metrics.DbUserFindByID.With(prometheus.Labels{"result": "error"}).Inc()
 	} else {
@@ -103,10 +111,15 @@ func (s *UserService) FindByID(id primitive.ObjectID) (User, error) {
 	return user, err
 }
 
-func (s *UserService) FindByTgID(id int) (User, error) {
-	log.Println("user.findByTgID")
+func (s *UserService) FindByTgID(ctx context.Context, id int) (User, error) {
+	ctx, end, err := startOp(ctx, "user_find_by_tg_id", opRead)
+	if err != nil {
+		return User{}, err
+	}
+	defer end()
+	logger.Debug(ctx, "user.findByTgID")
 	var user User
-	err := s.collection.FindOne(context.Background(), bson.M{"tg_id": id}).Decode(&user)
+	err = s.collection.FindOne(ctx, bson.M{"tg_id": id}).Decode(&user)
 	if err != nil {
 		metrics.DbUserFindByTgID.With(prometheus.Labels{"result": "error"}).Inc()
 	} else {
@@ -115,3 +128,51 @@ func (s *UserService) FindByTgID(id int) (User, error) {
 
 	return user, err
 }
+
+func (s *UserService) SetStores(ctx context.Context, id primitive.ObjectID, stores []StoreLayout) error {
+	ctx, end, err := startOp(ctx, "user_set_stores", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	_, err = s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"stores": stores}})
+	if err != nil {
+		metrics.DbUserSetStores.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbUserSetStores.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+func (s *UserService) SetTimezone(ctx context.Context, id primitive.ObjectID, timezone string) error {
+	ctx, end, err := startOp(ctx, "user_set_timezone", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	_, err = s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"timezone": timezone}})
+	if err != nil {
+		metrics.DbUserSetTimezone.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbUserSetTimezone.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
+
+func (s *UserService) SetPantry(ctx context.Context, id primitive.ObjectID, pantry bool) error {
+	ctx, end, err := startOp(ctx, "user_set_pantry", opWrite)
+	if err != nil {
+		return err
+	}
+	defer end()
+	_, err = s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"pantry": pantry}})
+	if err != nil {
+		metrics.DbUserSetPantry.With(prometheus.Labels{"result": "error"}).Inc()
+	} else {
+		metrics.DbUserSetPantry.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+
+	return err
+}
@@ -1,11 +1,18 @@
 package dialog
 
 import (
+	"context"
+	"errors"
+	"github.com/boryashkin/purchaselist/category"
+	"github.com/boryashkin/purchaselist/config"
 	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/export"
+	"github.com/boryashkin/purchaselist/importer"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/price"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"log"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -16,30 +23,84 @@ const (
 	ComCreatePost       = "create"
 	ComConfirm          = "ok"
 	ComClear            = "clear"
+	ComNew              = "new"
 	ComCancel           = "cancel"
 	ComDone             = "Гoтовo"
 	ComFinishedCrossout = "Нoвый списoк"
 	ComSwitchInline     = "Oткpыть мeню"
+	ComExport           = "export"
+	ComCategory         = "category"
+	ComStore            = "store"
+	ComBudget           = "budget"
+	ComSpent            = "spent"
+	ComSettle           = "settle"
+	ComTemplate         = "template"
+	ComSchedule         = "schedule"
+	ComTimezone         = "timezone"
+	ComRemind           = "remind"
+	ComRecipe           = "recipe"
+	ComPantry           = "pantry"
+
+	// CbExport prefixes callback data of the export format keyboard
+	CbExport = "exp:"
+	// CbSuggest prefixes callback data of the suggested items keyboard, the item hash follows
+	CbSuggest = "sug:"
+	// CbStore prefixes callback data of the store keyboard, the index of the store or "-" follows
+	CbStore = "st:"
+	// CbStoreNone is chosen to order the list in the default way
+	CbStoreNone = "-"
+	// CbSettle is the callback data of the button splitting the costs of a shared list
+	CbSettle = "settle"
+	// CbTemplate follows the template id in callback data of the template keyboard
+	CbTemplate = "tpl"
+	// CbSnooze prefixes callback data of the snooze buttons of a reminder, minutes or "tmr" follow
+	CbSnooze = "snz:"
+	// CbRemindOff is the callback data of the button turning the reminder of a list off
+	CbRemindOff = "rmoff"
+	// CbUsedUp follows the pantry item id in callback data of the button marking it used up
+	CbUsedUp = "pu"
+	// CbRestock follows the pantry item id in callback data of the button adding it to the list
+	CbRestock = "rs"
+
+	maxRejectedInReport = 10
+	suggestionsPerRow   = 2
+	// MaxStores a user may keep, each of them is a button of the store keyboard
+	MaxStores          = 10
+	maxStoreNameLength = 24
 )
 
 type MessageHandler struct {
 	Bot                 *tgbotapi.BotAPI
 	PurchaseListService *db.PurchaseListService
+	Config              *config.Config
 	commands            map[string]bool
 	textReplacer        *strings.Replacer
 }
 
-func NewMessageHandler(api *tgbotapi.BotAPI, purchaseListService *db.PurchaseListService) MessageHandler {
+func NewMessageHandler(api *tgbotapi.BotAPI, purchaseListService *db.PurchaseListService, cfg *config.Config) MessageHandler {
 	list := map[string]bool{
 		ComStartBot:         true,
 		ComHelp:             true,
 		ComCreatePost:       true,
 		ComConfirm:          true,
 		ComClear:            true,
+		ComNew:              true,
 		ComCancel:           true,
 		ComDone:             true,
 		ComFinishedCrossout: true,
 		ComSwitchInline:     true,
+		ComExport:           cfg.Features.Export,
+		ComCategory:         cfg.Features.Categories,
+		ComStore:            cfg.Features.Categories,
+		ComBudget:           cfg.Features.Prices,
+		ComSpent:            cfg.Features.Prices,
+		ComSettle:           cfg.Features.Prices,
+		ComTemplate:         cfg.Features.Templates,
+		ComSchedule:         cfg.Features.Templates,
+		ComTimezone:         cfg.Features.Templates || cfg.Features.Reminders,
+		ComRemind:           cfg.Features.Reminders,
+		ComRecipe:           cfg.Features.Recipes,
+		ComPantry:           cfg.Features.Pantry,
 	}
 	replacer := strings.NewReplacer(
 		"_", "\\_",
@@ -62,7 +123,13 @@ func NewMessageHandler(api *tgbotapi.BotAPI, purchaseListService *db.PurchaseLis
 		"!", "\\!",
 		"\\", "",
 	)
-	return MessageHandler{Bot: api, commands: list, PurchaseListService: purchaseListService, textReplacer: replacer}
+	return MessageHandler{
+		Bot:                 api,
+		Config:              cfg,
+		commands:            list,
+		PurchaseListService: purchaseListService,
+		textReplacer:        replacer,
+	}
 }
 
 func (h *MessageHandler) ReadMessage(message *tgbotapi.Message, chatMsgID ChatMessageID) MessageDto {
@@ -72,15 +139,20 @@ func (h *MessageHandler) ReadMessage(message *tgbotapi.Message, chatMsgID ChatMe
 	}
 	if message.IsCommand() {
 		m.Command = strings.ToLower(message.Command())
-		if _, found := h.commands[m.Command]; !found {
+		if enabled := h.commands[m.Command]; !enabled {
 			m.Command = ComHelp
 		}
+		m.Args = strings.TrimSpace(message.CommandArguments())
 	} else if message.Text == ComDone || message.Text == ComFinishedCrossout {
 		m.Command = ComConfirm
 		m.Text = ""
 	} else if message.Text == ComSwitchInline {
 		m.Command = ComSwitchInline
 		m.Text = ""
+	} else if message.Document != nil && h.Config.Features.Import {
+		m.Document = message.Document
+		caption := strings.ToLower(strings.TrimSpace(message.Caption))
+		m.ImportToNewList = caption == "новый" || caption == "new"
 	} else if message.Caption != "" {
 		m.Text = message.Caption
 	} else if message.Text != "" {
@@ -98,11 +170,11 @@ func (h *MessageHandler) ReadInlineQuery(query *tgbotapi.InlineQuery, chatMsgID
 	return m
 }
 
-func (h *MessageHandler) GetNewStateByMessage(message *MessageDto, dState *DialogState) *DialogState {
+func (h *MessageHandler) GetNewStateByMessage(ctx context.Context, message *MessageDto, dState *DialogState) *DialogState {
 	currState := dState.Session.PostingState
 	prevState := dState.Session.PreviousState
 	newSessState := h.getNewSessionStateByCommand(message.Command, currState)
-	log.Println("[STATES] ", prevState, currState, newSessState)
+	logger.Debug(ctx, "session state", "previous", prevState, "current", currState, "new", newSessState)
 
 	if newSessState == currState {
 		if currState == db.SessPStateDone {
@@ -110,9 +182,9 @@ func (h *MessageHandler) GetNewStateByMessage(message *MessageDto, dState *Dialo
 		}
 	}
 	if newSessState == db.SessPStateDone {
-		purchaseList, err := h.PurchaseListService.CreateEmptyList(dState.Session.UserId)
+		purchaseList, err := h.PurchaseListService.CreateEmptyList(ctx, dState.Session.UserId)
 		if err != nil {
-			log.Println("failed to create p list", err)
+			logger.Error(ctx, "failed to create a list", "err", err)
 			dState.Session.PurchaseListId = primitive.NilObjectID
 		} else {
 			dState.Session.PurchaseListId = purchaseList.Id
@@ -144,7 +216,7 @@ func (h *MessageHandler) getNewSessionStateByCommand(command string, currState d
 		if currState == db.SessPStateNew || currState == db.SessPStateRegistered {
 			return db.SessPStateCreation
 		}
-	case ComClear:
+	case ComClear, ComNew:
 		return db.SessPStateDone
 
 	}
@@ -152,6 +224,152 @@ func (h *MessageHandler) getNewSessionStateByCommand(command string, currState d
 	return currState
 }
 
+var (
+	ErrCategoryUsage   = errors.New("category correction is not item = category")
+	ErrUnknownCategory = errors.New("unknown category")
+)
+
+// CategoryCorrection moves an item to another category, it is read from "/category молоко = молочное"
+type CategoryCorrection struct {
+	Item     string
+	Category category.Category
+}
+
+func ParseCategoryCorrection(args string) (CategoryCorrection, error) {
+	parts := strings.SplitN(args, "=", 2)
+	if len(parts) != 2 || category.Normalize(parts[0]) == "" {
+		return CategoryCorrection{}, ErrCategoryUsage
+	}
+	correction := CategoryCorrection{Item: strings.TrimSpace(parts[0])}
+	c, found := category.Find(parts[1])
+	if !found {
+		return correction, ErrUnknownCategory
+	}
+	correction.Category = c
+
+	return correction, nil
+}
+
+func (h *MessageHandler) GetMessageForCategory(correction CategoryCorrection, err error) MessageForReply {
+	msg := MessageForReply{NewMessage: true}
+	switch err {
+	case nil:
+		msg.Text = "«" + correction.Item + "» теперь в разделе «" + correction.Category.Title + "»"
+	case ErrCategoryUsage, ErrUnknownCategory:
+		msg.Text = "Чтобы поправить раздел товара, напишите\n/category молоко = молочное\n\nРазделы:"
+		for _, c := range category.All {
+			msg.Text += "\n" + c.Title
+		}
+	default:
+		msg.Text = "Не удалось сохранить раздел, попробуйте ещё раз"
+	}
+
+	return msg
+}
+
+var (
+	ErrStoreUsage      = errors.New("store is not name = sections")
+	ErrStoreNameLength = errors.New("store name is too long")
+	ErrTooManyStores   = errors.New("too many stores")
+)
+
+// ErrUnknownSection names the section of a store missing from the categories
+type ErrUnknownSection string
+
+func (e ErrUnknownSection) Error() string {
+	return "unknown section " + string(e)
+}
+
+// ParseStoreLayout reads "Ашан = овощи, хлеб, молочное", a store without sections is to be removed
+func ParseStoreLayout(args string) (db.StoreLayout, error) {
+	parts := strings.SplitN(args, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return db.StoreLayout{}, ErrStoreUsage
+	}
+	layout := db.StoreLayout{Name: strings.TrimSpace(parts[0])}
+	if len([]rune(layout.Name)) > maxStoreNameLength {
+		return layout, ErrStoreNameLength
+	}
+	for _, section := range strings.Split(parts[1], ",") {
+		if strings.TrimSpace(section) == "" {
+			continue
+		}
+		c, found := category.Find(section)
+		if !found {
+			return layout, ErrUnknownSection(strings.TrimSpace(section))
+		}
+		layout.Sections = append(layout.Sections, c.ID)
+	}
+
+	return layout, nil
+}
+
+// GetMessageForStores offers the stores of the user to order the list by
+func (h *MessageHandler) GetMessageForStores(purchaseList *db.PurchaseList, stores []db.StoreLayout) MessageForReply {
+	msg := MessageForReply{NewMessage: true}
+	if len(stores) == 0 {
+		msg.Text = storeUsage()
+		return msg
+	}
+	msg.Text = "Выберите магазин, список разложится по его отделам"
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for i, store := range stores {
+		text := store.Name
+		if purchaseList.Store != nil && purchaseList.Store.Name == store.Name {
+			text = "✔️ " + text
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			text,
+			purchaseList.Id.Hex()+":"+CbStore+strconv.Itoa(i),
+		)))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+		"Обычный порядок",
+		purchaseList.Id.Hex()+":"+CbStore+CbStoreNone,
+	)))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	msg.InlineKeyboard = &keyboard
+
+	return msg
+}
+
+func (h *MessageHandler) GetMessageForStoreSaved(layout db.StoreLayout, err error) MessageForReply {
+	msg := MessageForReply{NewMessage: true}
+	var unknown ErrUnknownSection
+	switch {
+	case err == nil && len(layout.Sections) == 0:
+		msg.Text = "Магазин «" + layout.Name + "» удалён"
+	case err == nil:
+		msg.Text = "Магазин «" + layout.Name + "» сохранён:"
+		for _, id := range layout.Sections {
+			msg.Text += "\n" + category.Title(id)
+		}
+		msg.Text += "\n\nВыбрать его для списка - /store"
+	case errors.As(err, &unknown):
+		msg.Text = "Не знаю отдел «" + string(unknown) + "»\n\n" + storeUsage()
+	case err == ErrStoreNameLength:
+		msg.Text = "Название магазина должно быть не длиннее " + strconv.Itoa(maxStoreNameLength) + " символов"
+	case err == ErrTooManyStores:
+		msg.Text = "Можно сохранить не больше " + strconv.Itoa(MaxStores) + " магазинов, удалите ненужный: /store Название ="
+	case err == ErrStoreUsage:
+		msg.Text = storeUsage()
+	default:
+		msg.Text = "Не удалось сохранить магазин, попробуйте ещё раз"
+	}
+
+	return msg
+}
+
+func storeUsage() string {
+	text := "Чтобы добавить магазин, перечислите его отделы по порядку обхода:\n" +
+		"/store Ашан = овощи, хлеб, молочное, мясо\n" +
+		"Отделы, которых нет в списке, окажутся в конце. Удалить магазин: /store Ашан =\n\nОтделы:"
+	for _, c := range category.All {
+		text += "\n" + c.Title
+	}
+	return text
+}
+
 type MessageForReply struct {
 	NewMessage     bool
 	DeletePrevious *bool
@@ -160,6 +378,8 @@ type MessageForReply struct {
 	InlineKeyboard *tgbotapi.InlineKeyboardMarkup
 	AnswerCallback *tgbotapi.CallbackConfig
 	ReplyKeyboard  *tgbotapi.ReplyKeyboardMarkup
+	ForceReply     *tgbotapi.ForceReply
+	Document       *tgbotapi.FileBytes
 	Markdown       *string
 	CreatedAt      *time.Time
 	SessionID      primitive.ObjectID
@@ -187,9 +407,40 @@ func (h *MessageHandler) GetMessageForReply(
 			msg.Text = " Чтобы составить список, записывайте товары сюда\n" +
 				" - Отдельными сообщениями\n" +
 				" - Одним сообщением, каждый товар с новой строки\n" +
-				" - Пересылайте сообщения из других чатов\n\n"
+				" - Пересылайте сообщения из других чатов\n"
+			if h.Config.Features.Import {
+				msg.Text += " - Присылайте файлы .txt, .csv, .md или .json (с подписью «новый» - в новый список)\n"
+			}
+			msg.Text += "\n/new - начать новый список\n"
+			if h.Config.Features.Export {
+				msg.Text += "/export - выгрузить список в файл\n"
+			}
+			if h.Config.Features.Categories {
+				msg.Text += "/category - поправить раздел товара\n"
+				msg.Text += "/store - порядок отделов в магазине\n"
+			}
+			if h.Config.Features.Prices {
+				msg.Text += "/budget - бюджет списка, цены пишите после товара: хлеб 45₽\n"
+				msg.Text += "/spent - сколько потрачено за месяц\n"
+				msg.Text += "/settle - кто кому сколько должен по общему списку\n"
+			}
+			if h.Config.Features.Templates {
+				msg.Text += "/template - шаблоны списков, /schedule - присылать их по расписанию\n"
+			}
+			if h.Config.Features.Recipes {
+				msg.Text += "/recipe - рецепты, «борщ на 6» в списке добавит продукты на 6 порций\n"
+			}
+			if h.Config.Features.Pantry {
+				msg.Text += "/pantry - запасы дома из вычеркнутых товаров\n"
+			}
+			if h.Config.Features.Reminders {
+				msg.Text += "/remind - напомнить о списке, срок товара пишите после него: торт к субботе\n"
+			}
+			if h.Config.Features.Templates || h.Config.Features.Reminders {
+				msg.Text += "/timezone - часовой пояс расписаний и напоминаний\n"
+			}
 			return msg
-		case ComClear:
+		case ComClear, ComNew:
 			msg.Text = "Список закрыт\n\n" +
 				"Введите название товара или список"
 			msg.NewMessage = true
@@ -197,6 +448,10 @@ func (h *MessageHandler) GetMessageForReply(
 		case ComSwitchInline:
 			msg = returnInlineKeyboard(msg)
 			return msg
+		case ComExport:
+			msg.Markdown = nil
+			msg = createExportKeyboard(msg, purchaseList)
+			return msg
 		}
 	}
 	switch session.PostingState {
@@ -218,7 +473,6 @@ func (h *MessageHandler) GetMessageForReply(
 		break
 	case db.SessPStateDone:
 		if m.Text == "" {
-			log.Println("[GMFR] 3")
 			msg = h.createMessageForPurchaseList(msg, purchaseList)
 		}
 		break
@@ -229,12 +483,41 @@ func (h *MessageHandler) GetMessageForReply(
 	return msg
 }
 
-func (h *MessageHandler) readPhoto(photo *[]tgbotapi.PhotoSize) []string {
+func (h *MessageHandler) GetMessageForImport(result *importer.Result, err error) MessageForReply {
+	msg := MessageForReply{NewMessage: true}
+	if err != nil {
+		msg.Text = "Не получилось прочитать файл. Подойдут .txt, .csv, .md или .json, выгруженный через /export"
+		return msg
+	}
+	msg.Text = "Добавлено товаров: " + strconv.Itoa(result.Added)
+	if len(result.Rejected) == 0 {
+		return msg
+	}
+	msg.Text += "\nПропущено строк: " + strconv.Itoa(len(result.Rejected))
+	for i, rejected := range result.Rejected {
+		if i >= maxRejectedInReport {
+			msg.Text += "\n…"
+			break
+		}
+		msg.Text += "\n"
+		if rejected.Line > 0 {
+			msg.Text += strconv.Itoa(rejected.Line) + ": "
+		}
+		if rejected.Text != "" {
+			msg.Text += rejected.Text + " - "
+		}
+		msg.Text += rejected.Reason
+	}
+
+	return msg
+}
+
+func (h *MessageHandler) readPhoto(ctx context.Context, photo *[]tgbotapi.PhotoSize) []string {
 	var urls []string
 	for _, photo := range *photo {
 		url, err := h.Bot.GetFileDirectURL(photo.FileID)
 		if err != nil {
-			log.Println(err)
+			logger.Warn(ctx, "failed to get a photo url", "err", err)
 		}
 		urls = append(urls, url)
 	}
@@ -243,7 +526,6 @@ func (h *MessageHandler) readPhoto(photo *[]tgbotapi.PhotoSize) []string {
 }
 
 func (h *MessageHandler) createMessageForPurchaseList(msg MessageForReply, purchaseList *db.PurchaseList) MessageForReply {
-	log.Println("createMessageForPurchaseList")
 	rows := [][]tgbotapi.InlineKeyboardButton{}
 	dic := map[db.PurchaseItemHash]db.PurchaseItemName{}
 	name := ""
@@ -261,7 +543,7 @@ func (h *MessageHandler) createMessageForPurchaseList(msg MessageForReply, purch
 		} else {
 			name = "Название потерялось 😔"
 		}
-		msg.Text += stylePre + h.textReplacer.Replace(name) + stylePost + "️\n"
+		msg.Text += stylePre + h.textReplacer.Replace(name) + stylePost + h.priceOf(purchaseList, key) + h.payerOf(purchaseList, key) + "️\n"
 	}
 	stylePre = ""
 	stylePost = ""
@@ -271,28 +553,39 @@ func (h *MessageHandler) createMessageForPurchaseList(msg MessageForReply, purch
 		}
 		rows = append(rows, keys)
 	}
-	for _, key := range purchaseList.Items {
-		keys := []tgbotapi.InlineKeyboardButton{}
-		keyS := string(key)
-		if _, found := dic[key]; found {
-			name = string(dic[key])
-		} else {
-			name = "Название потерялось 😔"
+	// shared without the category headers, every line of it becomes an item
+	itemsText := ""
+	groups := h.groupItems(purchaseList, dic)
+	for _, group := range groups {
+		if len(groups) > 1 {
+			msg.Text += "*" + h.textReplacer.Replace(category.Title(group.category)) + "*\n"
+		}
+		for _, key := range group.items {
+			keys := []tgbotapi.InlineKeyboardButton{}
+			keyS := string(key)
+			if _, found := dic[key]; found {
+				name = string(dic[key])
+			} else {
+				name = "Название потерялось 😔"
+			}
+			keys = append(keys, tgbotapi.NewInlineKeyboardButtonData(name, purchaseList.Id.Hex()+":"+keyS))
+			rows = append(rows, keys)
+			msg.Text += stylePre + h.textReplacer.Replace(name) + stylePost + h.priceOf(purchaseList, key) + h.dueOf(purchaseList, key) + "\n"
+			itemsText += stylePre + h.textReplacer.Replace(name) + stylePost + "\n"
 		}
-		keys = append(keys, tgbotapi.NewInlineKeyboardButtonData(name, purchaseList.Id.Hex()+":"+keyS))
-		rows = append(rows, keys)
-		msg.Text += stylePre + h.textReplacer.Replace(name) + stylePost + "\n"
 	}
+	msg.Text += h.totals(purchaseList)
 	if len(rows) > 0 {
 		if len(purchaseList.DeletedItemHashes) == 0 {
-			rows[0][0].SwitchInlineQuery = &msg.Text
+			rows[0][0].SwitchInlineQuery = &itemsText
 		}
+		rows = h.appendSettleButton(rows, purchaseList)
 		keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
 		msg.InlineKeyboard = &keyboard
 	} else {
 		keys := []tgbotapi.InlineKeyboardButton{}
 		if purchaseList.InlineMsgID != "" {
-			inLnk := "https://t.me/" + os.Getenv("BOTNAME")
+			inLnk := "https://t.me/" + h.Config.Telegram.BotName
 			inBtn := tgbotapi.InlineKeyboardButton{
 				Text: "Перейти к боту",
 				URL:  &inLnk,
@@ -301,13 +594,102 @@ func (h *MessageHandler) createMessageForPurchaseList(msg MessageForReply, purch
 		} else {
 			keys = append(keys, tgbotapi.NewInlineKeyboardButtonData(ComFinishedCrossout, purchaseList.Id.Hex()+":"+ComFinishedCrossout))
 		}
-		keyboard := tgbotapi.NewInlineKeyboardMarkup(keys)
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(h.appendSettleButton([][]tgbotapi.InlineKeyboardButton{keys}, purchaseList)...)
 		msg.InlineKeyboard = &keyboard
 	}
 
 	return msg
 }
 
+// priceOf the item follows its name, it is empty when the item has no price
+func (h *MessageHandler) priceOf(purchaseList *db.PurchaseList, key db.PurchaseItemHash) string {
+	amount, found := purchaseList.Prices[key]
+	if !h.Config.Features.Prices || !found {
+		return ""
+	}
+	return " " + h.textReplacer.Replace("— "+price.Format(amount))
+}
+
+// dueOf marks an item with a due time, the time itself is in the reminder
+func (h *MessageHandler) dueOf(purchaseList *db.PurchaseList, key db.PurchaseItemHash) string {
+	if _, found := purchaseList.ItemsDue[key]; !h.Config.Features.Reminders || !found {
+		return ""
+	}
+	return " ⏰"
+}
+
+// payerOf a crossed out item is shown in a shared list
+func (h *MessageHandler) payerOf(purchaseList *db.PurchaseList, key db.PurchaseItemHash) string {
+	payer, found := purchaseList.PaidBy[key]
+	if !h.Config.Features.Prices || !found || !isShared(purchaseList) {
+		return ""
+	}
+	return " " + h.textReplacer.Replace("("+memberName(purchaseList, payer)+")")
+}
+
+func (h *MessageHandler) appendSettleButton(rows [][]tgbotapi.InlineKeyboardButton, purchaseList *db.PurchaseList) [][]tgbotapi.InlineKeyboardButton {
+	if !h.Config.Features.Prices || !isShared(purchaseList) || len(purchaseList.Paid()) == 0 {
+		return rows
+	}
+	return append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🤝 Рассчитаться", purchaseList.Id.Hex()+":"+CbSettle),
+	))
+}
+
+// totals close a list with prices or a budget
+func (h *MessageHandler) totals(purchaseList *db.PurchaseList) string {
+	if !h.Config.Features.Prices || (len(purchaseList.Prices) == 0 && purchaseList.Budget == 0) {
+		return ""
+	}
+	bought, remaining := purchaseList.Spent()
+	text := "\n💰 Куплено на " + price.Format(bought)
+	if remaining > 0 {
+		text += ", осталось на " + price.Format(remaining)
+	}
+	if purchaseList.Budget > 0 {
+		text += "\nБюджет " + price.Format(purchaseList.Budget)
+		if bought > purchaseList.Budget {
+			text += "\n⚠️ Бюджет превышен на " + price.Format(bought-purchaseList.Budget)
+		} else if bought+remaining > purchaseList.Budget {
+			text += "\n⚠️ Список дороже бюджета на " + price.Format(bought+remaining-purchaseList.Budget)
+		}
+	}
+	return h.textReplacer.Replace(text) + "\n"
+}
+
+type itemGroup struct {
+	category string
+	items    []db.PurchaseItemHash
+}
+
+// groupItems splits the items by category in the walk order of the list store or the default one,
+// all of them go in one group when categories are off
+func (h *MessageHandler) groupItems(purchaseList *db.PurchaseList, dic map[db.PurchaseItemHash]db.PurchaseItemName) []itemGroup {
+	if !h.Config.Features.Categories {
+		return []itemGroup{{category: category.Other, items: purchaseList.Items}}
+	}
+	byCategory := map[string][]db.PurchaseItemHash{}
+	for _, key := range purchaseList.Items {
+		id, found := purchaseList.Categories[key]
+		if !found {
+			id = category.Classify(string(dic[key]), nil)
+		}
+		byCategory[id] = append(byCategory[id], key)
+	}
+	order := h.Config.List.CategoryOrder
+	if purchaseList.Store != nil {
+		order = purchaseList.Store.Sections
+	}
+	var groups []itemGroup
+	for _, id := range category.Order(order) {
+		if len(byCategory[id]) > 0 {
+			groups = append(groups, itemGroup{category: id, items: byCategory[id]})
+		}
+	}
+
+	return groups
+}
+
 func returnInlineKeyboard(msg MessageForReply) MessageForReply {
 	keys := []tgbotapi.InlineKeyboardButton{}
 
@@ -319,6 +701,47 @@ func returnInlineKeyboard(msg MessageForReply) MessageForReply {
 	return msg
 }
 
+func createExportKeyboard(msg MessageForReply, purchaseList *db.PurchaseList) MessageForReply {
+	if len(purchaseList.Items) == 0 && len(purchaseList.DeletedItemHashes) == 0 {
+		msg.Text = "Список пуст, выгружать нечего"
+		return msg
+	}
+	keys := []tgbotapi.InlineKeyboardButton{}
+	for _, format := range export.Formats {
+		keys = append(keys, tgbotapi.NewInlineKeyboardButtonData(
+			strings.ToUpper(string(format)),
+			purchaseList.Id.Hex()+":"+CbExport+string(format),
+		))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(keys)
+	msg.InlineKeyboard = &keyboard
+	msg.Text = "Выберите формат файла"
+
+	return msg
+}
+
+// AddSuggestions puts the items bought before under the message, a press adds the item to the list
+func AddSuggestions(msg MessageForReply, listID primitive.ObjectID, items []db.HistoryItem) MessageForReply {
+	if len(items) == 0 {
+		return msg
+	}
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for i, item := range items {
+		if i%suggestionsPerRow == 0 {
+			rows = append(rows, []tgbotapi.InlineKeyboardButton{})
+		}
+		rows[len(rows)-1] = append(rows[len(rows)-1], tgbotapi.NewInlineKeyboardButtonData(
+			string(item.Name),
+			listID.Hex()+":"+CbSuggest+string(item.Hash),
+		))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	msg.InlineKeyboard = &keyboard
+	msg.Text += "\n\nЧасто покупаете:"
+
+	return msg
+}
+
 func GetInlineReplyButton(textList string) tgbotapi.InlineKeyboardButton {
 	key := tgbotapi.NewInlineKeyboardButtonSwitch("Поделиться списком", textList)
 
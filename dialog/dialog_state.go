@@ -1,9 +1,14 @@
 package dialog
 
-import "github.com/boryashkin/purchaselist/db"
+import (
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/importer"
+)
 
 type DialogState struct {
 	User         *db.User
 	Session      *db.Session
 	PurchaseList *db.PurchaseList
+	Import       *importer.Result
+	ImportErr    error
 }
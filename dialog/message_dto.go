@@ -3,11 +3,16 @@ package dialog
 import tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 
 type MessageDto struct {
-	ChatMsgID      ChatMessageID
-	PhotoUrls      []string
-	Command        string
+	ChatMsgID ChatMessageID
+	PhotoUrls []string
+	Command   string
+	// Args follow the command, as in "/category молоко = молочное"
+	Args           string
 	Text           string
 	UnknownContent bool
 	TgUser         *tgbotapi.User
 	TgContact      *tgbotapi.Contact
+	Document       *tgbotapi.Document
+	// ImportToNewList is set when a document is sent with a caption asking for a new list
+	ImportToNewList bool
 }
@@ -0,0 +1,201 @@
+package dialog
+
+import (
+	"errors"
+	"github.com/boryashkin/purchaselist/category"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/price"
+	"github.com/boryashkin/purchaselist/settle"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dateLayout = "02.01.2006"
+
+var (
+	ErrSpentRange   = errors.New("spent range is not days or dates")
+	ErrBudgetAmount = errors.New("budget is not an amount")
+)
+
+// SpentReport sums the prices of the items bought within [From, To)
+type SpentReport struct {
+	From       time.Time
+	To         time.Time
+	Total      int64
+	Items      int
+	Lists      int
+	ByCategory map[string]int64
+}
+
+// ParseSpentRange reads "" for the current month, "7" for the last 7 days,
+// "01.10" since a date and "01.10.2026 15.10.2026" for both dates inclusive
+func ParseSpentRange(args string, now time.Time) (time.Time, time.Time, error) {
+	fields := strings.Fields(strings.ReplaceAll(args, " - ", " "))
+	switch len(fields) {
+	case 0:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()), now, nil
+	case 1:
+		if days, err := strconv.Atoi(fields[0]); err == nil {
+			if days < 1 || days > 3660 {
+				return time.Time{}, time.Time{}, ErrSpentRange
+			}
+			today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+			return today.AddDate(0, 0, 1-days), now, nil
+		}
+		from, err := parseDate(fields[0], now)
+		return from, now, err
+	case 2:
+		from, err := parseDate(fields[0], now)
+		if err != nil {
+			return from, now, err
+		}
+		to, err := parseDate(fields[1], now)
+		if err != nil || to.Before(from) {
+			return from, now, ErrSpentRange
+		}
+		return from, to.AddDate(0, 0, 1), nil
+	}
+	return time.Time{}, time.Time{}, ErrSpentRange
+}
+
+// parseDate reads dd.mm.yyyy or dd.mm of the current year
+func parseDate(text string, now time.Time) (time.Time, error) {
+	if strings.Count(text, ".") == 1 {
+		text += "." + strconv.Itoa(now.Year())
+	}
+	date, err := time.ParseInLocation(dateLayout, text, now.Location())
+	if err != nil {
+		return date, ErrSpentRange
+	}
+	return date, nil
+}
+
+func (h *MessageHandler) GetMessageForSpent(report SpentReport, err error) MessageForReply {
+	msg := MessageForReply{NewMessage: true}
+	if err == ErrSpentRange {
+		msg.Text = "Не понял период. Например:\n" +
+			"/spent - с начала месяца\n" +
+			"/spent 7 - за последние 7 дней\n" +
+			"/spent 01.10.2026 15.10.2026 - между датами"
+		return msg
+	} else if err != nil {
+		msg.Text = "Не удалось посчитать траты, попробуйте ещё раз"
+		return msg
+	}
+	msg.Text = "Потрачено с " + report.From.Format(dateLayout) + " по " + report.To.Add(-time.Nanosecond).Format(dateLayout) + ": " +
+		price.Format(report.Total)
+	if report.Items == 0 {
+		msg.Text += "\n\nКупленных товаров с ценами не нашлось. Цену можно написать после товара: хлеб 45₽"
+		return msg
+	}
+	msg.Text += "\nТоваров: " + strconv.Itoa(report.Items) + ", списков: " + strconv.Itoa(report.Lists) + "\n"
+	var ids []string
+	for id := range report.ByCategory {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return report.ByCategory[ids[i]] > report.ByCategory[ids[j]]
+	})
+	for _, id := range ids {
+		msg.Text += "\n" + category.Title(id) + ": " + price.Format(report.ByCategory[id])
+	}
+
+	return msg
+}
+
+// GetMessageForBudget shows the budget of the list, changed tells that the user has just set it
+func (h *MessageHandler) GetMessageForBudget(purchaseList *db.PurchaseList, changed bool, err error) MessageForReply {
+	msg := MessageForReply{NewMessage: true}
+	if err == ErrBudgetAmount {
+		msg.Text = "Не понял сумму. Например: /budget 3000"
+		return msg
+	} else if err != nil {
+		msg.Text = "Не удалось сохранить бюджет, попробуйте ещё раз"
+		return msg
+	}
+	switch {
+	case purchaseList.Budget > 0:
+		msg.Text = "Бюджет списка: " + price.Format(purchaseList.Budget)
+	case changed:
+		msg.Text = "Бюджет списка убран"
+	default:
+		msg.Text = "У списка нет бюджета"
+	}
+	bought, remaining := purchaseList.Spent()
+	msg.Text += "\nКуплено на " + price.Format(bought) + ", осталось на " + price.Format(remaining) +
+		"\n\nЗадать бюджет: /budget 3000, убрать: /budget 0"
+
+	return msg
+}
+
+// GetPricePrompt asks for the price of a crossed out item, the reply is read as its price
+func (h *MessageHandler) GetPricePrompt(name db.PurchaseItemName) MessageForReply {
+	return MessageForReply{
+		NewMessage: true,
+		Text:       "Сколько стоил «" + string(name) + "»? Пришлите сумму, например 45",
+		ForceReply: &tgbotapi.ForceReply{ForceReply: true, Selective: true},
+	}
+}
+
+// SettleText works out the transfers between the owner of the list and the members who crossed out its items
+func SettleText(purchaseList *db.PurchaseList, owner *db.User) string {
+	paid := purchaseList.Paid()
+	if len(paid) == 0 {
+		return "В списке нет купленных товаров с ценами, делить нечего"
+	}
+	members := []int{owner.TgId}
+	for _, member := range purchaseList.Members {
+		members = append(members, member.TgID)
+	}
+	balances := settle.Balances(members, paid)
+	var total int64
+	for _, amount := range paid {
+		total += amount
+	}
+	if len(balances) < 2 {
+		for payer := range balances {
+			return "Всё купил(а) " + settlerName(purchaseList, owner, payer) + " на " + price.Format(total) + ", делить не с кем"
+		}
+	}
+	text := "Потрачено " + price.Format(total) + ", по " + price.Format(total/int64(len(balances))) + " на человека\n"
+	transfers := settle.Transfers(balances)
+	if len(transfers) == 0 {
+		return text + "Все в расчёте"
+	}
+	for _, t := range transfers {
+		text += "\n" + settlerName(purchaseList, owner, t.From) + " → " + settlerName(purchaseList, owner, t.To) + ": " + price.Format(t.Amount)
+	}
+	return text
+}
+
+func memberName(purchaseList *db.PurchaseList, tgID int) string {
+	if name := purchaseList.MemberName(tgID); name != "" {
+		return name
+	}
+	return "id" + strconv.Itoa(tgID)
+}
+
+// settlerName is the name of a member or of the owner, who may have crossed out nothing
+func settlerName(purchaseList *db.PurchaseList, owner *db.User, tgID int) string {
+	if tgID == owner.TgId && owner.Name != "" && purchaseList.MemberName(tgID) == "" {
+		return owner.Name
+	}
+	return memberName(purchaseList, tgID)
+}
+
+// isShared tells whether more than one member crossed out items of the list
+func isShared(purchaseList *db.PurchaseList) bool {
+	seen := map[int]bool{}
+	for _, member := range purchaseList.Members {
+		seen[member.TgID] = true
+	}
+	return len(seen) > 1
+}
+
+// GetMessageForSettle shows who owes whom for the bought items of the list
+func (h *MessageHandler) GetMessageForSettle(purchaseList *db.PurchaseList, owner *db.User) MessageForReply {
+	return MessageForReply{NewMessage: true, Text: SettleText(purchaseList, owner)}
+}
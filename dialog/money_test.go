@@ -0,0 +1,153 @@
+package dialog
+
+import (
+	"github.com/boryashkin/purchaselist/db"
+	"testing"
+	"time"
+)
+
+func TestParseSpentRange(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	now := time.Date(2026, 10, 19, 15, 30, 0, 0, moscow)
+	tests := []struct {
+		name    string
+		args    string
+		from    time.Time
+		to      time.Time
+		wantErr bool
+	}{
+		{
+			name: "since the start of the month",
+			from: time.Date(2026, 10, 1, 0, 0, 0, 0, moscow),
+			to:   now,
+		},
+		{
+			name: "the last 7 days with today",
+			args: "7",
+			from: time.Date(2026, 10, 13, 0, 0, 0, 0, moscow),
+			to:   now,
+		},
+		{
+			name: "today",
+			args: "1",
+			from: time.Date(2026, 10, 19, 0, 0, 0, 0, moscow),
+			to:   now,
+		},
+		{
+			name: "since a date",
+			args: "05.09.2026",
+			from: time.Date(2026, 9, 5, 0, 0, 0, 0, moscow),
+			to:   now,
+		},
+		{
+			name: "between dates of the current year, both included",
+			args: "01.10 - 15.10",
+			from: time.Date(2026, 10, 1, 0, 0, 0, 0, moscow),
+			to:   time.Date(2026, 10, 16, 0, 0, 0, 0, moscow),
+		},
+		{
+			name: "a single day",
+			args: "01.10.2026 01.10.2026",
+			from: time.Date(2026, 10, 1, 0, 0, 0, 0, moscow),
+			to:   time.Date(2026, 10, 2, 0, 0, 0, 0, moscow),
+		},
+		{name: "no days", args: "0", wantErr: true},
+		{name: "too many days", args: "3661", wantErr: true},
+		{name: "the end before the start", args: "15.10.2026 01.10.2026", wantErr: true},
+		{name: "not a date", args: "вчера", wantErr: true},
+		{name: "too many arguments", args: "01.10 02.10 03.10", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, err := ParseSpentRange(tt.args, now)
+			if tt.wantErr {
+				if err != ErrSpentRange {
+					t.Errorf("ParseSpentRange(%q) error = %v, want %v", tt.args, err, ErrSpentRange)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSpentRange(%q) error = %v", tt.args, err)
+			}
+			if !from.Equal(tt.from) || !to.Equal(tt.to) {
+				t.Errorf("ParseSpentRange(%q) = %v, %v, want %v, %v", tt.args, from, to, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+func TestSettleText(t *testing.T) {
+	bread, milk, cheese := db.PurchaseItemHash("bread"), db.PurchaseItemHash("milk"), db.PurchaseItemHash("cheese")
+	owner := &db.User{TgId: 1, Name: "Аня"}
+	tests := []struct {
+		name string
+		list db.PurchaseList
+		want string
+	}{
+		{
+			name: "nothing with a price is bought",
+			list: db.PurchaseList{
+				DeletedItemHashes: []db.PurchaseItemHash{bread},
+				PaidBy:            map[db.PurchaseItemHash]int{bread: 2},
+			},
+			want: "В списке нет купленных товаров с ценами, делить нечего",
+		},
+		{
+			name: "the owner bought everything alone",
+			list: db.PurchaseList{
+				DeletedItemHashes: []db.PurchaseItemHash{bread},
+				Prices:            map[db.PurchaseItemHash]int64{bread: 10000},
+				PaidBy:            map[db.PurchaseItemHash]int{bread: 1},
+				Members:           []db.ListMember{{TgID: 1, Name: "Аня"}},
+			},
+			want: "Всё купил(а) Аня на 100 ₽, делить не с кем",
+		},
+		{
+			name: "the owner who crossed out nothing pays a share",
+			list: db.PurchaseList{
+				DeletedItemHashes: []db.PurchaseItemHash{bread, milk},
+				Prices:            map[db.PurchaseItemHash]int64{bread: 10000, milk: 20000},
+				PaidBy:            map[db.PurchaseItemHash]int{bread: 2, milk: 2},
+				Members:           []db.ListMember{{TgID: 2, Name: "Боря"}},
+			},
+			want: "Потрачено 300 ₽, по 150 ₽ на человека\n\nАня → Боря: 150 ₽",
+		},
+		{
+			name: "a member without a price and without a name",
+			list: db.PurchaseList{
+				DeletedItemHashes: []db.PurchaseItemHash{bread, milk, cheese},
+				Prices:            map[db.PurchaseItemHash]int64{bread: 10000, milk: 20000},
+				PaidBy:            map[db.PurchaseItemHash]int{bread: 1, milk: 2, cheese: 3},
+				Members:           []db.ListMember{{TgID: 1, Name: "Аня"}, {TgID: 2, Name: "Боря"}, {TgID: 3}},
+			},
+			want: "Потрачено 300 ₽, по 100 ₽ на человека\n\nid3 → Боря: 100 ₽",
+		},
+		{
+			name: "the name the owner has in the list wins",
+			list: db.PurchaseList{
+				DeletedItemHashes: []db.PurchaseItemHash{bread},
+				Prices:            map[db.PurchaseItemHash]int64{bread: 10000},
+				PaidBy:            map[db.PurchaseItemHash]int{bread: 2},
+				Members:           []db.ListMember{{TgID: 1, Name: "Anna"}, {TgID: 2, Name: "Боря"}},
+			},
+			want: "Потрачено 100 ₽, по 50 ₽ на человека\n\nAnna → Боря: 50 ₽",
+		},
+		{
+			name: "everyone paid the same",
+			list: db.PurchaseList{
+				DeletedItemHashes: []db.PurchaseItemHash{bread, milk},
+				Prices:            map[db.PurchaseItemHash]int64{bread: 10000, milk: 10000},
+				PaidBy:            map[db.PurchaseItemHash]int{bread: 1, milk: 2},
+				Members:           []db.ListMember{{TgID: 1, Name: "Аня"}, {TgID: 2, Name: "Боря"}},
+			},
+			want: "Потрачено 200 ₽, по 100 ₽ на человека\nВсе в расчёте",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SettleText(&tt.list, owner); got != tt.want {
+				t.Errorf("SettleText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package dialog
+
+import (
+	"errors"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/recipe"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PantryOnWord turns the pantry of the user on: /pantry вкл
+	PantryOnWord = "вкл"
+	// PantryOffWord turns it off, the items are kept
+	PantryOffWord = scheduleOffWord
+	// PantryMinWord sets the low stock threshold of an item: /pantry минимум молоко 1 л
+	PantryMinWord = "минимум"
+	// PantryUseWord takes an amount of an item: /pantry минус яйца 3
+	PantryUseWord = "минус"
+	// PantryRemoveWord removes an item from the pantry: /pantry удалить молоко
+	PantryRemoveWord = templateRemoveWord
+
+	// maxPantryShown items are listed by /pantry, each of them with a button
+	maxPantryShown = 40
+)
+
+var (
+	ErrPantryUsage       = errors.New("pantry command is not known")
+	ErrPantryUnit        = errors.New("pantry item is kept in another unit")
+	ErrUnknownPantryItem = errors.New("unknown pantry item")
+)
+
+// PantryReport is what /pantry shows: the items at home or the item just changed by the action
+type PantryReport struct {
+	Enabled bool
+	Items   []db.PantryItem
+	// Action is one of the words of /pantry, empty to show the pantry
+	Action string
+	Item   db.PantryItem
+}
+
+// ParsePantryArgs reads the action and its item: "вкл", "выкл", "минимум молоко 1 л", "минус яйца 3", "удалить соль".
+// An item without an amount is a piece, except for "минус" which takes all of it.
+func ParsePantryArgs(args string) (string, recipe.Ingredient, error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return "", recipe.Ingredient{}, nil
+	}
+	action := strings.ToLower(fields[0])
+	rest := strings.Join(fields[1:], " ")
+	switch {
+	case len(fields) == 1 && (action == PantryOnWord || action == PantryOffWord):
+		return action, recipe.Ingredient{}, nil
+	case rest == "":
+		return action, recipe.Ingredient{}, ErrPantryUsage
+	case action == PantryRemoveWord:
+		return action, recipe.Ingredient{Name: rest}, nil
+	case action == PantryMinWord, action == PantryUseWord:
+		ingredient, _ := recipe.ParseIngredient(rest)
+		if ingredient.Amount == 0 && action == PantryMinWord {
+			ingredient.Amount, ingredient.Unit = 1, "шт"
+		}
+		return action, ingredient, nil
+	}
+	return action, recipe.Ingredient{}, ErrPantryUsage
+}
+
+func (h *MessageHandler) GetMessageForPantry(report PantryReport, err error) MessageForReply {
+	msg := MessageForReply{NewMessage: true}
+	item := report.Item
+	switch {
+	case err == ErrPantryUsage:
+		msg.Text = pantryUsage()
+	case err == ErrPantryUnit:
+		msg.Text = "«" + item.Name + "» дома считается в «" + item.Unit + "», напишите количество в них"
+	case err == ErrUnknownPantryItem:
+		msg.Text = "«" + item.Name + "» дома нет, посмотрите /pantry"
+	case err != nil:
+		msg.Text = "Не удалось обновить запасы, попробуйте ещё раз"
+	case report.Action == PantryOnWord:
+		msg.Text = "Вычеркнутые товары теперь попадают в запасы дома: /pantry"
+	case report.Action == PantryOffWord:
+		msg.Text = "Вычеркнутые товары больше не попадают в запасы, сохранённые остались: /pantry"
+	case report.Action == PantryRemoveWord:
+		msg.Text = "«" + item.Name + "» убрано из запасов"
+	case report.Action == PantryMinWord:
+		msg.Text = "Предложу купить «" + item.Name + "», когда останется меньше " +
+			recipe.Ingredient{Amount: item.Threshold, Unit: item.Unit}.AmountString()
+		if item.Low() {
+			offer := h.GetMessageForUsedUp(item)
+			msg.Text += "\n\n" + offer.Text
+			msg.InlineKeyboard = offer.InlineKeyboard
+		}
+	case report.Action == PantryUseWord && item.Low():
+		msg = h.GetMessageForUsedUp(item)
+	case report.Action == PantryUseWord:
+		msg.Text = "Осталось: " + item.Ingredient().String()
+	case len(report.Items) == 0 && !report.Enabled:
+		msg.Text = "Запасы дома выключены. Чтобы вычеркнутые товары попадали в них, напишите /pantry " + PantryOnWord
+	case len(report.Items) == 0:
+		msg.Text = "Дома пока ничего нет, вычеркнутые товары появятся здесь\n\n" + pantryUsage()
+	default:
+		msg = h.createMessageForPantry(msg, report.Items)
+		if !report.Enabled {
+			msg.Text += "\n\nЗапасы выключены, вычеркнутые товары в них не попадают"
+		}
+	}
+
+	return msg
+}
+
+// GetMessageForUsedUp offers to add an item which is used up or low to the active list
+func (h *MessageHandler) GetMessageForUsedUp(item db.PantryItem) MessageForReply {
+	msg := MessageForReply{NewMessage: true}
+	if item.Amount > 0 {
+		msg.Text = "«" + item.Name + "» заканчивается, осталось " + item.Ingredient().AmountString() + ". Добавить в список?"
+	} else {
+		msg.Text = "«" + item.Name + "» закончилось. Добавить в список?"
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(restockButton(item)))
+	msg.InlineKeyboard = &keyboard
+
+	return msg
+}
+
+// AddRestock puts the items which are low at home under the message, a press adds the item to the list
+func AddRestock(msg MessageForReply, items []db.PantryItem) MessageForReply {
+	if len(items) == 0 {
+		return msg
+	}
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	if msg.InlineKeyboard != nil {
+		rows = msg.InlineKeyboard.InlineKeyboard
+	}
+	for i, item := range items {
+		if i%suggestionsPerRow == 0 {
+			rows = append(rows, []tgbotapi.InlineKeyboardButton{})
+		}
+		rows[len(rows)-1] = append(rows[len(rows)-1], restockButton(item))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	msg.InlineKeyboard = &keyboard
+	msg.Text += "\n\nЗаканчивается дома:"
+
+	return msg
+}
+
+func (h *MessageHandler) createMessageForPantry(msg MessageForReply, items []db.PantryItem) MessageForReply {
+	msg.Text = "🏠 Дома:\n"
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for i, item := range items {
+		if i == maxPantryShown {
+			msg.Text += "\n…и ещё " + strconv.Itoa(len(items)-i)
+			break
+		}
+		msg.Text += "\n" + item.Name
+		switch {
+		case item.Amount <= 0:
+			msg.Text += " - закончилось ⚠️"
+		case item.Low():
+			msg.Text += " - " + item.Ingredient().AmountString() + " ⚠️"
+		default:
+			msg.Text += " - " + item.Ingredient().AmountString()
+		}
+		row := []tgbotapi.InlineKeyboardButton{}
+		if item.Amount > 0 {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData("✖ "+item.Name, item.Id.Hex()+":"+CbUsedUp))
+		}
+		if item.Low() {
+			row = append(row, restockButton(item))
+		}
+		rows = append(rows, row)
+	}
+	msg.Text += "\n\n✖ - закончилось, ➕ - добавить в список\n" + pantryUsage()
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	msg.InlineKeyboard = &keyboard
+
+	return msg
+}
+
+func restockButton(item db.PantryItem) tgbotapi.InlineKeyboardButton {
+	return tgbotapi.NewInlineKeyboardButtonData("➕ "+item.Name, item.Id.Hex()+":"+CbRestock)
+}
+
+func pantryUsage() string {
+	return "Купленные товары попадают в запасы дома, если написать /pantry " + PantryOnWord + "\n" +
+		"Потратить: /pantry " + PantryUseWord + " яйца 3\n" +
+		"Предлагать купить, когда мало: /pantry " + PantryMinWord + " молоко 1 л\n" +
+		"Убрать: /pantry " + PantryRemoveWord + " соль\n" +
+		"Выключить: /pantry " + PantryOffWord
+}
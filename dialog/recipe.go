@@ -0,0 +1,121 @@
+package dialog
+
+import (
+	"errors"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/recipe"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxRecipes a user may keep
+	MaxRecipes = 30
+	// MaxIngredients of a recipe
+	MaxIngredients      = 30
+	maxRecipeNameLength = 24
+)
+
+var (
+	ErrRecipeUsage        = errors.New("recipe name is missing")
+	ErrRecipeNameLength   = errors.New("recipe name is too long")
+	ErrTooManyRecipes     = errors.New("too many recipes")
+	ErrTooManyIngredients = errors.New("too many ingredients")
+	ErrUnknownRecipe      = errors.New("unknown recipe")
+)
+
+// ParseRecipeArgs reads a recipe: the name with the servings on the first line and an ingredient on every next one,
+// or "Салат на 2: огурцы 2, помидоры 3" on a single line. The amounts are turned into the amounts per serving.
+// A name alone shows the recipe, "удалить Борщ" removes it.
+func ParseRecipeArgs(args string) (db.Recipe, bool, error) {
+	lines := strings.Split(args, "\n")
+	head := strings.TrimSpace(lines[0])
+	if i := strings.Index(head, ":"); i >= 0 {
+		lines = append(strings.Split(head[i+1:], ","), lines[1:]...)
+		head = strings.TrimSpace(head[:i])
+	} else {
+		lines = lines[1:]
+	}
+	remove := false
+	if fields := strings.Fields(head); len(fields) > 1 && strings.ToLower(fields[0]) == templateRemoveWord {
+		remove = true
+		head = strings.TrimSpace(strings.TrimPrefix(head, fields[0]))
+	}
+	r := db.Recipe{Name: head, Servings: 1}
+	if name, servings, ok := recipe.Cut(head); ok {
+		r.Name, r.Servings = name, servings
+	}
+	if r.Name == "" {
+		return r, remove, ErrRecipeUsage
+	}
+	if len([]rune(r.Name)) > maxRecipeNameLength {
+		return r, remove, ErrRecipeNameLength
+	}
+	for _, line := range lines {
+		ingredient, ok := recipe.ParseIngredient(strings.TrimLeft(strings.TrimSpace(line), "-•*–"))
+		if !ok {
+			continue
+		}
+		r.Ingredients = append(r.Ingredients, ingredient.Scale(1/float64(r.Servings)))
+	}
+	if len(r.Ingredients) > MaxIngredients {
+		return r, remove, ErrTooManyIngredients
+	}
+	return r, remove, nil
+}
+
+func (h *MessageHandler) GetMessageForRecipes(recipes []db.Recipe, err error) MessageForReply {
+	msg := MessageForReply{NewMessage: true}
+	if err != nil {
+		msg.Text = "Не удалось загрузить рецепты, попробуйте ещё раз"
+		return msg
+	}
+	if len(recipes) == 0 {
+		msg.Text = recipeUsage()
+		return msg
+	}
+	msg.Text = "Рецепты, напишите в список «Название на 4», чтобы добавить продукты на 4 порции:\n"
+	for _, r := range recipes {
+		msg.Text += "\n" + r.Name + " - продуктов: " + strconv.Itoa(len(r.Ingredients))
+	}
+	msg.Text += "\n\nПосмотреть рецепт: /recipe " + recipes[0].Name + "\n\n" + recipeUsage()
+
+	return msg
+}
+
+// GetMessageForRecipe shows the recipe for the servings it was written for, after it is saved as well
+func (h *MessageHandler) GetMessageForRecipe(r db.Recipe, removed bool, err error) MessageForReply {
+	msg := MessageForReply{NewMessage: true}
+	switch {
+	case err == nil && removed:
+		msg.Text = "Рецепт «" + r.Name + "» удалён"
+	case err == nil:
+		msg.Text = "Рецепт «" + r.Name + "» на " + strconv.Itoa(r.Servings) + ":\n"
+		for _, ingredient := range r.Ingredients {
+			msg.Text += "\n" + ingredient.Scale(float64(r.Servings)).String()
+		}
+		msg.Text += "\n\nДобавить в список: " + r.Name + " на " + strconv.Itoa(r.Servings)
+	case err == ErrRecipeNameLength:
+		msg.Text = "Название рецепта должно быть не длиннее " + strconv.Itoa(maxRecipeNameLength) + " символов"
+	case err == ErrTooManyRecipes:
+		msg.Text = "Можно сохранить не больше " + strconv.Itoa(MaxRecipes) + " рецептов, удалите ненужный: /recipe удалить Название"
+	case err == ErrTooManyIngredients:
+		msg.Text = "В рецепте может быть не больше " + strconv.Itoa(MaxIngredients) + " продуктов"
+	case err == ErrUnknownRecipe:
+		msg.Text = "Рецепта «" + r.Name + "» нет, посмотрите /recipe"
+	case err == ErrRecipeUsage:
+		msg.Text = recipeUsage()
+	default:
+		msg.Text = "Не удалось сохранить рецепт, попробуйте ещё раз"
+	}
+
+	return msg
+}
+
+func recipeUsage() string {
+	return "Чтобы сохранить рецепт, напишите название, на сколько порций он, и продукты с новой строки:\n" +
+		"/recipe Борщ на 4\nсвёкла 400 г\nкапуста 300 г\nкартофель 3\n\n" +
+		"Или одной строкой: /recipe Салат на 2: огурцы 2, помидоры 3\n" +
+		"Затем пишите в список «Борщ на 6» - продукты добавятся на 6 порций\n" +
+		"Удалить рецепт: /recipe удалить Борщ"
+}
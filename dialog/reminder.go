@@ -0,0 +1,119 @@
+package dialog
+
+import (
+	"errors"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/schedule"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"strconv"
+	"time"
+)
+
+const (
+	// SnoozeTomorrow follows CbSnooze to move the reminder to the next morning
+	SnoozeTomorrow = "tmr"
+	// RemindOffWord turns the reminder of the list off: /remind выкл
+	RemindOffWord = scheduleOffWord
+)
+
+var (
+	ErrRemindUsage   = errors.New("reminder is not a day or a time in the future")
+	ErrEmptyReminder = errors.New("list to remind of is empty")
+)
+
+// snoozeMinutes are the buttons moving a reminder by an hour or three
+var snoozeMinutes = []int{60, 180}
+
+// GetMessageForRemind confirms the due time of the list in the timezone of the user
+func (h *MessageHandler) GetMessageForRemind(purchaseList *db.PurchaseList, loc *time.Location, changed bool, err error) MessageForReply {
+	msg := MessageForReply{NewMessage: true}
+	switch {
+	case err == ErrRemindUsage:
+		msg.Text = remindUsage()
+	case err == ErrEmptyReminder:
+		msg.Text = "Список пуст. Добавьте товары, затем напишите /remind 18:30"
+	case err != nil:
+		msg.Text = "Не удалось сохранить напоминание, попробуйте ещё раз"
+	case purchaseList.DueAt == 0 && changed:
+		msg.Text = "Напоминание о списке отключено"
+	case purchaseList.DueAt == 0:
+		msg.Text = remindUsage()
+	default:
+		msg.Text = "⏰ Напомню о списке " + purchaseList.DueAt.Time().In(loc).Format(nextRunLayout) +
+			" (" + loc.String() + "), если его не закроют - ещё раз\n\n" +
+			"Перенести - /remind завтра 9:00, отключить - /remind " + scheduleOffWord
+	}
+
+	return msg
+}
+
+// GetMessageForReminder sends the list back when it is due, the items can be crossed out in it
+// and the reminder can be snoozed
+func (h *MessageHandler) GetMessageForReminder(purchaseList *db.PurchaseList, loc *time.Location) MessageForReply {
+	msg := h.createMessageForPurchaseList(MessageForReply{NewMessage: true}, purchaseList)
+	header := "⏰ Список к " + purchaseList.DueAt.Time().In(loc).Format(nextRunLayout)
+	if purchaseList.Reminded > 0 {
+		header += " ещё не закрыт"
+	}
+	dues := ""
+	for _, item := range purchaseList.ItemsDictionary {
+		at, found := purchaseList.ItemsDue[item.Hash]
+		if found && inList(purchaseList.Items, item.Hash) {
+			dues += h.textReplacer.Replace(string(item.Name)+" - к "+at.Time().In(loc).Format(nextRunLayout)) + "\n"
+		}
+	}
+	msg.Text = "*" + h.textReplacer.Replace(header) + "*\n" + dues + "\n" + msg.Text
+
+	listID := purchaseList.Id.Hex()
+	snooze := []tgbotapi.InlineKeyboardButton{}
+	for _, minutes := range snoozeMinutes {
+		snooze = append(snooze, tgbotapi.NewInlineKeyboardButtonData(
+			"+"+strconv.Itoa(minutes/60)+" ч",
+			listID+":"+CbSnooze+strconv.Itoa(minutes),
+		))
+	}
+	snooze = append(snooze, tgbotapi.NewInlineKeyboardButtonData("Завтра", listID+":"+CbSnooze+SnoozeTomorrow))
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	if msg.InlineKeyboard != nil {
+		rows = msg.InlineKeyboard.InlineKeyboard
+	}
+	rows = append(rows, snooze, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🔕 Не напоминать", listID+":"+CbRemindOff),
+	))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	msg.InlineKeyboard = &keyboard
+
+	return msg
+}
+
+// ParseSnooze reads the choice of a snooze button, the reminder is moved to the returned time
+func ParseSnooze(choice string, now time.Time) (time.Time, bool) {
+	if choice == SnoozeTomorrow {
+		minute := schedule.DefaultDueMinute
+		return time.Date(now.Year(), now.Month(), now.Day()+1, minute/60, minute%60, 0, 0, now.Location()), true
+	}
+	minutes, err := strconv.Atoi(choice)
+	if err != nil || minutes <= 0 {
+		return time.Time{}, false
+	}
+	return now.Add(time.Duration(minutes) * time.Minute), true
+}
+
+func inList(hashes []db.PurchaseItemHash, hash db.PurchaseItemHash) bool {
+	for _, h := range hashes {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+func remindUsage() string {
+	return "Чтобы бот прислал список, когда пора за покупками, напишите срок:\n" +
+		"/remind 18:30\n" +
+		"/remind завтра 9:00\n" +
+		"/remind к субботе\n" +
+		"/remind через 2 часа\n" +
+		"Срок товара пишите после него: торт к субботе\n" +
+		"Отключить: /remind " + scheduleOffWord
+}
@@ -0,0 +1,31 @@
+package dialog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSnooze(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	now := time.Date(2026, 10, 31, 23, 30, 0, 0, moscow)
+	tests := []struct {
+		choice string
+		want   time.Time
+		ok     bool
+	}{
+		{choice: "60", want: time.Date(2026, 11, 1, 0, 30, 0, 0, moscow), ok: true},
+		{choice: "180", want: time.Date(2026, 11, 1, 2, 30, 0, 0, moscow), ok: true},
+		{choice: SnoozeTomorrow, want: time.Date(2026, 11, 1, 10, 0, 0, 0, moscow), ok: true},
+		{choice: "0"},
+		{choice: "-60"},
+		{choice: "час"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.choice, func(t *testing.T) {
+			got, ok := ParseSnooze(tt.choice, now)
+			if !got.Equal(tt.want) || ok != tt.ok {
+				t.Errorf("ParseSnooze(%q) = %v, %v, want %v, %v", tt.choice, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package dialog
+
+import (
+	"context"
+	"errors"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/boryashkin/purchaselist/tracing"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSuperseded is returned for an edit dropped because a newer edit of the same message was queued
+var ErrSuperseded = errors.New("superseded by a newer edit")
+
+var retryAfterRe = regexp.MustCompile(`retry after (\d+)`)
+
+type SenderLimits struct {
+	// PerSecond requests are sent to the whole api
+	PerSecond int
+	// ChatInterval is kept between requests to one chat
+	ChatInterval time.Duration
+	// Retries of a request rejected with retry_after or a transient error
+	Retries int
+}
+
+// Sender puts the requests to telegram in line with its limits.
+// Requests to one chat are sent in the order they come, an edit waiting in the line
+// is dropped once a newer edit of the same message comes.
+type Sender struct {
+	limits SenderLimits
+	mu     sync.Mutex
+	next   time.Time
+	chats  map[int64]*chatLine
+	edits  map[string]*request
+	pruned time.Time
+}
+
+// chatLine serializes requests to a chat, next and waiting are guarded by Sender.mu
+type chatLine struct {
+	mu      sync.Mutex
+	next    time.Time
+	waiting int
+}
+
+type request struct {
+	superseded bool
+}
+
+func NewSender(limits SenderLimits) *Sender {
+	return &Sender{
+		limits: limits,
+		chats:  map[int64]*chatLine{},
+		edits:  map[string]*request{},
+	}
+}
+
+// Do sends a request when the limits allow. chatID is 0 for requests not bound to a chat,
+// editKey identifies the edited message and is empty for everything else.
+func (s *Sender) Do(ctx context.Context, chatID int64, editKey string, msgType string, send func() (tgbotapi.Message, error)) (sent tgbotapi.Message, err error) {
+	ctx, span := tracing.Start(ctx, "telegram."+msgType)
+	attempts := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("attempts", attempts))
+		if err == ErrSuperseded {
+			span.SetAttributes(attribute.Bool("superseded", true))
+			span.End()
+			return
+		}
+		tracing.End(span, err)
+	}()
+	metrics.TgSendQueue.Inc()
+	defer metrics.TgSendQueue.Dec()
+	start := time.Now()
+	defer func() {
+		metrics.TgSendLatency.With(prometheus.Labels{"msg_type": msgType}).Observe(time.Since(start).Seconds())
+	}()
+
+	req := &request{}
+	if editKey != "" {
+		s.mu.Lock()
+		if prev, found := s.edits[editKey]; found {
+			prev.superseded = true
+		}
+		s.edits[editKey] = req
+		s.mu.Unlock()
+		defer func() {
+			s.mu.Lock()
+			if s.edits[editKey] == req {
+				delete(s.edits, editKey)
+			}
+			s.mu.Unlock()
+		}()
+	}
+	line := s.lockChat(chatID)
+	defer s.unlockChat(chatID, line)
+
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		if s.isSuperseded(req) {
+			metrics.TgSendDropped.With(prometheus.Labels{"reason": "superseded"}).Inc()
+			return tgbotapi.Message{}, ErrSuperseded
+		}
+		time.Sleep(time.Until(s.chatNext(line)))
+		time.Sleep(time.Until(s.reserve()))
+		if s.isSuperseded(req) {
+			metrics.TgSendDropped.With(prometheus.Labels{"reason": "superseded"}).Inc()
+			return tgbotapi.Message{}, ErrSuperseded
+		}
+
+		attempts++
+		sent, err = send()
+		s.pause(line, s.limits.ChatInterval, false)
+		if err == nil || attempt >= s.limits.Retries {
+			return sent, err
+		}
+		if wait := RetryAfter(err); wait > 0 {
+			metrics.TgSendRetry.With(prometheus.Labels{"reason": "rate_limit"}).Inc()
+			logger.Warn(ctx, "telegram asked to retry after a pause", "pause", wait.String(), "msg_type", msgType)
+			s.pause(line, wait, true)
+			continue
+		}
+		if !isTransient(err) {
+			return sent, err
+		}
+		metrics.TgSendRetry.With(prometheus.Labels{"reason": "transient"}).Inc()
+		logger.Warn(ctx, "retrying a telegram request", "msg_type", msgType, "err", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *Sender) chatNext(line *chatLine) time.Time {
+	if line == nil {
+		return time.Time{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return line.next
+}
+
+// reserve takes the next free slot of the global limit
+func (s *Sender) reserve() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	at := time.Now()
+	if s.next.After(at) {
+		at = s.next
+	}
+	s.next = at.Add(time.Second / time.Duration(s.limits.PerSecond))
+	return at
+}
+
+// pause holds the chat, and every request when it is not bound to a chat and global is set
+func (s *Sender) pause(line *chatLine, wait time.Duration, global bool) {
+	until := time.Now().Add(wait)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if line != nil {
+		line.next = until
+	} else if global && s.next.Before(until) {
+		s.next = until
+	}
+}
+
+func (s *Sender) isSuperseded(req *request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return req.superseded
+}
+
+func (s *Sender) lockChat(chatID int64) *chatLine {
+	if chatID == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	s.prune()
+	line, found := s.chats[chatID]
+	if !found {
+		line = &chatLine{}
+		s.chats[chatID] = line
+	}
+	line.waiting++
+	s.mu.Unlock()
+	line.mu.Lock()
+
+	return line
+}
+
+func (s *Sender) unlockChat(chatID int64, line *chatLine) {
+	if line == nil {
+		return
+	}
+	line.mu.Unlock()
+	s.mu.Lock()
+	line.waiting--
+	s.mu.Unlock()
+}
+
+// prune drops idle chats once a minute, a chat is kept while its pause lasts
+func (s *Sender) prune() {
+	now := time.Now()
+	if now.Sub(s.pruned) < time.Minute {
+		return
+	}
+	s.pruned = now
+	for chatID, line := range s.chats {
+		if line.waiting == 0 && !line.next.After(now) {
+			delete(s.chats, chatID)
+		}
+	}
+}
+
+// RetryAfter returns the pause telegram asked for with a 429 response, or 0
+func RetryAfter(err error) time.Duration {
+	if tgErr, ok := err.(tgbotapi.Error); ok && tgErr.RetryAfter > 0 {
+		return time.Duration(tgErr.RetryAfter) * time.Second
+	}
+	// uploads return only the description
+	if match := retryAfterRe.FindStringSubmatch(err.Error()); match != nil {
+		seconds, _ := strconv.Atoi(match[1])
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
+
+// isTransient tells server and network errors from rejected requests, which fail the same way on retry
+func isTransient(err error) bool {
+	msg := err.Error()
+	for _, prefix := range []string{"Internal Server Error", "Bad Gateway", "Service Unavailable", "Gateway Timeout"} {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+	if _, ok := err.(tgbotapi.Error); ok {
+		return false
+	}
+	for _, prefix := range []string{"Bad Request", "Forbidden", "Unauthorized", "Not Found", "Conflict"} {
+		if strings.HasPrefix(msg, prefix) {
+			return false
+		}
+	}
+	return true
+}
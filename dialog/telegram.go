@@ -1,11 +1,17 @@
 package dialog
 
 import (
+	"context"
 	"errors"
+	"github.com/boryashkin/purchaselist/logger"
 	"github.com/boryashkin/purchaselist/metrics"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/prometheus/client_golang/prometheus"
-	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
 )
 
 type ChatMessageID struct {
@@ -14,20 +20,72 @@ type ChatMessageID struct {
 	InlineMessageID *string
 }
 
-type BotReply func(bot *tgbotapi.BotAPI, chatMsgID ChatMessageID, forReply MessageForReply) (*tgbotapi.Message, error)
+// NewBotAPI authorizes the bot, apiEndpoint replaces https://api.telegram.org when set
+func NewBotAPI(token string, apiEndpoint string) (*tgbotapi.BotAPI, error) {
+	transport := apiTransport{}
+	if apiEndpoint != "" {
+		target, err := url.Parse(apiEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		transport.target = target
+	}
+	return tgbotapi.NewBotAPIWithClient(token, &http.Client{Transport: transport})
+}
 
-func Reply(bot *tgbotapi.BotAPI, chatMsgID ChatMessageID, forReply MessageForReply) (*tgbotapi.Message, error) {
+// apiTransport times the api requests and sends them to the target instead of telegram if it is set
+type apiTransport struct {
+	target *url.URL
+}
+
+func (t apiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.target != nil {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = t.target.Scheme
+		req.URL.Host = t.target.Host
+		req.Host = t.target.Host
+	}
+	// the path is /bot<token>/<method>, only the method is safe to expose
+	method := path.Base(req.URL.Path)
+	start := time.Now()
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	result := "error"
+	if err == nil {
+		result = strconv.Itoa(resp.StatusCode)
+	}
+	metrics.TgAPIDuration.With(prometheus.Labels{"method": method, "result": result}).Observe(time.Since(start).Seconds())
+
+	return resp, err
+}
+
+type BotReply func(ctx context.Context, bot *tgbotapi.BotAPI, chatMsgID ChatMessageID, forReply MessageForReply) (*tgbotapi.Message, error)
+
+// Reply sends the message through the sender, it matches BotReply
+func (s *Sender) Reply(ctx context.Context, bot *tgbotapi.BotAPI, chatMsgID ChatMessageID, forReply MessageForReply) (*tgbotapi.Message, error) {
 	if bot == nil {
-		log.Println("[No bot] ", forReply.Text)
+		logger.Warn(ctx, "no bot to reply with", "text", logger.Private(forReply.Text))
 		return nil, errors.New("No bot")
 	}
 
 	var msg tgbotapi.Chattable
+	var chatID int64
+	if chatMsgID.ChatID != nil {
+		chatID = *chatMsgID.ChatID
+	}
+	editKey := ""
 	msgLabel := "empty"
-	if forReply.NewMessage {
+	if forReply.Document != nil {
+		msgLabel = "document"
+		if chatMsgID.ChatID == nil {
+			return nil, errors.New("No chat to send a document to")
+		}
+		msgDoc := tgbotapi.NewDocumentUpload(*chatMsgID.ChatID, *forReply.Document)
+		msgDoc.Caption = forReply.Text
+		msg = msgDoc
+	} else if forReply.NewMessage {
 		msgLabel = "new"
 		if forReply.Text == "" {
-			log.Println("not sent")
+			logger.Warn(ctx, "empty message is not sent")
 			return nil, errors.New("Not sent")
 		}
 		msgNew := tgbotapi.NewMessage(*chatMsgID.ChatID, forReply.Text)
@@ -41,12 +99,15 @@ func Reply(bot *tgbotapi.BotAPI, chatMsgID ChatMessageID, forReply MessageForRep
 		if forReply.ReplyKeyboard != nil {
 			msgNew.ReplyMarkup = forReply.ReplyKeyboard
 		}
+		if forReply.ForceReply != nil {
+			msgNew.ReplyMarkup = forReply.ForceReply
+		}
 		msg = msgNew
 	} else {
 		msgLabel = "edit"
-		log.Println("EditMessage")
 		var msgEdit tgbotapi.EditMessageTextConfig
 		if chatMsgID.InlineMessageID != nil {
+			editKey = "inline:" + *chatMsgID.InlineMessageID
 			metrics.TgCbInlineAnswer.With(prometheus.Labels{"result": "success"}).Inc()
 			msgEdit = tgbotapi.EditMessageTextConfig{
 				BaseEdit: tgbotapi.BaseEdit{
@@ -55,6 +116,7 @@ func Reply(bot *tgbotapi.BotAPI, chatMsgID ChatMessageID, forReply MessageForRep
 				Text: forReply.Text,
 			}
 		} else {
+			editKey = "edit:" + strconv.FormatInt(chatID, 10) + ":" + strconv.Itoa(*chatMsgID.MessageID)
 			msgEdit = tgbotapi.NewEditMessageText(*chatMsgID.ChatID, *chatMsgID.MessageID, forReply.Text)
 		}
 		if forReply.Markdown != nil {
@@ -67,29 +129,47 @@ func Reply(bot *tgbotapi.BotAPI, chatMsgID ChatMessageID, forReply MessageForRep
 		msg = msgEdit
 	}
 	if forReply.AnswerCallback != nil {
-		_, err := bot.AnswerCallbackQuery(*forReply.AnswerCallback)
-		if err != nil {
-			metrics.TgCbAnswer.With(prometheus.Labels{"result": "error"}).Inc()
-			log.Println("err while answering CallbackQuery " + err.Error())
-		} else {
-			metrics.TgCbAnswer.With(prometheus.Labels{"result": "success"}).Inc()
-		}
+		s.AnswerCallback(ctx, bot, *forReply.AnswerCallback)
 	}
 
-	sent, err := bot.Send(msg)
-	if err != nil {
+	sent, err := s.Do(ctx, chatID, editKey, msgLabel, func() (tgbotapi.Message, error) {
+		return bot.Send(msg)
+	})
+	if err == ErrSuperseded {
+		logger.Debug(ctx, "edit skipped, a newer one is queued", "msg_type", msgLabel)
+	} else if err != nil {
 		metrics.TgMsgSent.With(prometheus.Labels{"result": "error", "msg_type": msgLabel}).Inc()
-		log.Println("err while sending " + err.Error())
+		logger.Error(ctx, "failed to send a message", "msg_type", msgLabel, "err", err)
+		// an error message would only prolong the flood, and inline messages have no chat to send it to
+		if chatMsgID.ChatID == nil || RetryAfter(err) > 0 {
+			return &sent, err
+		}
 		msgNew := tgbotapi.NewMessage(*chatMsgID.ChatID, "Произошла ошибка при отправке. Попробуйте ещё раз или нажмите /clear")
-		_, err = bot.Send(msgNew)
-		if err != nil {
+		_, retryErr := s.Do(ctx, chatID, "", msgLabel, func() (tgbotapi.Message, error) {
+			return bot.Send(msgNew)
+		})
+		if retryErr != nil {
 			metrics.TgMsgRetrySent.With(prometheus.Labels{"result": "error", "msg_type": msgLabel}).Inc()
 		} else {
 			metrics.TgMsgRetrySent.With(prometheus.Labels{"result": "success", "msg_type": msgLabel}).Inc()
 		}
 	} else {
 		metrics.TgMsgSent.With(prometheus.Labels{"result": "success", "msg_type": msgLabel}).Inc()
-		log.Println("bot.Send() ok")
+		logger.Debug(ctx, "message sent", "msg_type", msgLabel)
 	}
 	return &sent, err
 }
+
+// AnswerCallback stops the spinner on the pressed button, showing the text if there is one
+func (s *Sender) AnswerCallback(ctx context.Context, bot *tgbotapi.BotAPI, cbAnswer tgbotapi.CallbackConfig) {
+	_, err := s.Do(ctx, 0, "", "callback_answer", func() (tgbotapi.Message, error) {
+		_, err := bot.AnswerCallbackQuery(cbAnswer)
+		return tgbotapi.Message{}, err
+	})
+	if err != nil {
+		metrics.TgCbAnswer.With(prometheus.Labels{"result": "error"}).Inc()
+		logger.Error(ctx, "failed to answer a callback query", "err", err)
+	} else {
+		metrics.TgCbAnswer.With(prometheus.Labels{"result": "success"}).Inc()
+	}
+}
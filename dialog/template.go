@@ -0,0 +1,183 @@
+package dialog
+
+import (
+	"errors"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/schedule"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxTemplates a user may keep, each of them is a button of the template keyboard
+	MaxTemplates          = 10
+	maxTemplateNameLength = 24
+	templateRemoveWord    = "удалить"
+	scheduleOffWord       = "выкл"
+	nextRunLayout         = "02.01 в 15:04"
+)
+
+var (
+	ErrTemplateUsage      = errors.New("template name is missing")
+	ErrTemplateNameLength = errors.New("template name is too long")
+	ErrTooManyTemplates   = errors.New("too many templates")
+	ErrEmptyTemplate      = errors.New("list to save as a template is empty")
+	ErrUnknownTemplate    = errors.New("unknown template")
+	ErrScheduleUsage      = errors.New("schedule is not name, days and time")
+	ErrTimezoneUsage      = errors.New("timezone is unknown")
+)
+
+// ParseTemplateArgs reads "Продукты" to save the current list as a template and "удалить Продукты" to remove it
+func ParseTemplateArgs(args string) (name string, remove bool, err error) {
+	name = strings.TrimSpace(args)
+	if fields := strings.Fields(name); len(fields) > 1 && strings.ToLower(fields[0]) == templateRemoveWord {
+		remove = true
+		name = strings.TrimSpace(strings.TrimPrefix(name, fields[0]))
+	}
+	if name == "" {
+		return name, remove, ErrTemplateUsage
+	}
+	if len([]rune(name)) > maxTemplateNameLength {
+		return name, remove, ErrTemplateNameLength
+	}
+	return name, remove, nil
+}
+
+// ParseScheduleArgs reads "Продукты пт 18:00", "Продукты выкл" turns the schedule off and gives a nil one
+func ParseScheduleArgs(args string) (string, *schedule.Weekly, error) {
+	fields := strings.Fields(args)
+	if len(fields) > 1 && strings.ToLower(fields[len(fields)-1]) == scheduleOffWord {
+		return strings.Join(fields[:len(fields)-1], " "), nil, nil
+	}
+	name, weekly, ok := schedule.Cut(args)
+	if !ok || name == "" {
+		return name, nil, ErrScheduleUsage
+	}
+	return name, &weekly, nil
+}
+
+// GetMessageForTemplates offers to create a list from a template of the user
+func (h *MessageHandler) GetMessageForTemplates(templates []db.Template, err error) MessageForReply {
+	msg := MessageForReply{NewMessage: true}
+	if err != nil {
+		msg.Text = "Не удалось загрузить шаблоны, попробуйте ещё раз"
+		return msg
+	}
+	if len(templates) == 0 {
+		msg.Text = templateUsage()
+		return msg
+	}
+	msg.Text = "Шаблоны, нажмите, чтобы начать список по шаблону:\n"
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for _, tpl := range templates {
+		msg.Text += "\n" + tpl.Name + " - товаров: " + strconv.Itoa(len(tpl.Items))
+		if tpl.Schedule != nil {
+			msg.Text += ", " + tpl.Schedule.String()
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"📋 "+tpl.Name,
+			tpl.Id.Hex()+":"+CbTemplate,
+		)))
+	}
+	msg.Text += "\n\n" + templateUsage()
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	msg.InlineKeyboard = &keyboard
+
+	return msg
+}
+
+func (h *MessageHandler) GetMessageForTemplateSaved(tpl db.Template, removed bool, err error) MessageForReply {
+	msg := MessageForReply{NewMessage: true}
+	switch {
+	case err == nil && removed:
+		msg.Text = "Шаблон «" + tpl.Name + "» удалён"
+	case err == nil:
+		msg.Text = "Шаблон «" + tpl.Name + "» сохранён, товаров: " + strconv.Itoa(len(tpl.Items)) + "\n\n" +
+			"Начать список по нему - /template\n" +
+			"Присылать по расписанию - /schedule " + tpl.Name + " пт 18:00"
+	case err == ErrTemplateNameLength:
+		msg.Text = "Название шаблона должно быть не длиннее " + strconv.Itoa(maxTemplateNameLength) + " символов"
+	case err == ErrTooManyTemplates:
+		msg.Text = "Можно сохранить не больше " + strconv.Itoa(MaxTemplates) + " шаблонов, удалите ненужный: /template удалить Название"
+	case err == ErrEmptyTemplate:
+		msg.Text = "Список пуст. Составьте список, затем сохраните его: /template Продукты"
+	case err == ErrUnknownTemplate:
+		msg.Text = "Шаблона «" + tpl.Name + "» нет, посмотрите /template"
+	case err == ErrTemplateUsage:
+		msg.Text = templateUsage()
+	default:
+		msg.Text = "Не удалось сохранить шаблон, попробуйте ещё раз"
+	}
+
+	return msg
+}
+
+// GetMessageForSchedule confirms the schedule of the template, next is its first run in the timezone of the user
+func (h *MessageHandler) GetMessageForSchedule(tpl db.Template, next time.Time, err error) MessageForReply {
+	msg := MessageForReply{NewMessage: true}
+	switch {
+	case err == nil && tpl.Schedule == nil:
+		msg.Text = "Шаблон «" + tpl.Name + "» больше не приходит по расписанию"
+	case err == nil:
+		msg.Text = "Список по шаблону «" + tpl.Name + "» будет приходить " + tpl.Schedule.String() +
+			", следующий - " + next.Format(nextRunLayout) + " (" + next.Location().String() + ")\n\n" +
+			"Часовой пояс - /timezone, отключить - /schedule " + tpl.Name + " " + scheduleOffWord
+	case err == ErrUnknownTemplate:
+		msg.Text = "Шаблона «" + tpl.Name + "» нет. Сначала сохраните список: /template " + tpl.Name
+	case err == ErrScheduleUsage:
+		msg.Text = scheduleUsage()
+	default:
+		msg.Text = "Не удалось сохранить расписание, попробуйте ещё раз"
+	}
+
+	return msg
+}
+
+// GetMessageForTimezone shows the timezone of the user with the local time in it
+func (h *MessageHandler) GetMessageForTimezone(loc *time.Location, changed bool, err error) MessageForReply {
+	msg := MessageForReply{NewMessage: true}
+	if err == ErrTimezoneUsage {
+		msg.Text = "Не знаю такой часовой пояс. Напишите его название или разницу с UTC:\n" +
+			"/timezone Europe/Moscow\n/timezone +5"
+		return msg
+	} else if err != nil {
+		msg.Text = "Не удалось сохранить часовой пояс, попробуйте ещё раз"
+		return msg
+	}
+	msg.Text = "Часовой пояс: " + loc.String() + ", сейчас " + time.Now().In(loc).Format("15:04")
+	if changed {
+		msg.Text += "\nРасписания шаблонов переведены на него"
+	} else {
+		msg.Text += "\n\nПоменять: /timezone Europe/Moscow или /timezone +5"
+	}
+
+	return msg
+}
+
+func templateUsage() string {
+	return "Чтобы сохранить текущий список как шаблон, напишите\n/template Продукты\n\n" +
+		"Шаблоны можно присылать по расписанию: /schedule Продукты пт 18:00\n" +
+		"Удалить шаблон: /template удалить Продукты"
+}
+
+func scheduleUsage() string {
+	return "Чтобы список по шаблону приходил сам, напишите название шаблона, дни и время:\n" +
+		"/schedule Продукты пт 18:00\n" +
+		"/schedule Продукты пн, чт 9:30\n" +
+		"/schedule Продукты ежедневно 20:00\n" +
+		"Отключить: /schedule Продукты " + scheduleOffWord
+}
+
+// GetMessageForTemplateList is a new list created from the template, headed by its name
+func (h *MessageHandler) GetMessageForTemplateList(tpl *db.Template, purchaseList *db.PurchaseList, scheduled bool) MessageForReply {
+	msg := h.createMessageForPurchaseList(MessageForReply{NewMessage: true}, purchaseList)
+	header := "📋 "
+	if scheduled {
+		header = "🗓 "
+	}
+	msg.Text = header + "*" + h.textReplacer.Replace(tpl.Name) + "*\n\n" + msg.Text
+
+	return msg
+}
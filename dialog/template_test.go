@@ -0,0 +1,37 @@
+package dialog
+
+import (
+	"github.com/boryashkin/purchaselist/schedule"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleArgs(t *testing.T) {
+	tests := []struct {
+		args    string
+		name    string
+		weekly  *schedule.Weekly
+		wantErr bool
+	}{
+		{args: "Продукты пт 18:00", name: "Продукты", weekly: &schedule.Weekly{Days: []time.Weekday{time.Friday}, Minute: 18 * 60}},
+		{args: "Дача по выходным 9:00", name: "Дача", weekly: &schedule.Weekly{Days: []time.Weekday{time.Saturday, time.Sunday}, Minute: 9 * 60}},
+		{args: "Продукты ежедневно 20:00", name: "Продукты", weekly: &schedule.Weekly{Minute: 20 * 60}},
+		{args: "Продукты ВЫКЛ", name: "Продукты"},
+		{args: "Для дачи выкл", name: "Для дачи"},
+		{args: "выкл", name: "выкл", wantErr: true},
+		{args: "пт 18:00", wantErr: true},
+		{args: "Продукты пт", name: "Продукты пт", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			name, weekly, err := ParseScheduleArgs(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseScheduleArgs(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if name != tt.name || !reflect.DeepEqual(weekly, tt.weekly) {
+				t.Errorf("ParseScheduleArgs(%q) = %q, %v, want %q, %v", tt.args, name, weekly, tt.name, tt.weekly)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package dialog
+
+import (
+	"context"
+	"errors"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/writeas/go-strip-markdown"
+	"strings"
+)
+
+// ErrNotYours is returned when a button of another user's list or pantry is pressed
+var ErrNotYours = errors.New("the button belongs to another user")
+
+// ErrorText replaces the text of an error with a friendly one while the database is down
+func ErrorText(err error, text string) string {
+	if errors.Is(err, db.ErrUnavailable) || errors.Is(err, context.DeadlineExceeded) {
+		return "Сервис временно недоступен, попробуйте через пару минут"
+	}
+	if errors.Is(err, ErrNotYours) {
+		return "Эта кнопка не для вас"
+	}
+	return text
+}
+
+// SanitizeItem strips the markdown of an item and cuts it to maxLength runes
+func SanitizeItem(text string, maxLength int) string {
+	text = stripmd.Strip(text)
+	text = strings.Trim(text, "\n\t")
+	if runes := []rune(text); len(runes) > maxLength {
+		text = string(runes[:maxLength]) + "…"
+	}
+	return text
+}
+
+// UniqueItems drops the repeated items and keeps at most max of them
+func UniqueItems(list []string, max int) []string {
+	var result []string
+	keys := make(map[string]string)
+	i := 0
+	for _, key := range list {
+		hash := db.GetMD5Hash(key)
+		if _, found := keys[hash]; !found {
+			keys[hash] = key
+			result = append(result, key)
+
+			i++
+			if i >= max {
+				break
+			}
+		}
+	}
+
+	return result
+}
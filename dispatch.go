@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/boryashkin/purchaselist/cluster"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/boryashkin/purchaselist/queue"
+	"github.com/boryashkin/purchaselist/tracing"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// dispatcher hands the received updates to goroutines. An update takes the turn of its user
+// before it is handed over, so the updates of a user are handled in the order they are received.
+type dispatcher struct {
+	locker    cluster.Locker
+	processed cluster.Processed
+	offsets   *queue.Offsets
+	// saveOffset keeps the polling offset for the next leader, it is nil with a webhook
+	saveOffset func(ctx context.Context, offset int) error
+	handle     func(ctx context.Context, update tgbotapi.Update)
+	inFlight   sync.WaitGroup
+	count      int64
+}
+
+func newDispatcher(locker cluster.Locker, processed cluster.Processed, saveOffset func(ctx context.Context, offset int) error, handle func(ctx context.Context, update tgbotapi.Update)) *dispatcher {
+	return &dispatcher{
+		locker:     locker,
+		processed:  processed,
+		offsets:    queue.NewOffsets(),
+		saveOffset: saveOffset,
+		handle:     handle,
+	}
+}
+
+// Dispatch takes the turn of the update and handles it in a goroutine. An update which gets no turn
+// is never done, so the offset stays before it and it is redelivered after a restart.
+func (d *dispatcher) Dispatch(ctx context.Context, update tgbotapi.Update) error {
+	d.offsets.Start(update.UpdateID)
+	turn, err := d.locker.Join(ctx, updateLockKey(update))
+	if err != nil {
+		return err
+	}
+	d.inFlight.Add(1)
+	atomic.AddInt64(&d.count, 1)
+	metrics.BotInFlight.Inc()
+	go func() {
+		defer d.inFlight.Done()
+		defer atomic.AddInt64(&d.count, -1)
+		defer metrics.BotInFlight.Dec()
+		ctx := logger.WithCorrelation(context.Background(), "u"+strconv.Itoa(update.UpdateID))
+		ctx, span := tracing.Start(ctx, "update",
+			attribute.Int("update_id", update.UpdateID),
+			attribute.String("update_type", updateType(update)),
+		)
+		defer span.End()
+		handled, err := cluster.Handle(ctx, turn, d.processed, update.UpdateID, func(ctx context.Context) {
+			metrics.TgUpdateReceived.With(prometheus.Labels{"result": "handled"}).Inc()
+			start := time.Now()
+			d.handle(ctx, update)
+			metrics.BotUpdateDuration.With(prometheus.Labels{"update_type": updateType(update)}).Observe(time.Since(start).Seconds())
+		})
+		if err != nil {
+			logger.Error(ctx, "the update is left for a redelivery", "err", err)
+			return
+		}
+		d.offsets.Done(update.UpdateID)
+		if !handled {
+			metrics.TgUpdateReceived.With(prometheus.Labels{"result": "duplicate"}).Inc()
+		}
+		if d.saveOffset == nil {
+			return
+		}
+		err = d.saveOffset(ctx, d.offsets.Offset())
+		if err != nil {
+			logger.Error(ctx, "failed to save the polling offset", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+// ServeHTTP takes the updates of the webhook. The next update of the user may be sent to another
+// instance as soon as this one is answered, so the answer waits until the update has its turn.
+func (d *dispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var update tgbotapi.Update
+	err := json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+	err = d.Dispatch(r.Context(), update)
+	if err != nil {
+		// telegram delivers the update again
+		logger.Error(r.Context(), "failed to dispatch the update", "update_id", update.UpdateID, "err", err)
+		http.Error(w, "no turn for the update", http.StatusInternalServerError)
+	}
+}
+
+// Wait blocks until the dispatched updates are handled or the context is done
+func (d *dispatcher) Wait(ctx context.Context) error {
+	return waitWithContext(ctx, &d.inFlight)
+}
+
+// Backlog is the number of updates dispatched and not handled yet
+func (d *dispatcher) Backlog() int64 {
+	return atomic.LoadInt64(&d.count)
+}
+
+// Offset is the lowest update not handled yet, telegram may forget the updates before it
+func (d *dispatcher) Offset() int {
+	return d.offsets.Offset()
+}
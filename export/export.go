@@ -0,0 +1,175 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/boryashkin/purchaselist/db"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Format string
+
+const (
+	FormatCSV      Format = "csv"
+	FormatJSON     Format = "json"
+	FormatMarkdown Format = "md"
+	FormatText     Format = "txt"
+
+	// JSONVersion is written into every JSON export, so the importer could tell the layout apart
+	JSONVersion = 1
+
+	dateLayout = "02.01.2006 15:04"
+)
+
+// Formats in the order they are offered to a user
+var Formats = []Format{FormatCSV, FormatJSON, FormatMarkdown, FormatText}
+
+var quantityRe = regexp.MustCompile(`^(.+?)\s+[xх×]?(\d+(?:[.,]\d+)?\s*[\p{L}.]{0,5})$`)
+
+type Item struct {
+	Name       string `json:"name"`
+	Quantity   string `json:"quantity,omitempty"`
+	CrossedOut bool   `json:"crossed_out"`
+}
+
+type ListDto struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Items     []Item    `json:"items"`
+}
+
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", errors.New("unknown export format " + s)
+}
+
+// Export renders the list into a file of the given format
+func Export(list *db.PurchaseList, format Format) ([]byte, error) {
+	items := ListItems(list)
+	switch format {
+	case FormatCSV:
+		return toCSV(list, items)
+	case FormatJSON:
+		return toJSON(list, items)
+	case FormatMarkdown:
+		return toMarkdown(list, items), nil
+	case FormatText:
+		return toText(list, items), nil
+	}
+	return nil, errors.New("unknown export format " + string(format))
+}
+
+func FileName(list *db.PurchaseList, format Format) string {
+	return "purchaselist_" + list.CreatedAt.Time().Format("2006-01-02") + "." + string(format)
+}
+
+// ListItems resolves names of active and crossed out items in the order they were added
+func ListItems(list *db.PurchaseList) []Item {
+	crossed := map[db.PurchaseItemHash]bool{}
+	for _, hash := range list.DeletedItemHashes {
+		crossed[hash] = true
+	}
+	active := map[db.PurchaseItemHash]bool{}
+	for _, hash := range list.Items {
+		active[hash] = true
+	}
+	var items []Item
+	seen := map[db.PurchaseItemHash]bool{}
+	for _, pItem := range list.ItemsDictionary {
+		if seen[pItem.Hash] || (!active[pItem.Hash] && !crossed[pItem.Hash]) {
+			continue
+		}
+		seen[pItem.Hash] = true
+		name, quantity := SplitQuantity(string(pItem.Name))
+		items = append(items, Item{
+			Name:       name,
+			Quantity:   quantity,
+			CrossedOut: crossed[pItem.Hash] && !active[pItem.Hash],
+		})
+	}
+	return items
+}
+
+// SplitQuantity cuts a trailing amount like "2", "x3" or "1.5кг" off an item name
+func SplitQuantity(text string) (string, string) {
+	matches := quantityRe.FindStringSubmatch(strings.TrimSpace(text))
+	if matches == nil {
+		return strings.TrimSpace(text), ""
+	}
+	return matches[1], matches[2]
+}
+
+func toCSV(list *db.PurchaseList, items []Item) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	created := list.CreatedAt.Time().Format(time.RFC3339)
+	updated := list.UpdatedAt.Time().Format(time.RFC3339)
+	err := w.Write([]string{"name", "quantity", "crossed_out", "created_at", "updated_at"})
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		err = w.Write([]string{item.Name, item.Quantity, strconv.FormatBool(item.CrossedOut), created, updated})
+		if err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func toJSON(list *db.PurchaseList, items []Item) ([]byte, error) {
+	if items == nil {
+		items = []Item{}
+	}
+	return json.MarshalIndent(ListDto{
+		Version:   JSONVersion,
+		CreatedAt: list.CreatedAt.Time(),
+		UpdatedAt: list.UpdatedAt.Time(),
+		Items:     items,
+	}, "", "  ")
+}
+
+func toMarkdown(list *db.PurchaseList, items []Item) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# Список покупок " + list.CreatedAt.Time().Format(dateLayout) + "\n\n")
+	for _, item := range items {
+		if item.CrossedOut {
+			buf.WriteString("- [x] ")
+		} else {
+			buf.WriteString("- [ ] ")
+		}
+		buf.WriteString(item.String() + "\n")
+	}
+	buf.WriteString("\n_Обновлён " + list.UpdatedAt.Time().Format(dateLayout) + "_\n")
+	return buf.Bytes()
+}
+
+func toText(list *db.PurchaseList, items []Item) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("Список покупок " + list.CreatedAt.Time().Format(dateLayout) + "\n\n")
+	for _, item := range items {
+		if item.CrossedOut {
+			buf.WriteString("✔ ")
+		}
+		buf.WriteString(item.String() + "\n")
+	}
+	return buf.Bytes()
+}
+
+func (i Item) String() string {
+	if i.Quantity == "" {
+		return i.Name
+	}
+	return i.Name + " " + i.Quantity
+}
@@ -0,0 +1,163 @@
+package export
+
+import (
+	"github.com/boryashkin/purchaselist/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitQuantity(t *testing.T) {
+	tests := []struct {
+		text     string
+		name     string
+		quantity string
+	}{
+		{text: "молоко", name: "молоко"},
+		{text: "молоко 2", name: "молоко", quantity: "2"},
+		{text: "яйца x10", name: "яйца", quantity: "10"},
+		{text: "яйца х10", name: "яйца", quantity: "10"},
+		{text: "сыр 1.5кг", name: "сыр", quantity: "1.5кг"},
+		{text: "сыр 0,5 кг", name: "сыр", quantity: "0,5 кг"},
+		{text: "  хлеб  ", name: "хлеб"},
+		{text: "7up", name: "7up"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			name, quantity := SplitQuantity(tt.text)
+			if name != tt.name || quantity != tt.quantity {
+				t.Errorf("SplitQuantity(%q) = %q, %q, want %q, %q", tt.text, name, quantity, tt.name, tt.quantity)
+			}
+		})
+	}
+}
+
+func TestListItems(t *testing.T) {
+	milk, eggs, bread, old := hash("молоко 2"), hash("яйца"), hash("хлеб"), hash("старое")
+	tests := []struct {
+		name string
+		list db.PurchaseList
+		want []Item
+	}{
+		{
+			name: "empty",
+			list: db.PurchaseList{},
+		},
+		{
+			name: "active and crossed out in the order they were added",
+			list: db.PurchaseList{
+				ItemsDictionary: []db.PurchaseItem{
+					{Name: "молоко 2", Hash: milk},
+					{Name: "яйца", Hash: eggs},
+					{Name: "хлеб", Hash: bread},
+				},
+				Items:             []db.PurchaseItemHash{bread, milk},
+				DeletedItemHashes: []db.PurchaseItemHash{eggs},
+			},
+			want: []Item{
+				{Name: "молоко", Quantity: "2"},
+				{Name: "яйца", CrossedOut: true},
+				{Name: "хлеб"},
+			},
+		},
+		{
+			name: "an item added again after it was crossed out is active",
+			list: db.PurchaseList{
+				ItemsDictionary:   []db.PurchaseItem{{Name: "яйца", Hash: eggs}},
+				Items:             []db.PurchaseItemHash{eggs},
+				DeletedItemHashes: []db.PurchaseItemHash{eggs},
+			},
+			want: []Item{{Name: "яйца"}},
+		},
+		{
+			name: "duplicates and removed items of the dictionary are skipped",
+			list: db.PurchaseList{
+				ItemsDictionary: []db.PurchaseItem{
+					{Name: "яйца", Hash: eggs},
+					{Name: "старое", Hash: old},
+					{Name: "яйца", Hash: eggs},
+				},
+				Items: []db.PurchaseItemHash{eggs},
+			},
+			want: []Item{{Name: "яйца"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ListItems(&tt.list); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListItems() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	created := primitive.NewDateTimeFromTime(time.Date(2026, 10, 1, 9, 30, 0, 0, time.UTC))
+	updated := primitive.NewDateTimeFromTime(time.Date(2026, 10, 2, 18, 5, 0, 0, time.UTC))
+	createdAt, updatedAt := created.Time().Format(dateLayout), updated.Time().Format(dateLayout)
+	milk, eggs := hash("молоко 2"), hash("яйца")
+	list := db.PurchaseList{
+		CreatedAt:         created,
+		UpdatedAt:         updated,
+		ItemsDictionary:   []db.PurchaseItem{{Name: "молоко 2", Hash: milk}, {Name: "яйца", Hash: eggs}},
+		Items:             []db.PurchaseItemHash{milk},
+		DeletedItemHashes: []db.PurchaseItemHash{eggs},
+	}
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{
+			format: FormatCSV,
+			want: "name,quantity,crossed_out,created_at,updated_at\n" +
+				"молоко,2,false," + created.Time().Format(time.RFC3339) + "," + updated.Time().Format(time.RFC3339) + "\n" +
+				"яйца,,true," + created.Time().Format(time.RFC3339) + "," + updated.Time().Format(time.RFC3339) + "\n",
+		},
+		{
+			format: FormatMarkdown,
+			want:   "# Список покупок " + createdAt + "\n\n- [ ] молоко 2\n- [x] яйца\n\n_Обновлён " + updatedAt + "_\n",
+		},
+		{
+			format: FormatText,
+			want:   "Список покупок " + createdAt + "\n\nмолоко 2\n✔ яйца\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			got, err := Export(&list, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Export() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportEmptyJSON(t *testing.T) {
+	got, err := Export(&db.PurchaseList{}, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"items": []`; !strings.Contains(string(got), want) {
+		t.Errorf("Export() = %s, want %s in it", got, want)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, format := range Formats {
+		if got, err := ParseFormat(string(format)); err != nil || got != format {
+			t.Errorf("ParseFormat(%q) = %q, %v", format, got, err)
+		}
+	}
+	if _, err := ParseFormat("pdf"); err == nil {
+		t.Error("ParseFormat(pdf) returned no error")
+	}
+}
+
+func hash(name string) db.PurchaseItemHash {
+	return db.PurchaseItemHash(db.GetMD5Hash(name))
+}
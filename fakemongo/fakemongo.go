@@ -0,0 +1,202 @@
+// Package fakemongo answers the commands of the mongo driver without a server, so the
+// services can be tested and benchmarked in memory.
+//
+// Every collection holds at most one document: a read returns it, a write matches it
+// and changes nothing. Commands are counted, in total and by name.
+package fakemongo
+
+import (
+	"context"
+	"errors"
+	"github.com/boryashkin/purchaselist/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/address"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+	"sync"
+	"sync/atomic"
+)
+
+// Deployment is a mongo server with one document per collection
+type Deployment struct {
+	docs     map[string]bson.Raw
+	commands int64
+	mu       sync.Mutex
+	byName   map[string]int64
+	updates  chan description.Topology
+}
+
+// New deployment holding the documents by their collection names
+func New(docs map[string]interface{}) *Deployment {
+	f := &Deployment{docs: map[string]bson.Raw{}, byName: map[string]int64{}}
+	for collection, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			panic(err)
+		}
+		f.docs[collection] = raw
+	}
+	return f
+}
+
+// Connect a client to the deployment
+func Connect(ctx context.Context, f *Deployment) (*mongo.Client, error) {
+	opts := options.Client()
+	opts.Deployment = f
+	client, err := mongo.NewClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	return client, client.Connect(ctx)
+}
+
+// Count of all the commands received
+func (f *Deployment) Count() int64 {
+	return atomic.LoadInt64(&f.commands)
+}
+
+// Commands received with the name, like "find" or "insert"
+func (f *Deployment) Commands(name string) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.byName[name]
+}
+
+func (f *Deployment) SelectServer(context.Context, description.ServerSelector) (driver.Server, error) {
+	return f, nil
+}
+
+func (f *Deployment) Kind() description.TopologyKind {
+	return description.Single
+}
+
+func (f *Deployment) Connection(context.Context) (driver.Connection, error) {
+	return &connection{deployment: f}, nil
+}
+
+func (f *Deployment) Connect() error {
+	return nil
+}
+
+func (f *Deployment) Disconnect(context.Context) error {
+	return nil
+}
+
+// Subscribe tells the client that the server supports sessions
+func (f *Deployment) Subscribe() (*driver.Subscription, error) {
+	if f.updates == nil {
+		f.updates = make(chan description.Topology, 1)
+		f.updates <- description.Topology{SessionTimeoutMinutes: 30}
+	}
+	return &driver.Subscription{Updates: f.updates}, nil
+}
+
+func (f *Deployment) Unsubscribe(*driver.Subscription) error {
+	return nil
+}
+
+// reply to the command named by the first element of the document
+func (f *Deployment) reply(command bsoncore.Document) bson.M {
+	atomic.AddInt64(&f.commands, 1)
+	element, err := command.IndexErr(0)
+	if err != nil {
+		return bson.M{"ok": 0, "errmsg": "empty command"}
+	}
+	f.mu.Lock()
+	f.byName[element.Key()]++
+	f.mu.Unlock()
+	collection, _ := element.Value().StringValueOK()
+	doc, found := f.docs[collection]
+	switch element.Key() {
+	case "find":
+		batch := bson.A{}
+		if found {
+			batch = append(batch, doc)
+		}
+		return bson.M{"cursor": bson.M{"id": int64(0), "ns": db.DbName + "." + collection, "firstBatch": batch}, "ok": 1}
+	case "findAndModify":
+		return bson.M{"value": doc, "lastErrorObject": bson.M{"n": 1, "updatedExisting": true}, "ok": 1}
+	case "update", "insert", "delete":
+		return bson.M{"n": 1, "nModified": 1, "ok": 1}
+	}
+	return bson.M{"ok": 1}
+}
+
+// connection serves one operation, the reply is prepared when the command is written
+type connection struct {
+	deployment *Deployment
+	mu         sync.Mutex
+	next       bson.M
+}
+
+func (c *connection) WriteWireMessage(_ context.Context, wm []byte) error {
+	_, _, _, opcode, rem, ok := wiremessage.ReadHeader(wm)
+	if !ok || opcode != wiremessage.OpMsg {
+		return errors.New("only OP_MSG is supported")
+	}
+	_, rem, ok = wiremessage.ReadMsgFlags(rem)
+	if ok {
+		_, rem, ok = wiremessage.ReadMsgSectionType(rem)
+	}
+	var command bsoncore.Document
+	if ok {
+		command, _, ok = wiremessage.ReadMsgSectionSingleDocument(rem)
+	}
+	if !ok {
+		return errors.New("malformed OP_MSG")
+	}
+	c.mu.Lock()
+	c.next = c.deployment.reply(command)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *connection) ReadWireMessage(_ context.Context, dst []byte) ([]byte, error) {
+	c.mu.Lock()
+	reply := c.next
+	c.mu.Unlock()
+	raw, err := bson.Marshal(reply)
+	if err != nil {
+		return dst, err
+	}
+	var start int32
+	start, dst = wiremessage.AppendHeaderStart(dst, wiremessage.NextRequestID(), 0, wiremessage.OpMsg)
+	dst = wiremessage.AppendMsgFlags(dst, 0)
+	dst = wiremessage.AppendMsgSectionType(dst, wiremessage.SingleDocument)
+	dst = append(dst, raw...)
+	return bsoncore.UpdateLength(dst, start, int32(len(dst[start:]))), nil
+}
+
+func (c *connection) Description() description.Server {
+	return description.Server{
+		Addr:                  c.Address(),
+		CanonicalAddr:         c.Address(),
+		Kind:                  description.Standalone,
+		MaxDocumentSize:       16 * 1024 * 1024,
+		MaxMessageSize:        48 * 1000 * 1000,
+		MaxBatchCount:         100000,
+		SessionTimeoutMinutes: 30,
+		WireVersion:           &description.VersionRange{Min: 6, Max: topology.SupportedWireVersions.Max},
+	}
+}
+
+func (c *connection) Close() error {
+	return nil
+}
+
+func (c *connection) ID() string {
+	return "fake"
+}
+
+func (c *connection) Address() address.Address {
+	return "fake:27017"
+}
+
+func (c *connection) Stale() bool {
+	return false
+}
@@ -0,0 +1,308 @@
+// Package faketg imitates the parts of the Telegram Bot API the bot uses, so several
+// instances can be tested locally without a real bot token.
+//
+// Every instance registering a webhook gets a share of updates round robin, like behind
+// a load balancer, instances without one receive updates through long polling. Some of
+// the updates are delivered twice, some of the messages may be rejected with 429.
+package faketg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server imitates the Telegram Bot API for the bot and sends it the updates of fake users
+type Server struct {
+	mu         sync.Mutex
+	updateID   int
+	messageID  int
+	updates    []tgbotapi.Update
+	webhooks   []string
+	next       int
+	messages   map[int64]map[int]string
+	items      map[int64][]string
+	counts     map[string]int
+	duplicates float64
+	flood      float64
+	pause      time.Duration
+	delivered  int
+}
+
+// NewServer makes a server delivering the share duplicates of updates twice and rejecting the share flood
+// of sent and edited messages with 429, the updates of a user are sent with the pause between them
+func NewServer(duplicates float64, flood float64, pause time.Duration) *Server {
+	return &Server{
+		messages:   map[int64]map[int]string{},
+		items:      map[int64][]string{},
+		counts:     map[string]int{},
+		duplicates: duplicates,
+		flood:      flood,
+		pause:      pause,
+	}
+}
+
+// ServeHTTP serves /bot<token>/<method>
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "bot") {
+		http.NotFound(w, r)
+		return
+	}
+	method := parts[1]
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		r.ParseMultipartForm(10 << 20)
+	} else {
+		r.ParseForm()
+	}
+	s.mu.Lock()
+	s.counts[method]++
+	s.mu.Unlock()
+
+	if (method == "sendMessage" || method == "editMessageText") && rand.Float64() < s.flood {
+		s.mu.Lock()
+		s.counts["429"]++
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tgbotapi.APIResponse{
+			ErrorCode:   http.StatusTooManyRequests,
+			Description: "Too Many Requests: retry after 1",
+			Parameters:  &tgbotapi.ResponseParameters{RetryAfter: 1},
+		})
+		return
+	}
+
+	switch method {
+	case "getMe":
+		reply(w, tgbotapi.User{ID: 1, IsBot: true, FirstName: "Fake", UserName: "fake_bot"})
+	case "getUpdates":
+		reply(w, s.pollUpdates(r))
+	case "setWebhook":
+		s.mu.Lock()
+		s.webhooks = append(s.webhooks, r.FormValue("url"))
+		s.mu.Unlock()
+		log.Println("webhook", r.FormValue("url"))
+		reply(w, true)
+	case "sendMessage", "sendDocument":
+		chatID, _ := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+		s.mu.Lock()
+		s.messageID++
+		id := s.messageID
+		if method == "sendMessage" {
+			s.chat(chatID)[id] = r.FormValue("text")
+		}
+		s.mu.Unlock()
+		reply(w, tgbotapi.Message{MessageID: id, Chat: &tgbotapi.Chat{ID: chatID}, Text: r.FormValue("text"), Date: int(time.Now().Unix())})
+	case "editMessageText":
+		chatID, _ := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+		id, _ := strconv.Atoi(r.FormValue("message_id"))
+		s.mu.Lock()
+		if _, found := s.chat(chatID)[id]; found {
+			s.chat(chatID)[id] = r.FormValue("text")
+		}
+		s.mu.Unlock()
+		reply(w, tgbotapi.Message{MessageID: id, Chat: &tgbotapi.Chat{ID: chatID}, Text: r.FormValue("text"), Date: int(time.Now().Unix())})
+	case "deleteMessage":
+		chatID, _ := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+		id, _ := strconv.Atoi(r.FormValue("message_id"))
+		s.mu.Lock()
+		delete(s.chat(chatID), id)
+		s.mu.Unlock()
+		reply(w, true)
+	case "answerCallbackQuery", "answerInlineQuery", "deleteWebhook":
+		reply(w, true)
+	default:
+		log.Println("unknown method", method)
+		reply(w, true)
+	}
+}
+
+func (s *Server) chat(chatID int64) map[int]string {
+	if _, found := s.messages[chatID]; !found {
+		s.messages[chatID] = map[int]string{}
+	}
+	return s.messages[chatID]
+}
+
+func (s *Server) pollUpdates(r *http.Request) []tgbotapi.Update {
+	offset, _ := strconv.Atoi(r.FormValue("offset"))
+	timeout, _ := strconv.Atoi(r.FormValue("timeout"))
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	for {
+		s.mu.Lock()
+		var pending []tgbotapi.Update
+		for _, update := range s.updates {
+			if update.UpdateID >= offset {
+				pending = append(pending, update)
+			}
+		}
+		s.mu.Unlock()
+		if len(pending) > 0 || time.Now().After(deadline) {
+			return pending
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Run sends /start and items from every user, the updates of a user one after another
+// with the pause between them. It returns once all of them are delivered or queued.
+func (s *Server) Run(users int, items int) {
+	var wg sync.WaitGroup
+	for u := 1; u <= users; u++ {
+		wg.Add(1)
+		go func(userID int) {
+			defer wg.Done()
+			s.deliver(newMessage(userID, "/start"))
+			for i := 1; i <= items; i++ {
+				item := "u" + strconv.Itoa(userID) + "i" + strconv.Itoa(i)
+				s.mu.Lock()
+				s.items[int64(userID)] = append(s.items[int64(userID)], item)
+				s.mu.Unlock()
+				s.deliver(newMessage(userID, item))
+				time.Sleep(s.pause)
+			}
+		}(u)
+	}
+	wg.Wait()
+	log.Println("all updates are sent")
+}
+
+// deliver pushes the update to the next webhook or queues it for polling
+func (s *Server) deliver(message *tgbotapi.Message) {
+	s.mu.Lock()
+	s.updateID++
+	update := tgbotapi.Update{UpdateID: s.updateID, Message: message}
+	times := 1
+	if rand.Float64() < s.duplicates {
+		times = 2
+	}
+	if len(s.webhooks) == 0 {
+		for i := 0; i < times; i++ {
+			s.updates = append(s.updates, update)
+		}
+		s.mu.Unlock()
+		return
+	}
+	var targets []string
+	for i := 0; i < times; i++ {
+		targets = append(targets, s.webhooks[s.next%len(s.webhooks)])
+		s.next++
+	}
+	s.mu.Unlock()
+
+	body, _ := json.Marshal(update)
+	for _, target := range targets {
+		resp, err := http.Post(target, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Println("webhook err", target, err)
+			continue
+		}
+		resp.Body.Close()
+		s.mu.Lock()
+		s.delivered++
+		s.mu.Unlock()
+	}
+}
+
+// Items are the items sent by the user so far
+func (s *Server) Items(userID int64) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.items[userID]...)
+}
+
+// Updates is the number of updates sent, without the duplicates
+func (s *Server) Updates() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateID
+}
+
+// Delivered is the number of posts to the webhooks, with the duplicates
+func (s *Server) Delivered() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.delivered
+}
+
+func newMessage(userID int, text string) *tgbotapi.Message {
+	message := &tgbotapi.Message{
+		MessageID: rand.Intn(1 << 30),
+		From:      &tgbotapi.User{ID: userID, FirstName: "User" + strconv.Itoa(userID), LanguageCode: "ru"},
+		Chat:      &tgbotapi.Chat{ID: int64(userID), Type: "private"},
+		Date:      int(time.Now().Unix()),
+		Text:      text,
+	}
+	if strings.HasPrefix(text, "/") {
+		message.Entities = &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(text)}}
+	}
+	return message
+}
+
+// Verify looks for a message of every user which lists all the items they sent
+func (s *Server) Verify(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var failed []string
+	for userID, items := range s.items {
+		complete := false
+		for _, text := range s.messages[userID] {
+			missing := 0
+			for _, item := range items {
+				if !strings.Contains(text, item) {
+					missing++
+				}
+			}
+			if missing == 0 {
+				complete = true
+				break
+			}
+		}
+		if !complete {
+			failed = append(failed, strconv.FormatInt(userID, 10))
+		}
+	}
+	if len(s.items) == 0 {
+		http.Error(w, "nothing was sent, call /run first", http.StatusConflict)
+		return
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		http.Error(w, "incomplete lists of users "+strings.Join(failed, ", "), http.StatusExpectationFailed)
+		return
+	}
+	fmt.Fprintf(w, "ok: lists of %d users are complete\n", len(s.items))
+}
+
+func (s *Server) Stats(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(w, "updates: %d\nwebhooks: %s\n", s.updateID, strings.Join(s.webhooks, " "))
+	var methods []string
+	for method := range s.counts {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		fmt.Fprintf(w, "%s: %d\n", method, s.counts[method])
+	}
+}
+
+func reply(w http.ResponseWriter, result interface{}) {
+	raw, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: raw})
+}
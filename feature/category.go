@@ -0,0 +1,153 @@
+package feature
+
+import (
+	"context"
+	"github.com/boryashkin/purchaselist/category"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/dialog"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strconv"
+	"strings"
+)
+
+// chooseStore orders the list by the chosen store and shows it instead of the store keyboard
+func (f *Handler) chooseStore(ctx context.Context, query *tgbotapi.CallbackQuery, listID primitive.ObjectID, choice string, c *dialog.MessageHandler, cbAnswer *tgbotapi.CallbackConfig) dialog.MessageForReply {
+	pList, _, user, err := f.ListState(ctx, listID)
+	if err == nil && !IsListMember(pList, user, query.From.ID) {
+		err = dialog.ErrNotYours
+	}
+	if err != nil {
+		cbAnswer.Text = dialog.ErrorText(err, "Список не найден")
+		logger.Warn(ctx, "store choice failed", "err", err)
+		return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: cbAnswer}
+	}
+	var store *db.StoreLayout
+	cbAnswer.Text = "Обычный порядок"
+	if choice != dialog.CbStoreNone {
+		i, err := strconv.Atoi(choice)
+		if err != nil || i < 0 || i >= len(user.Stores) {
+			cbAnswer.Text = "Магазин не найден, посмотрите /store"
+			return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: cbAnswer}
+		}
+		store = &user.Stores[i]
+		cbAnswer.Text = "Порядок магазина «" + store.Name + "»"
+	}
+	err = f.Lists.SetStore(ctx, listID, store)
+	if err != nil {
+		cbAnswer.Text = dialog.ErrorText(err, "Ошибка, попробуйте ещё раз")
+		logger.Error(ctx, "store choice failed", "err", err)
+		return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: cbAnswer}
+	}
+	pList.Store = store
+	if query.Message != nil {
+		// the keyboard message becomes a message of the list, so it is deleted with the others
+		f.Lists.AddMsgID(ctx, listID, db.TgMsgID{TgChatID: query.Message.Chat.ID, TgMessageID: query.Message.MessageID})
+	}
+	msg := c.GetMessageForReply(&dialog.MessageDto{UnknownContent: true}, nil, nil, pList)
+	msg.AnswerCallback = cbAnswer
+
+	return msg
+}
+
+// saveStore adds, replaces or removes a store of the user, the current list follows the changes of its store
+func (f *Handler) saveStore(ctx context.Context, args string, dState *dialog.DialogState) (db.StoreLayout, error) {
+	layout, err := dialog.ParseStoreLayout(args)
+	if err != nil {
+		return layout, err
+	}
+	var stores []db.StoreLayout
+	replaced := false
+	for _, store := range dState.User.Stores {
+		if !strings.EqualFold(store.Name, layout.Name) {
+			stores = append(stores, store)
+			continue
+		}
+		replaced = true
+		layout.Name = store.Name
+		if len(layout.Sections) > 0 {
+			stores = append(stores, layout)
+		}
+	}
+	if !replaced && len(layout.Sections) > 0 {
+		if len(stores) >= dialog.MaxStores {
+			return layout, dialog.ErrTooManyStores
+		}
+		stores = append(stores, layout)
+	}
+	if !replaced && len(layout.Sections) == 0 {
+		return layout, dialog.ErrStoreUsage
+	}
+	err = f.Users.SetStores(ctx, dState.User.Id, stores)
+	if err != nil {
+		logger.Error(ctx, "failed to save stores", "err", err)
+		return layout, err
+	}
+	dState.User.Stores = stores
+	pList := dState.PurchaseList
+	if pList.Store != nil && strings.EqualFold(pList.Store.Name, layout.Name) {
+		var store *db.StoreLayout
+		if len(layout.Sections) > 0 {
+			store = &layout
+		}
+		err = f.Lists.SetStore(ctx, pList.Id, store)
+		if err != nil {
+			logger.Warn(ctx, "failed to update the store of a list", "err", err)
+		}
+	}
+
+	return layout, nil
+}
+
+// correctCategory learns the category of an item from the user and reclassifies the current list with it
+func (f *Handler) correctCategory(ctx context.Context, args string, dState *dialog.DialogState) (dialog.CategoryCorrection, error) {
+	correction, err := dialog.ParseCategoryCorrection(args)
+	if err != nil {
+		return correction, err
+	}
+	err = f.Categories.Learn(ctx, dState.User.Id, category.Normalize(correction.Item), correction.Category.ID)
+	if err != nil {
+		logger.Error(ctx, "failed to learn a category", "err", err)
+		return correction, err
+	}
+	learned, err := f.Categories.Learned(ctx, dState.User.Id)
+	if err != nil {
+		logger.Warn(ctx, "failed to read learned categories", "err", err)
+		return correction, nil
+	}
+	pList := dState.PurchaseList
+	// only the items classified differently now are written, a concurrent change of the others is kept
+	categories := map[db.PurchaseItemHash]string{}
+	for _, item := range pList.ItemsDictionary {
+		if id := category.Classify(string(item.Name), learned); id != pList.Categories[item.Hash] {
+			categories[item.Hash] = id
+		}
+	}
+	err = f.Lists.SetCategories(ctx, pList.Id, categories)
+	if err != nil {
+		logger.Warn(ctx, "failed to reclassify a list", "err", err)
+	}
+
+	return correction, nil
+}
+
+// LearnedCategories of the user are read only when there are items to classify
+func (f *Handler) LearnedCategories(ctx context.Context, userID primitive.ObjectID, items int) map[string]string {
+	if !f.Config.Features.Categories || items == 0 {
+		return nil
+	}
+	learned, err := f.Categories.Learned(ctx, userID)
+	if err != nil {
+		logger.Warn(ctx, "failed to read learned categories", "err", err)
+	}
+	return learned
+}
+
+// ClassifyItem returns no category when categories are off, the item is classified if they are turned on later
+func (f *Handler) ClassifyItem(name string, learned map[string]string) string {
+	if !f.Config.Features.Categories {
+		return ""
+	}
+	return category.Classify(name, learned)
+}
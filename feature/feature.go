@@ -0,0 +1,198 @@
+// Package feature handles the commands and buttons of the optional features: categories and stores,
+// prices, templates, reminders, recipes, suggestions and the pantry.
+//
+// The bot reads an update and builds the dialog state, Handler changes the lists of the features
+// and renders the replies of their commands with the dialog messages.
+package feature
+
+import (
+	"context"
+	"fmt"
+	"github.com/boryashkin/purchaselist/cluster"
+	"github.com/boryashkin/purchaselist/config"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/dialog"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+)
+
+const (
+	// maxSuggestions items are offered under the message about a new list
+	maxSuggestions = 6
+	// settleScanLimit recent lists are looked through by /settle for one with payments
+	settleScanLimit = 10
+	// maxAlertLength is the limit of Telegram for the text of a callback alert
+	maxAlertLength = 200
+)
+
+// Services are the collections the features read and write
+type Services struct {
+	Users      *db.UserService
+	Sessions   *db.SessionService
+	Lists      *db.PurchaseListService
+	History    *db.HistoryService
+	Categories *db.CategoryService
+	Templates  *db.TemplateService
+	Jobs       *db.JobService
+	Recipes    *db.RecipeService
+	Pantry     *db.PantryService
+}
+
+type Handler struct {
+	Services
+	Config *config.Config
+	Bot    *tgbotapi.BotAPI
+	Sender *dialog.Sender
+	// Locker serializes the scheduled lists with the updates of their user
+	Locker cluster.Locker
+}
+
+func NewHandler(services Services, cfg *config.Config, api *tgbotapi.BotAPI, sender *dialog.Sender, locker cluster.Locker) *Handler {
+	return &Handler{
+		Services: services,
+		Config:   cfg,
+		Bot:      api,
+		Sender:   sender,
+		Locker:   locker,
+	}
+}
+
+// Command replaces the reply to a command of a feature, the reply to other messages is kept as is
+func (f *Handler) Command(ctx context.Context, c *dialog.MessageHandler, m *dialog.MessageDto, dState *dialog.DialogState, msg dialog.MessageForReply) dialog.MessageForReply {
+	switch {
+	case m.Command == dialog.ComCategory:
+		return c.GetMessageForCategory(f.correctCategory(ctx, m.Args, dState))
+	case m.Command == dialog.ComStore && m.Args == "":
+		return c.GetMessageForStores(dState.PurchaseList, dState.User.Stores)
+	case m.Command == dialog.ComStore:
+		return c.GetMessageForStoreSaved(f.saveStore(ctx, m.Args, dState))
+	case m.Command == dialog.ComBudget:
+		return c.GetMessageForBudget(f.setBudget(ctx, m.Args, dState))
+	case m.Command == dialog.ComSpent:
+		return c.GetMessageForSpent(f.spentReport(ctx, m.Args, dState.User))
+	case m.Command == dialog.ComSettle:
+		return c.GetMessageForSettle(f.settleList(ctx, dState))
+	case m.Command == dialog.ComTemplate && m.Args == "":
+		return c.GetMessageForTemplates(f.Templates.FindByUserID(ctx, dState.User.Id))
+	case m.Command == dialog.ComTemplate:
+		return c.GetMessageForTemplateSaved(f.saveTemplate(ctx, m.Args, *m.ChatMsgID.ChatID, dState))
+	case m.Command == dialog.ComSchedule:
+		return c.GetMessageForSchedule(f.scheduleTemplate(ctx, m.Args, dState))
+	case m.Command == dialog.ComTimezone:
+		return c.GetMessageForTimezone(f.setTimezone(ctx, m.Args, dState))
+	case m.Command == dialog.ComRecipe && m.Args == "":
+		return c.GetMessageForRecipes(f.Recipes.FindByUserID(ctx, dState.User.Id))
+	case m.Command == dialog.ComRecipe:
+		return c.GetMessageForRecipe(f.saveRecipe(ctx, m.Args, dState))
+	case m.Command == dialog.ComRemind:
+		return c.GetMessageForRemind(f.remindList(ctx, m.Args, dState))
+	case m.Command == dialog.ComPantry:
+		return c.GetMessageForPantry(f.pantryReport(ctx, m.Args, dState))
+	case m.Command == dialog.ComClear, m.Command == dialog.ComNew:
+		return f.AddSuggestions(ctx, msg, dState.User, dState.Session.PurchaseListId)
+	}
+
+	return msg
+}
+
+// Callback handles a press on a button of a feature, false is returned for other buttons.
+// The id is of the list, of the template or of the pantry item, depending on the button.
+func (f *Handler) Callback(ctx context.Context, query *tgbotapi.CallbackQuery, c *dialog.MessageHandler, id primitive.ObjectID, action string, cbAnswer *tgbotapi.CallbackConfig) (dialog.MessageForReply, bool) {
+	var msg dialog.MessageForReply
+	switch {
+	case strings.HasPrefix(action, dialog.CbStore):
+		metrics.BotCallback.With(prometheus.Labels{"action": "store"}).Inc()
+		msg = f.chooseStore(ctx, query, id, strings.TrimPrefix(action, dialog.CbStore), c, cbAnswer)
+	case action == dialog.CbTemplate:
+		metrics.BotCallback.With(prometheus.Labels{"action": "template"}).Inc()
+		msg = f.templateCallback(ctx, query, id, c, cbAnswer)
+	case action == dialog.CbSettle:
+		metrics.BotCallback.With(prometheus.Labels{"action": "settle"}).Inc()
+		msg = f.settleCallback(ctx, query, id, c, cbAnswer)
+	case strings.HasPrefix(action, dialog.CbSnooze):
+		metrics.BotCallback.With(prometheus.Labels{"action": "snooze"}).Inc()
+		msg = f.snoozeCallback(ctx, query, id, strings.TrimPrefix(action, dialog.CbSnooze), c, cbAnswer)
+	case action == dialog.CbRemindOff:
+		metrics.BotCallback.With(prometheus.Labels{"action": "remind_off"}).Inc()
+		msg = f.snoozeCallback(ctx, query, id, "", c, cbAnswer)
+	case action == dialog.CbUsedUp:
+		metrics.BotCallback.With(prometheus.Labels{"action": "used_up"}).Inc()
+		msg = f.usedUpCallback(ctx, query, id, c, cbAnswer)
+	default:
+		return msg, false
+	}
+
+	return msg, true
+}
+
+// CrossedOut records an item crossed out just now in the history and the pantry of the list owner
+func (f *Handler) CrossedOut(ctx context.Context, pList *db.PurchaseList, hash db.PurchaseItemHash) {
+	if f.Config.Features.Suggestions {
+		f.recordPurchase(ctx, pList, hash)
+	}
+	if f.Config.Features.Pantry {
+		f.stockPantry(ctx, pList, hash)
+	}
+}
+
+// AddSuggestions puts the items bought before and the items low at home under the message about a new list
+func (f *Handler) AddSuggestions(ctx context.Context, msg dialog.MessageForReply, user *db.User, listID primitive.ObjectID) dialog.MessageForReply {
+	suggested := f.SuggestItems(ctx, user.Id, "", nil, maxSuggestions)
+	msg = dialog.AddSuggestions(msg, listID, suggested)
+
+	return dialog.AddRestock(msg, f.restockItems(ctx, user, suggested))
+}
+
+// Session is the session of the user, it is created on the first message
+func (f *Handler) Session(ctx context.Context, user *db.User) (*db.Session, error) {
+	// the session is usually cached, so it is read before trying to create it
+	session, err := f.Sessions.FindByUserID(ctx, user.Id)
+	if err == nil {
+		return &session, nil
+	}
+	session = db.Session{
+		UserId:         user.Id,
+		PostingState:   db.SessPStateNew,
+		PurchaseListId: primitive.NilObjectID,
+		CreatedAt:      primitive.NewDateTimeFromTime(time.Now()),
+	}
+	err = f.Sessions.Create(ctx, &session)
+	if err != nil {
+		logger.Debug(ctx, "session exists", "err", err)
+		session, err = f.Sessions.FindByUserID(ctx, user.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &session, err
+}
+
+// ListState is the list with the session and the user of its owner
+func (f *Handler) ListState(ctx context.Context, listID primitive.ObjectID) (*db.PurchaseList, *db.Session, *db.User, error) {
+	var pList db.PurchaseList
+	var user db.User
+	pList, err := f.Lists.FindByID(ctx, listID)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to find a purchaseList %s: %w", listID.Hex(), err)
+	}
+	user, err = f.Users.FindByID(ctx, pList.UserID)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to find a user: %w", err)
+	}
+	session, err := f.Session(ctx, &user)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to find a session: %w", err)
+	}
+
+	return &pList, session, &user, err
+}
+
+func (f *Handler) sanitizeItem(text string) string {
+	return dialog.SanitizeItem(text, f.Config.List.ItemMaxLength)
+}
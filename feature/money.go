@@ -0,0 +1,168 @@
+package feature
+
+import (
+	"context"
+	"github.com/boryashkin/purchaselist/category"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/dialog"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/price"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// SplitPrice cuts the price off an item when prices are on
+func (f *Handler) SplitPrice(item string) (string, int64, bool) {
+	if !f.Config.Features.Prices {
+		return item, 0, false
+	}
+	return price.Split(item)
+}
+
+// PromptPrice asks the owner who crossed out an item without a price how much it cost,
+// only lists with prices or a budget ask
+func (f *Handler) PromptPrice(ctx context.Context, query *tgbotapi.CallbackQuery, pList *db.PurchaseList, hash db.PurchaseItemHash, c *dialog.MessageHandler) {
+	if !f.Config.Features.Prices || query.Message == nil || (len(pList.Prices) == 0 && pList.Budget == 0) {
+		return
+	}
+	if _, found := pList.Prices[hash]; found {
+		return
+	}
+	_, session, user, err := f.ListState(ctx, pList.Id)
+	if err != nil || user.TgId != query.From.ID {
+		return
+	}
+	for _, item := range pList.ItemsDictionary {
+		if item.Hash != hash {
+			continue
+		}
+		session.PricePrompt = &db.PricePrompt{ListID: pList.Id, Hash: hash}
+		err = f.Sessions.UpdateSession(ctx, session)
+		if err != nil {
+			logger.Warn(ctx, "failed to save a price prompt", "err", err)
+			return
+		}
+		chatID := query.Message.Chat.ID
+		f.Sender.Reply(ctx, f.Bot, dialog.ChatMessageID{ChatID: &chatID}, c.GetPricePrompt(item.Name))
+		return
+	}
+}
+
+// setBudget of the current list from "/budget 3000", without an amount the budget is only shown
+func (f *Handler) setBudget(ctx context.Context, args string, dState *dialog.DialogState) (*db.PurchaseList, bool, error) {
+	if args == "" {
+		return dState.PurchaseList, false, nil
+	}
+	amount, ok := price.Parse(args)
+	if !ok {
+		return dState.PurchaseList, false, dialog.ErrBudgetAmount
+	}
+	err := f.Lists.SetBudget(ctx, dState.PurchaseList.Id, amount)
+	if err != nil {
+		logger.Error(ctx, "failed to save a budget", "err", err)
+		return dState.PurchaseList, false, err
+	}
+	dState.PurchaseList.Budget = amount
+
+	return dState.PurchaseList, true, nil
+}
+
+// settleList is the current list when something in it is paid for, otherwise the latest list of the user with payments
+func (f *Handler) settleList(ctx context.Context, dState *dialog.DialogState) (*db.PurchaseList, *db.User) {
+	if len(dState.PurchaseList.Paid()) > 0 {
+		return dState.PurchaseList, f.listOwner(ctx, dState.PurchaseList, dState.User)
+	}
+	pLists, err := f.Lists.FindByUserID(ctx, dState.User.Id, settleScanLimit)
+	if err != nil {
+		logger.Error(ctx, "failed to find lists to settle", "err", err)
+		return dState.PurchaseList, f.listOwner(ctx, dState.PurchaseList, dState.User)
+	}
+	for i := range pLists {
+		if len(pLists[i].Paid()) > 0 {
+			return &pLists[i], dState.User
+		}
+	}
+	return dState.PurchaseList, f.listOwner(ctx, dState.PurchaseList, dState.User)
+}
+
+// listOwner is the user unless the current list of the user belongs to someone else
+func (f *Handler) listOwner(ctx context.Context, pList *db.PurchaseList, user *db.User) *db.User {
+	if pList.UserID == user.Id {
+		return user
+	}
+	owner, err := f.Users.FindByID(ctx, pList.UserID)
+	if err != nil {
+		logger.Warn(ctx, "failed to find the list owner", "err", err)
+		return user
+	}
+	return &owner
+}
+
+// settleCallback sends who owes whom to the chat of the list, an inline message has no chat of the bot, so an alert is shown there
+func (f *Handler) settleCallback(ctx context.Context, query *tgbotapi.CallbackQuery, listID primitive.ObjectID, c *dialog.MessageHandler, cbAnswer *tgbotapi.CallbackConfig) dialog.MessageForReply {
+	pList, _, owner, err := f.ListState(ctx, listID)
+	if err != nil {
+		cbAnswer.Text = dialog.ErrorText(err, "Список не найден")
+		logger.Warn(ctx, "settle failed", "err", err)
+		return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: cbAnswer}
+	}
+	msg := c.GetMessageForSettle(pList, owner)
+	if query.Message == nil {
+		cbAnswer.Text = msg.Text
+		if text := []rune(msg.Text); len(text) > maxAlertLength {
+			cbAnswer.Text = string(text[:maxAlertLength-1]) + "…"
+		}
+		cbAnswer.ShowAlert = true
+		return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: cbAnswer}
+	}
+	msg.AnswerCallback = cbAnswer
+
+	return msg
+}
+
+// spentReport sums the prices of the items the user bought within the range from "/spent", the dates are
+// of the timezone of the user. The items of the own lists are counted unless another member paid for them,
+// in the lists of others only the items the user paid for are.
+func (f *Handler) spentReport(ctx context.Context, args string, user *db.User) (dialog.SpentReport, error) {
+	from, to, err := dialog.ParseSpentRange(args, time.Now().In(f.UserLocation(user)))
+	report := dialog.SpentReport{From: from, To: to, ByCategory: map[string]int64{}}
+	if err != nil {
+		return report, err
+	}
+	pLists, err := f.Lists.FindWithPurchasesSince(ctx, user.Id, user.TgId, from)
+	if err != nil {
+		logger.Error(ctx, "failed to find lists with purchases", "err", err)
+		return report, err
+	}
+	for _, pList := range pLists {
+		names := map[db.PurchaseItemHash]db.PurchaseItemName{}
+		for _, item := range pList.ItemsDictionary {
+			names[item.Hash] = item.Name
+		}
+		counted := false
+		for _, hash := range pList.DeletedItemHashes {
+			amount, priced := pList.Prices[hash]
+			at := pList.BoughtAt[hash].Time()
+			if !priced || at.Before(from) || !at.Before(to) {
+				continue
+			}
+			if payer, found := pList.PaidBy[hash]; found && payer != user.TgId || !found && pList.UserID != user.Id {
+				continue
+			}
+			id, found := pList.Categories[hash]
+			if !found {
+				id = category.Classify(string(names[hash]), nil)
+			}
+			report.Total += amount
+			report.Items++
+			report.ByCategory[id] += amount
+			counted = true
+		}
+		if counted {
+			report.Lists++
+		}
+	}
+
+	return report, nil
+}
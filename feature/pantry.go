@@ -0,0 +1,166 @@
+package feature
+
+import (
+	"context"
+	"fmt"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/dialog"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/boryashkin/purchaselist/recipe"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"math"
+	"strings"
+)
+
+// pantryReport shows the pantry of the user from "/pantry", turns it on or off, or changes an item of it
+func (f *Handler) pantryReport(ctx context.Context, args string, dState *dialog.DialogState) (dialog.PantryReport, error) {
+	action, ingredient, err := dialog.ParsePantryArgs(args)
+	ingredient.Name = f.sanitizeItem(ingredient.Name)
+	report := dialog.PantryReport{Enabled: dState.User.Pantry, Action: action, Item: db.PantryItem{Name: ingredient.Name}}
+	if err != nil {
+		return report, err
+	}
+	switch action {
+	case "":
+		report.Items, err = f.Pantry.FindByUserID(ctx, dState.User.Id)
+		return report, err
+	case dialog.PantryOnWord, dialog.PantryOffWord:
+		report.Enabled = action == dialog.PantryOnWord
+		return report, f.Users.SetPantry(ctx, dState.User.Id, report.Enabled)
+	}
+	item, err := f.Pantry.FindByName(ctx, dState.User.Id, ingredient.Name)
+	if err == mongo.ErrNoDocuments && action != dialog.PantryMinWord {
+		return report, dialog.ErrUnknownPantryItem
+	} else if err != nil && err != mongo.ErrNoDocuments {
+		return report, err
+	} else if err == nil {
+		report.Item = item
+		if ingredient.Amount > 0 && item.Unit != ingredient.Unit {
+			return report, dialog.ErrPantryUnit
+		}
+	}
+	switch action {
+	case dialog.PantryRemoveWord:
+		err = f.Pantry.Delete(ctx, item.Id)
+	case dialog.PantryMinWord:
+		report.Item, err = f.Pantry.SetThreshold(ctx, dState.User.Id, ingredient)
+	case dialog.PantryUseWord:
+		// no amount takes all of it
+		report.Item.Amount = 0
+		if ingredient.Amount > 0 {
+			report.Item.Amount = math.Max(0, item.Amount-ingredient.Amount)
+		}
+		err = f.Pantry.SetAmount(ctx, item.Id, report.Item.Amount)
+	}
+	if err != nil {
+		logger.Error(ctx, "failed to update the pantry", "err", err)
+	}
+
+	return report, err
+}
+
+// stockPantry adds a crossed out item to the pantry of the list owner who keeps one, an item without an amount is a piece
+func (f *Handler) stockPantry(ctx context.Context, pList *db.PurchaseList, hash db.PurchaseItemHash) {
+	owner, err := f.Users.FindByID(ctx, pList.UserID)
+	if err != nil {
+		logger.Warn(ctx, "failed to find the list owner", "err", err)
+		return
+	}
+	if !owner.Pantry {
+		return
+	}
+	for _, item := range pList.ItemsDictionary {
+		if item.Hash != hash {
+			continue
+		}
+		ingredient, ok := recipe.ParseIngredient(string(item.Name))
+		if !ok {
+			return
+		}
+		if ingredient.Amount == 0 {
+			ingredient.Amount, ingredient.Unit = 1, "шт"
+		}
+		err = f.Pantry.Stock(ctx, owner.Id, ingredient)
+		if err != nil {
+			logger.Warn(ctx, "failed to stock the pantry", "err", err)
+		}
+		return
+	}
+}
+
+// restockItems are the items which are low at home and are not suggested already
+func (f *Handler) restockItems(ctx context.Context, user *db.User, suggested []db.HistoryItem) []db.PantryItem {
+	if !f.Config.Features.Pantry || !user.Pantry {
+		return nil
+	}
+	items, err := f.Pantry.FindByUserID(ctx, user.Id)
+	if err != nil {
+		logger.Warn(ctx, "failed to load the pantry", "err", err)
+		return nil
+	}
+	low := []db.PantryItem{}
+	for _, item := range items {
+		isSuggested := false
+		for _, s := range suggested {
+			isSuggested = isSuggested || strings.EqualFold(string(s.Name), item.Name)
+		}
+		if item.Low() && !isSuggested && len(low) < maxSuggestions {
+			low = append(low, item)
+		}
+	}
+	return low
+}
+
+// usedUpCallback marks a pantry item used up and offers to add it to the list
+func (f *Handler) usedUpCallback(ctx context.Context, query *tgbotapi.CallbackQuery, itemID primitive.ObjectID, c *dialog.MessageHandler, cbAnswer *tgbotapi.CallbackConfig) dialog.MessageForReply {
+	item, err := f.findOwnPantryItem(ctx, query.From, itemID)
+	if err == nil {
+		item.Amount = 0
+		err = f.Pantry.SetAmount(ctx, item.Id, item.Amount)
+	}
+	if err != nil {
+		cbAnswer.Text = dialog.ErrorText(err, "Товар не найден, посмотрите /pantry")
+		logger.Warn(ctx, "used up failed", "err", err)
+		return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: cbAnswer}
+	}
+	msg := c.GetMessageForUsedUp(item)
+	msg.AnswerCallback = cbAnswer
+
+	return msg
+}
+
+// readRestockCallback turns a press on an item which is low at home into a message with its name
+func (f *Handler) readRestockCallback(ctx context.Context, query *tgbotapi.CallbackQuery, chatMsgID dialog.ChatMessageID) (dialog.MessageDto, error) {
+	metrics.BotCallback.With(prometheus.Labels{"action": "restock"}).Inc()
+	itemID, err := primitive.ObjectIDFromHex(query.Data[:24])
+	if err != nil {
+		return dialog.MessageDto{}, fmt.Errorf("failed to read a pantry item id: %w", err)
+	}
+	item, err := f.findOwnPantryItem(ctx, query.From, itemID)
+	if err != nil {
+		return dialog.MessageDto{}, err
+	}
+
+	return dialog.MessageDto{ChatMsgID: chatMsgID, TgUser: query.From, Text: item.Name}, nil
+}
+
+// findOwnPantryItem loads the pantry item of a button, only the one whose pantry it is may press it
+func (f *Handler) findOwnPantryItem(ctx context.Context, tgUser *tgbotapi.User, itemID primitive.ObjectID) (db.PantryItem, error) {
+	user, err := f.Users.FindByTgID(ctx, tgUser.ID)
+	if err != nil {
+		return db.PantryItem{}, fmt.Errorf("failed to find a user: %w", err)
+	}
+	item, err := f.Pantry.FindByID(ctx, itemID)
+	if err != nil {
+		return db.PantryItem{}, fmt.Errorf("failed to find a pantry item: %w", err)
+	}
+	if item.UserID != user.Id {
+		return db.PantryItem{}, dialog.ErrNotYours
+	}
+
+	return item, nil
+}
@@ -0,0 +1,193 @@
+package feature
+
+import (
+	"context"
+	"fmt"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/dialog"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/recipe"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+)
+
+// saveRecipe saves the recipe from "/recipe Борщ на 4" with the ingredients on the next lines,
+// shows it when there are no ingredients or removes it
+func (f *Handler) saveRecipe(ctx context.Context, args string, dState *dialog.DialogState) (db.Recipe, bool, error) {
+	r, remove, err := dialog.ParseRecipeArgs(args)
+	r.UserID = dState.User.Id
+	if err != nil {
+		return r, remove, err
+	}
+	if remove || len(r.Ingredients) == 0 {
+		found, err := f.Recipes.FindByName(ctx, dState.User.Id, r.Name)
+		if err == mongo.ErrNoDocuments {
+			return r, remove, dialog.ErrUnknownRecipe
+		} else if err != nil || !remove {
+			return found, false, err
+		}
+		return found, true, f.Recipes.Delete(ctx, found.Id)
+	}
+	for i := range r.Ingredients {
+		r.Ingredients[i].Name = f.sanitizeItem(r.Ingredients[i].Name)
+	}
+	recipes, err := f.Recipes.FindByUserID(ctx, dState.User.Id)
+	if err != nil {
+		return r, false, err
+	}
+	replaced := false
+	for _, saved := range recipes {
+		replaced = replaced || strings.EqualFold(saved.Name, r.Name)
+	}
+	if !replaced && len(recipes) >= dialog.MaxRecipes {
+		return r, false, dialog.ErrTooManyRecipes
+	}
+	err = f.Recipes.Save(ctx, &r)
+	if err != nil {
+		logger.Error(ctx, "failed to save a recipe", "err", err)
+	}
+
+	return r, false, err
+}
+
+// ListFromText splits the text into items and the ingredients of the recipes of the user named in it
+func (f *Handler) ListFromText(ctx context.Context, userID primitive.ObjectID, text string) ([]string, []recipe.Ingredient) {
+	return f.createListFromText(text, f.userRecipes(ctx, userID, text))
+}
+
+// createListFromText splits the text into items, a line like "борщ на 6" is replaced with the ingredients
+// of the recipe for 6 servings, the same ingredients of several recipes add up
+func (f *Handler) createListFromText(text string, recipes []db.Recipe) ([]string, []recipe.Ingredient) {
+	var list []string
+	var ingredients []recipe.Ingredient
+	for _, line := range strings.Split(text, "\n") {
+		r, servings, found := findRecipe(line, recipes)
+		if !found {
+			list = append(list, line)
+			continue
+		}
+		for _, ingredient := range r.Ingredients {
+			ingredients = addIngredient(ingredients, ingredient.Scale(float64(servings)))
+		}
+	}
+	if len(ingredients) > f.Config.List.MaxItems {
+		ingredients = ingredients[:f.Config.List.MaxItems]
+	}
+	return dialog.UniqueItems(list, f.Config.List.MaxItems), ingredients
+}
+
+func findRecipe(line string, recipes []db.Recipe) (db.Recipe, int, bool) {
+	name, servings, ok := recipe.Cut(line)
+	if !ok {
+		return db.Recipe{}, 0, false
+	}
+	for _, r := range recipes {
+		if strings.EqualFold(r.Name, name) {
+			return r, servings, true
+		}
+	}
+	return db.Recipe{}, 0, false
+}
+
+// addIngredient adds the amount to the same ingredient or appends it
+func addIngredient(ingredients []recipe.Ingredient, ingredient recipe.Ingredient) []recipe.Ingredient {
+	for i := range ingredients {
+		if sum, ok := recipe.Add(ingredients[i], ingredient); ok {
+			ingredients[i] = sum
+			return ingredients
+		}
+	}
+	return append(ingredients, ingredient)
+}
+
+// userRecipes are loaded only when the text has a line like "борщ на 6"
+func (f *Handler) userRecipes(ctx context.Context, userID primitive.ObjectID, text string) []db.Recipe {
+	if !f.Config.Features.Recipes {
+		return nil
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if _, _, ok := recipe.Cut(line); !ok {
+			continue
+		}
+		recipes, err := f.Recipes.FindByUserID(ctx, userID)
+		if err != nil {
+			logger.Warn(ctx, "failed to read recipes", "err", err)
+		}
+		return recipes
+	}
+	return nil
+}
+
+// MergeIngredients adds the ingredients to the list, an ingredient already in the list is replaced
+// with an item of the summed amount: "лук 150 г" and "лук 100 г" make "лук 250 г".
+// Every item is changed on its own, so items crossed out meanwhile stay crossed out.
+func (f *Handler) MergeIngredients(ctx context.Context, listID primitive.ObjectID, ingredients []recipe.Ingredient, learned map[string]string) error {
+	pList, err := f.Lists.FindByID(ctx, listID)
+	if err != nil {
+		return fmt.Errorf("failed to find a purchaseList: %w", err)
+	}
+	dic := map[db.PurchaseItemHash]db.PurchaseItemName{}
+	for _, item := range pList.ItemsDictionary {
+		dic[item.Hash] = item.Name
+	}
+	count := len(pList.Items)
+	for _, ingredient := range ingredients {
+		old, sum, found := findIngredient(pList.Items, dic, ingredient)
+		name := f.sanitizeItem(sum.String())
+		hash := db.PurchaseItemHash(db.GetMD5Hash(name))
+		if found && hash == old {
+			// an ingredient without an amount is in the list already
+			continue
+		}
+		if found && !inItems(pList.Items, hash) {
+			category := pList.Categories[old]
+			if category == "" {
+				category = f.ClassifyItem(name, learned)
+			}
+			replaced, err := f.Lists.ReplaceItem(ctx, listID, old, db.PurchaseItemName(name), category)
+			if err != nil {
+				return err
+			}
+			if replaced {
+				continue
+			}
+			// the item was crossed out meanwhile, the ingredient is added on its own
+		}
+		if count >= f.Config.List.MaxItems {
+			logger.Warn(ctx, "the list is full, an ingredient is skipped", "max_items", f.Config.List.MaxItems)
+			continue
+		}
+		name = f.sanitizeItem(ingredient.String())
+		err = f.Lists.AddItemToPurchaseList(ctx, listID, db.PurchaseItemName(name), f.ClassifyItem(name, learned))
+		if err != nil {
+			return err
+		}
+		count++
+	}
+	return nil
+}
+
+// findIngredient is an active item of the same ingredient with the summed amount, the items which aren't
+// read as an ingredient are skipped
+func findIngredient(items []db.PurchaseItemHash, dic map[db.PurchaseItemHash]db.PurchaseItemName, ingredient recipe.Ingredient) (db.PurchaseItemHash, recipe.Ingredient, bool) {
+	for _, hash := range items {
+		existing, ok := recipe.ParseIngredient(string(dic[hash]))
+		if !ok {
+			continue
+		}
+		if sum, ok := recipe.Add(existing, ingredient); ok {
+			return hash, sum, true
+		}
+	}
+	return "", ingredient, false
+}
+
+func inItems(items []db.PurchaseItemHash, hash db.PurchaseItemHash) bool {
+	for _, item := range items {
+		if item == hash {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package feature
+
+import (
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/recipe"
+	"testing"
+)
+
+func TestAddIngredient(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string
+	}{
+		{
+			name:  "the same ingredient of two recipes adds up",
+			lines: []string{"лук 150 г", "морковь 2", "лук 1 кг"},
+			want:  []string{"лук 1,15 кг", "морковь 2 шт"},
+		},
+		{
+			name:  "different units stay apart",
+			lines: []string{"лук 150 г", "лук 2"},
+			want:  []string{"лук 150 г", "лук 2 шт"},
+		},
+		{
+			name:  "an ingredient without an amount is added once",
+			lines: []string{"соль", "Соль"},
+			want:  []string{"соль"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ingredients []recipe.Ingredient
+			for _, line := range tt.lines {
+				ingredient, _ := recipe.ParseIngredient(line)
+				ingredients = addIngredient(ingredients, ingredient)
+			}
+			if len(ingredients) != len(tt.want) {
+				t.Fatalf("addIngredient() = %v, want %v", ingredients, tt.want)
+			}
+			for i, ingredient := range ingredients {
+				if ingredient.String() != tt.want[i] {
+					t.Errorf("addIngredient() = %v, want %v", ingredients, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFindIngredient(t *testing.T) {
+	onion, eggs, salt := db.PurchaseItemHash("onion"), db.PurchaseItemHash("eggs"), db.PurchaseItemHash("salt")
+	dic := map[db.PurchaseItemHash]db.PurchaseItemName{onion: "лук 150 г", eggs: "яйца 2", salt: "соль"}
+	items := []db.PurchaseItemHash{onion, eggs, salt}
+	tests := []struct {
+		name       string
+		items      []db.PurchaseItemHash
+		ingredient string
+		hash       db.PurchaseItemHash
+		sum        string
+		found      bool
+	}{
+		{name: "summed with the item", items: items, ingredient: "лук 1 кг", hash: onion, sum: "лук 1,15 кг", found: true},
+		{name: "pieces", items: items, ingredient: "Яйца 3", hash: eggs, sum: "яйца 5 шт", found: true},
+		{name: "without an amount", items: items, ingredient: "соль", hash: salt, sum: "соль", found: true},
+		{name: "another unit", items: items, ingredient: "лук 2", sum: "лук 2 шт"},
+		{name: "a crossed out item is not summed", items: []db.PurchaseItemHash{eggs}, ingredient: "лук 1 кг", sum: "лук 1 кг"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ingredient, _ := recipe.ParseIngredient(tt.ingredient)
+			hash, sum, found := findIngredient(tt.items, dic, ingredient)
+			if hash != tt.hash || sum.String() != tt.sum || found != tt.found {
+				t.Errorf("findIngredient(%q) = %q, %q, %v, want %q, %q, %v", tt.ingredient, hash, sum, found, tt.hash, tt.sum, tt.found)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package feature
+
+import (
+	"context"
+	"errors"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/dialog"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/schedule"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+	"time"
+)
+
+// remindList sets the due time of the current list from "/remind 18:30", "/remind выкл" removes it,
+// without a time the current one is only shown
+func (f *Handler) remindList(ctx context.Context, args string, dState *dialog.DialogState) (*db.PurchaseList, *time.Location, bool, error) {
+	pList := dState.PurchaseList
+	loc := f.UserLocation(dState.User)
+	if args == "" {
+		return pList, loc, false, nil
+	}
+	var due time.Time
+	if !strings.EqualFold(strings.TrimSpace(args), dialog.RemindOffWord) {
+		if len(pList.Items) == 0 {
+			return pList, loc, false, dialog.ErrEmptyReminder
+		}
+		var err error
+		due, err = schedule.ParseDue(args, time.Now().In(loc))
+		if err != nil {
+			return pList, loc, false, dialog.ErrRemindUsage
+		}
+	}
+	err := f.remindAt(ctx, pList.Id, due)
+	if err != nil {
+		logger.Error(ctx, "failed to save a reminder", "err", err)
+		return pList, loc, false, err
+	}
+	pList.DueAt = 0
+	if !due.IsZero() {
+		pList.DueAt = primitive.NewDateTimeFromTime(due)
+	}
+
+	return pList, loc, true, nil
+}
+
+// remindAt sets the due time of the list and moves its reminder to it, a zero time removes both
+func (f *Handler) remindAt(ctx context.Context, listID primitive.ObjectID, due time.Time) error {
+	err := f.Lists.SetDue(ctx, listID, due)
+	if err != nil {
+		return err
+	}
+	if due.IsZero() {
+		return f.Jobs.Cancel(ctx, db.JobReminder, listID)
+	}
+	return f.Jobs.Schedule(ctx, db.JobReminder, listID, due)
+}
+
+// SplitDue cuts a due time like "к субботе" off an item when reminders are on
+func (f *Handler) SplitDue(item string, loc *time.Location) (string, time.Time, bool) {
+	if !f.Config.Features.Reminders {
+		return item, time.Time{}, false
+	}
+	return schedule.CutDue(item, time.Now().In(loc))
+}
+
+// DueItem keeps the due time of the item, the list is reminded of at the earliest due time
+func (f *Handler) DueItem(ctx context.Context, pList *db.PurchaseList, hash db.PurchaseItemHash, due time.Time) error {
+	err := f.Lists.SetItemDue(ctx, pList.Id, hash, due)
+	if err != nil || (pList.DueAt != 0 && pList.DueAt.Time().Before(due)) {
+		return err
+	}
+	err = f.remindAt(ctx, pList.Id, due)
+	if err == nil {
+		pList.DueAt = primitive.NewDateTimeFromTime(due)
+	}
+	return err
+}
+
+// snoozeCallback moves the reminder of the list by the chosen time or turns it off on an empty choice,
+// the list is shown without the snooze buttons then
+func (f *Handler) snoozeCallback(ctx context.Context, query *tgbotapi.CallbackQuery, listID primitive.ObjectID, choice string, c *dialog.MessageHandler, cbAnswer *tgbotapi.CallbackConfig) dialog.MessageForReply {
+	pList, _, user, err := f.ListState(ctx, listID)
+	if err == nil && !IsListMember(pList, user, query.From.ID) {
+		err = dialog.ErrNotYours
+	}
+	if err != nil {
+		cbAnswer.Text = dialog.ErrorText(err, "Список не найден")
+		logger.Warn(ctx, "snooze failed", "err", err)
+		return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: cbAnswer}
+	}
+	loc := f.UserLocation(user)
+	var due time.Time
+	if choice != "" {
+		var ok bool
+		due, ok = dialog.ParseSnooze(choice, time.Now().In(loc))
+		if !ok {
+			cbAnswer.Text = "Ошибка, попробуйте ещё раз"
+			return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: cbAnswer}
+		}
+	}
+	err = f.remindAt(ctx, listID, due)
+	if err != nil {
+		cbAnswer.Text = dialog.ErrorText(err, "Ошибка, попробуйте ещё раз")
+		logger.Error(ctx, "snooze failed", "err", err)
+		return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: cbAnswer}
+	}
+	cbAnswer.Text = "Напоминание отключено"
+	if !due.IsZero() {
+		cbAnswer.Text = "Напомню " + due.Format("02.01 в 15:04")
+	}
+	msg := c.GetMessageForReply(&dialog.MessageDto{UnknownContent: true}, nil, nil, pList)
+	msg.AnswerCallback = cbAnswer
+
+	return msg
+}
+
+// RunReminderJob sends the list back to its owner and members when it is due,
+// an unfinished list is sent again until the reminders run out
+func (f *Handler) RunReminderJob(ctx context.Context, job db.Job) (time.Time, error) {
+	pList, err := f.Lists.FindByID(ctx, job.RefID)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	if pList.DueAt == 0 || len(pList.Items) == 0 {
+		return time.Time{}, nil
+	}
+	user, err := f.Users.FindByID(ctx, pList.UserID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	c := dialog.NewMessageHandler(f.Bot, f.Lists, f.Config)
+	msg := c.GetMessageForReminder(&pList, f.UserLocation(&user))
+	sent := 0
+	for _, tgID := range reminderRecipients(&pList, &user) {
+		// the private chat with a user has the id of the user
+		chatID := int64(tgID)
+		reminder, err := f.Sender.Reply(ctx, f.Bot, dialog.ChatMessageID{ChatID: &chatID}, msg)
+		if err != nil {
+			logger.Warn(ctx, "failed to send a reminder", "tg_user", tgID, "err", err)
+			continue
+		}
+		sent++
+		if reminder.Chat != nil {
+			f.Lists.AddMsgID(ctx, pList.Id, db.TgMsgID{TgChatID: reminder.Chat.ID, TgMessageID: reminder.MessageID})
+		}
+	}
+	if sent == 0 {
+		return time.Time{}, errors.New("no member got the reminder")
+	}
+	err = f.Lists.IncReminded(ctx, pList.Id)
+	if err != nil {
+		logger.Warn(ctx, "failed to count a reminder", "err", err)
+	}
+	if pList.Reminded+1 >= f.Config.Schedule.ReminderTimes {
+		return time.Time{}, nil
+	}
+
+	return time.Now().Add(f.Config.Schedule.ReminderRepeat.Duration), nil
+}
+
+// reminderRecipients are the owner of the list and the members who crossed out its items
+func reminderRecipients(pList *db.PurchaseList, owner *db.User) []int {
+	recipients := []int{owner.TgId}
+	seen := map[int]bool{owner.TgId: true}
+	for _, member := range pList.Members {
+		if !seen[member.TgID] {
+			seen[member.TgID] = true
+			recipients = append(recipients, member.TgID)
+		}
+	}
+	return recipients
+}
+
+// IsListMember tells whether the one who pressed a button of the list is its owner or one of its members
+func IsListMember(pList *db.PurchaseList, owner *db.User, tgID int) bool {
+	for _, member := range reminderRecipients(pList, owner) {
+		if member == tgID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package feature
+
+import (
+	"context"
+	"fmt"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/dialog"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+)
+
+// recordPurchase counts a crossed out item in the history of the list owner
+func (f *Handler) recordPurchase(ctx context.Context, pList *db.PurchaseList, hash db.PurchaseItemHash) {
+	for _, item := range pList.ItemsDictionary {
+		if item.Hash != hash {
+			continue
+		}
+		err := f.History.Record(ctx, pList.UserID, []db.PurchaseItem{item}, time.Now())
+		if err != nil {
+			logger.Warn(ctx, "failed to record a purchase", "err", err)
+		}
+		return
+	}
+}
+
+// SuggestItems are the items the user bought before and which are not in the list yet
+func (f *Handler) SuggestItems(ctx context.Context, userID primitive.ObjectID, query string, pList *db.PurchaseList, limit int) []db.HistoryItem {
+	if !f.Config.Features.Suggestions {
+		return nil
+	}
+	exclude := map[db.PurchaseItemHash]bool{}
+	if pList != nil {
+		for _, hash := range pList.Items {
+			exclude[hash] = true
+		}
+	}
+	items, err := f.History.Suggest(ctx, userID, query, exclude, limit)
+	if err != nil {
+		logger.Warn(ctx, "failed to suggest items", "err", err)
+	}
+	return items
+}
+
+// IsSuggestionCallback tells a press on a suggested item or on an item to restock from the pantry,
+// both of them are added to the list as a message
+func IsSuggestionCallback(query *tgbotapi.CallbackQuery) bool {
+	return query.Message != nil && len(query.Data) > 25 &&
+		(strings.HasPrefix(query.Data[25:], dialog.CbSuggest) || query.Data[25:] == dialog.CbRestock)
+}
+
+// ReadSuggestionCallback turns a press on a suggested item into a message with its name,
+// only the members of the list under which it was suggested may press it
+func (f *Handler) ReadSuggestionCallback(ctx context.Context, query *tgbotapi.CallbackQuery, chatMsgID dialog.ChatMessageID) (dialog.MessageDto, error) {
+	if query.Data[25:] == dialog.CbRestock {
+		return f.readRestockCallback(ctx, query, chatMsgID)
+	}
+	metrics.BotCallback.With(prometheus.Labels{"action": "suggestion"}).Inc()
+	hash := db.PurchaseItemHash(strings.TrimPrefix(query.Data[25:], dialog.CbSuggest))
+	listID, err := primitive.ObjectIDFromHex(query.Data[:24])
+	if err != nil {
+		return dialog.MessageDto{}, fmt.Errorf("failed to read a list id: %w", err)
+	}
+	pList, _, owner, err := f.ListState(ctx, listID)
+	if err != nil {
+		return dialog.MessageDto{}, err
+	}
+	if !IsListMember(pList, owner, query.From.ID) {
+		return dialog.MessageDto{}, dialog.ErrNotYours
+	}
+	// the items are suggested from the history of the list owner
+	item, err := f.History.Find(ctx, owner.Id, hash)
+	if err != nil {
+		return dialog.MessageDto{}, fmt.Errorf("failed to find a suggested item: %w", err)
+	}
+
+	return dialog.MessageDto{ChatMsgID: chatMsgID, TgUser: query.From, Text: string(item.Name)}, nil
+}
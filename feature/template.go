@@ -0,0 +1,244 @@
+package feature
+
+import (
+	"context"
+	"fmt"
+	"github.com/boryashkin/purchaselist/cluster"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/dialog"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/schedule"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// saveTemplate saves the current list as a template of the user or removes the template
+func (f *Handler) saveTemplate(ctx context.Context, args string, chatID int64, dState *dialog.DialogState) (db.Template, bool, error) {
+	name, remove, err := dialog.ParseTemplateArgs(args)
+	tpl := db.Template{UserID: dState.User.Id, Name: name, ChatID: chatID}
+	if err != nil {
+		return tpl, remove, err
+	}
+	if remove {
+		found, err := f.Templates.FindByName(ctx, dState.User.Id, name)
+		if err == mongo.ErrNoDocuments {
+			return tpl, true, dialog.ErrUnknownTemplate
+		} else if err != nil {
+			return tpl, true, err
+		}
+		err = f.Jobs.Cancel(ctx, db.JobTemplate, found.Id)
+		if err == nil {
+			err = f.Templates.Delete(ctx, found.Id)
+		}
+		return found, true, err
+	}
+	pList := dState.PurchaseList
+	inList := map[db.PurchaseItemHash]bool{}
+	for _, hash := range append(append([]db.PurchaseItemHash{}, pList.Items...), pList.DeletedItemHashes...) {
+		inList[hash] = true
+	}
+	tpl.Categories = map[db.PurchaseItemHash]string{}
+	tpl.Prices = map[db.PurchaseItemHash]int64{}
+	for _, item := range pList.ItemsDictionary {
+		if !inList[item.Hash] {
+			continue
+		}
+		tpl.Items = append(tpl.Items, item)
+		if id, found := pList.Categories[item.Hash]; found {
+			tpl.Categories[item.Hash] = id
+		}
+		if amount, found := pList.Prices[item.Hash]; found {
+			tpl.Prices[item.Hash] = amount
+		}
+	}
+	if len(tpl.Items) == 0 {
+		return tpl, false, dialog.ErrEmptyTemplate
+	}
+	templates, err := f.Templates.FindByUserID(ctx, dState.User.Id)
+	if err != nil {
+		return tpl, false, err
+	}
+	replaced := false
+	for _, saved := range templates {
+		replaced = replaced || strings.EqualFold(saved.Name, name)
+	}
+	if !replaced && len(templates) >= dialog.MaxTemplates {
+		return tpl, false, dialog.ErrTooManyTemplates
+	}
+	err = f.Templates.Save(ctx, &tpl)
+	if err != nil {
+		logger.Error(ctx, "failed to save a template", "err", err)
+	}
+
+	return tpl, false, err
+}
+
+// scheduleTemplate sets or removes the schedule of a template from "/schedule Продукты пт 18:00"
+func (f *Handler) scheduleTemplate(ctx context.Context, args string, dState *dialog.DialogState) (db.Template, time.Time, error) {
+	name, weekly, err := dialog.ParseScheduleArgs(args)
+	if err != nil {
+		return db.Template{Name: name}, time.Time{}, err
+	}
+	tpl, err := f.Templates.FindByName(ctx, dState.User.Id, name)
+	if err == mongo.ErrNoDocuments {
+		return db.Template{Name: name}, time.Time{}, dialog.ErrUnknownTemplate
+	} else if err != nil {
+		return db.Template{Name: name}, time.Time{}, err
+	}
+	err = f.Templates.SetSchedule(ctx, tpl.Id, weekly)
+	if err != nil {
+		logger.Error(ctx, "failed to save a schedule", "err", err)
+		return tpl, time.Time{}, err
+	}
+	tpl.Schedule = weekly
+	if weekly == nil {
+		return tpl, time.Time{}, f.Jobs.Cancel(ctx, db.JobTemplate, tpl.Id)
+	}
+	next := weekly.Next(time.Now(), f.UserLocation(dState.User))
+	err = f.Jobs.Schedule(ctx, db.JobTemplate, tpl.Id, next)
+	if err != nil {
+		logger.Error(ctx, "failed to schedule a template", "err", err)
+	}
+
+	return tpl, next, err
+}
+
+// setTimezone of the user from "/timezone Europe/Moscow" and moves the scheduled templates to it,
+// without a timezone the current one is only shown
+func (f *Handler) setTimezone(ctx context.Context, args string, dState *dialog.DialogState) (*time.Location, bool, error) {
+	if args == "" {
+		return f.UserLocation(dState.User), false, nil
+	}
+	loc, err := schedule.Location(args)
+	if err != nil {
+		return nil, false, dialog.ErrTimezoneUsage
+	}
+	err = f.Users.SetTimezone(ctx, dState.User.Id, loc.String())
+	if err != nil {
+		logger.Error(ctx, "failed to save a timezone", "err", err)
+		return loc, false, err
+	}
+	dState.User.Timezone = loc.String()
+	templates, err := f.Templates.FindByUserID(ctx, dState.User.Id)
+	if err != nil {
+		logger.Warn(ctx, "failed to reschedule templates", "err", err)
+	}
+	for _, tpl := range templates {
+		if tpl.Schedule == nil {
+			continue
+		}
+		err = f.Jobs.Schedule(ctx, db.JobTemplate, tpl.Id, tpl.Schedule.Next(time.Now(), loc))
+		if err != nil {
+			logger.Warn(ctx, "failed to reschedule a template", "template_id", tpl.Id, "err", err)
+		}
+	}
+
+	return loc, true, nil
+}
+
+// UserLocation is the timezone of the user or the default one of the bot
+func (f *Handler) UserLocation(user *db.User) *time.Location {
+	for _, name := range []string{user.Timezone, f.Config.Schedule.Timezone} {
+		if loc, err := schedule.Location(name); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// templateCallback starts a list from the template, the template keyboard is replaced with a note about it
+func (f *Handler) templateCallback(ctx context.Context, query *tgbotapi.CallbackQuery, tplID primitive.ObjectID, c *dialog.MessageHandler, cbAnswer *tgbotapi.CallbackConfig) dialog.MessageForReply {
+	tpl, err := f.Templates.FindByID(ctx, tplID)
+	if err != nil || query.Message == nil {
+		cbAnswer.Text = dialog.ErrorText(err, "Шаблон не найден, посмотрите /template")
+		logger.Warn(ctx, "template list failed", "err", err)
+		return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: cbAnswer}
+	}
+	err = f.sendTemplateList(ctx, &tpl, query.Message.Chat.ID, c, false)
+	if err != nil {
+		cbAnswer.Text = dialog.ErrorText(err, "Ошибка, попробуйте ещё раз")
+		logger.Error(ctx, "template list failed", "err", err)
+		return dialog.MessageForReply{NewMessage: false, Text: "", AnswerCallback: cbAnswer}
+	}
+
+	return dialog.MessageForReply{NewMessage: false, Text: "Начат список по шаблону «" + tpl.Name + "»", AnswerCallback: cbAnswer}
+}
+
+// RunTemplateJob sends a scheduled list, the next one is due on the next day of the schedule
+func (f *Handler) RunTemplateJob(ctx context.Context, job db.Job) (time.Time, error) {
+	tpl, err := f.Templates.FindByID(ctx, job.RefID)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	if tpl.Schedule == nil {
+		return time.Time{}, nil
+	}
+	user, err := f.Users.FindByID(ctx, tpl.UserID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := tpl.Schedule.Next(time.Now(), f.UserLocation(&user))
+	// the session of the user is switched to the new list, as if the user did it
+	unlock, err := cluster.Lock(ctx, f.Locker, "user:"+strconv.Itoa(user.TgId))
+	if err != nil {
+		return next, err
+	}
+	defer unlock()
+	c := dialog.NewMessageHandler(f.Bot, f.Lists, f.Config)
+
+	return next, f.sendTemplateList(ctx, &tpl, tpl.ChatID, &c, true)
+}
+
+// sendTemplateList creates a list from the template, makes it the current list of the user and sends it to the chat
+func (f *Handler) sendTemplateList(ctx context.Context, tpl *db.Template, chatID int64, c *dialog.MessageHandler, scheduled bool) error {
+	now := primitive.NewDateTimeFromTime(time.Now())
+	pList := db.PurchaseList{
+		UserID:            tpl.UserID,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		TgMsgID:           []db.TgMsgID{},
+		ItemsDictionary:   []db.PurchaseItem{},
+		Items:             []db.PurchaseItemHash{},
+		DeletedItemHashes: []db.PurchaseItemHash{},
+		Categories:        tpl.Categories,
+		Prices:            tpl.Prices,
+	}
+	for _, item := range tpl.Items {
+		pList.ItemsDictionary = append(pList.ItemsDictionary, item)
+		pList.Items = append(pList.Items, item.Hash)
+	}
+	err := f.Lists.Create(ctx, &pList)
+	if err != nil {
+		return fmt.Errorf("failed to save a purchaseList: %w", err)
+	}
+	user, err := f.Users.FindByID(ctx, tpl.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to find a user: %w", err)
+	}
+	session, err := f.Session(ctx, &user)
+	if err != nil {
+		return fmt.Errorf("failed to find a session: %w", err)
+	}
+	session.PreviousState = session.PostingState
+	session.PostingState = db.SessPStateCreation
+	session.PurchaseListId = pList.Id
+	err = f.Sessions.UpdateSession(ctx, session)
+	if err != nil {
+		return err
+	}
+	sent, err := f.Sender.Reply(ctx, f.Bot, dialog.ChatMessageID{ChatID: &chatID}, c.GetMessageForTemplateList(tpl, &pList, scheduled))
+	if err != nil {
+		return err
+	}
+	if sent.Chat != nil {
+		f.Lists.AddMsgID(ctx, pList.Id, db.TgMsgID{TgChatID: sent.Chat.ID, TgMessageID: sent.MessageID})
+	}
+
+	return nil
+}
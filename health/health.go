@@ -0,0 +1,128 @@
+// Package health serves the liveness and readiness probes.
+//
+// /healthz answers as long as the process serves http. /readyz runs every
+// registered check and answers 503 if any of them fails, so an orchestrator
+// holds traffic from the instance until it recovers.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrShuttingDown = errors.New("shutting down")
+
+// Check returns nil when the dependency works
+type Check func(ctx context.Context) error
+
+type Checker struct {
+	timeout  time.Duration
+	mu       sync.Mutex
+	names    []string
+	checks   map[string]Check
+	stopping bool
+}
+
+type report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// NewChecker makes a checker which gives every check up to timeout
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout, checks: map[string]Check{}}
+}
+
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, found := c.checks[name]; !found {
+		c.names = append(c.names, name)
+	}
+	c.checks[name] = check
+}
+
+// Stop makes the instance unready for the rest of its life
+func (c *Checker) Stop() {
+	c.mu.Lock()
+	c.stopping = true
+	c.mu.Unlock()
+}
+
+// Run executes the checks concurrently and returns their errors by name
+func (c *Checker) Run(ctx context.Context) map[string]error {
+	c.mu.Lock()
+	names := append([]string(nil), c.names...)
+	checks := make([]Check, len(names))
+	for i, name := range names {
+		checks[i] = c.checks[name]
+	}
+	stopping := c.stopping
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = run(ctx, checks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	result := map[string]error{}
+	for i, name := range names {
+		result[name] = errs[i]
+	}
+	if stopping {
+		result["shutdown"] = ErrShuttingDown
+	}
+	return result
+}
+
+// run gives up on a check which ignores the context
+func run(ctx context.Context, check Check) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Checker) Live(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, report{Status: "ok"})
+}
+
+func (c *Checker) Ready(w http.ResponseWriter, r *http.Request) {
+	rep := report{Status: "ok", Checks: map[string]string{}}
+	code := http.StatusOK
+	for name, err := range c.Run(r.Context()) {
+		if err != nil {
+			rep.Checks[name] = err.Error()
+			rep.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		} else {
+			rep.Checks[name] = "ok"
+		}
+	}
+	writeReport(w, code, rep)
+}
+
+func writeReport(w http.ResponseWriter, code int, rep report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(rep)
+}
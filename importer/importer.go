@@ -0,0 +1,230 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/boryashkin/purchaselist/export"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxFileSize is the biggest document the bot agrees to download
+const MaxFileSize = 512 * 1024
+
+var (
+	checkboxRe   = regexp.MustCompile(`^[-*+]\s+\[([ xXхХ])\]\s*(.*)$`)
+	bulletRe     = regexp.MustCompile(`^[-*+]\s+(.*)$`)
+	exportHeadRe = regexp.MustCompile(`^Список покупок \d{2}\.\d{2}\.\d{4} \d{2}:\d{2}$`)
+)
+
+type Rejected struct {
+	Line   int
+	Text   string
+	Reason string
+}
+
+type Result struct {
+	Items    []export.Item
+	Rejected []Rejected
+	// Added is filled by the caller once items are saved to a list
+	Added int
+}
+
+// Parse reads items out of a file exported by the bot or written by hand.
+// The format is taken from the file extension, falling back to the mime type.
+func Parse(fileName string, mimeType string, content []byte) (*Result, error) {
+	if len(content) > MaxFileSize {
+		return nil, errors.New("file is too big")
+	}
+	switch detectFormat(fileName, mimeType) {
+	case export.FormatCSV:
+		return parseCSV(content)
+	case export.FormatJSON:
+		return parseJSON(content)
+	case export.FormatMarkdown:
+		return parseMarkdown(content)
+	case export.FormatText:
+		return parseText(content)
+	}
+	return nil, errors.New("unsupported file type " + fileName)
+}
+
+func detectFormat(fileName string, mimeType string) export.Format {
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".csv":
+		return export.FormatCSV
+	case ".json":
+		return export.FormatJSON
+	case ".md", ".markdown":
+		return export.FormatMarkdown
+	case ".txt":
+		return export.FormatText
+	}
+	switch mimeType {
+	case "text/csv":
+		return export.FormatCSV
+	case "application/json":
+		return export.FormatJSON
+	case "text/markdown":
+		return export.FormatMarkdown
+	case "text/plain":
+		return export.FormatText
+	}
+	return ""
+}
+
+func parseText(content []byte) (*Result, error) {
+	result := Result{}
+	err := eachLine(content, func(n int, line string) {
+		if exportHeadRe.MatchString(line) {
+			return
+		}
+		crossedOut := false
+		if strings.HasPrefix(line, "✔") {
+			crossedOut = true
+			line = strings.TrimSpace(strings.TrimPrefix(line, "✔"))
+		}
+		result.add(n, line, crossedOut)
+	})
+	return &result, err
+}
+
+func parseMarkdown(content []byte) (*Result, error) {
+	result := Result{}
+	err := eachLine(content, func(n int, line string) {
+		if strings.HasPrefix(line, "#") || (strings.HasPrefix(line, "_") && strings.HasSuffix(line, "_")) {
+			return
+		}
+		if matches := checkboxRe.FindStringSubmatch(line); matches != nil {
+			result.add(n, matches[2], matches[1] != " ")
+		} else if matches := bulletRe.FindStringSubmatch(line); matches != nil {
+			result.add(n, matches[1], false)
+		} else {
+			result.reject(n, line, "не пункт списка")
+		}
+	})
+	return &result, err
+}
+
+func parseCSV(content []byte) (*Result, error) {
+	result := Result{}
+	r := csv.NewReader(bytes.NewReader(content))
+	r.FieldsPerRecord = -1
+	nameCol, quantityCol, crossedCol := 0, 1, -1
+	n := 0
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		n++
+		if err != nil {
+			result.reject(n, "", err.Error())
+			continue
+		}
+		if n == 1 && isCSVHeader(record) {
+			nameCol, quantityCol, crossedCol = -1, -1, -1
+			for i, col := range record {
+				switch strings.ToLower(strings.TrimSpace(col)) {
+				case "name":
+					nameCol = i
+				case "quantity":
+					quantityCol = i
+				case "crossed_out":
+					crossedCol = i
+				}
+			}
+			continue
+		}
+		item := export.Item{}
+		if nameCol >= 0 && nameCol < len(record) {
+			item.Name = strings.TrimSpace(record[nameCol])
+		}
+		if quantityCol >= 0 && quantityCol < len(record) {
+			item.Quantity = strings.TrimSpace(record[quantityCol])
+		}
+		if crossedCol >= 0 && crossedCol < len(record) && record[crossedCol] != "" {
+			item.CrossedOut, err = strconv.ParseBool(strings.TrimSpace(record[crossedCol]))
+			if err != nil {
+				result.reject(n, strings.Join(record, ","), "не понятно, вычеркнут ли товар")
+				continue
+			}
+		}
+		if item.Name == "" {
+			result.reject(n, strings.Join(record, ","), "нет названия")
+			continue
+		}
+		result.Items = append(result.Items, item)
+	}
+	if len(result.Items) == 0 && n > 0 && nameCol < 0 {
+		return nil, errors.New("no name column in csv")
+	}
+	return &result, nil
+}
+
+func isCSVHeader(record []string) bool {
+	for _, col := range record {
+		if strings.ToLower(strings.TrimSpace(col)) == "name" {
+			return true
+		}
+	}
+	return false
+}
+
+func parseJSON(content []byte) (*Result, error) {
+	var list export.ListDto
+	err := json.Unmarshal(content, &list)
+	if err != nil {
+		return nil, err
+	}
+	if list.Version > export.JSONVersion {
+		return nil, errors.New("unsupported json version " + strconv.Itoa(list.Version))
+	}
+	result := Result{}
+	for i, item := range list.Items {
+		item.Name = strings.TrimSpace(item.Name)
+		if item.Name == "" {
+			result.reject(i+1, "", "нет названия")
+			continue
+		}
+		result.Items = append(result.Items, item)
+	}
+	return &result, nil
+}
+
+func eachLine(content []byte, fn func(n int, line string)) error {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" {
+			continue
+		}
+		fn(n, line)
+	}
+	return scanner.Err()
+}
+
+func (r *Result) add(n int, text string, crossedOut bool) {
+	name, quantity := export.SplitQuantity(text)
+	if name == "" {
+		r.reject(n, text, "нет названия")
+		return
+	}
+	r.Items = append(r.Items, export.Item{Name: name, Quantity: quantity, CrossedOut: crossedOut})
+}
+
+func (r *Result) Reject(text string, reason string) {
+	r.reject(0, text, reason)
+}
+
+func (r *Result) reject(n int, text string, reason string) {
+	r.Rejected = append(r.Rejected, Rejected{Line: n, Text: text, Reason: reason})
+}
@@ -0,0 +1,169 @@
+package importer
+
+import (
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/export"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		mimeType string
+		content  string
+		want     *Result
+		wantErr  bool
+	}{
+		{
+			name:     "text exported by the bot",
+			fileName: "list.txt",
+			content:  "\ufeffСписок покупок 01.10.2026 09:30\n\nмолоко 2\n✔ яйца x10\n",
+			want: &Result{Items: []export.Item{
+				{Name: "молоко", Quantity: "2"},
+				{Name: "яйца", Quantity: "10", CrossedOut: true},
+			}},
+		},
+		{
+			name:     "text without an item name",
+			fileName: "list.txt",
+			content:  "хлеб\n✔\n",
+			want: &Result{
+				Items:    []export.Item{{Name: "хлеб"}},
+				Rejected: []Rejected{{Line: 2, Text: "", Reason: "нет названия"}},
+			},
+		},
+		{
+			name:     "markdown with checkboxes, bullets and a paragraph",
+			fileName: "list.md",
+			content:  "# Список покупок\n\n- [ ] молоко\n* [x] хлеб\n- [Х] сыр 200 г\n+ соль\nкупить всё\n\n_Обновлён 02.10.2026 18:05_\n",
+			want: &Result{
+				Items: []export.Item{
+					{Name: "молоко"},
+					{Name: "хлеб", CrossedOut: true},
+					{Name: "сыр", Quantity: "200 г", CrossedOut: true},
+					{Name: "соль"},
+				},
+				Rejected: []Rejected{{Line: 7, Text: "купить всё", Reason: "не пункт списка"}},
+			},
+		},
+		{
+			name:     "csv with a header in another column order",
+			fileName: "list.csv",
+			content:  "crossed_out,Name,quantity\ntrue,молоко,2\n,хлеб,\nда,сыр,1\nfalse,,3\n",
+			want: &Result{
+				Items: []export.Item{
+					{Name: "молоко", Quantity: "2", CrossedOut: true},
+					{Name: "хлеб"},
+				},
+				Rejected: []Rejected{
+					{Line: 4, Text: "да,сыр,1", Reason: "не понятно, вычеркнут ли товар"},
+					{Line: 5, Text: "false,,3", Reason: "нет названия"},
+				},
+			},
+		},
+		{
+			name:     "csv without a header",
+			fileName: "list.csv",
+			content:  "молоко,2\nхлеб\n",
+			want: &Result{Items: []export.Item{
+				{Name: "молоко", Quantity: "2"},
+				{Name: "хлеб"},
+			}},
+		},
+		{
+			name:     "csv with only the name column",
+			fileName: "list.csv",
+			content:  "name\nмолоко 2\n",
+			want:     &Result{Items: []export.Item{{Name: "молоко 2"}}},
+		},
+		{
+			name:     "csv with only a header",
+			fileName: "list.csv",
+			content:  "name,quantity,crossed_out\n",
+			want:     &Result{},
+		},
+		{
+			name:     "json",
+			fileName: "list.json",
+			content:  `{"version":1,"items":[{"name":" молоко ","quantity":"2"},{"name":""},{"name":"хлеб","crossed_out":true}]}`,
+			want: &Result{
+				Items: []export.Item{
+					{Name: "молоко", Quantity: "2"},
+					{Name: "хлеб", CrossedOut: true},
+				},
+				Rejected: []Rejected{{Line: 2, Text: "", Reason: "нет названия"}},
+			},
+		},
+		{
+			name:     "json of a newer version",
+			fileName: "list.json",
+			content:  `{"version":2,"items":[]}`,
+			wantErr:  true,
+		},
+		{
+			name:     "broken json",
+			fileName: "list.json",
+			content:  `{"items":[`,
+			wantErr:  true,
+		},
+		{
+			name:     "format from the mime type",
+			fileName: "list",
+			mimeType: "text/markdown",
+			content:  "- [x] хлеб\n",
+			want:     &Result{Items: []export.Item{{Name: "хлеб", CrossedOut: true}}},
+		},
+		{
+			name:     "extension wins over the mime type",
+			fileName: "LIST.TXT",
+			mimeType: "application/json",
+			content:  "хлеб\n",
+			want:     &Result{Items: []export.Item{{Name: "хлеб"}}},
+		},
+		{
+			name:     "unsupported file type",
+			fileName: "list.pdf",
+			mimeType: "application/pdf",
+			content:  "хлеб",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.fileName, tt.mimeType, []byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExported(t *testing.T) {
+	milk, eggs := db.PurchaseItemHash(db.GetMD5Hash("молоко 2")), db.PurchaseItemHash(db.GetMD5Hash("яйца"))
+	list := db.PurchaseList{
+		ItemsDictionary:   []db.PurchaseItem{{Name: "молоко 2", Hash: milk}, {Name: "яйца", Hash: eggs}},
+		Items:             []db.PurchaseItemHash{milk},
+		DeletedItemHashes: []db.PurchaseItemHash{eggs},
+	}
+	want := export.ListItems(&list)
+	for _, format := range export.Formats {
+		t.Run(string(format), func(t *testing.T) {
+			content, err := export.Export(&list, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Parse(export.FileName(&list, format), "", content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Rejected) != 0 || !reflect.DeepEqual(got.Items, want) {
+				t.Errorf("Parse() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+// Package logger writes leveled structured logs, one JSON object or text line per entry.
+//
+// Every entry takes a context, the correlation ID put into it with WithCorrelation
+// is added to the entry, so all the lines of one update can be found together.
+// Values which may hold personal data are wrapped with Private and are only
+// written at the debug level.
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+const redacted = "[redacted]"
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+type ctxKey struct{}
+
+var (
+	mu     sync.Mutex
+	out    io.Writer = os.Stderr
+	level            = LevelInfo
+	format           = FormatJSON
+)
+
+func ParseLevel(name string) (Level, error) {
+	for l, n := range levelNames {
+		if n == strings.ToLower(name) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// Setup replaces the defaults, which are info level JSON to stderr
+func Setup(w io.Writer, l Level, f string) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+	level = l
+	format = f
+}
+
+func IsDebug() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return level == LevelDebug
+}
+
+// WithCorrelation marks all the entries logged with the returned context
+func WithCorrelation(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+func CorrelationID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+type private struct {
+	value interface{}
+}
+
+// Private hides the value, such as a message text or a phone number, unless the level is debug
+func Private(value interface{}) interface{} {
+	return private{value}
+}
+
+func Debug(ctx context.Context, msg string, kv ...interface{}) {
+	write(ctx, LevelDebug, msg, kv)
+}
+
+func Info(ctx context.Context, msg string, kv ...interface{}) {
+	write(ctx, LevelInfo, msg, kv)
+}
+
+func Warn(ctx context.Context, msg string, kv ...interface{}) {
+	write(ctx, LevelWarn, msg, kv)
+}
+
+func Error(ctx context.Context, msg string, kv ...interface{}) {
+	write(ctx, LevelError, msg, kv)
+}
+
+// Writer turns lines written by the standard log package, such as those of the libraries, into entries
+func Writer(l Level) io.Writer {
+	return stdWriter{level: l}
+}
+
+type stdWriter struct {
+	level Level
+}
+
+func (w stdWriter) Write(p []byte) (int, error) {
+	write(context.Background(), w.level, strings.TrimRight(string(p), "\n"), nil)
+	return len(p), nil
+}
+
+func write(ctx context.Context, l Level, msg string, kv []interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	if l < level {
+		return
+	}
+	fields := map[string]interface{}{}
+	if id := CorrelationID(ctx); id != "" {
+		fields["cid"] = id
+	}
+	for i := 0; i < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+		if i+1 == len(kv) {
+			fields["!extra"] = key
+			break
+		}
+		fields[key] = value(kv[i+1])
+	}
+
+	var buf bytes.Buffer
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if format == FormatText {
+		buf.WriteString(now + " " + strings.ToUpper(l.String()) + " " + msg)
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&buf, " %s=%v", key, fields[key])
+		}
+		buf.WriteByte('\n')
+	} else {
+		fields["time"] = now
+		fields["level"] = l.String()
+		fields["msg"] = msg
+		err := json.NewEncoder(&buf).Encode(fields)
+		if err != nil {
+			buf.Reset()
+			fmt.Fprintf(&buf, "{\"time\":%q,\"level\":%q,\"msg\":%q,\"log_error\":%q}\n", now, l.String(), msg, err.Error())
+		}
+	}
+	out.Write(buf.Bytes())
+}
+
+// value makes the field printable, mu is held
+func value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case private:
+		if level == LevelDebug {
+			return value(t.value)
+		}
+		return redacted
+	case error:
+		return t.Error()
+	}
+	return v
+}
@@ -0,0 +1,5 @@
+// Package metrics declares the prometheus metrics of the bot.
+//
+// The metrics are registered in the default registry by promauto as soon as
+// the package is imported, bot.go serves them on /metrics.
+package metrics
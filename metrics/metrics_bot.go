@@ -34,11 +34,75 @@ var (
 		},
 		[]string{"result"},
 	)
+	TgUpdateReceived = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "bot_tg_update_received",
+			Help: "The total number of telegram updates by whether they were handled or skipped as duplicates",
+		},
+		[]string{"result"},
+	)
+	TgSendQueue = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "bot_tg_send_queue",
+			Help: "The number of telegram requests waiting for the rate limits or being sent",
+		},
+	)
+	TgSendLatency = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "bot_tg_send_latency_seconds",
+			Help:    "Time from queueing a telegram request till its response, including waiting and retries",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30},
+		},
+		[]string{"msg_type"},
+	)
+	TgSendRetry = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "bot_tg_send_retry",
+			Help: "The total number of retried telegram requests by the reason",
+		},
+		[]string{"reason"},
+	)
+	TgSendDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "bot_tg_send_dropped",
+			Help: "The total number of telegram requests dropped before sending",
+		},
+		[]string{"reason"},
+	)
+	TgAPIDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "bot_tg_api_duration_seconds",
+			Help:    "Duration of telegram api requests, getUpdates includes the long polling timeout",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30, 60},
+		},
+		[]string{"method", "result"},
+	)
+	BotUpdateDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "bot_update_duration_seconds",
+			Help:    "Time of handling an update, from its dispatch till the reply",
+			Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		},
+		[]string{"update_type"},
+	)
+	BotCommand = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "bot_command",
+			Help: "The total number of commands by name",
+		},
+		[]string{"command"},
+	)
+	BotCallback = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "bot_callback",
+			Help: "The total number of inline keyboard presses by action",
+		},
+		[]string{"action"},
+	)
+	BotInFlight = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "bot_in_flight",
+			Help: "The number of updates being handled",
+		},
+	)
 )
-
-func InitBotMetrics() {
-	prometheus.MustRegister(TgMsgSent)
-	prometheus.MustRegister(TgMsgRetrySent)
-	prometheus.MustRegister(TgCbAnswer)
-	prometheus.MustRegister(TgCbInlineAnswer)
-}
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	CacheRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_requests",
+			Help: "Cache lookups by hit or miss",
+		},
+		[]string{"cache", "result"},
+	)
+	CacheEvicted = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_evicted",
+			Help: "Cache entries dropped because they expired or the cache was full",
+		},
+		[]string{"cache", "reason"},
+	)
+	CacheSize = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cache_size",
+			Help: "The number of cached entries",
+		},
+		[]string{"cache"},
+	)
+)
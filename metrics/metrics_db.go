@@ -3,6 +3,7 @@ package metrics
 import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"time"
 )
 
 var (
@@ -34,6 +35,34 @@ var (
 		},
 		[]string{"result"},
 	)
+	DbPlistFindByUserID = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_plist_find_by_user_id",
+			Help: "Purchase FindByUserID",
+		},
+		[]string{"result"},
+	)
+	DbPlistCountUpdatedSince = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_plist_count_updated_since",
+			Help: "Purchase CountUpdatedSince",
+		},
+		[]string{"result"},
+	)
+	DbPlistUpdateItems = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_plist_update_items",
+			Help: "Purchase UpdateItems",
+		},
+		[]string{"result"},
+	)
+	DbPlistRepair = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_plist_repair",
+			Help: "Purchase Repair",
+		},
+		[]string{"result"},
+	)
 	DbPlistCrossOutItemFromPurchaseList = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "db_plist_crossout_item",
@@ -48,6 +77,13 @@ var (
 		},
 		[]string{"result"},
 	)
+	DbPlistReplaceItem = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_plist_replace_item",
+			Help: "Purchase ReplaceItem",
+		},
+		[]string{"result"},
+	)
 
 	DbSessionFindByUserID = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -92,13 +128,389 @@ var (
 		},
 		[]string{"result"},
 	)
+	DbUserSetStores = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_user_set_stores",
+			Help: "User SetStores",
+		},
+		[]string{"result"},
+	)
+
+	DbUpdateIsProcessed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_update_is_processed",
+			Help: "Update IsProcessed",
+		},
+		[]string{"result"},
+	)
+	DbUpdateMarkProcessed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_update_mark_processed",
+			Help: "Update MarkProcessed",
+		},
+		[]string{"result"},
+	)
+	DbUpdateSaveOffset = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_update_save_offset",
+			Help: "Update SaveOffset",
+		},
+		[]string{"result"},
+	)
+
+	DbLeaseAcquire = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_lease_acquire",
+			Help: "Lease Acquire",
+		},
+		[]string{"result"},
+	)
+	DbLeaseRelease = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_lease_release",
+			Help: "Lease Release",
+		},
+		[]string{"result"},
+	)
+	DbTicketTake = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_ticket_take",
+			Help: "Ticket Take",
+		},
+		[]string{"result"},
+	)
+	DbTicketServed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_ticket_served",
+			Help: "Ticket Served",
+		},
+		[]string{"result"},
+	)
+	DbTicketServe = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_ticket_serve",
+			Help: "Ticket Serve",
+		},
+		[]string{"result"},
+	)
+	DbTicketTouch = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_ticket_touch",
+			Help: "Ticket Touch",
+		},
+		[]string{"result"},
+	)
+	DbTicketAbandon = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_ticket_abandon",
+			Help: "Ticket Abandon",
+		},
+		[]string{"result"},
+	)
+
+	DbDebounceSetLast = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_debounce_set_last",
+			Help: "Debounce SetLast",
+		},
+		[]string{"result"},
+	)
+	DbDebounceIsLast = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_debounce_is_last",
+			Help: "Debounce IsLast",
+		},
+		[]string{"result"},
+	)
+	DbCategoryLearn = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_category_learn",
+			Help: "Category Learn",
+		},
+		[]string{"result"},
+	)
+	DbCategoryLearned = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_category_learned",
+			Help: "Category Learned",
+		},
+		[]string{"result"},
+	)
+	DbPlistSetPrice = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_plist_set_price",
+			Help: "Purchase SetPrice",
+		},
+		[]string{"result"},
+	)
+	DbPlistSetBudget = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_plist_set_budget",
+			Help: "Purchase SetBudget",
+		},
+		[]string{"result"},
+	)
+	DbPlistSetDue = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_plist_set_due",
+			Help: "Purchase SetDue",
+		},
+		[]string{"result"},
+	)
+	DbPlistSetItemDue = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_plist_set_item_due",
+			Help: "Purchase SetItemDue",
+		},
+		[]string{"result"},
+	)
+	DbPlistIncReminded = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_plist_inc_reminded",
+			Help: "Purchase IncReminded",
+		},
+		[]string{"result"},
+	)
+	DbPlistFindWithPurchasesSince = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_plist_find_with_purchases_since",
+			Help: "Purchase FindWithPurchasesSince",
+		},
+		[]string{"result"},
+	)
+	DbPlistSetStore = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_plist_set_store",
+			Help: "Purchase SetStore",
+		},
+		[]string{"result"},
+	)
+	DbPlistSetCategories = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_plist_set_categories",
+			Help: "Purchase SetCategories",
+		},
+		[]string{"result"},
+	)
+	DbHistoryRecord = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_history_record",
+			Help: "History Record",
+		},
+		[]string{"result"},
+	)
+	DbHistoryFind = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_history_find",
+			Help: "History Find",
+		},
+		[]string{"result"},
+	)
+	DbHistorySuggest = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_history_suggest",
+			Help: "History Suggest",
+		},
+		[]string{"result"},
+	)
+	DbUserSetTimezone = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_user_set_timezone",
+			Help: "User SetTimezone",
+		},
+		[]string{"result"},
+	)
+	DbUserSetPantry = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_user_set_pantry",
+			Help: "User SetPantry",
+		},
+		[]string{"result"},
+	)
+	DbTemplateSave = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_template_save",
+			Help: "Template Save",
+		},
+		[]string{"result"},
+	)
+	DbTemplateFindByID = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_template_find_by_id",
+			Help: "Template FindByID",
+		},
+		[]string{"result"},
+	)
+	DbTemplateFindByName = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_template_find_by_name",
+			Help: "Template FindByName",
+		},
+		[]string{"result"},
+	)
+	DbTemplateFindByUserID = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_template_find_by_user_id",
+			Help: "Template FindByUserID",
+		},
+		[]string{"result"},
+	)
+	DbTemplateSetSchedule = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_template_set_schedule",
+			Help: "Template SetSchedule",
+		},
+		[]string{"result"},
+	)
+	DbTemplateDelete = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_template_delete",
+			Help: "Template Delete",
+		},
+		[]string{"result"},
+	)
+	DbRecipeSave = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_recipe_save",
+			Help: "Recipe Save",
+		},
+		[]string{"result"},
+	)
+	DbRecipeFindByName = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_recipe_find_by_name",
+			Help: "Recipe FindByName",
+		},
+		[]string{"result"},
+	)
+	DbRecipeFindByUserID = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_recipe_find_by_user_id",
+			Help: "Recipe FindByUserID",
+		},
+		[]string{"result"},
+	)
+	DbRecipeDelete = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_recipe_delete",
+			Help: "Recipe Delete",
+		},
+		[]string{"result"},
+	)
+	DbPantryStock = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_pantry_stock",
+			Help: "Pantry Stock",
+		},
+		[]string{"result"},
+	)
+	DbPantryFindByID = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_pantry_find_by_id",
+			Help: "Pantry FindByID",
+		},
+		[]string{"result"},
+	)
+	DbPantryFindByName = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_pantry_find_by_name",
+			Help: "Pantry FindByName",
+		},
+		[]string{"result"},
+	)
+	DbPantryFindByUserID = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_pantry_find_by_user_id",
+			Help: "Pantry FindByUserID",
+		},
+		[]string{"result"},
+	)
+	DbPantrySetAmount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_pantry_set_amount",
+			Help: "Pantry SetAmount",
+		},
+		[]string{"result"},
+	)
+	DbPantrySetThreshold = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_pantry_set_threshold",
+			Help: "Pantry SetThreshold",
+		},
+		[]string{"result"},
+	)
+	DbPantryDelete = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_pantry_delete",
+			Help: "Pantry Delete",
+		},
+		[]string{"result"},
+	)
+	DbJobSchedule = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_job_schedule",
+			Help: "Job Schedule",
+		},
+		[]string{"result"},
+	)
+	DbJobCancel = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_job_cancel",
+			Help: "Job Cancel",
+		},
+		[]string{"result"},
+	)
+	DbJobClaim = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_job_claim",
+			Help: "Job Claim",
+		},
+		[]string{"result"},
+	)
+	DbJobFinish = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_job_finish",
+			Help: "Job Finish",
+		},
+		[]string{"result"},
+	)
+	DbDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "db_duration_seconds",
+			Help:    "Duration of db service calls",
+			Buckets: []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 5},
+		},
+		[]string{"operation"},
+	)
+	DbActiveLists = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "db_active_lists",
+			Help: "The number of purchase lists changed within the last day",
+		},
+	)
+	DbBreakerOpen = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "db_breaker_open",
+			Help: "1 while the db circuit breaker fails calls fast",
+		},
+	)
+	DbBreakerRejected = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_breaker_rejected",
+			Help: "Db calls failed fast by the circuit breaker",
+		},
+		[]string{"operation"},
+	)
+	DbTimeout = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_timeout",
+			Help: "Db calls which ran out of time",
+		},
+		[]string{"operation"},
+	)
 )
 
-func InitDbMetrics() {
-	prometheus.MustRegister(DbPlistCreate)
-	prometheus.MustRegister(DbPlistFindByID)
-	prometheus.MustRegister(DbPlistAddMsgID)
-	prometheus.MustRegister(DbPlistAddItemToPurchaseList)
-	prometheus.MustRegister(DbPlistDeleteMsgID)
-	prometheus.MustRegister(DbPlistCrossOutItemFromPurchaseList)
+// ObserveDb is deferred at the start of a db call with its start time
+func ObserveDb(operation string, start time.Time) {
+	DbDuration.With(prometheus.Labels{"operation": operation}).Observe(time.Since(start).Seconds())
 }
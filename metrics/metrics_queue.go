@@ -13,8 +13,17 @@ var (
 		},
 		[]string{"action"},
 	)
+	QueuePending = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "queue_pending",
+			Help: "The number of delayed messages waiting to be sent",
+		},
+	)
+	SchedulerJob = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "scheduler_job",
+			Help: "Scheduled jobs run",
+		},
+		[]string{"kind", "result"},
+	)
 )
-
-func InitDbQueue() {
-	prometheus.MustRegister(QueueExecItem)
-}
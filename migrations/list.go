@@ -0,0 +1,270 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"github.com/boryashkin/purchaselist/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// All migrations known to the bot. Never change or reuse the ID of an applied migration, add a new one instead.
+var All = []Migration{
+	{ID: 1, Name: "users_tg_id_unique_index", Up: usersTgIDIndex},
+	{ID: 2, Name: "sessions_user_id_unique_index", Up: sessionsUserIDIndex},
+	{ID: 3, Name: "purchase_lists_user_id_index", Up: purchaseListsUserIDIndex},
+	{ID: 4, Name: "purchase_lists_backfill_arrays", Up: purchaseListsBackfillArrays},
+	{ID: 5, Name: "purchase_lists_repair_dictionary_hashes", Up: purchaseListsRepairDictionary},
+	{ID: 6, Name: "processed_updates_ttl_index", Up: processedUpdatesTTLIndex},
+	{ID: 7, Name: "debounce_ttl_index", Up: debounceTTLIndex},
+	{ID: 8, Name: "item_history_backfill_and_indexes", Up: itemHistoryBackfill},
+	{ID: 9, Name: "category_words_unique_index", Up: categoryWordsIndex},
+	{ID: 10, Name: "templates_and_jobs_indexes", Up: templatesAndJobsIndexes},
+	{ID: 11, Name: "recipes_unique_index", Up: recipesIndex},
+	{ID: 12, Name: "pantry_unique_index", Up: pantryIndex},
+	{ID: 13, Name: "tickets_ttl_index", Up: ticketsTTLIndex},
+}
+
+func usersTgIDIndex(ctx context.Context, database *mongo.Database, dryRun bool) (string, error) {
+	return createIndex(ctx, database.Collection(db.ColUsers), bson.D{{Key: "tg_id", Value: 1}}, true, dryRun)
+}
+
+// sessionsUserIDIndex keeps the latest session of every user, as the unique index can't be built over duplicates
+func sessionsUserIDIndex(ctx context.Context, database *mongo.Database, dryRun bool) (string, error) {
+	col := database.Collection(db.ColSessions)
+	cursor, err := col.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.M{"_id": -1}}},
+		{{Key: "$group", Value: bson.M{"_id": "$user_id", "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return "", err
+	}
+	var groups []struct {
+		IDs []primitive.ObjectID `bson:"ids"`
+	}
+	err = cursor.All(ctx, &groups)
+	if err != nil {
+		return "", err
+	}
+	var duplicates []primitive.ObjectID
+	for _, group := range groups {
+		duplicates = append(duplicates, group.IDs[1:]...)
+	}
+	if len(duplicates) > 0 && !dryRun {
+		_, err = col.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": duplicates}})
+		if err != nil {
+			return "", err
+		}
+	}
+	summary, err := createIndex(ctx, col, bson.D{{Key: "user_id", Value: 1}}, true, dryRun)
+
+	return fmt.Sprintf("%d duplicate sessions removed, %s", len(duplicates), summary), err
+}
+
+func purchaseListsUserIDIndex(ctx context.Context, database *mongo.Database, dryRun bool) (string, error) {
+	return createIndex(
+		ctx,
+		database.Collection(db.ColProducts),
+		bson.D{{Key: "user_id", Value: 1}, {Key: "updated_at", Value: -1}},
+		false,
+		dryRun,
+	)
+}
+
+// purchaseListsBackfillArrays replaces missing arrays with empty ones, $push and $addToSet fail on null
+func purchaseListsBackfillArrays(ctx context.Context, database *mongo.Database, dryRun bool) (string, error) {
+	col := database.Collection(db.ColProducts)
+	summary := ""
+	for _, field := range []string{"items_dictionary", "purchase_items", "deleted_purchase_items", "tg_msg_id"} {
+		filter := bson.M{field: nil}
+		var count int64
+		var err error
+		if dryRun {
+			count, err = col.CountDocuments(ctx, filter)
+		} else {
+			var result *mongo.UpdateResult
+			result, err = col.UpdateMany(ctx, filter, bson.M{"$set": bson.M{field: bson.A{}}})
+			if result != nil {
+				count = result.ModifiedCount
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		if summary != "" {
+			summary += ", "
+		}
+		summary += fmt.Sprintf("%s: %d", field, count)
+	}
+
+	return summary, nil
+}
+
+func purchaseListsRepairDictionary(ctx context.Context, database *mongo.Database, dryRun bool) (string, error) {
+	col := database.Collection(db.ColProducts)
+	cursor, err := col.Find(ctx, bson.M{})
+	if err != nil {
+		return "", err
+	}
+	defer cursor.Close(ctx)
+	lists, entries, orphans := 0, 0, 0
+	for cursor.Next(ctx) {
+		var pList db.PurchaseList
+		err = cursor.Decode(&pList)
+		if err != nil {
+			return "", err
+		}
+		fixed, orphaned := db.RepairDictionary(&pList, false)
+		orphans += len(orphaned)
+		if fixed == 0 {
+			continue
+		}
+		lists++
+		entries += fixed
+		if dryRun {
+			continue
+		}
+		_, err = col.UpdateOne(ctx, bson.M{"_id": pList.Id}, bson.M{"$set": db.RepairFields(&pList)})
+		if err != nil {
+			return "", err
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d entries fixed in %d lists, %d items left without a name", entries, lists, orphans), nil
+}
+
+// processedUpdatesTTLIndex expires processed update ids after a day, telegram doesn't keep updates longer
+func processedUpdatesTTLIndex(ctx context.Context, database *mongo.Database, dryRun bool) (string, error) {
+	return createTTLIndex(ctx, database.Collection(db.ColProcessedUpdates), "created_at", 24*time.Hour, dryRun)
+}
+
+// debounceTTLIndex drops the render marks of lists nobody touched for an hour
+func debounceTTLIndex(ctx context.Context, database *mongo.Database, dryRun bool) (string, error) {
+	return createTTLIndex(ctx, database.Collection(db.ColDebounce), "updated_at", time.Hour, dryRun)
+}
+
+// ticketsTTLIndex drops the queues of users whose updates stopped a day ago
+func ticketsTTLIndex(ctx context.Context, database *mongo.Database, dryRun bool) (string, error) {
+	return createTTLIndex(ctx, database.Collection(db.ColTickets), "moved_at", 24*time.Hour, dryRun)
+}
+
+// itemHistoryBackfill counts the crossed out items of the existing lists as bought when the list was last updated
+func itemHistoryBackfill(ctx context.Context, database *mongo.Database, dryRun bool) (string, error) {
+	type key struct {
+		userID primitive.ObjectID
+		hash   db.PurchaseItemHash
+	}
+	history := map[key]*db.HistoryItem{}
+	cursor, err := database.Collection(db.ColProducts).Find(ctx, bson.M{"deleted_purchase_items.0": bson.M{"$exists": true}})
+	if err != nil {
+		return "", err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var pList db.PurchaseList
+		err = cursor.Decode(&pList)
+		if err != nil {
+			return "", err
+		}
+		names := map[db.PurchaseItemHash]db.PurchaseItemName{}
+		for _, item := range pList.ItemsDictionary {
+			names[item.Hash] = item.Name
+		}
+		for _, hash := range pList.DeletedItemHashes {
+			name, found := names[hash]
+			if !found {
+				continue
+			}
+			item, found := history[key{pList.UserID, hash}]
+			if !found {
+				item = &db.HistoryItem{UserID: pList.UserID, Hash: hash}
+				history[key{pList.UserID, hash}] = item
+			}
+			item.Count++
+			if pList.UpdatedAt >= item.LastBoughtAt {
+				item.LastBoughtAt = pList.UpdatedAt
+				item.Name = name
+			}
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return "", err
+	}
+
+	col := database.Collection(db.ColItemHistory)
+	if !dryRun && len(history) > 0 {
+		var models []mongo.WriteModel
+		for _, item := range history {
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"user_id": item.UserID, "hash": item.Hash}).
+				// the counts are absolute, every purchase is in a list, so a re-run writes the same values
+				SetUpdate(bson.M{
+					"$set": bson.M{"count": item.Count, "name": item.Name},
+					"$max": bson.M{"last_bought_at": item.LastBoughtAt},
+				}).
+				SetUpsert(true))
+		}
+		_, err = col.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return "", err
+		}
+	}
+	unique, err := createIndex(ctx, col, bson.D{{Key: "user_id", Value: 1}, {Key: "hash", Value: 1}}, true, dryRun)
+	if err != nil {
+		return "", err
+	}
+	recent, err := createIndex(ctx, col, bson.D{{Key: "user_id", Value: 1}, {Key: "last_bought_at", Value: -1}}, false, dryRun)
+
+	return fmt.Sprintf("%d items backfilled, %s, %s", len(history), unique, recent), err
+}
+
+func categoryWordsIndex(ctx context.Context, database *mongo.Database, dryRun bool) (string, error) {
+	return createIndex(ctx, database.Collection(db.ColCategoryWords), bson.D{{Key: "user_id", Value: 1}, {Key: "word", Value: 1}}, true, dryRun)
+}
+
+// templatesAndJobsIndexes keep one template per name of a user and one job per ref, due jobs are found by run_at
+func templatesAndJobsIndexes(ctx context.Context, database *mongo.Database, dryRun bool) (string, error) {
+	templates, err := createIndex(ctx, database.Collection(db.ColTemplates), bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}}, true, dryRun)
+	if err != nil {
+		return "", err
+	}
+	jobs := database.Collection(db.ColJobs)
+	refs, err := createIndex(ctx, jobs, bson.D{{Key: "kind", Value: 1}, {Key: "ref_id", Value: 1}}, true, dryRun)
+	if err != nil {
+		return "", err
+	}
+	due, err := createIndex(ctx, jobs, bson.D{{Key: "run_at", Value: 1}}, false, dryRun)
+
+	return fmt.Sprintf("%s, %s, %s", templates, refs, due), err
+}
+
+// recipesIndex keeps one recipe per name of a user
+func recipesIndex(ctx context.Context, database *mongo.Database, dryRun bool) (string, error) {
+	return createIndex(ctx, database.Collection(db.ColRecipes), bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}}, true, dryRun)
+}
+
+// pantryIndex keeps one pantry item per name of a user
+func pantryIndex(ctx context.Context, database *mongo.Database, dryRun bool) (string, error) {
+	return createIndex(ctx, database.Collection(db.ColPantry), bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}}, true, dryRun)
+}
+
+func createTTLIndex(ctx context.Context, col *mongo.Collection, field string, ttl time.Duration, dryRun bool) (string, error) {
+	if dryRun {
+		return "ttl index would be created on " + col.Name(), nil
+	}
+	name, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(ttl.Seconds())),
+	})
+	if err != nil {
+		return "", err
+	}
+	return "ttl index " + name + " created on " + col.Name(), nil
+}
@@ -0,0 +1,120 @@
+// Package migrations keeps the collections in the shape the code expects.
+//
+// Migrations run in the order of their IDs, each applied migration is recorded
+// in the migrations collection and never runs again. A migration should check
+// the data it changes, so running it on an already migrated database is a noop.
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"time"
+)
+
+// UpFunc applies a migration, or only counts what would change when dryRun is set.
+// The returned summary is shown to an operator and saved with the record.
+type UpFunc func(ctx context.Context, database *mongo.Database, dryRun bool) (string, error)
+
+type Migration struct {
+	ID   int
+	Name string
+	Up   UpFunc
+}
+
+type Record struct {
+	ID        int                `json:"_id" bson:"_id"`
+	Name      string             `json:"name" bson:"name"`
+	Summary   string             `json:"summary" bson:"summary"`
+	AppliedAt primitive.DateTime `json:"applied_at" bson:"applied_at"`
+}
+
+type Status struct {
+	Migration Migration
+	Record    *Record
+}
+
+// GetStatus lists all the known migrations along with their records if they were applied
+func GetStatus(ctx context.Context, database *mongo.Database) ([]Status, error) {
+	cursor, err := database.Collection(db.ColMigrations).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	err = cursor.All(ctx, &records)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]*Record{}
+	for i := range records {
+		applied[records[i].ID] = &records[i]
+	}
+	var statuses []Status
+	for _, m := range sorted() {
+		statuses = append(statuses, Status{Migration: m, Record: applied[m.ID]})
+	}
+
+	return statuses, nil
+}
+
+// Run applies pending migrations one by one and stops at the first failure.
+// Every applied or, on a dry run, pending migration is passed to report.
+func Run(ctx context.Context, database *mongo.Database, dryRun bool, report func(m Migration, summary string)) error {
+	statuses, err := GetStatus(ctx, database)
+	if err != nil {
+		return err
+	}
+	for _, st := range statuses {
+		if st.Record != nil {
+			continue
+		}
+		logger.Info(ctx, "applying a migration", "id", st.Migration.ID, "name", st.Migration.Name, "dry_run", dryRun)
+		summary, err := st.Migration.Up(ctx, database, dryRun)
+		if err != nil {
+			return fmt.Errorf("migration %d %s: %v", st.Migration.ID, st.Migration.Name, err)
+		}
+		if !dryRun {
+			_, err = database.Collection(db.ColMigrations).InsertOne(ctx, Record{
+				ID:        st.Migration.ID,
+				Name:      st.Migration.Name,
+				Summary:   summary,
+				AppliedAt: primitive.NewDateTimeFromTime(time.Now()),
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d %s is applied, but not recorded: %v", st.Migration.ID, st.Migration.Name, err)
+			}
+		}
+		if report != nil {
+			report(st.Migration, summary)
+		}
+	}
+
+	return nil
+}
+
+func sorted() []Migration {
+	list := make([]Migration, len(All))
+	copy(list, All)
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+func createIndex(ctx context.Context, col *mongo.Collection, keys bson.D, unique bool, dryRun bool) (string, error) {
+	model := mongo.IndexModel{Keys: keys, Options: options.Index().SetUnique(unique)}
+	if dryRun {
+		return "index would be created on " + col.Name(), nil
+	}
+	name, err := col.Indexes().CreateOne(ctx, model)
+	if err != nil {
+		return "", err
+	}
+	return "index " + name + " created on " + col.Name(), nil
+}
@@ -0,0 +1,147 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/fakemongo"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAll(t *testing.T) {
+	ids, names := map[int]bool{}, map[string]bool{}
+	for i, m := range All {
+		if m.ID != i+1 {
+			t.Errorf("migration %s has ID %d, want %d", m.Name, m.ID, i+1)
+		}
+		if names[m.Name] || ids[m.ID] {
+			t.Errorf("migration %d %s is not unique", m.ID, m.Name)
+		}
+		if m.Up == nil {
+			t.Errorf("migration %d %s has no Up", m.ID, m.Name)
+		}
+		ids[m.ID], names[m.Name] = true, true
+	}
+}
+
+func TestRun(t *testing.T) {
+	failed := errors.New("failed")
+	tests := []struct {
+		name     string
+		dryRun   bool
+		failing  int
+		ran      []int
+		reported []int
+		inserts  int64
+		wantErr  string
+	}{
+		{
+			name:     "pending migrations run in the order of their IDs",
+			ran:      []int{1, 3, 4},
+			reported: []int{1, 3, 4},
+			inserts:  3,
+		},
+		{
+			name:     "a dry run reports and records nothing",
+			dryRun:   true,
+			ran:      []int{1, 3, 4},
+			reported: []int{1, 3, 4},
+		},
+		{
+			name:     "the first failure stops the run",
+			failing:  3,
+			ran:      []int{1, 3},
+			reported: []int{1},
+			inserts:  1,
+			wantErr:  "migration 3 third: failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran []int
+			up := func(id int) UpFunc {
+				return func(ctx context.Context, database *mongo.Database, dryRun bool) (string, error) {
+					ran = append(ran, id)
+					if dryRun != tt.dryRun {
+						t.Errorf("migration %d dryRun = %v, want %v", id, dryRun, tt.dryRun)
+					}
+					if id == tt.failing {
+						return "", failed
+					}
+					return "done", nil
+				}
+			}
+			defer func(all []Migration) {
+				All = all
+			}(All)
+			All = []Migration{
+				{ID: 4, Name: "fourth", Up: up(4)},
+				{ID: 2, Name: "second", Up: up(2)},
+				{ID: 1, Name: "first", Up: up(1)},
+				{ID: 3, Name: "third", Up: up(3)},
+			}
+			// the second migration is applied already
+			deployment := fakemongo.New(map[string]interface{}{
+				db.ColMigrations: Record{ID: 2, Name: "second"},
+			})
+			client, err := fakemongo.Connect(context.Background(), deployment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Disconnect(context.Background())
+
+			var reported []int
+			err = Run(context.Background(), client.Database(db.DbName), tt.dryRun, func(m Migration, summary string) {
+				reported = append(reported, m.ID)
+			})
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Run() error = %v, want %q", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(ran, tt.ran) || !reflect.DeepEqual(reported, tt.reported) {
+				t.Errorf("Run() ran %v and reported %v, want %v and %v", ran, reported, tt.ran, tt.reported)
+			}
+			if inserts := deployment.Commands("insert"); inserts != tt.inserts {
+				t.Errorf("Run() recorded %d migrations, want %d", inserts, tt.inserts)
+			}
+		})
+	}
+}
+
+func TestPurchaseListsRepairDictionary(t *testing.T) {
+	list := db.PurchaseList{
+		ItemsDictionary: []db.PurchaseItem{{Name: "молоко", Hash: "stale"}, {Name: "хлеб", Hash: db.PurchaseItemHash(db.GetMD5Hash("хлеб"))}},
+		Items:           []db.PurchaseItemHash{"stale", "orphan"},
+	}
+	tests := []struct {
+		name    string
+		dryRun  bool
+		updates int64
+	}{
+		{name: "repaired", updates: 1},
+		{name: "counted on a dry run", dryRun: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment := fakemongo.New(map[string]interface{}{db.ColProducts: list})
+			client, err := fakemongo.Connect(context.Background(), deployment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Disconnect(context.Background())
+
+			summary, err := purchaseListsRepairDictionary(context.Background(), client.Database(db.DbName), tt.dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "1 entries fixed in 1 lists, 1 items left without a name"; summary != want {
+				t.Errorf("summary = %q, want %q", summary, want)
+			}
+			if updates := deployment.Commands("update"); updates != tt.updates {
+				t.Errorf("%d lists updated, want %d", updates, tt.updates)
+			}
+		})
+	}
+}
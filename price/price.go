@@ -0,0 +1,81 @@
+// Package price reads and writes amounts of money, kept in kopecks.
+//
+// An item may carry its price after the name: "хлеб 45₽", "сыр 350,50 руб".
+// The currency is required there, so "молоко 2" stays an item named so.
+package price
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Max is the largest amount accepted, ten million rubles
+const Max = 10000000 * 100
+
+var (
+	amountRe = regexp.MustCompile(`^(\d{1,8})(?:[.,](\d{1,2}))?$`)
+	itemRe   = regexp.MustCompile(`(?i)^(.*\S)\s+(\d{1,8}(?:[.,]\d{1,2})?)\s*(?:₽|р|р\.|руб|руб\.|rub)$`)
+)
+
+// Parse reads an amount like "45", "45.5", "45,50" or "45 ₽"
+func Parse(text string) (int64, bool) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	for _, currency := range []string{"₽", "руб.", "руб", "р.", "р", "rub"} {
+		if strings.HasSuffix(text, currency) {
+			text = strings.TrimSpace(strings.TrimSuffix(text, currency))
+			break
+		}
+	}
+	parts := amountRe.FindStringSubmatch(text)
+	if parts == nil {
+		return 0, false
+	}
+	rubles, _ := strconv.ParseInt(parts[1], 10, 64)
+	kopecks := int64(0)
+	if parts[2] != "" {
+		kopecks, _ = strconv.ParseInt(parts[2], 10, 64)
+		if len(parts[2]) == 1 {
+			kopecks *= 10
+		}
+	}
+	amount := rubles*100 + kopecks
+	if amount > Max {
+		return 0, false
+	}
+	return amount, true
+}
+
+// Split cuts the price off an item, ok is false when the item has no price
+func Split(item string) (name string, amount int64, ok bool) {
+	parts := itemRe.FindStringSubmatch(strings.TrimSpace(item))
+	if parts == nil {
+		return item, 0, false
+	}
+	amount, ok = Parse(parts[2])
+	if !ok {
+		return item, 0, false
+	}
+	return parts[1], amount, true
+}
+
+// Format writes the amount as "45 ₽" or "45,50 ₽"
+func Format(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	text := sign + groupThousands(strconv.FormatInt(amount/100, 10))
+	if amount%100 != 0 {
+		text += "," + strconv.FormatInt(amount%100/10, 10) + strconv.FormatInt(amount%10, 10)
+	}
+	return text + " ₽"
+}
+
+func groupThousands(digits string) string {
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + " " + digits[i:]
+	}
+	return digits
+}
@@ -0,0 +1,81 @@
+package price
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text   string
+		amount int64
+		ok     bool
+	}{
+		{text: "45", amount: 4500, ok: true},
+		{text: "45.5", amount: 4550, ok: true},
+		{text: "45,05", amount: 4505, ok: true},
+		{text: " 45 ₽ ", amount: 4500, ok: true},
+		{text: "350,50 РУБ.", amount: 35050, ok: true},
+		{text: "12р", amount: 1200, ok: true},
+		{text: "10000000", amount: Max, ok: true},
+		{text: "10000000,01"},
+		{text: "45,505"},
+		{text: "-45"},
+		{text: "45$"},
+		{text: "сорок"},
+		{text: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			amount, ok := Parse(tt.text)
+			if amount != tt.amount || ok != tt.ok {
+				t.Errorf("Parse(%q) = %d, %v, want %d, %v", tt.text, amount, ok, tt.amount, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		item   string
+		name   string
+		amount int64
+		ok     bool
+	}{
+		{item: "хлеб 45₽", name: "хлеб", amount: 4500, ok: true},
+		{item: "сыр 350,50 руб", name: "сыр", amount: 35050, ok: true},
+		{item: "молоко 2 л 89 р.", name: "молоко 2 л", amount: 8900, ok: true},
+		{item: "кофе 499 RUB", name: "кофе", amount: 49900, ok: true},
+		{item: "молоко 2", name: "молоко 2"},
+		{item: "45₽", name: "45₽"},
+		{item: "хлеб 45,555 ₽", name: "хлеб 45,555 ₽"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.item, func(t *testing.T) {
+			name, amount, ok := Split(tt.item)
+			if name != tt.name || amount != tt.amount || ok != tt.ok {
+				t.Errorf("Split(%q) = %q, %d, %v, want %q, %d, %v", tt.item, name, amount, ok, tt.name, tt.amount, tt.ok)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		amount int64
+		want   string
+	}{
+		{amount: 0, want: "0 ₽"},
+		{amount: 4500, want: "45 ₽"},
+		{amount: 4550, want: "45,50 ₽"},
+		{amount: 4505, want: "45,05 ₽"},
+		{amount: 123456700, want: "1 234 567 ₽"},
+		{amount: -100050, want: "-1 000,50 ₽"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := Format(tt.amount); got != tt.want {
+				t.Errorf("Format(%d) = %q, want %q", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
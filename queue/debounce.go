@@ -0,0 +1,36 @@
+package queue
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+)
+
+// DebounceStore remembers the latest render requested for a list.
+// db.DebounceService shares it between bot instances, MemoryDebounce serves a single one.
+type DebounceStore interface {
+	SetLast(ctx context.Context, id primitive.ObjectID, random int) error
+	IsLast(ctx context.Context, id primitive.ObjectID, random int) (bool, error)
+}
+
+type MemoryDebounce struct {
+	messages map[primitive.ObjectID]int
+	mu       sync.Mutex
+}
+
+func NewMemoryDebounce() *MemoryDebounce {
+	return &MemoryDebounce{messages: make(map[primitive.ObjectID]int)}
+}
+
+func (m *MemoryDebounce) SetLast(ctx context.Context, id primitive.ObjectID, random int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages[id] = random
+	return nil
+}
+
+func (m *MemoryDebounce) IsLast(ctx context.Context, id primitive.ObjectID, random int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.messages[id] == random, nil
+}
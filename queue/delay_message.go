@@ -1,53 +1,118 @@
 package queue
 
 import (
+	"context"
 	"github.com/boryashkin/purchaselist/db"
 	"github.com/boryashkin/purchaselist/dialog"
+	"github.com/boryashkin/purchaselist/logger"
 	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/boryashkin/purchaselist/tracing"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"log"
+	"go.opentelemetry.io/otel/attribute"
+	"sync"
 	"time"
 )
 
 type DelayMessage struct {
-	messages     map[primitive.ObjectID]int
+	store        DebounceStore
 	fn           dialog.BotReply
 	pListService *db.PurchaseListService
+	delay        time.Duration
+	pending      *sync.WaitGroup
+	flush        chan struct{}
+	flushOnce    *sync.Once
 }
 
-func NewDelayMessage(fn dialog.BotReply, pListService *db.PurchaseListService) DelayMessage {
+func NewDelayMessage(fn dialog.BotReply, pListService *db.PurchaseListService, delay time.Duration, store DebounceStore) DelayMessage {
 	return DelayMessage{
-		messages:     make(map[primitive.ObjectID]int),
+		store:        store,
 		fn:           fn,
 		pListService: pListService,
+		delay:        delay,
+		pending:      &sync.WaitGroup{},
+		flush:        make(chan struct{}),
+		flushOnce:    &sync.Once{},
 	}
 }
 
-func (d *DelayMessage) SetLastDate(id primitive.ObjectID, random int) {
-	d.messages[id] = random
+func (d *DelayMessage) SetLastDate(ctx context.Context, id primitive.ObjectID, random int) {
+	err := d.store.SetLast(ctx, id, random)
+	if err != nil {
+		logger.Error(ctx, "failed to set the last message", "list_id", id, "err", err)
+	}
+}
+
+func (d *DelayMessage) isLast(ctx context.Context, id primitive.ObjectID, random int) bool {
+	last, err := d.store.IsLast(ctx, id, random)
+	if err != nil {
+		// sending an extra render is better than sending none
+		logger.Error(ctx, "failed to check the last message", "list_id", id, "err", err)
+		return true
+	}
+	return last
+}
+
+// Schedule runs ExecItem in background, Flush waits for it
+func (d *DelayMessage) Schedule(ctx context.Context, bot *tgbotapi.BotAPI, chatMsgID dialog.ChatMessageID, reply dialog.MessageForReply) {
+	d.pending.Add(1)
+	metrics.QueuePending.Inc()
+	go func() {
+		defer d.pending.Done()
+		defer metrics.QueuePending.Dec()
+		d.ExecItem(ctx, bot, chatMsgID, reply)
+	}()
 }
 
-func (d *DelayMessage) ExecItem(bot *tgbotapi.BotAPI, chatMsgID dialog.ChatMessageID, reply dialog.MessageForReply) {
+// Flush wakes up the delayed messages, so the latest of each list is sent right away,
+// and waits for them to be sent until the context is done
+func (d *DelayMessage) Flush(ctx context.Context) error {
+	d.flushOnce.Do(func() {
+		close(d.flush)
+	})
+	done := make(chan struct{})
+	go func() {
+		d.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *DelayMessage) ExecItem(ctx context.Context, bot *tgbotapi.BotAPI, chatMsgID dialog.ChatMessageID, reply dialog.MessageForReply) {
+	ctx, span := tracing.Start(ctx, "queue.exec_item", attribute.Bool("delayed", reply.CreatedAt != nil))
+	defer span.End()
 	if reply.CreatedAt != nil {
-		time.Sleep(time.Millisecond * 500)
-		if reply.Rand == d.messages[reply.PListID] {
+		_, wait := tracing.Start(ctx, "queue.debounce")
+		select {
+		case <-time.After(d.delay):
+		case <-d.flush:
+		}
+		wait.End()
+		last := d.isLast(ctx, reply.PListID, reply.Rand)
+		span.SetAttributes(attribute.Bool("skipped", !last))
+		if last {
 			metrics.QueueExecItem.With(prometheus.Labels{"action": "exec_delayed"}).Inc()
-			sent, err := d.fn(bot, chatMsgID, reply)
-			if err == nil {
+			sent, err := d.fn(ctx, bot, chatMsgID, reply)
+			// edits of inline messages come back without a chat
+			if err == nil && sent.Chat != nil {
 				msgID := db.TgMsgID{
 					TgChatID:    sent.Chat.ID,
 					TgMessageID: sent.MessageID,
 				}
-				d.pListService.AddMsgID(reply.PListID, msgID)
+				d.pListService.AddMsgID(ctx, reply.PListID, msgID)
 			}
 		} else {
 			metrics.QueueExecItem.With(prometheus.Labels{"action": "skip"}).Inc()
-			log.Println("[queue] skip")
+			logger.Debug(ctx, "delayed message skipped, a newer one is scheduled", "list_id", reply.PListID)
 		}
 	} else {
 		metrics.QueueExecItem.With(prometheus.Labels{"action": "no_delay"}).Inc()
-		d.fn(bot, chatMsgID, reply)
+		d.fn(ctx, bot, chatMsgID, reply)
 	}
 }
@@ -0,0 +1,51 @@
+package queue
+
+import (
+	"sync"
+)
+
+// Offsets track the updates being handled to tell the polling offset which loses none of them.
+// Updates are handled concurrently and finish out of order, so the offset is the lowest update
+// still running rather than the highest one finished.
+type Offsets struct {
+	mu       sync.Mutex
+	inFlight map[int]int
+	next     int
+}
+
+func NewOffsets() *Offsets {
+	return &Offsets{inFlight: make(map[int]int)}
+}
+
+// Start is called when an update is received, before it is handled
+func (o *Offsets) Start(updateID int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.inFlight[updateID]++
+	if updateID >= o.next {
+		o.next = updateID + 1
+	}
+}
+
+// Done is called once the update is handled or skipped as a duplicate
+func (o *Offsets) Done(updateID int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.inFlight[updateID]--
+	if o.inFlight[updateID] <= 0 {
+		delete(o.inFlight, updateID)
+	}
+}
+
+// Offset is the lowest update not done yet, every update before it is done. It is 0 before the first update.
+func (o *Offsets) Offset() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	offset := o.next
+	for updateID := range o.inFlight {
+		if updateID < offset {
+			offset = updateID
+		}
+	}
+	return offset
+}
@@ -0,0 +1,134 @@
+package queue
+
+import (
+	"context"
+	"github.com/boryashkin/purchaselist/db"
+	"github.com/boryashkin/purchaselist/logger"
+	"github.com/boryashkin/purchaselist/metrics"
+	"github.com/boryashkin/purchaselist/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"sync"
+	"time"
+)
+
+const (
+	// schedulerLockTTL is longer than any job runs, the job of a dead instance is run again after it
+	schedulerLockTTL = 5 * time.Minute
+	// schedulerMaxAttempts of a failing job, then it waits for its next run
+	schedulerMaxAttempts = 5
+	schedulerRetryDelay  = time.Minute
+)
+
+// JobHandler runs a due job and returns the time of its next run, a zero time ends the job
+type JobHandler func(ctx context.Context, job db.Job) (time.Time, error)
+
+// Scheduler runs the jobs kept in the database. Every instance may run one,
+// a job is claimed by a single instance at a time.
+type Scheduler struct {
+	jobs     *db.JobService
+	handlers map[string]JobHandler
+	holder   string
+	interval time.Duration
+	running  *sync.WaitGroup
+}
+
+func NewScheduler(jobs *db.JobService, holder string, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		jobs:     jobs,
+		handlers: map[string]JobHandler{},
+		holder:   holder,
+		interval: interval,
+		running:  &sync.WaitGroup{},
+	}
+}
+
+// Handle registers the handler of a job kind, before Run
+func (s *Scheduler) Handle(kind string, handler JobHandler) {
+	s.handlers[kind] = handler
+}
+
+// Run checks for due jobs every interval until the context is done.
+// The jobs missed while the bot was down are due at start, each of them runs once.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.running.Add(1)
+	defer s.running.Done()
+	for {
+		s.runDue(ctx)
+		select {
+		case <-time.After(s.interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Wait for the job being run to finish until the context is done
+func (s *Scheduler) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) runDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, found, err := s.jobs.Claim(ctx, time.Now(), s.holder, schedulerLockTTL)
+		if err != nil {
+			logger.Error(ctx, "failed to claim a job", "err", err)
+			return
+		}
+		if !found {
+			return
+		}
+		// a job started before the shutdown is finished, the context of the loop is done by then
+		s.run(logger.WithCorrelation(context.Background(), "j"+job.Id.Hex()), job)
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, job db.Job) {
+	ctx, span := tracing.Start(ctx, "job",
+		attribute.String("job.kind", job.Kind),
+		attribute.String("job.ref_id", job.RefID.Hex()),
+	)
+	handler, found := s.handlers[job.Kind]
+	if !found {
+		logger.Warn(ctx, "no handler of a job, it is dropped", "kind", job.Kind)
+		metrics.SchedulerJob.With(prometheus.Labels{"kind": job.Kind, "result": "unknown"}).Inc()
+		s.finish(ctx, job, time.Time{}, 0)
+		span.End()
+		return
+	}
+	next, err := handler(ctx, job)
+	defer tracing.End(span, err)
+	attempts := 0
+	if err != nil {
+		metrics.SchedulerJob.With(prometheus.Labels{"kind": job.Kind, "result": "error"}).Inc()
+		attempts = job.Attempts + 1
+		if attempts < schedulerMaxAttempts {
+			logger.Warn(ctx, "job failed, it is retried", "kind", job.Kind, "attempts", attempts, "err", err)
+			next = time.Now().Add(schedulerRetryDelay * time.Duration(attempts))
+		} else {
+			logger.Error(ctx, "job failed, it waits for the next run", "kind", job.Kind, "attempts", attempts, "err", err)
+			attempts = 0
+		}
+	} else {
+		metrics.SchedulerJob.With(prometheus.Labels{"kind": job.Kind, "result": "success"}).Inc()
+	}
+	s.finish(ctx, job, next, attempts)
+}
+
+func (s *Scheduler) finish(ctx context.Context, job db.Job, next time.Time, attempts int) {
+	err := s.jobs.Finish(ctx, job, next, attempts)
+	if err != nil {
+		// the lock expires and the job runs again
+		logger.Error(ctx, "failed to finish a job", "kind", job.Kind, "err", err)
+	}
+}
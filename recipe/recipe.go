@@ -0,0 +1,147 @@
+// Package recipe reads the ingredients of recipes and scales them to a number of servings.
+//
+// An ingredient is a name with an amount after it: "свёкла 100 г", "яйца 2", "соль".
+// Grams and kilograms, millilitres and litres are kept in the smaller unit,
+// so the same ingredients add up: "лук 150 г" and "лук 0,5 кг" make "лук 650 г".
+// An ingredient without an amount isn't scaled.
+package recipe
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxServings a recipe is scaled to
+const MaxServings = 100
+
+var (
+	ingredientRe = regexp.MustCompile(`^(.+?)\s+(\d{1,6}(?:[.,]\d{1,3})?)\s*([\p{L}.]{0,8})$`)
+	servingsRe   = regexp.MustCompile(`(?i)^(.+?)\s+на\s+(\d{1,3})(?:\s+(?:порц\p{L}*|персон\p{L}*|человек\p{L}*|чел\.?))?$`)
+
+	// units are kept in the base one, the factor converts to it
+	units = map[string]unit{
+		"":       {"шт", 1},
+		"шт":     {"шт", 1},
+		"шт.":    {"шт", 1},
+		"штук":   {"шт", 1},
+		"штуки":  {"шт", 1},
+		"штука":  {"шт", 1},
+		"г":      {"г", 1},
+		"г.":     {"г", 1},
+		"гр":     {"г", 1},
+		"гр.":    {"г", 1},
+		"грамм":  {"г", 1},
+		"грамма": {"г", 1},
+		"кг":     {"г", 1000},
+		"кг.":    {"г", 1000},
+		"мл":     {"мл", 1},
+		"мл.":    {"мл", 1},
+		"л":      {"мл", 1000},
+		"л.":     {"мл", 1000},
+		"литр":   {"мл", 1000},
+		"литра":  {"мл", 1000},
+	}
+	// larger units the base ones are written in from a thousand on
+	larger = map[string]string{"г": "кг", "мл": "л"}
+)
+
+type unit struct {
+	base   string
+	factor float64
+}
+
+type Ingredient struct {
+	Name   string  `json:"name" bson:"name"`
+	Amount float64 `json:"amount,omitempty" bson:"amount,omitempty"`
+	// Unit is "г", "мл", "шт" or another one as written, like "ст.л."
+	Unit string `json:"unit,omitempty" bson:"unit,omitempty"`
+}
+
+// ParseIngredient reads "свёкла 100 г", "яйца 2" or "соль", ok is false for an empty text
+func ParseIngredient(text string) (Ingredient, bool) {
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return Ingredient{}, false
+	}
+	parts := ingredientRe.FindStringSubmatch(text)
+	if parts == nil {
+		return Ingredient{Name: text}, true
+	}
+	amount, err := strconv.ParseFloat(strings.Replace(parts[2], ",", ".", 1), 64)
+	if err != nil || amount <= 0 {
+		return Ingredient{Name: text}, true
+	}
+	written := strings.ToLower(parts[3])
+	u, known := units[written]
+	if !known {
+		u = unit{written, 1}
+	}
+	return Ingredient{Name: parts[1], Amount: amount * u.factor, Unit: u.base}, true
+}
+
+// Cut takes the servings off a dish: "борщ на 6" or "борщ на 6 порций" is "борщ" for 6
+func Cut(text string) (string, int, bool) {
+	parts := servingsRe.FindStringSubmatch(strings.TrimSpace(text))
+	if parts == nil {
+		return text, 0, false
+	}
+	servings, _ := strconv.Atoi(parts[2])
+	if servings < 1 || servings > MaxServings {
+		return text, 0, false
+	}
+	return parts[1], servings, true
+}
+
+// Scale the amount of the ingredient by the factor
+func (i Ingredient) Scale(factor float64) Ingredient {
+	i.Amount *= factor
+	return i
+}
+
+// Key is the same for the ingredients which add up
+func (i Ingredient) Key() string {
+	return strings.ToLower(i.Name) + "|" + i.Unit
+}
+
+// Add the amounts of the same ingredient, ok is false when the names or units differ
+func Add(a, b Ingredient) (Ingredient, bool) {
+	if a.Key() != b.Key() {
+		return a, false
+	}
+	a.Amount += b.Amount
+	return a, true
+}
+
+// String is the ingredient as an item of a list: "свёкла 600 г", "лук 1,2 кг", "яйца 3 шт"
+func (i Ingredient) String() string {
+	if i.Amount == 0 {
+		return i.Name
+	}
+	return i.Name + " " + i.AmountString()
+}
+
+// AmountString is the amount alone: "600 г", "1,2 кг", "3 шт"
+func (i Ingredient) AmountString() string {
+	switch i.Unit {
+	case "г", "мл":
+		amount := math.Max(1, math.Round(i.Amount))
+		if amount >= 1000 {
+			return formatAmount(amount/1000, 2) + " " + larger[i.Unit]
+		}
+		return formatAmount(amount, 0) + " " + i.Unit
+	case "шт":
+		// a piece can't be bought in halves
+		return formatAmount(math.Ceil(i.Amount-0.01), 0) + " " + i.Unit
+	}
+	return formatAmount(i.Amount, 1) + " " + i.Unit
+}
+
+func formatAmount(amount float64, decimals int) string {
+	text := strconv.FormatFloat(amount, 'f', decimals, 64)
+	if strings.Contains(text, ".") {
+		text = strings.TrimRight(strings.TrimRight(text, "0"), ".")
+	}
+	return strings.Replace(text, ".", ",", 1)
+}
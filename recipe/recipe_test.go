@@ -0,0 +1,121 @@
+package recipe
+
+import (
+	"testing"
+)
+
+func TestParseIngredient(t *testing.T) {
+	tests := []struct {
+		text string
+		want Ingredient
+		ok   bool
+	}{
+		{text: "свёкла 400 г", want: Ingredient{Name: "свёкла", Amount: 400, Unit: "г"}, ok: true},
+		{text: "лук 0,5 кг", want: Ingredient{Name: "лук", Amount: 500, Unit: "г"}, ok: true},
+		{text: "молоко 1.5л", want: Ingredient{Name: "молоко", Amount: 1500, Unit: "мл"}, ok: true},
+		{text: "картофель 3", want: Ingredient{Name: "картофель", Amount: 3, Unit: "шт"}, ok: true},
+		{text: "яйца 2 ШТ.", want: Ingredient{Name: "яйца", Amount: 2, Unit: "шт"}, ok: true},
+		{text: "сахар 2 ст.л.", want: Ingredient{Name: "сахар", Amount: 2, Unit: "ст.л."}, ok: true},
+		{text: "  сметана   200  мл ", want: Ingredient{Name: "сметана", Amount: 200, Unit: "мл"}, ok: true},
+		{text: "соль", want: Ingredient{Name: "соль"}, ok: true},
+		{text: "вода 0 мл", want: Ingredient{Name: "вода 0 мл"}, ok: true},
+		{text: "  "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, ok := ParseIngredient(tt.text)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("ParseIngredient(%q) = %+v, %v, want %+v, %v", tt.text, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCut(t *testing.T) {
+	tests := []struct {
+		text     string
+		name     string
+		servings int
+		ok       bool
+	}{
+		{text: "Борщ на 6", name: "Борщ", servings: 6, ok: true},
+		{text: "борщ на 4 порции", name: "борщ", servings: 4, ok: true},
+		{text: "Салат оливье на 10 человек", name: "Салат оливье", servings: 10, ok: true},
+		{text: "плов на 2 чел.", name: "плов", servings: 2, ok: true},
+		{text: "борщ на 0", name: "борщ на 0"},
+		{text: "борщ на 101", name: "борщ на 101"},
+		{text: "борщ", name: "борщ"},
+		{text: "на 6", name: "на 6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			name, servings, ok := Cut(tt.text)
+			if name != tt.name || servings != tt.servings || ok != tt.ok {
+				t.Errorf("Cut(%q) = %q, %d, %v, want %q, %d, %v", tt.text, name, servings, ok, tt.name, tt.servings, tt.ok)
+			}
+		})
+	}
+}
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want string
+		ok   bool
+	}{
+		{name: "grams and kilograms", a: "лук 150 г", b: "лук 1 кг", want: "лук 1,15 кг", ok: true},
+		{name: "below a kilogram", a: "лук 150 г", b: "лук 0,5 кг", want: "лук 650 г", ok: true},
+		{name: "millilitres and litres", a: "молоко 500 мл", b: "Молоко 1 л", want: "молоко 1,5 л", ok: true},
+		{name: "pieces", a: "яйца 2", b: "яйца 3 шт", want: "яйца 5 шт", ok: true},
+		{name: "unknown units of the same kind", a: "сахар 1 ст.л.", b: "сахар 2,5 ст.л.", want: "сахар 3,5 ст.л.", ok: true},
+		{name: "without an amount", a: "соль", b: "соль", want: "соль", ok: true},
+		{name: "different units", a: "лук 150 г", b: "лук 2", want: "лук 150 г"},
+		{name: "an amount and none", a: "соль 10 г", b: "соль", want: "соль 10 г"},
+		{name: "different names", a: "лук 150 г", b: "чеснок 10 г", want: "лук 150 г"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := ParseIngredient(tt.a)
+			b, _ := ParseIngredient(tt.b)
+			sum, ok := Add(a, b)
+			if sum.String() != tt.want || ok != tt.ok {
+				t.Errorf("Add(%q, %q) = %q, %v, want %q, %v", tt.a, tt.b, sum, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		ingredient Ingredient
+		want       string
+	}{
+		{ingredient: Ingredient{Name: "свёкла", Amount: 600, Unit: "г"}, want: "свёкла 600 г"},
+		{ingredient: Ingredient{Name: "свёкла", Amount: 0.2, Unit: "г"}, want: "свёкла 1 г"},
+		{ingredient: Ingredient{Name: "мука", Amount: 1234.4, Unit: "г"}, want: "мука 1,23 кг"},
+		{ingredient: Ingredient{Name: "вода", Amount: 2000, Unit: "мл"}, want: "вода 2 л"},
+		{ingredient: Ingredient{Name: "яйца", Amount: 1.5, Unit: "шт"}, want: "яйца 2 шт"},
+		{ingredient: Ingredient{Name: "яйца", Amount: 3.004, Unit: "шт"}, want: "яйца 3 шт"},
+		{ingredient: Ingredient{Name: "сахар", Amount: 1.25, Unit: "ст.л."}, want: "сахар 1,2 ст.л."},
+		{ingredient: Ingredient{Name: "соль"}, want: "соль"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.ingredient.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScale(t *testing.T) {
+	beet := Ingredient{Name: "свёкла", Amount: 100, Unit: "г"}
+	if got := beet.Scale(6).String(); got != "свёкла 600 г" {
+		t.Errorf("Scale(6) = %q, want %q", got, "свёкла 600 г")
+	}
+	if beet.Amount != 100 {
+		t.Errorf("Scale changed the ingredient to %v", beet)
+	}
+}
@@ -0,0 +1,153 @@
+package schedule
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultDueMinute is the time of a due day written without a time
+const DefaultDueMinute = 10 * 60
+
+var ErrDue = errors.New("due time is not a day or a time in the future")
+
+var (
+	dateRe = regexp.MustCompile(`^(\d{1,2})\.(\d{1,2})$`)
+
+	// dueStems match the forms of a single day: к субботе, в пятницу, до понедельника.
+	// They are longer than the stems of a schedule, so "к средству" isn't a day.
+	dueStems = map[string]time.Weekday{
+		"понедельник": time.Monday,
+		"вторник":     time.Tuesday,
+		"сред":        time.Wednesday,
+		"четверг":     time.Thursday,
+		"пятниц":      time.Friday,
+		"суббот":      time.Saturday,
+		"воскресень":  time.Sunday,
+		"выходн":      time.Saturday,
+	}
+	relativeDays = map[string]int{"сегодня": 0, "завтра": 1, "послезавтра": 2}
+	dueFillers   = map[string]bool{"к": true, "ко": true, "до": true, "в": true, "во": true, "на": true}
+	dueMarkers   = map[string]bool{"к": true, "ко": true, "до": true}
+)
+
+// ParseDue reads a due time after now: "18:30", "завтра", "к субботе 12:00", "25.10", "через 2 часа".
+// The time is in the location of now, a day without a time is due at DefaultDueMinute.
+func ParseDue(text string, now time.Time) (time.Time, error) {
+	tokens := strings.Fields(strings.ToLower(strings.ReplaceAll(text, ",", " ")))
+	if len(tokens) > 0 && tokens[0] == "через" {
+		return parseIn(tokens[1:], now)
+	}
+	minute, clocked := 0, false
+	year, month, day := now.Date()
+	dated, weekly := false, false
+	for _, token := range tokens {
+		token = strings.TrimRight(token, ".")
+		if dueFillers[token] {
+			continue
+		}
+		if m, ok := parseClock(token); ok && strings.Contains(token, ":") && !clocked {
+			minute, clocked = m, true
+			continue
+		}
+		if dated {
+			return time.Time{}, ErrDue
+		}
+		dated = true
+		if shift, found := relativeDays[token]; found {
+			day += shift
+		} else if weekday, found := parseDueDay(token); found {
+			day += (int(weekday) - int(now.Weekday()) + 7) % 7
+			weekly = true
+		} else if parts := dateRe.FindStringSubmatch(token); parts != nil {
+			day, _ = strconv.Atoi(parts[1])
+			m, _ := strconv.Atoi(parts[2])
+			month = time.Month(m)
+			if date := time.Date(year, month, day, 0, 0, 0, 0, now.Location()); date.Day() != day || date.Month() != month {
+				return time.Time{}, ErrDue
+			}
+			if time.Date(year, month, day+1, 0, 0, 0, 0, now.Location()).Before(now) {
+				year++
+			}
+		} else {
+			return time.Time{}, ErrDue
+		}
+	}
+	if !clocked && !dated {
+		return time.Time{}, ErrDue
+	}
+	if !clocked {
+		minute = DefaultDueMinute
+	}
+	due := time.Date(year, month, day, minute/60, minute%60, 0, 0, now.Location())
+	if !due.After(now) {
+		switch {
+		case !dated:
+			due = due.AddDate(0, 0, 1)
+		case weekly:
+			due = due.AddDate(0, 0, 7)
+		default:
+			return time.Time{}, ErrDue
+		}
+	}
+	return due, nil
+}
+
+// CutDue takes a due time marked by "к" or "до" off the end of an item: "торт к субботе" is "торт" due on Saturday
+func CutDue(text string, now time.Time) (string, time.Time, bool) {
+	tokens := strings.Fields(text)
+	for i := len(tokens) - 1; i > 0; i-- {
+		if !dueMarkers[strings.ToLower(tokens[i])] {
+			continue
+		}
+		due, err := ParseDue(strings.Join(tokens[i:], " "), now)
+		if err != nil {
+			return text, time.Time{}, false
+		}
+		return strings.Join(tokens[:i], " "), due, true
+	}
+	return text, time.Time{}, false
+}
+
+// parseIn reads the rest of "через 2 часа", "через час" or "через 30 минут"
+func parseIn(tokens []string, now time.Time) (time.Time, error) {
+	n := 1
+	if len(tokens) > 1 {
+		v, err := strconv.Atoi(tokens[0])
+		if err != nil || v <= 0 {
+			return time.Time{}, ErrDue
+		}
+		n, tokens = v, tokens[1:]
+	}
+	if len(tokens) != 1 {
+		return time.Time{}, ErrDue
+	}
+	switch unit := tokens[0]; {
+	case unit == "полчаса":
+		return now.Add(30 * time.Minute), nil
+	case strings.HasPrefix(unit, "мин"):
+		return now.Add(time.Duration(n) * time.Minute), nil
+	case strings.HasPrefix(unit, "час"):
+		return now.Add(time.Duration(n) * time.Hour), nil
+	case strings.HasPrefix(unit, "ден"), strings.HasPrefix(unit, "дн"):
+		return now.AddDate(0, 0, n), nil
+	}
+	return time.Time{}, ErrDue
+}
+
+func parseDueDay(word string) (time.Weekday, bool) {
+	for day, short := range shortDays {
+		if word == short {
+			return day, true
+		}
+	}
+	for stem, day := range dueStems {
+		if strings.HasPrefix(word, stem) && utf8.RuneCountInString(word)-utf8.RuneCountInString(stem) <= 2 {
+			return day, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,87 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDue(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	// a Monday
+	now := time.Date(2026, 10, 19, 15, 30, 0, 0, moscow)
+	at := func(month time.Month, day int, hour int, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, moscow)
+	}
+	tests := []struct {
+		text    string
+		want    time.Time
+		wantErr bool
+	}{
+		{text: "18:30", want: at(10, 19, 18, 30)},
+		{text: "9:00", want: at(10, 20, 9, 0)},
+		{text: "завтра", want: at(10, 20, 10, 0)},
+		{text: "Завтра, 9:00", want: at(10, 20, 9, 0)},
+		{text: "послезавтра в 7:15", want: at(10, 21, 7, 15)},
+		{text: "к субботе", want: at(10, 24, 10, 0)},
+		{text: "до выходных", want: at(10, 24, 10, 0)},
+		{text: "пн 16:00", want: at(10, 19, 16, 0)},
+		{text: "в понедельник 12:00", want: at(10, 26, 12, 0)},
+		{text: "25.10 18:00", want: at(10, 25, 18, 0)},
+		{text: "01.10", want: time.Date(2027, 10, 1, 10, 0, 0, 0, moscow)},
+		{text: "через 2 часа", want: at(10, 19, 17, 30)},
+		{text: "через час", want: at(10, 19, 16, 30)},
+		{text: "через полчаса", want: at(10, 19, 16, 0)},
+		{text: "через 45 минут", want: at(10, 19, 16, 15)},
+		{text: "через 3 дня", want: at(10, 22, 15, 30)},
+		{text: "сегодня", wantErr: true},
+		{text: "19.10", wantErr: true},
+		{text: "31.02", wantErr: true},
+		{text: "завтра в пятницу", wantErr: true},
+		{text: "к средству", wantErr: true},
+		{text: "через 0 часов", wantErr: true},
+		{text: "через", wantErr: true},
+		{text: "25:00", wantErr: true},
+		{text: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := ParseDue(tt.text, now)
+			if tt.wantErr {
+				if err != ErrDue {
+					t.Errorf("ParseDue(%q) = %v, %v, want %v", tt.text, got, err, ErrDue)
+				}
+				return
+			}
+			if err != nil || !got.Equal(tt.want) {
+				t.Errorf("ParseDue(%q) = %v, %v, want %v", tt.text, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCutDue(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	now := time.Date(2026, 10, 19, 15, 30, 0, 0, moscow)
+	tests := []struct {
+		text string
+		item string
+		due  time.Time
+		ok   bool
+	}{
+		{text: "торт к субботе", item: "торт", due: time.Date(2026, 10, 24, 10, 0, 0, 0, moscow), ok: true},
+		{text: "торт К субботе 12:00", item: "торт", due: time.Date(2026, 10, 24, 12, 0, 0, 0, moscow), ok: true},
+		{text: "хлеб до 18:00", item: "хлеб", due: time.Date(2026, 10, 19, 18, 0, 0, 0, moscow), ok: true},
+		{text: "молоко 2 л к завтра", item: "молоко 2 л", due: time.Date(2026, 10, 20, 10, 0, 0, 0, moscow), ok: true},
+		{text: "печенье к чаю", item: "печенье к чаю"},
+		{text: "к субботе", item: "к субботе"},
+		{text: "молоко", item: "молоко"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			item, due, ok := CutDue(tt.text, now)
+			if item != tt.item || !due.Equal(tt.due) || ok != tt.ok {
+				t.Errorf("CutDue(%q) = %q, %v, %v, want %q, %v, %v", tt.text, item, due, ok, tt.item, tt.due, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+// Package schedule reads weekly schedules like "пт 18:00" and works out their next run.
+//
+// A schedule is a time of day and the days of the week it runs on, no days
+// mean every day. The time is local to the timezone of the user, so a list
+// scheduled for 18:00 comes at 18:00 after a DST change as well.
+package schedule
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSchedule = errors.New("schedule is not days and a time")
+	ErrTimezone = errors.New("unknown timezone")
+)
+
+type Weekly struct {
+	// Days of the week, empty means every day
+	Days []time.Weekday `json:"days,omitempty" bson:"days,omitempty"`
+	// Minute of the day
+	Minute int `json:"minute" bson:"minute"`
+}
+
+var (
+	clockRe  = regexp.MustCompile(`^(\d{1,2})[:.](\d{2})$`)
+	offsetRe = regexp.MustCompile(`(?i)^(?:utc|gmt)?\s*([+-])(\d{1,2})(?::?(\d{2}))?$`)
+
+	// week starts on Monday in the names and in the text of a schedule
+	week      = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+	shortDays = map[time.Weekday]string{
+		time.Monday: "пн", time.Tuesday: "вт", time.Wednesday: "ср", time.Thursday: "чт",
+		time.Friday: "пт", time.Saturday: "сб", time.Sunday: "вс",
+	}
+	// dayStems match the forms of the day names: пятница, пятницу, пятницам
+	dayStems = map[string][]time.Weekday{
+		"понед":  {time.Monday},
+		"вторн":  {time.Tuesday},
+		"сред":   {time.Wednesday},
+		"четв":   {time.Thursday},
+		"пятн":   {time.Friday},
+		"субб":   {time.Saturday},
+		"воскр":  {time.Sunday},
+		"будн":   {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		"выходн": {time.Saturday, time.Sunday},
+	}
+	everyDay = map[string]bool{"ежедневно": true, "день": true, "дни": true}
+	fillers  = map[string]bool{"каждый": true, "каждую": true, "каждое": true, "каждые": true, "по": true, "в": true, "во": true, "и": true}
+)
+
+// Cut takes a schedule off the end of the text: "Продукты пт 18:00" is "Продукты" every Friday at 18:00
+func Cut(text string) (string, Weekly, bool) {
+	tokens := strings.Fields(strings.ReplaceAll(text, ",", " "))
+	if len(tokens) == 0 {
+		return text, Weekly{}, false
+	}
+	minute, ok := parseClock(tokens[len(tokens)-1])
+	if !ok {
+		return text, Weekly{}, false
+	}
+	w := Weekly{Minute: minute}
+	days := map[time.Weekday]bool{}
+	all := false
+	i := len(tokens) - 1
+	for ; i > 0; i-- {
+		word := strings.ToLower(strings.Trim(tokens[i-1], ".:"))
+		if fillers[word] {
+			continue
+		}
+		if everyDay[word] {
+			all = true
+			continue
+		}
+		found := parseDays(word)
+		if found == nil {
+			break
+		}
+		for _, day := range found {
+			days[day] = true
+		}
+	}
+	if !all && len(days) < len(week) {
+		for _, day := range week {
+			if days[day] {
+				w.Days = append(w.Days, day)
+			}
+		}
+	}
+
+	return strings.Join(tokens[:i], " "), w, true
+}
+
+// Parse reads a schedule alone, like "пн, ср 9:30" or "ежедневно 20:00"
+func Parse(text string) (Weekly, error) {
+	head, w, ok := Cut(text)
+	if !ok || head != "" {
+		return Weekly{}, ErrSchedule
+	}
+	return w, nil
+}
+
+func parseClock(text string) (int, bool) {
+	parts := clockRe.FindStringSubmatch(text)
+	if parts == nil {
+		return 0, false
+	}
+	hour, _ := strconv.Atoi(parts[1])
+	minute, _ := strconv.Atoi(parts[2])
+	if hour > 23 || minute > 59 {
+		return 0, false
+	}
+	return hour*60 + minute, true
+}
+
+func parseDays(word string) []time.Weekday {
+	for day, short := range shortDays {
+		if word == short {
+			return []time.Weekday{day}
+		}
+	}
+	for stem, days := range dayStems {
+		if strings.HasPrefix(word, stem) {
+			return days
+		}
+	}
+	return nil
+}
+
+// Next run strictly after the given time
+func (w Weekly) Next(after time.Time, loc *time.Location) time.Time {
+	local := after.In(loc)
+	for i := 0; i <= len(week); i++ {
+		run := time.Date(local.Year(), local.Month(), local.Day()+i, w.Minute/60, w.Minute%60, 0, 0, loc)
+		if run.After(after) && w.runsOn(run.Weekday()) {
+			return run
+		}
+	}
+	return time.Time{}
+}
+
+func (w Weekly) runsOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// String is the schedule in words: "пн, пт в 18:00" or "каждый день в 09:00"
+func (w Weekly) String() string {
+	clock := Clock(w.Minute)
+	if len(w.Days) == 0 {
+		return "каждый день в " + clock
+	}
+	var names []string
+	for _, day := range week {
+		if w.runsOn(day) {
+			names = append(names, shortDays[day])
+		}
+	}
+	return strings.Join(names, ", ") + " в " + clock
+}
+
+// Clock writes a minute of the day as 09:05
+func Clock(minute int) string {
+	text := strconv.Itoa(minute/60) + ":" + strconv.Itoa(minute%60/10) + strconv.Itoa(minute%10)
+	if minute < 600 {
+		text = "0" + text
+	}
+	return text
+}
+
+// Location reads a timezone name like "Europe/Moscow" or an offset like "+3", "UTC+05:30"
+func Location(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if parts := offsetRe.FindStringSubmatch(name); parts != nil {
+		hours, _ := strconv.Atoi(parts[2])
+		minutes, _ := strconv.Atoi(parts[3])
+		if hours > 14 || minutes > 59 {
+			return nil, ErrTimezone
+		}
+		offset := hours*3600 + minutes*60
+		if parts[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone("UTC"+parts[1]+Clock(hours*60+minutes), offset), nil
+	}
+	if name == "" || strings.EqualFold(name, "local") {
+		return nil, ErrTimezone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, ErrTimezone
+	}
+	return loc, nil
+}
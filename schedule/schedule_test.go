@@ -0,0 +1,169 @@
+package schedule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCut(t *testing.T) {
+	weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	tests := []struct {
+		text   string
+		head   string
+		weekly Weekly
+		ok     bool
+	}{
+		{text: "Продукты пт 18:00", head: "Продукты", weekly: Weekly{Days: []time.Weekday{time.Friday}, Minute: 18 * 60}, ok: true},
+		{text: "Продукты чт, пн 9.30", head: "Продукты", weekly: Weekly{Days: []time.Weekday{time.Monday, time.Thursday}, Minute: 9*60 + 30}, ok: true},
+		{text: "Продукты по будням 8:05", head: "Продукты", weekly: Weekly{Days: weekdays, Minute: 8*60 + 5}, ok: true},
+		{text: "Дача каждую пятницу и субботу 7:00", head: "Дача", weekly: Weekly{Days: []time.Weekday{time.Friday, time.Saturday}, Minute: 7 * 60}, ok: true},
+		{text: "Продукты выходные 10:00", head: "Продукты", weekly: Weekly{Days: []time.Weekday{time.Saturday, time.Sunday}, Minute: 10 * 60}, ok: true},
+		{text: "Продукты ежедневно 20:00", head: "Продукты", weekly: Weekly{Minute: 20 * 60}, ok: true},
+		{text: "Продукты каждый день 0:00", head: "Продукты", weekly: Weekly{Minute: 0}, ok: true},
+		{text: "Продукты будни выходные 12:00", head: "Продукты", weekly: Weekly{Minute: 12 * 60}, ok: true},
+		{text: "Продукты в 18:00", head: "Продукты", weekly: Weekly{Minute: 18 * 60}, ok: true},
+		{text: "Для Пети пт 18:00", head: "Для Пети", weekly: Weekly{Days: []time.Weekday{time.Friday}, Minute: 18 * 60}, ok: true},
+		{text: "Продукты пт 24:00", head: "Продукты пт 24:00"},
+		{text: "Продукты пт", head: "Продукты пт"},
+		{text: "", head: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			head, weekly, ok := Cut(tt.text)
+			if head != tt.head || !reflect.DeepEqual(weekly, tt.weekly) || ok != tt.ok {
+				t.Errorf("Cut(%q) = %q, %v, %v, want %q, %v, %v", tt.text, head, weekly, ok, tt.head, tt.weekly, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    Weekly
+		wantErr bool
+	}{
+		{text: "пн, ср 9:30", want: Weekly{Days: []time.Weekday{time.Monday, time.Wednesday}, Minute: 9*60 + 30}},
+		{text: "ежедневно 20:00", want: Weekly{Minute: 20 * 60}},
+		{text: "Продукты пт 18:00", wantErr: true},
+		{text: "пн", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := Parse(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no timezone database:", err)
+	}
+	moscow := time.FixedZone("MSK", 3*60*60)
+	friday := Weekly{Days: []time.Weekday{time.Friday}, Minute: 18 * 60}
+	tests := []struct {
+		name   string
+		weekly Weekly
+		after  time.Time
+		loc    *time.Location
+		want   time.Time
+	}{
+		{
+			name:   "later the same day",
+			weekly: friday,
+			after:  time.Date(2026, 10, 16, 9, 0, 0, 0, moscow),
+			loc:    moscow,
+			want:   time.Date(2026, 10, 16, 18, 0, 0, 0, moscow),
+		},
+		{
+			name:   "strictly after, so a week later",
+			weekly: friday,
+			after:  time.Date(2026, 10, 16, 18, 0, 0, 0, moscow),
+			loc:    moscow,
+			want:   time.Date(2026, 10, 23, 18, 0, 0, 0, moscow),
+		},
+		{
+			name:   "in the timezone of the user, not of the given time",
+			weekly: friday,
+			after:  time.Date(2026, 10, 16, 16, 0, 0, 0, time.UTC),
+			loc:    moscow,
+			want:   time.Date(2026, 10, 23, 18, 0, 0, 0, moscow),
+		},
+		{
+			name:   "every day, tomorrow",
+			weekly: Weekly{Minute: 8 * 60},
+			after:  time.Date(2026, 10, 19, 8, 1, 0, 0, moscow),
+			loc:    moscow,
+			want:   time.Date(2026, 10, 20, 8, 0, 0, 0, moscow),
+		},
+		{
+			name:   "the same local time after the clocks go forward",
+			weekly: Weekly{Days: []time.Weekday{time.Sunday}, Minute: 18 * 60},
+			after:  time.Date(2026, 3, 27, 18, 0, 0, 0, berlin),
+			loc:    berlin,
+			want:   time.Date(2026, 3, 29, 16, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.weekly.Next(tt.after, tt.loc); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		weekly Weekly
+		want   string
+	}{
+		{weekly: Weekly{Minute: 9 * 60}, want: "каждый день в 09:00"},
+		{weekly: Weekly{Days: []time.Weekday{time.Sunday, time.Monday}, Minute: 18*60 + 5}, want: "пн, вс в 18:05"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.weekly.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocation(t *testing.T) {
+	tests := []struct {
+		name    string
+		offset  int
+		wantErr bool
+	}{
+		{name: "+3", offset: 3 * 60 * 60},
+		{name: "UTC+05:30", offset: 5*60*60 + 30*60},
+		{name: "gmt -4", offset: -4 * 60 * 60},
+		{name: "+15", wantErr: true},
+		{name: "local", wantErr: true},
+		{name: "", wantErr: true},
+		{name: "Mars/Olympus", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := Location(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Location(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if _, offset := time.Date(2026, 10, 19, 12, 0, 0, 0, loc).Zone(); offset != tt.offset {
+				t.Errorf("Location(%q) offset = %d, want %d", tt.name, offset, tt.offset)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+#!/bin/sh
+# Runs several bot instances behind the fake telegram server against a local mongo
+# and checks that no item got lost. Usage: scripts/cluster-test.sh [instances] [mode]
+# mode is "webhook" (default) or "polling", where a single leader polls at a time.
+set -e
+
+INSTANCES=${1:-3}
+MODE=${2:-webhook}
+FAKETG=127.0.0.1:8081
+export TGTOKEN=test BOTNAME=fake_bot TG_API_ENDPOINT=http://$FAKETG CLUSTER_ENABLED=true
+export MONGO_URI=${MONGO_URI:-mongodb://127.0.0.1:27017}
+
+cd "$(dirname "$0")/.."
+mkdir -p tmp
+go build -o tmp/bot bot.go
+go build -o tmp/faketg ./cmd/faketg
+
+pids=""
+trap 'kill $pids 2>/dev/null' EXIT
+
+tmp/faketg -addr $FAKETG -users 10 -items 20 > tmp/faketg.log 2>&1 &
+pids="$pids $!"
+sleep 1
+
+i=1
+while [ "$i" -le "$INSTANCES" ]; do
+	port=$((21400 + i))
+	if [ "$MODE" = "webhook" ]; then
+		export TG_WEBHOOK_URL=http://127.0.0.1:$port/tg
+	fi
+	INSTANCE_ID=bot$i METRICSPORT=$port tmp/bot > tmp/bot$i.log 2>&1 &
+	pids="$pids $!"
+	i=$((i + 1))
+done
+sleep 5
+
+curl -s http://$FAKETG/run
+sleep 15
+curl -s http://$FAKETG/stats
+curl -sf http://$FAKETG/verify
@@ -0,0 +1,100 @@
+// Package settle works out who owes whom after a shared purchase.
+//
+// The total paid is split equally between the members, the kopecks left
+// after the division go to the first members. Debts are then paid off
+// greedily, the largest debtor pays the largest creditor, so there are
+// fewer transfers than members.
+package settle
+
+import (
+	"sort"
+)
+
+type Transfer struct {
+	From   int
+	To     int
+	Amount int64
+}
+
+// Balances of the members in kopecks, positive ones are owed money.
+// Members who paid but are missing from the list are added after it.
+func Balances(members []int, paid map[int]int64) map[int]int64 {
+	seen := map[int]bool{}
+	var all []int
+	for _, member := range members {
+		if !seen[member] {
+			seen[member] = true
+			all = append(all, member)
+		}
+	}
+	var payers []int
+	for member := range paid {
+		if !seen[member] {
+			payers = append(payers, member)
+		}
+	}
+	sort.Ints(payers)
+	all = append(all, payers...)
+
+	balances := map[int]int64{}
+	if len(all) == 0 {
+		return balances
+	}
+	var total int64
+	for _, amount := range paid {
+		total += amount
+	}
+	share, rest := total/int64(len(all)), total%int64(len(all))
+	for i, member := range all {
+		owed := share
+		if int64(i) < rest {
+			owed++
+		}
+		balances[member] = paid[member] - owed
+	}
+	return balances
+}
+
+// Transfers pay off the balances
+func Transfers(balances map[int]int64) []Transfer {
+	type balance struct {
+		member int
+		amount int64
+	}
+	var debtors, creditors []balance
+	for member, amount := range balances {
+		if amount < 0 {
+			debtors = append(debtors, balance{member, -amount})
+		} else if amount > 0 {
+			creditors = append(creditors, balance{member, amount})
+		}
+	}
+	byAmount := func(b []balance) func(i, j int) bool {
+		return func(i, j int) bool {
+			if b[i].amount != b[j].amount {
+				return b[i].amount > b[j].amount
+			}
+			return b[i].member < b[j].member
+		}
+	}
+	sort.Slice(debtors, byAmount(debtors))
+	sort.Slice(creditors, byAmount(creditors))
+
+	var transfers []Transfer
+	for d, c := 0, 0; d < len(debtors) && c < len(creditors); {
+		amount := debtors[d].amount
+		if creditors[c].amount < amount {
+			amount = creditors[c].amount
+		}
+		transfers = append(transfers, Transfer{From: debtors[d].member, To: creditors[c].member, Amount: amount})
+		debtors[d].amount -= amount
+		creditors[c].amount -= amount
+		if debtors[d].amount == 0 {
+			d++
+		}
+		if creditors[c].amount == 0 {
+			c++
+		}
+	}
+	return transfers
+}
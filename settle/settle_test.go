@@ -0,0 +1,87 @@
+package settle
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBalances(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []int
+		paid    map[int]int64
+		want    map[int]int64
+	}{
+		{
+			name: "no members",
+			want: map[int]int64{},
+		},
+		{
+			name:    "an equal split",
+			members: []int{1, 2},
+			paid:    map[int]int64{1: 300},
+			want:    map[int]int64{1: 150, 2: -150},
+		},
+		{
+			name:    "the kopecks left go to the first members",
+			members: []int{1, 2, 3},
+			paid:    map[int]int64{3: 100},
+			want:    map[int]int64{1: -34, 2: -33, 3: 67},
+		},
+		{
+			name:    "a payer missing from the members is added",
+			members: []int{1, 1},
+			paid:    map[int]int64{2: 100},
+			want:    map[int]int64{1: -50, 2: 50},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Balances(tt.members, tt.paid); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Balances() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransfers(t *testing.T) {
+	tests := []struct {
+		name     string
+		balances map[int]int64
+		want     []Transfer
+	}{
+		{
+			name:     "settled",
+			balances: map[int]int64{1: 0, 2: 0},
+		},
+		{
+			name:     "one debtor",
+			balances: map[int]int64{1: 150, 2: -150},
+			want:     []Transfer{{From: 2, To: 1, Amount: 150}},
+		},
+		{
+			name:     "the largest debtor pays the largest creditor first",
+			balances: map[int]int64{1: 300, 2: 100, 3: -250, 4: -150},
+			want: []Transfer{
+				{From: 3, To: 1, Amount: 250},
+				{From: 4, To: 1, Amount: 50},
+				{From: 4, To: 2, Amount: 100},
+			},
+		},
+		{
+			name:     "equal amounts are ordered by member",
+			balances: map[int]int64{1: -100, 2: -100, 3: 200},
+			want: []Transfer{
+				{From: 1, To: 3, Amount: 100},
+				{From: 2, To: 3, Amount: 100},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Transfers(tt.balances); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Transfers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Package tracing exports spans of the update pipeline: receipt of an update,
+// the dialog state loaded from mongo, every db call, the debounce of list renders
+// and the requests to telegram.
+//
+// Spans go to an OTLP collector over http or to stdout. Until Setup is called,
+// and with the none exporter, spans are noop and cost next to nothing.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+const instrumentation = "github.com/boryashkin/purchaselist"
+
+type Options struct {
+	// Exporter is none, stdout or otlp
+	Exporter string
+	// Endpoint is host:port of an OTLP http collector
+	Endpoint string
+	// Insecure sends spans over plain http
+	Insecure bool
+	// Ratio of updates traced, from 0 to 1
+	Ratio float64
+	// Stdout receives spans of the stdout exporter
+	Stdout io.Writer
+
+	Service  string
+	Instance string
+}
+
+// Setup installs the global tracer provider. The returned func sends the spans left, it is called on shutdown.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch opts.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(opts.Stdout))
+	case ExporterOTLP:
+		httpOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(opts.Endpoint)}
+		if opts.Insecure {
+			httpOpts = append(httpOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, httpOpts...)
+	default:
+		err = errors.New("unknown exporter " + opts.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String(opts.Service),
+		semconv.ServiceInstanceIDKey.String(opts.Instance),
+	)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.Ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// Start opens a span, a child of the span in ctx if there is one
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End closes the span, marking it failed when err is set
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceID of the span in ctx, empty when the update is not sampled
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsSampled() {
+		return ""
+	}
+	return sc.TraceID().String()
+}